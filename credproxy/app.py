@@ -4,26 +4,41 @@
 from __future__ import annotations
 
 import time
+import uuid
 from typing import TYPE_CHECKING
 
 from flask import Flask, g, request
 
+from credproxy import __version__
+from credproxy.imds import IMDSTokenStore, imds_bp
+from credproxy.audit import AuditLog
 from credproxy.config import Config as AppConfig
 from credproxy.logger import LOG, setup_json_logging
-from credproxy.routes import api_bp, register_metrics_route
+from credproxy.routes import CREDENTIALS_ENDPOINTS, api_bp, register_metrics_route
 from credproxy.metrics import init_metrics, record_request
+from credproxy.tracing import init_tracing, instrument_app
+from credproxy.version import VERSION_HEADER
+from credproxy.disk_cache import create_disk_cache
 from credproxy.file_watcher import FileWatcherService
 from credproxy.credentials_handler import CredentialsHandler
 
 
 if TYPE_CHECKING:
+    from collections.abc import Mapping
+
     from flask import Flask
 
+    from credproxy.providers import CredentialsProvider
+
+
+# Response header echoing the request ID found in the logs of the request
+REQUEST_ID_HEADER = "X-Credproxy-Request-Id"
+
 
 def set_service_context():
     """Set service name in Flask's g context for access logging."""
     # Only set service context for credential requests
-    if request.endpoint == "api.get_credentials":
+    if request.endpoint in CREDENTIALS_ENDPOINTS:
         # Get the authorization token from request header
         provided_token = request.headers.get("Authorization")
         if provided_token:
@@ -31,7 +46,8 @@ def set_service_context():
             from flask import current_app
 
             config = current_app.config.get("credproxy_config")
-            if config:
+            # Client tokens of the allowlists are not service tokens
+            if config and not config.get_client_name_by_token(provided_token):
                 # Find the service name for this token using instant lookup
                 service_name = config.get_service_name_by_token(provided_token)
                 if service_name:
@@ -52,8 +68,16 @@ def set_service_context():
         LOG.debug("Skipping service context: endpoint=%s", request.endpoint)
 
 
-def init_app(config: AppConfig) -> Flask:
-    """Create and configure Flask app."""
+def init_app(
+    config: AppConfig,
+    source_providers: Mapping[str, CredentialsProvider] | None = None,
+) -> Flask:
+    """Create and configure Flask app.
+
+    source_providers provide the source credentials of the services they are
+    set for, in place of their configured method, for applications embedding
+    CredProxy.
+    """
     app = Flask(__name__)
 
     # Disable Flask's default logging handler to prevent duplicate logs
@@ -67,10 +91,25 @@ def init_app(config: AppConfig) -> Flask:
     # Store config in app context
     app.config["credproxy_config"] = config
 
+    # Traced first, so the spans of requests cover every request hook
+    if config.metrics.tracing.enabled and init_tracing():
+        instrument_app(app)
+
     # Create credentials handler
-    credentials_handler = CredentialsHandler(config)
+    credentials_handler = CredentialsHandler(
+        config,
+        disk_cache=create_disk_cache(
+            config.credentials.cache_dir, config.credentials.cache_key_file
+        ),
+        source_providers=source_providers,
+    )
     app.config["credentials_handler"] = credentials_handler
 
+    # Audit trail of the credentials vended, failing startup if it cannot be written
+    app.config["audit_log"] = (
+        AuditLog(config.server.audit_log) if config.server.audit_log else None
+    )
+
     # Create and start file watcher service
     file_watcher = FileWatcherService(config)
     app.config["file_watcher"] = file_watcher
@@ -84,8 +123,8 @@ def init_app(config: AppConfig) -> Flask:
     # Add request ID generation and metrics timing
     @app.before_request
     def make_request_id() -> None:
-        # Use timestamp-based ID (microseconds for uniqueness)
-        g.request_id = f"{request.remote_addr}-{int(time.time() * 1000000)}"
+        # Random ID, unique across instances and restarts
+        g.request_id = uuid.uuid4().hex
         # Record request start time for metrics
         g.start_time = time.time()
 
@@ -99,11 +138,26 @@ def init_app(config: AppConfig) -> Flask:
             LOG.info("=== SHUTDOWN IN PROGRESS - Rejecting new requests ===")
             return "Service shutting down", 503
 
+    # Echo the request ID so clients can correlate their requests with the logs
+    @app.after_request
+    def add_request_id_header(response):
+        request_id = g.get("request_id")
+        if request_id:
+            response.headers[REQUEST_ID_HEADER] = request_id
+        return response
+
+    # The version of CredProxy helps support requests about served credentials
+    @app.after_request
+    def add_version_header(response):
+        if request.endpoint in CREDENTIALS_ENDPOINTS or request.blueprint == "imds":
+            response.headers[VERSION_HEADER] = __version__
+        return response
+
     # Add metrics recording after each request
     @app.after_request
     def record_metrics(response):
         # Only record metrics for credential requests
-        if request.endpoint == "api.get_credentials":
+        if request.endpoint in CREDENTIALS_ENDPOINTS:
             try:
                 # Calculate request duration
                 duration = time.time() - g.get("start_time", time.time())
@@ -112,10 +166,16 @@ def init_app(config: AppConfig) -> Flask:
                 # Determine result based on status code
                 if response.status_code == 200:
                     result = "success"
-                elif response.status_code == 401:
-                    result = "denied_missing_token"
                 elif response.status_code == 403:
-                    result = "denied_invalid_token"
+                    # Valid clients requesting services outside of their allowlist
+                    if g.get("denied_reason") == "not_allowed":
+                        result = "denied_not_allowed"
+                    elif request.headers.get("Authorization"):
+                        result = "denied_invalid_token"
+                    else:
+                        result = "denied_missing_token"
+                elif response.status_code == 429:
+                    result = "rate_limited"
                 else:
                     result = "error"
 
@@ -148,6 +208,12 @@ def init_app(config: AppConfig) -> Flask:
     # Register blueprint
     app.register_blueprint(api_bp)
 
+    # Register IMDS emulation endpoints if enabled
+    if config.imds.enabled:
+        app.config["imds_token_store"] = IMDSTokenStore()
+        app.register_blueprint(imds_bp)
+        LOG.info("IMDS emulation enabled in %s mode", config.imds.mode)
+
     # Start file watcher service
     try:
         file_watcher.start()
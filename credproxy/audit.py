@@ -0,0 +1,95 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Audit trail of the credentials vended, apart from the operational logs.
+
+Every set of credentials served is recorded as a JSON line, with the role
+session ID STS returned, which CloudTrail records as the principal of the calls
+made with the credentials. No secret value is recorded, clients being recorded by
+the SHA-256 of their token rather than the token.
+"""
+
+from __future__ import annotations
+
+import os
+import json
+import hashlib
+import threading
+from datetime import datetime, timezone
+from dataclasses import field, asdict, dataclass
+
+from credproxy.logger import LOG
+
+
+# Only the user running CredProxy may read the audit trail
+AUDIT_FILE_MODE = 0o600
+
+
+def _utc_now() -> str:
+    """Format the current time as CloudTrail event times are, to the millisecond."""
+    now = datetime.now(timezone.utc).isoformat(timespec="milliseconds")
+    return now.replace("+00:00", "Z")
+
+
+def token_fingerprint(token: str | None) -> str | None:
+    """Get the SHA-256 of a client token, recorded in place of the token."""
+    return hashlib.sha256(token.encode()).hexdigest() if token else None
+
+
+@dataclass
+class AuditRecord:
+    """Credentials vended to a client."""
+
+    service: str
+    role_arn: str
+    assumed_role_id: str | None  # AssumedRoleUser.AssumedRoleId of STS
+    cache: str | None  # "hit" or "miss"
+    expiration: str
+    endpoint: str  # "container" or "imds"
+    remote: str | None = None
+    client: str | None = None  # Name of the client in clients, if any
+    token_sha256: str | None = None
+    client_cn: str | None = None  # Common name of the client certificate
+    timestamp: str = field(default_factory=_utc_now)
+
+
+class AuditLog:
+    """Append-only file of audit records, reopened by reopen after rotation.
+
+    Raises OSError when the file cannot be opened.
+    """
+
+    def __init__(self, path: str):
+        self.path = path
+        self._lock = threading.Lock()
+        self._file = self._open()
+
+    def _open(self):
+        """Open the file to append to, creating it readable by its owner only."""
+        fd = os.open(self.path, os.O_WRONLY | os.O_APPEND | os.O_CREAT, AUDIT_FILE_MODE)
+        return os.fdopen(fd, "a", encoding="utf-8")
+
+    def record(self, record: AuditRecord) -> None:
+        """Append a record, logging failures rather than failing the request."""
+        # Timestamp first, as in the operational logs
+        entry = {"timestamp": record.timestamp, **asdict(record)}
+        line = json.dumps(entry, separators=(",", ":")) + "\n"
+        with self._lock:
+            try:
+                self._file.write(line)
+                self._file.flush()
+            except OSError as error:
+                LOG.error("Failed to write audit record to %s", self.path)
+                LOG.exception(error)
+
+    def reopen(self) -> None:
+        """Open the file again, such as once logrotate moved it."""
+        with self._lock:
+            previous, self._file = self._file, self._open()
+            previous.close()
+        LOG.info("Reopened audit log %s", self.path)
+
+    def close(self) -> None:
+        """Close the file."""
+        with self._lock:
+            self._file.close()
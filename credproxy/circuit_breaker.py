@@ -0,0 +1,145 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Circuit breaker of the STS calls of a region.
+
+When STS throttles hard, retrying every cache miss only adds to the load. After
+failure_threshold consecutive throttling or server errors within window_seconds,
+the circuit opens and STS calls fail fast for cooldown_seconds. A single probe
+call is then allowed through, closing the circuit if STS answers, and opening it
+again for another cooldown if STS still fails.
+"""
+
+from __future__ import annotations
+
+import time
+import threading
+from dataclasses import dataclass
+
+from botocore.exceptions import ClientError
+
+from credproxy.logger import LOG
+from credproxy.retry import is_retryable
+
+
+CLOSED = "closed"
+OPEN = "open"
+HALF_OPEN = "half-open"
+# Seconds calls are told to wait for while the probe call is in flight
+PROBE_RETRY_AFTER = 1.0
+
+
+class CircuitOpen(Exception):
+    """Raised instead of calling STS while the circuit of its region is open."""
+
+    def __init__(self, region: str, retry_after: float):
+        self.region = region
+        self.retry_after = retry_after
+        super().__init__(
+            f"STS of {region} is failing, not called for {retry_after:.0f} seconds"
+        )
+
+
+@dataclass
+class CircuitStatus:
+    """State of a circuit breaker, as shown by /admin/status."""
+
+    state: str
+    consecutive_failures: int
+    retry_after: float | None  # Seconds until a probe is allowed, while open
+
+    def to_dict(self) -> dict:
+        return {
+            "state": self.state,
+            "consecutive_failures": self.consecutive_failures,
+            "retry_after": self.retry_after,
+        }
+
+
+class StsCircuitBreaker:
+    """Circuit breaker of STS calls, shared by the requests of a region."""
+
+    def __init__(
+        self,
+        region: str,
+        failure_threshold: int = 5,
+        window_seconds: float = 60.0,
+        cooldown_seconds: float = 30.0,
+    ):
+        self.region = region
+        self.failure_threshold = failure_threshold
+        self.window_seconds = window_seconds
+        self.cooldown_seconds = cooldown_seconds
+        self._failures = 0
+        # Monotonic times of the first consecutive failure, and of the opening
+        self._first_failure_at = 0.0
+        self._opened_at: float | None = None
+        self._probing = False
+        self._lock = threading.Lock()
+
+    def before_call(self) -> bool:
+        """Allow an STS call, raising CircuitOpen while the circuit is open.
+
+        Once the cooldown elapsed, the first call is the probe, returning True,
+        and other calls keep failing fast until it completes.
+        """
+        with self._lock:
+            if self._opened_at is None:
+                return False
+            retry_after = self._opened_at + self.cooldown_seconds - time.monotonic()
+            if retry_after > 0:
+                raise CircuitOpen(self.region, retry_after)
+            if self._probing:
+                raise CircuitOpen(self.region, PROBE_RETRY_AFTER)
+            self._probing = True
+            return True
+
+    def record(self, probe: bool, error: Exception | None = None) -> None:
+        """Record the outcome of an STS call allowed by before_call."""
+        with self._lock:
+            if probe:
+                self._probing = False
+            if error is None or (
+                isinstance(error, ClientError) and not is_retryable(error)
+            ):
+                # STS answered, whether or not it granted the request
+                if self._opened_at is not None:
+                    LOG.info("STS of %s answered again, closing circuit", self.region)
+                self._failures = 0
+                self._opened_at = None
+                return
+            if not is_retryable(error):
+                return
+
+            now = time.monotonic()
+            if self._failures and now - self._first_failure_at > self.window_seconds:
+                self._failures = 0
+            if not self._failures:
+                self._first_failure_at = now
+            self._failures += 1
+            if probe or (
+                self._opened_at is None and self._failures >= self.failure_threshold
+            ):
+                self._opened_at = now
+                LOG.warning(
+                    "STS of %s failed %d times in a row, not calling it for %.0f "
+                    "seconds: %s",
+                    self.region,
+                    self._failures,
+                    self.cooldown_seconds,
+                    error,
+                )
+
+    def status(self) -> CircuitStatus:
+        """Get the state of the circuit."""
+        with self._lock:
+            if self._opened_at is None:
+                return CircuitStatus(CLOSED, self._failures, None)
+            retry_after = self._opened_at + self.cooldown_seconds - time.monotonic()
+            if retry_after > 0:
+                return CircuitStatus(OPEN, self._failures, retry_after)
+            return CircuitStatus(HALF_OPEN, self._failures, None)
+
+    def is_open(self) -> bool:
+        """Check if STS calls fail fast, the probe call included."""
+        return self.status().state != CLOSED
@@ -5,6 +5,7 @@
 
 from __future__ import annotations
 
+import sys
 import argparse
 from typing import TYPE_CHECKING
 
@@ -12,8 +13,88 @@ from typing import TYPE_CHECKING
 if TYPE_CHECKING:
     import argparse
 
-from credproxy import __version__
-from credproxy.logger import LOG
+from credproxy.config import validate_endpoint_url
+from credproxy.logger import LOG, LOG_FORMATS, set_log_format
+from credproxy.shells import SHELLS
+from credproxy.version import VERSION_OUTPUT_FORMATS, print_version
+from credproxy.named_pipe import PIPE_NAME_PATTERN
+
+
+def non_negative_int(value: str) -> int:
+    """Argparse type accepting integers greater than or equal to zero."""
+    try:
+        number = int(value)
+    except ValueError as error:
+        raise argparse.ArgumentTypeError(f"invalid integer value: '{value}'") from error
+    if number < 0:
+        raise argparse.ArgumentTypeError(f"value must be >= 0, got {number}")
+    return number
+
+
+def positive_int(value: str) -> int:
+    """Argparse type accepting integers greater than zero."""
+    try:
+        number = int(value)
+    except ValueError as error:
+        raise argparse.ArgumentTypeError(f"invalid integer value: '{value}'") from error
+    if number < 1:
+        raise argparse.ArgumentTypeError(f"value must be >= 1, got {number}")
+    return number
+
+
+def non_negative_float(value: str) -> float:
+    """Argparse type accepting numbers greater than or equal to zero."""
+    try:
+        number = float(value)
+    except ValueError as error:
+        raise argparse.ArgumentTypeError(f"invalid number value: '{value}'") from error
+    if number < 0:
+        raise argparse.ArgumentTypeError(f"value must be >= 0, got {number:g}")
+    return number
+
+
+def positive_float(value: str) -> float:
+    """Argparse type accepting numbers greater than zero."""
+    try:
+        number = float(value)
+    except ValueError as error:
+        raise argparse.ArgumentTypeError(f"invalid number value: '{value}'") from error
+    if number <= 0:
+        raise argparse.ArgumentTypeError(f"value must be > 0, got {number:g}")
+    return number
+
+
+def pipe_name(value: str) -> str:
+    """Argparse type accepting the names of local Windows named pipes."""
+    if sys.platform != "win32":
+        raise argparse.ArgumentTypeError("named pipes are only supported on Windows")
+    if not PIPE_NAME_PATTERN.match(value):
+        raise argparse.ArgumentTypeError(
+            f"invalid named pipe: '{value}', expected \\\\.\\pipe\\NAME"
+        )
+    return value
+
+
+def host_port(value: str) -> tuple[str, int]:
+    """Argparse type parsing HOST:PORT, with [HOST]:PORT for IPv6 hosts."""
+    host, separator, port = value.rpartition(":")
+    if not separator or not host:
+        raise argparse.ArgumentTypeError(f"expected HOST:PORT, got '{value}'")
+    try:
+        port_number = int(port)
+    except ValueError as error:
+        raise argparse.ArgumentTypeError(f"invalid port: '{port}'") from error
+    if not 1 <= port_number <= 65535:
+        raise argparse.ArgumentTypeError(f"port must be 1-65535, got {port_number}")
+    return host.strip("[]"), port_number
+
+
+def endpoint_url(value: str) -> str:
+    """Argparse type accepting absolute http(s) URLs."""
+    try:
+        return validate_endpoint_url(value)
+    except ValueError as error:
+        raise argparse.ArgumentTypeError(str(error)) from error
 
 
 def create_parser() -> argparse.ArgumentParser:
@@ -36,7 +117,17 @@ def create_parser() -> argparse.ArgumentParser:
     )
 
     _ = parser.add_argument(
-        "--version", action="version", version=f"%(prog)s {__version__}"
+        "--version",
+        action="store_true",
+        help="Print the version, git commit and build date and exit",
+    )
+
+    _ = parser.add_argument(
+        "-o",
+        "--output",
+        choices=VERSION_OUTPUT_FORMATS,
+        default="text",
+        help="Output format of --version (default: text)",
     )
 
     _ = parser.add_argument(
@@ -45,12 +136,314 @@ def create_parser() -> argparse.ArgumentParser:
         help="Set logging level (default: INFO)",
     )
 
+    _ = parser.add_argument(
+        "--log-format",
+        choices=LOG_FORMATS,
+        help="Set log lines format (default: json)",
+    )
+
+    _ = parser.add_argument(
+        "--imds-mode",
+        choices=["v2-optional", "v2-required"],
+        help="IMDS session mode, overrides imds.mode (default: v2-optional)",
+    )
+
+    _ = parser.add_argument(
+        "--refresh-window",
+        type=non_negative_int,
+        metavar="SECONDS",
+        help=(
+            "Refresh cached credentials this many seconds before expiry, "
+            "overrides credentials.refresh_buffer_seconds (default: 300)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--refresh-jitter",
+        type=non_negative_int,
+        metavar="SECONDS",
+        help=(
+            "Refresh at a random point up to this many seconds around the refresh "
+            "window, overrides credentials.refresh_jitter_seconds (default: 0)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--expiry-skew",
+        type=non_negative_int,
+        metavar="SECONDS",
+        help=(
+            "Consider credentials expired this many seconds early, for drifting "
+            "clocks, 30 recommended, overrides credentials.expiry_skew_seconds "
+            "(default: 0)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--sts-max-attempts",
+        type=positive_int,
+        metavar="N",
+        help=(
+            "Attempts of STS calls failing with throttling, server or network "
+            "errors, overrides credentials.sts_max_attempts (default: 3)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--request-timeout",
+        type=positive_int,
+        metavar="SECONDS",
+        help=(
+            "Answer credential requests with 504 when STS does not answer within "
+            "SECONDS, overrides credentials.request_timeout (default: 30)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--startup-max-wait",
+        type=non_negative_int,
+        metavar="SECONDS",
+        help=(
+            "Exit with 1 when credentials still fail SECONDS after startup, "
+            "overrides credentials.startup_max_wait (default: 0, never exit)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--cache-dir",
+        metavar="PATH",
+        help=(
+            "Cache credentials encrypted in PATH, to be reused after a restart, "
+            "overrides credentials.cache_dir (default: disabled)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--cache-key-file",
+        metavar="PATH",
+        help=(
+            "Key file of the disk cache, outside of its directory, overrides "
+            "credentials.cache_key_file (default: cache.key in the directory)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--rate-limit",
+        type=positive_float,
+        metavar="RPS",
+        help=(
+            "Rate limit the credential requests of each client to RPS per second, "
+            "overrides credentials.rate_limit.requests_per_second "
+            "(default: disabled)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--rate-burst",
+        type=positive_int,
+        metavar="N",
+        help=(
+            "Requests of a client allowed at once before being rate limited, "
+            "overrides credentials.rate_limit.burst (default: RPS rounded up)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--rate-limit-sts-only",
+        action="store_true",
+        help=(
+            "Only rate limit the requests that would call STS, serving cached "
+            "credentials without limit"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--listen-unix",
+        metavar="PATH",
+        help=(
+            "Also serve on a Unix domain socket at PATH, accessible by its owner "
+            "only, overrides server.unix_socket"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--listen-pipe",
+        metavar="NAME",
+        type=pipe_name,
+        help=(
+            "Also serve on a Windows named pipe such as \\\\.\\pipe\\credproxy, "
+            "accessible by the current user only, overrides server.named_pipe"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--audit-log",
+        metavar="PATH",
+        help=(
+            "Append an audit record of every credentials vended to PATH, reopened "
+            "on SIGHUP, overrides server.audit_log"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--tls-cert",
+        metavar="PATH",
+        help=(
+            "PEM certificate to serve TCP over TLS with, read again on SIGHUP, "
+            "requires --tls-key, overrides server.tls.cert_file"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--tls-key",
+        metavar="PATH",
+        help="PEM private key of --tls-cert, overrides server.tls.key_file",
+    )
+
+    _ = parser.add_argument(
+        "--tls-client-ca",
+        metavar="PATH",
+        help=(
+            "PEM CA bundle client certificates must be signed by (mutual TLS), "
+            "overrides server.tls.client_ca_file"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--metrics-addr",
+        type=host_port,
+        metavar="HOST:PORT",
+        help=(
+            "Serve Prometheus metrics on a separate HOST:PORT, enables "
+            "metrics.prometheus (default: disabled)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--tracing",
+        action="store_true",
+        help=(
+            "Export OpenTelemetry traces as set by the OTEL_EXPORTER_OTLP_* "
+            "environment variables, enables metrics.tracing"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--shutdown-timeout",
+        type=non_negative_float,
+        metavar="SECONDS",
+        help=(
+            "Seconds to wait for in-flight requests on shutdown, overrides "
+            "server.shutdown_timeout (default: 10)"
+        ),
+    )
+
+    _ = parser.add_argument(
+        "--region",
+        metavar="REGION",
+        help="AWS region of STS, overrides source_credentials.region of all services",
+    )
+
+    _ = parser.add_argument(
+        "--sts-endpoint",
+        type=endpoint_url,
+        metavar="URL",
+        help=(
+            "STS endpoint URL, overrides source_credentials.sts_endpoint of all "
+            "services (default: regional STS endpoint)"
+        ),
+    )
+
     _ = parser.add_argument(
         "--dev",
         action="store_true",
         help="Enable development mode (sets debug=True and log-level=DEBUG)",
     )
 
+    subparsers = parser.add_subparsers(dest="command", metavar="COMMAND")
+
+    credentials_parser = subparsers.add_parser(
+        "credentials",
+        help="Print the credentials of a service for credential_process and exit",
+        description=(
+            "Print the credentials of a service as the AWS CLI credential_process "
+            "JSON on stdout"
+        ),
+    )
+    _ = credentials_parser.add_argument(
+        "--service",
+        "--profile",
+        dest="service",
+        required=True,
+        help="Name of the service to print the credentials of",
+    )
+    # Also accepted after the command, keeping the main parser value otherwise
+    _ = credentials_parser.add_argument(
+        "--config",
+        default=argparse.SUPPRESS,
+        help="Path to configuration file (default: /credproxy/config.yaml)",
+    )
+
+    env_parser = subparsers.add_parser(
+        "env",
+        help="Print the shell exports pointing the AWS SDKs at a service and exit",
+        description=(
+            "Print the exports of AWS_CONTAINER_CREDENTIALS_FULL_URI and of the "
+            "authorization token of a service, for "
+            'eval "$(credproxy env --profile NAME)"'
+        ),
+    )
+    _ = env_parser.add_argument(
+        "--service",
+        "--profile",
+        dest="service",
+        required=True,
+        help="Name of the service to print the exports of",
+    )
+    _ = env_parser.add_argument(
+        "--shell",
+        choices=SHELLS,
+        default="bash",
+        help="Syntax of the exports (default: bash)",
+    )
+    _ = env_parser.add_argument(
+        "--config",
+        default=argparse.SUPPRESS,
+        help="Path to configuration file (default: /credproxy/config.yaml)",
+    )
+
+    validate_parser = subparsers.add_parser(
+        "validate",
+        help="Validate the configuration of every service and exit",
+        description=(
+            "Validate the configuration file and each of its services, printing "
+            "an OK or FAIL line per service. Exits with 1 if any failed"
+        ),
+    )
+    _ = validate_parser.add_argument(
+        "--check-assume",
+        action="store_true",
+        help="Also assume the role of each valid service once",
+    )
+    _ = validate_parser.add_argument(
+        "--config",
+        default=argparse.SUPPRESS,
+        help="Path to configuration file (default: /credproxy/config.yaml)",
+    )
+
+    version_parser = subparsers.add_parser(
+        "version",
+        help="Print the version, git commit and build date and exit",
+        description="Print the version and build information of CredProxy",
+    )
+    _ = version_parser.add_argument(
+        "-o",
+        "--output",
+        choices=VERSION_OUTPUT_FORMATS,
+        default=argparse.SUPPRESS,
+        help="Output format (default: text)",
+    )
+
     return parser
 
 
@@ -58,17 +451,29 @@ def main(argv: list[str] | None = None) -> int:
     """Main CLI entry point - parse arguments and delegate."""
     parser = create_parser()
     args = parser.parse_args(argv)
+    if bool(args.tls_cert) != bool(args.tls_key):
+        parser.error("--tls-cert and --tls-key must be used together")
+
+    if args.version or args.command == "version":
+        return print_version(args.output)
 
     # Handle --dev flag: set debug mode and default log level to DEBUG
     if args.dev:
         args.log_level = args.log_level or "DEBUG"
 
+    # Keep the logs of one-shot commands out of the way of their output
+    if args.command in ("credentials", "env", "validate"):
+        args.log_level = args.log_level or "WARNING"
+
     # Set up logging level from CLI argument if provided
     if args.log_level:
         from credproxy.runner import setup_cli_logging
 
         setup_cli_logging(args.log_level)
 
+    if args.log_format:
+        set_log_format(args.log_format)
+
     try:
         # If validation only, validate and exit
         if args.validate_only:
@@ -80,6 +485,21 @@ def main(argv: list[str] | None = None) -> int:
         LOG.error("Fatal error during validation: %s", str(error))
         return 1
 
+    if args.command == "credentials":
+        from credproxy.runner import print_process_credentials
+
+        return print_process_credentials(args)
+
+    if args.command == "env":
+        from credproxy.shell_env import run_env
+
+        return run_env(args)
+
+    if args.command == "validate":
+        from credproxy.validate import run_validate
+
+        return run_validate(args)
+
     # Delegate to server runner for normal operation
     from credproxy.runner import run_server
 
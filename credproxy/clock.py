@@ -0,0 +1,31 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Clocks the expiration of credentials is checked against.
+
+The credentials handler reads the time from a clock, the system clock unless
+another one is given, so that tests can drive expirations without sleeping.
+"""
+
+from __future__ import annotations
+
+import time
+from abc import ABC, abstractmethod
+
+
+class Clock(ABC):
+    """Source of the current time."""
+
+    @abstractmethod
+    def now(self) -> float:
+        """Get the current time, in epoch seconds."""
+
+
+class SystemClock(Clock):
+    """Clock of the host."""
+
+    def now(self) -> float:
+        return time.time()
+
+
+SYSTEM_CLOCK = SystemClock()
@@ -3,11 +3,15 @@
 
 from __future__ import annotations
 
+import os
+import ssl
+import hmac
 import json
 import threading
 from typing import Any
 from pathlib import Path
 from dataclasses import field, dataclass
+from urllib.parse import urlparse
 
 import yaml
 import jsonschema
@@ -15,6 +19,7 @@ import jsonschema
 from credproxy.logger import LOG
 from credproxy.metrics import update_active_services
 from credproxy.settings import NAMESPACE, get_config_file
+from credproxy.partitions import check_partition
 from credproxy.sanitizer import (
     register_sensitive_dict,
     register_sensitive_value,
@@ -23,6 +28,21 @@ from credproxy.sanitizer import (
 
 # Import substitution parser and centralized logging
 from credproxy.substitutions import substitute_variables
+from credproxy.session_names import DEFAULT_SESSION_NAME, expand_session_name
+
+
+# JSON schema the configuration is validated against
+SCHEMA_PATH = Path(__file__).parent / "config-schema.json"
+# Keys of the source credentials authentication methods, set one at a time
+SOURCE_CREDENTIALS_METHODS = (
+    "iam_profile",
+    "iam_keys",
+    "sso",
+    "web_identity",
+    "saml",
+    "static",
+    "sources",
+)
 
 
 def keyisset(key: str, data: dict) -> Any:
@@ -37,6 +57,39 @@ def set_else_none(key: str, data: dict, default: Any) -> Any:
     return data.get(key, default)
 
 
+def validate_endpoint_url(url: str) -> str:
+    """Check url is an absolute http(s) URL, raising ValueError otherwise."""
+    try:
+        parsed = urlparse(url)
+        # Accessing the port validates it
+        _ = parsed.port
+    except ValueError as error:
+        raise ValueError(f"Invalid endpoint URL {url!r}: {error}") from error
+    if parsed.scheme not in ("http", "https") or not parsed.hostname:
+        raise ValueError(f"Invalid endpoint URL {url!r}: expected http(s)://HOST")
+    return url
+
+
+def validate_ca_bundle(path: str) -> str:
+    """Check path is a PEM file of CA certificates, raising ValueError otherwise."""
+    try:
+        ssl.create_default_context(cafile=path)
+    except OSError as error:
+        raise ValueError(f"Invalid CA bundle {path!r}: {error}") from error
+    return path
+
+
+def read_auth_token_file(token_file: str) -> tuple[str, int]:
+    """Read a service auth token file, returning the token and the file mtime."""
+    with open(token_file, encoding="utf-8") as file:
+        token = file.read().strip()
+        mtime = os.fstat(file.fileno()).st_mtime_ns
+    if not token:
+        raise ValueError(f"Auth token file {token_file} is empty")
+    register_sensitive_value(token)
+    return token, mtime
+
+
 @dataclass
 class IAMProfileAuthConfig:
     """IAM profile authentication configuration."""
@@ -54,13 +107,65 @@ class IAMKeysAuthConfig:
     session_token: str | None = None  # For temporary credentials
 
 
+@dataclass
+class SSOAuthConfig:
+    """AWS IAM Identity Center (SSO) authentication configuration."""
+
+    start_url: str
+    sso_region: str
+    account_id: str
+    role_name: str
+
+
+@dataclass
+class WebIdentityAuthConfig:
+    """Web identity token file authentication configuration."""
+
+    token_file: str
+    role_arn: str
+    role_session_name: str = DEFAULT_SESSION_NAME
+
+
+@dataclass
+class SAMLAuthConfig:
+    """SAML federation authentication configuration."""
+
+    principal_arn: str
+    role_arn: str
+    # Exactly one of the file or command providing the SAML response
+    assertion_file: str | None = None
+    assertion_command: str | None = None
+
+
+@dataclass
+class StaticAuthConfig:
+    """Credentials served without calling STS, read from the environment if unset."""
+
+    aws_access_key_id: str | None = None
+    aws_secret_access_key: str | None = None
+    session_token: str | None = None
+    duration: int = 3600  # Seconds until the expiration served
+
+
 @dataclass
 class SourceCredentialsConfig:
     """Source AWS credentials configuration."""
 
     region: str | None = None
+    # STS endpoint URL, the regional endpoint of region when not set
+    sts_endpoint: str | None = None
+    # Outbound HTTP proxy and CA bundle of the STS clients, for locked-down networks
+    http_proxy: str | None = None
+    ca_bundle: str | None = None
+    insecure_skip_verify: bool = False  # Testing only, STS is not authenticated
     iam_profile: IAMProfileAuthConfig | None = None
     iam_keys: IAMKeysAuthConfig | None = None
+    sso: SSOAuthConfig | None = None
+    web_identity: WebIdentityAuthConfig | None = None
+    saml: SAMLAuthConfig | None = None
+    static: StaticAuthConfig | None = None
+    # Sources tried in order, each with one method or none for the SDK defaults
+    sources: list[SourceCredentialsConfig] | None = None
 
 
 @dataclass
@@ -68,7 +173,7 @@ class AssumedRoleConfig:
     """AWS role assumption configuration."""
 
     RoleArn: str
-    RoleSessionName: str = "credproxy"
+    RoleSessionName: str = DEFAULT_SESSION_NAME
     DurationSeconds: int = 900
     ExternalId: str | None = None
     PolicyArns: list[dict] | None = None
@@ -80,6 +185,15 @@ class AssumedRoleConfig:
     SourceIdentity: str | None = None
 
 
+@dataclass
+class TLSConfig:
+    """TLS settings of the TCP listener."""
+
+    cert_file: str
+    key_file: str
+    client_ca_file: str | None = None  # CA bundle verifying client certificates
+
+
 @dataclass
 class ServerConfig:
     """Server configuration settings."""
@@ -88,6 +202,39 @@ class ServerConfig:
     port: int = 1338
     debug: bool = False
     log_health_checks: bool = False
+    unix_socket: str | None = None  # Path of an additional Unix domain socket
+    named_pipe: str | None = None  # Name of an additional Windows named pipe
+    audit_log: str | None = None  # File of the audit records of credentials vended
+    shutdown_timeout: float = 10.0  # Seconds to drain in-flight requests
+    # Seconds a connection of the TCP listener may block on a read or a write,
+    # and wait for its next request
+    read_timeout: float = 10.0
+    write_timeout: float = 10.0
+    idle_timeout: float = 60.0
+    # Token of the admin endpoints, disabled when None
+    admin_token: str | None = None
+    tls: TLSConfig | None = None
+
+
+@dataclass
+class RateLimitConfig:
+    """Rate limit of the credential requests of each client."""
+
+    requests_per_second: float
+    burst: int | None = None  # Defaults to requests_per_second, rounded up
+    # Serve cached credentials without limit, only limiting STS calls
+    sts_requests_only: bool = False
+
+
+@dataclass
+class CircuitBreakerConfig:
+    """Circuit breaker of the STS calls of each region."""
+
+    failure_threshold: int = 5
+    # Seconds the consecutive failures must happen within
+    window_seconds: float = 60.0
+    # Seconds STS is not called for once the circuit opened
+    cooldown_seconds: float = 30.0
 
 
 @dataclass
@@ -95,8 +242,22 @@ class CredentialsConfig:
     """Credential management settings."""
 
     refresh_buffer_seconds: int = 300
+    # Random offset band around refresh_buffer_seconds
+    refresh_jitter_seconds: int = 0
+    # Seconds taken off STS expirations, for drifting clocks
+    expiry_skew_seconds: int = 0
     retry_delay: int = 60
     request_timeout: int = 30
+    # Attempts of STS calls failing for transient reasons
+    sts_max_attempts: int = 3
+    # Seconds startup fetches are retried for before exiting, 0 for ever
+    startup_max_wait: int = 0
+    # Directory of the encrypted credentials cache surviving restarts
+    cache_dir: str | None = None
+    # Key file of the disk cache, cache.key in cache_dir if not set
+    cache_key_file: str | None = None
+    rate_limit: RateLimitConfig | None = None
+    circuit_breaker: CircuitBreakerConfig | None = None
 
 
 @dataclass
@@ -120,20 +281,52 @@ class DynamicServicesConfig:
     watcher_stop_timeout: int = 5  # Timeout in seconds for stopping the file watcher
 
 
+@dataclass
+class IMDSIdentityConfig:
+    """Fields of the emulated instance identity document."""
+
+    region: str | None = None  # Region of the IMDS service source credentials if None
+    availability_zone: str | None = None  # First zone of the region if None
+    account_id: str | None = None  # Account of the IMDS service role if None
+    instance_id: str = "i-00000000000000000"
+    instance_type: str = "t3.micro"
+    image_id: str = "ami-00000000000000000"
+    private_ip: str = "127.0.0.1"
+    architecture: str = "x86_64"
+
+
+@dataclass
+class IMDSConfig:
+    """EC2 instance metadata service emulation settings."""
+
+    enabled: bool = False
+    mode: str = "v2-optional"  # v2-optional or v2-required
+    service: str | None = None  # Service whose credentials are served via IMDS
+    identity: IMDSIdentityConfig = field(default_factory=IMDSIdentityConfig)
+
+
 @dataclass
 class PrometheusConfig:
     """Prometheus metrics configuration."""
 
-    enabled: bool = True
+    enabled: bool = False
     host: str = "0.0.0.0"
     port: int = 9090
 
 
+@dataclass
+class TracingConfig:
+    """OpenTelemetry tracing configuration."""
+
+    enabled: bool = False
+
+
 @dataclass
 class MetricsConfig:
     """Metrics and telemetry configuration."""
 
     prometheus: PrometheusConfig = field(default_factory=PrometheusConfig)
+    tracing: TracingConfig = field(default_factory=TracingConfig)
 
 
 @dataclass
@@ -144,6 +337,19 @@ class ServiceConfig:
     source_credentials: SourceCredentialsConfig
     assumed_role: AssumedRoleConfig
     source_file: str | None = None  # Track which file loaded this service
+    # Roles assumed in order before assumed_role
+    role_chain: list[AssumedRoleConfig] = field(default_factory=list)
+    # File auth_token is read from, read again when it changes
+    auth_token_file: str | None = None
+
+
+@dataclass
+class ClientConfig:
+    """Client allowed to get the credentials of the services listed."""
+
+    services: list[str] = field(default_factory=list)  # Empty, denied every service
+    auth_token: str | None = None
+    client_cn: str | None = None  # Common name of the client certificate (mTLS)
 
 
 def _parse_directory_configs(
@@ -187,8 +393,14 @@ def _parse_directory_configs(
 
 
 def merge_aws_config(defaults: dict, overrides: dict) -> dict:
-    """Merge AWS configuration with defaults and service-specific overrides."""
+    """Merge AWS configuration with defaults and service-specific overrides.
+
+    An authentication method of the overrides replaces the one of the defaults.
+    """
     merged = defaults.copy() if defaults else {}
+    if any(method in overrides for method in SOURCE_CREDENTIALS_METHODS):
+        for method in SOURCE_CREDENTIALS_METHODS:
+            merged.pop(method, None)
 
     # Apply service-specific overrides
     for key, value in overrides.items():
@@ -207,6 +419,8 @@ class Config:
     services: dict[str, ServiceConfig] = field(default_factory=dict)
     dynamic_services: DynamicServicesConfig | None = None
     metrics: MetricsConfig = field(default_factory=MetricsConfig)
+    imds: IMDSConfig = field(default_factory=IMDSConfig)
+    clients: dict[str, ClientConfig] = field(default_factory=dict)
 
     # Token-to-service mapping for instant lookup
     _token_to_service: dict[str, str] = field(
@@ -218,6 +432,11 @@ class Config:
         default_factory=threading.RLock, init=False, repr=False
     )
 
+    # Modification time of each service auth token file when last read
+    _auth_token_mtimes: dict[str, int] = field(
+        default_factory=dict, init=False, repr=False
+    )
+
     # Class-level sanitizer for message sanitization
 
     def __post_init__(self):
@@ -226,15 +445,17 @@ class Config:
 
     def _build_token_mapping(self):
         """Build instant lookup mapping from tokens to service names."""
-        self._token_to_service.clear()
+        token_to_service = {}
         LOG.info("Building token mapping for %d services", len(self.services))
         for service_name, service_config in self.services.items():
-            self._token_to_service[service_config.auth_token] = service_name
+            token_to_service[service_config.auth_token] = service_name
             LOG.debug(
                 "Mapped token for service %s: %s...",
                 service_name,
                 service_config.auth_token[:8] + "...",
             )
+        # Swapped at once so lookups never see a partially built mapping
+        self._token_to_service = token_to_service
         LOG.info(
             "Token mapping built successfully with %d services",
             len(self._token_to_service),
@@ -242,7 +463,14 @@ class Config:
 
     def get_service_name_by_token(self, token: str) -> str | None:
         """Get service name by authorization token."""
-        service_name = self._token_to_service.get(token)
+        self.reload_auth_token_files()
+        service_name = None
+        provided = token.encode()
+        # Compare against every token in constant time so that response times
+        # do not reveal how much of a token matched
+        for candidate, candidate_service in self._token_to_service.items():
+            if hmac.compare_digest(candidate.encode(), provided):
+                service_name = candidate_service
         LOG.info("Token lookup for %s...: %s", token[:8] + "...", service_name)
         LOG.debug("Token registry contains %d tokens", len(self._token_to_service))
         LOG.debug("Available services: %s", list(self.services.keys()))
@@ -254,6 +482,59 @@ class Config:
             LOG.info("Available tokens in registry: %s", token_list)
         return service_name
 
+    def get_client_name_by_token(self, token: str) -> str | None:
+        """Get the name of the client of an authorization token."""
+        client_name = None
+        provided = token.encode()
+        # Compared in constant time, as service tokens
+        for candidate_name, client in self.clients.items():
+            if client.auth_token and hmac.compare_digest(
+                client.auth_token.encode(), provided
+            ):
+                client_name = candidate_name
+        return client_name
+
+    def get_client_name_by_cn(self, common_name: str) -> str | None:
+        """Get the name of the client of a client certificate common name."""
+        for client_name, client in self.clients.items():
+            if client.client_cn == common_name:
+                return client_name
+        return None
+
+    def reload_auth_token_files(self) -> None:
+        """Read again the auth token files changed since they were last read."""
+        with self._services_lock:
+            changed = False
+            for service_name, service_config in self.services.items():
+                token_file = service_config.auth_token_file
+                if not token_file:
+                    continue
+                try:
+                    mtime = os.stat(token_file).st_mtime_ns
+                    if self._auth_token_mtimes.get(service_name) == mtime:
+                        continue
+                    token, mtime = read_auth_token_file(token_file)
+                except (OSError, ValueError) as error:
+                    # Keep serving with the last token read
+                    LOG.warning(
+                        "Cannot read auth token file %s of service %s: %s",
+                        token_file,
+                        service_name,
+                        error,
+                    )
+                    continue
+                self._auth_token_mtimes[service_name] = mtime
+                if token != service_config.auth_token:
+                    LOG.info(
+                        "Reloaded auth token of service %s from %s",
+                        service_name,
+                        token_file,
+                    )
+                    service_config.auth_token = token
+                    changed = True
+            if changed:
+                self._build_token_mapping()
+
     def add_service(self, service_name: str, service_config: ServiceConfig) -> bool:
         """Add a new service dynamically."""
         with self._services_lock:
@@ -321,6 +602,12 @@ class Config:
     @classmethod
     def from_file(cls, config_path: str | None = None) -> Config:
         """Load configuration from YAML or JSON file."""
+        config_data, config_path = cls.read_config_file(config_path)
+        return cls.from_dict(config_data, config_path)
+
+    @classmethod
+    def read_config_file(cls, config_path: str | None = None) -> tuple[Any, str]:
+        """Read the YAML or JSON data of a configuration file, with its path."""
         if config_path is None:
             config_path = get_config_file(NAMESPACE)
         else:
@@ -352,7 +639,7 @@ class Config:
                     f"JSON error: {json_error}"
                 ) from error
 
-        return cls.from_dict(config_data, config_path)
+        return config_data, config_path
 
     @classmethod
     def from_dict(cls, config_data: dict, config_path: str | None = None) -> Config:
@@ -369,6 +656,7 @@ class Config:
         services_data = config_data.get("services", {})
         dynamic_services_data = config_data.get("dynamic_services", {})
         metrics_data = config_data.get("metrics", {})
+        imds_data = config_data.get("imds", {})
 
         # Create AWS defaults if provided
         aws_defaults = None
@@ -403,6 +691,7 @@ class Config:
         for service_name, service_config in services_data.items():
             source_creds_data = service_config.get("source_credentials", {})
             assumed_role_data = service_config.get("assumed_role", {})
+            role_chain_data = service_config.get("role_chain", [])
 
             # Merge defaults with service-specific overrides for source credentials
             merged_source_creds_data = merge_aws_config(
@@ -415,25 +704,29 @@ class Config:
             # Register sensitive values for sanitization
 
             # Register auth token
-            auth_token = keyisset("auth_token", service_config)
-            register_sensitive_value(auth_token)
+            auth_token = cls._read_service_auth_token(service_config)
 
             # Register credentials from source_credentials
             register_sensitive_dict(merged_source_creds_data)
 
-            # Register ExternalId if present
-            if "ExternalId" in assumed_role_data:
-                register_sensitive_value(assumed_role_data["ExternalId"])
+            # Register ExternalId of every hop if present
+            for role_data in [*role_chain_data, assumed_role_data]:
+                if "ExternalId" in role_data:
+                    register_sensitive_value(role_data["ExternalId"])
 
             services[service_name] = ServiceConfig(
                 auth_token=auth_token,
                 source_credentials=cls._create_source_credentials_config(
-                    merged_source_creds_data
+                    merged_source_creds_data, service_name
+                ),
+                assumed_role=cls._create_assumed_role_config(
+                    assumed_role_data, service_name
                 ),
-                assumed_role=cls._create_assumed_role_config(assumed_role_data),
                 source_file=str(Path(config_path).resolve())
                 if config_path
                 else "static_config",
+                role_chain=cls._create_role_chain_config(role_chain_data, service_name),
+                auth_token_file=service_config.get("auth_token_file"),
             )
 
         # Validate service configurations after inheritance
@@ -441,12 +734,16 @@ class Config:
 
         # Create metrics config
         prometheus_data = metrics_data.get("prometheus", {})
+        tracing_data = metrics_data.get("tracing", {})
         metrics = MetricsConfig(
             prometheus=PrometheusConfig(
-                enabled=set_else_none("enabled", prometheus_data, True),
+                enabled=set_else_none("enabled", prometheus_data, False),
                 host=set_else_none("host", prometheus_data, "0.0.0.0"),
                 port=set_else_none("port", prometheus_data, 9090),
-            )
+            ),
+            tracing=TracingConfig(
+                enabled=set_else_none("enabled", tracing_data, False)
+            ),
         )
 
         # Import LOG_HEALTH_CHECKS from settings for env var support
@@ -458,31 +755,81 @@ class Config:
         )
         log_health_checks = log_health_checks_config or LOG_HEALTH_CHECKS
 
+        admin_token = cls._read_admin_token(server_data)
+        if admin_token and any(
+            service.auth_token == admin_token for service in services.values()
+        ):
+            raise ValueError("server.admin_token must differ from the service tokens")
+        clients = cls._create_clients_config(
+            config_data.get("clients", {}), services, admin_token
+        )
+
         return cls(
             server=ServerConfig(
                 host=set_else_none("host", server_data, "localhost"),
                 port=set_else_none("port", server_data, 1338),
                 debug=set_else_none("debug", server_data, False),
                 log_health_checks=log_health_checks,
+                unix_socket=set_else_none("unix_socket", server_data, None),
+                named_pipe=set_else_none("named_pipe", server_data, None),
+                audit_log=set_else_none("audit_log", server_data, None),
+                shutdown_timeout=set_else_none("shutdown_timeout", server_data, 10.0),
+                read_timeout=set_else_none("read_timeout", server_data, 10.0),
+                write_timeout=set_else_none("write_timeout", server_data, 10.0),
+                idle_timeout=set_else_none("idle_timeout", server_data, 60.0),
+                admin_token=admin_token,
+                tls=cls._create_tls_config(server_data.get("tls")),
             ),
             credentials=CredentialsConfig(
                 refresh_buffer_seconds=set_else_none(
                     "refresh_buffer_seconds", creds_data, 300
                 ),
+                refresh_jitter_seconds=set_else_none(
+                    "refresh_jitter_seconds", creds_data, 0
+                ),
+                expiry_skew_seconds=set_else_none("expiry_skew_seconds", creds_data, 0),
                 retry_delay=set_else_none("retry_delay", creds_data, 60),
                 request_timeout=set_else_none("request_timeout", creds_data, 30),
+                sts_max_attempts=set_else_none("sts_max_attempts", creds_data, 3),
+                startup_max_wait=set_else_none("startup_max_wait", creds_data, 0),
+                cache_dir=set_else_none("cache_dir", creds_data, None),
+                cache_key_file=set_else_none("cache_key_file", creds_data, None),
+                rate_limit=cls._create_rate_limit_config(creds_data.get("rate_limit")),
+                circuit_breaker=cls._create_circuit_breaker_config(
+                    creds_data.get("circuit_breaker")
+                ),
             ),
             aws_defaults=aws_defaults,
             services=services,
             dynamic_services=dynamic_services,
             metrics=metrics,
+            imds=IMDSConfig(
+                enabled=set_else_none("enabled", imds_data, False),
+                mode=set_else_none("mode", imds_data, "v2-optional"),
+                service=set_else_none("service", imds_data, None),
+                identity=cls._create_imds_identity_config(
+                    imds_data.get("identity", {})
+                ),
+            ),
+            clients=clients,
         )
 
     @classmethod
-    def _create_source_credentials_config(cls, data: dict) -> SourceCredentialsConfig:
-        """Create SourceCredentialsConfig from dictionary data."""
+    def _create_source_credentials_config(
+        cls, data: dict, service_name: str | None = None
+    ) -> SourceCredentialsConfig:
+        """Create SourceCredentialsConfig from dictionary data.
+
+        Session names are expanded for service_name, and left as templates
+        without it.
+        """
         iam_profile_config = None
         iam_keys_config = None
+        sso_config = None
+        web_identity_config = None
+        saml_config = None
+        static_config = None
+        sources = None
 
         # Auto-detect auth method based on presence of config objects
         if "iam_profile" in data:
@@ -500,31 +847,234 @@ class Config:
                 ),
                 session_token=set_else_none("session_token", keys_data, None),
             )
-        # If neither iam_profile nor iam_keys present, use default SDK behavior
+        elif "sso" in data:
+            sso_data = data["sso"]
+            sso_config = SSOAuthConfig(
+                start_url=keyisset("start_url", sso_data),
+                sso_region=keyisset("sso_region", sso_data),
+                account_id=keyisset("account_id", sso_data),
+                role_name=keyisset("role_name", sso_data),
+            )
+        elif "web_identity" in data:
+            web_identity_data = data["web_identity"]
+            web_identity_config = WebIdentityAuthConfig(
+                token_file=keyisset("token_file", web_identity_data),
+                role_arn=keyisset("role_arn", web_identity_data),
+                role_session_name=cls._session_name(
+                    "role_session_name", web_identity_data, service_name
+                ),
+            )
+        elif "saml" in data:
+            saml_data = data["saml"]
+            saml_config = SAMLAuthConfig(
+                principal_arn=keyisset("principal_arn", saml_data),
+                role_arn=keyisset("role_arn", saml_data),
+                assertion_file=set_else_none("assertion_file", saml_data, None),
+                assertion_command=set_else_none("assertion_command", saml_data, None),
+            )
+        elif "static" in data:
+            static_data = data["static"]
+            static_config = StaticAuthConfig(
+                aws_access_key_id=set_else_none("aws_access_key_id", static_data, None),
+                aws_secret_access_key=set_else_none(
+                    "aws_secret_access_key", static_data, None
+                ),
+                session_token=set_else_none("session_token", static_data, None),
+                duration=set_else_none("duration", static_data, 3600),
+            )
+        elif "sources" in data:
+            sources = [
+                cls._create_source_credentials_config(source_data, service_name)
+                for source_data in data["sources"]
+            ]
+        # If no auth method is present, use default SDK behavior
+
+        sts_endpoint = set_else_none("sts_endpoint", data, None)
+        if sts_endpoint:
+            validate_endpoint_url(sts_endpoint)
+        http_proxy = set_else_none("http_proxy", data, None)
+        if http_proxy:
+            validate_endpoint_url(http_proxy)
+        # Fail at startup rather than on the first STS call
+        ca_bundle = set_else_none("ca_bundle", data, None)
+        if ca_bundle:
+            validate_ca_bundle(ca_bundle)
+        insecure_skip_verify = set_else_none("insecure_skip_verify", data, False)
+        if insecure_skip_verify:
+            LOG.warning(
+                "TLS verification of STS is disabled for %s, credentials may be "
+                "obtained from anyone intercepting the connections. Only use "
+                "insecure_skip_verify for testing",
+                service_name or "aws_defaults",
+            )
 
         return SourceCredentialsConfig(
             region=set_else_none("region", data, None),
+            sts_endpoint=sts_endpoint,
+            http_proxy=http_proxy,
+            ca_bundle=ca_bundle,
+            insecure_skip_verify=insecure_skip_verify,
             iam_profile=iam_profile_config,
             iam_keys=iam_keys_config,
+            sso=sso_config,
+            web_identity=web_identity_config,
+            saml=saml_config,
+            static=static_config,
+            sources=sources,
+        )
+
+    @classmethod
+    def _create_session_tags(cls, data: dict) -> list[dict] | None:
+        """Get the STS Tags of a role, checking TransitiveTagKeys are among them."""
+        tags = set_else_none("Tags", data, None)
+        if isinstance(tags, dict):
+            # Map of values by key, as Key/Value pairs for STS
+            tags = [{"Key": key, "Value": value} for key, value in tags.items()]
+
+        tag_keys = {tag["Key"] for tag in tags or []}
+        missing_keys = [
+            key
+            for key in set_else_none("TransitiveTagKeys", data, None) or []
+            if key not in tag_keys
+        ]
+        if missing_keys:
+            raise ValueError(
+                f"TransitiveTagKeys {missing_keys} of role {data.get('RoleArn')} "
+                "are not in its Tags"
+            )
+        return tags
+
+    @classmethod
+    def _create_tls_config(cls, data: dict | None) -> TLSConfig | None:
+        """Create TLSConfig from dictionary data, None when TLS is not enabled."""
+        if not data:
+            return None
+        return TLSConfig(
+            cert_file=keyisset("cert_file", data),
+            key_file=keyisset("key_file", data),
+            client_ca_file=set_else_none("client_ca_file", data, None),
+        )
+
+    @classmethod
+    def _create_imds_identity_config(cls, data: dict) -> IMDSIdentityConfig:
+        """Create IMDSIdentityConfig from dictionary data."""
+        return IMDSIdentityConfig(
+            region=set_else_none("region", data, None),
+            availability_zone=set_else_none("availability_zone", data, None),
+            account_id=set_else_none("account_id", data, None),
+            instance_id=set_else_none("instance_id", data, "i-00000000000000000"),
+            instance_type=set_else_none("instance_type", data, "t3.micro"),
+            image_id=set_else_none("image_id", data, "ami-00000000000000000"),
+            private_ip=set_else_none("private_ip", data, "127.0.0.1"),
+            architecture=set_else_none("architecture", data, "x86_64"),
         )
 
     @classmethod
-    def _create_assumed_role_config(cls, data: dict) -> AssumedRoleConfig:
+    def _create_rate_limit_config(cls, data: dict | None) -> RateLimitConfig | None:
+        """Create RateLimitConfig from dictionary data, None when not limited."""
+        if not data:
+            return None
+        return RateLimitConfig(
+            requests_per_second=keyisset("requests_per_second", data),
+            burst=set_else_none("burst", data, None),
+            sts_requests_only=set_else_none("sts_requests_only", data, False),
+        )
+
+    @classmethod
+    def _create_circuit_breaker_config(
+        cls, data: dict | None
+    ) -> CircuitBreakerConfig | None:
+        """Create CircuitBreakerConfig from dictionary data, None when disabled."""
+        if data is None:
+            return None
+        return CircuitBreakerConfig(
+            failure_threshold=set_else_none("failure_threshold", data, 5),
+            window_seconds=set_else_none("window_seconds", data, 60.0),
+            cooldown_seconds=set_else_none("cooldown_seconds", data, 30.0),
+        )
+
+    @classmethod
+    def _create_assumed_role_config(
+        cls, data: dict, service_name: str | None = None
+    ) -> AssumedRoleConfig:
         """Create AssumedRoleConfig from dictionary data."""
         return AssumedRoleConfig(
             RoleArn=keyisset("RoleArn", data),
-            RoleSessionName=set_else_none("RoleSessionName", data, "credproxy"),
+            RoleSessionName=cls._session_name("RoleSessionName", data, service_name),
             DurationSeconds=set_else_none("DurationSeconds", data, 900),
             ExternalId=set_else_none("ExternalId", data, None),
             PolicyArns=set_else_none("PolicyArns", data, None),
             Policy=set_else_none("Policy", data, None),
-            Tags=set_else_none("Tags", data, None),
+            Tags=cls._create_session_tags(data),
             TransitiveTagKeys=set_else_none("TransitiveTagKeys", data, None),
             SerialNumber=set_else_none("SerialNumber", data, None),
             TokenCode=set_else_none("TokenCode", data, None),
             SourceIdentity=set_else_none("SourceIdentity", data, None),
         )
 
+    @classmethod
+    def _create_role_chain_config(
+        cls, data: list, service_name: str | None = None
+    ) -> list[AssumedRoleConfig]:
+        """Create the ordered list of chained AssumedRoleConfig."""
+        return [
+            cls._create_assumed_role_config(role_data, service_name)
+            for role_data in data
+        ]
+
+    @classmethod
+    def _session_name(cls, key: str, data: dict, service_name: str | None) -> str:
+        """Get a session name template, expanded for service_name if set."""
+        session_name = set_else_none(key, data, DEFAULT_SESSION_NAME)
+        if service_name is None:
+            return session_name
+        return expand_session_name(session_name, service_name)
+
+    @classmethod
+    def _read_service_auth_token(cls, data: dict) -> str:
+        """Get the service auth token, reading it from auth_token_file if set."""
+        if "auth_token_file" in data:
+            auth_token, _ = read_auth_token_file(data["auth_token_file"])
+            return auth_token
+        auth_token = keyisset("auth_token", data)
+        register_sensitive_value(auth_token)
+        return auth_token
+
+    @classmethod
+    def _read_admin_token(cls, server_data: dict) -> str | None:
+        """Get the admin endpoints token, registered as a sensitive value."""
+        admin_token = set_else_none("admin_token", server_data, None)
+        if admin_token:
+            register_sensitive_value(admin_token)
+        return admin_token
+
+    @classmethod
+    def _create_clients_config(
+        cls,
+        clients_data: dict,
+        services: dict[str, ServiceConfig],
+        admin_token: str | None,
+    ) -> dict[str, ClientConfig]:
+        """Create the clients, whose tokens must differ from the other tokens."""
+        tokens = {service.auth_token for service in services.values()}
+        clients = {}
+        for client_name, client_data in clients_data.items():
+            auth_token = set_else_none("auth_token", client_data, None)
+            if auth_token:
+                register_sensitive_value(auth_token)
+                if auth_token in tokens or auth_token == admin_token:
+                    raise ValueError(
+                        f"Token of client {client_name} must differ from the "
+                        "service, admin and other client tokens"
+                    )
+                tokens.add(auth_token)
+            clients[client_name] = ClientConfig(
+                services=client_data["services"],
+                auth_token=auth_token,
+                client_cn=set_else_none("client_cn", client_data, None),
+            )
+        return clients
+
     @classmethod
     def _source_credentials_config_to_dict(
         cls, source_config: SourceCredentialsConfig | None
@@ -536,6 +1086,14 @@ class Config:
         result: dict = {
             "region": source_config.region,
         }
+        if source_config.sts_endpoint:
+            result["sts_endpoint"] = source_config.sts_endpoint
+        if source_config.http_proxy:
+            result["http_proxy"] = source_config.http_proxy
+        if source_config.ca_bundle:
+            result["ca_bundle"] = source_config.ca_bundle
+        if source_config.insecure_skip_verify:
+            result["insecure_skip_verify"] = True
 
         if source_config.iam_profile:
             result["iam_profile"] = {
@@ -548,20 +1106,50 @@ class Config:
                 "aws_secret_access_key": source_config.iam_keys.aws_secret_access_key,
                 "session_token": source_config.iam_keys.session_token,
             }
+        elif source_config.sso:
+            result["sso"] = {
+                "start_url": source_config.sso.start_url,
+                "sso_region": source_config.sso.sso_region,
+                "account_id": source_config.sso.account_id,
+                "role_name": source_config.sso.role_name,
+            }
+        elif source_config.web_identity:
+            result["web_identity"] = {
+                "token_file": source_config.web_identity.token_file,
+                "role_arn": source_config.web_identity.role_arn,
+                "role_session_name": source_config.web_identity.role_session_name,
+            }
+        elif source_config.saml:
+            result["saml"] = {
+                "principal_arn": source_config.saml.principal_arn,
+                "role_arn": source_config.saml.role_arn,
+                "assertion_file": source_config.saml.assertion_file,
+                "assertion_command": source_config.saml.assertion_command,
+            }
+        elif source_config.static:
+            result["static"] = {
+                "aws_access_key_id": source_config.static.aws_access_key_id,
+                "aws_secret_access_key": source_config.static.aws_secret_access_key,
+                "session_token": source_config.static.session_token,
+                "duration": source_config.static.duration,
+            }
+        elif source_config.sources:
+            result["sources"] = [
+                cls._source_credentials_config_to_dict(source)
+                for source in source_config.sources
+            ]
 
         return result
 
     @classmethod
     def validate_schema(cls, config_data: dict) -> None:
         """Validate configuration data against JSON schema."""
-        schema_path = Path(__file__).parent / "config-schema.json"
-
-        if not schema_path.exists():
-            LOG.warning("JSON schema file not found at %s", schema_path)
+        if not SCHEMA_PATH.exists():
+            LOG.warning("JSON schema file not found at %s", SCHEMA_PATH)
             return
 
         try:
-            with open(schema_path, encoding="utf-8") as f:
+            with open(SCHEMA_PATH, encoding="utf-8") as f:
                 schema = json.load(f)
 
             # Validate the config data
@@ -596,6 +1184,29 @@ class Config:
             LOG.error("Error validating configuration against schema: %s", str(error))
             raise ValueError(f"Schema validation error: {str(error)}") from error
 
+    @classmethod
+    def _validate_partition(
+        cls, service_name: str, service_config: ServiceConfig
+    ) -> None:
+        """Check the ARNs of a service are in the partition of its region."""
+        source_credentials = service_config.source_credentials
+        if not source_credentials.region:
+            return
+        arns = []
+        for source in [source_credentials, *(source_credentials.sources or [])]:
+            if source.web_identity:
+                arns.append(source.web_identity.role_arn)
+            if source.saml:
+                arns += [source.saml.principal_arn, source.saml.role_arn]
+        for role_config in [*service_config.role_chain, service_config.assumed_role]:
+            arns.append(role_config.RoleArn)
+            if role_config.SerialNumber and role_config.SerialNumber.startswith("arn:"):
+                arns.append(role_config.SerialNumber)
+        try:
+            check_partition(source_credentials.region, arns)
+        except ValueError as error:
+            raise ValueError(f"Invalid service '{service_name}': {error}") from error
+
     @classmethod
     def _validate_services(
         cls,
@@ -617,12 +1228,14 @@ class Config:
             source_creds = service_config.source_credentials
             assumed_role = service_config.assumed_role
 
-            if not source_creds.region:
+            # Static credentials are served without calling STS
+            if not source_creds.region and not source_creds.static:
                 raise ValueError(f"AWS region is required for service '{service_name}'")
             if not assumed_role.RoleArn:
                 raise ValueError(
                     f"AWS role ARN is required for service '{service_name}'"
                 )
+            cls._validate_partition(service_name, service_config)
 
             # No additional validation needed:
             # - JSON schema validates required fields within auth sections
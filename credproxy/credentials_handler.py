@@ -3,19 +3,158 @@
 
 from __future__ import annotations
 
+import os
+import json
 import time
+import random
+import hashlib
 import threading
 from typing import TYPE_CHECKING
-from dataclasses import asdict, dataclass
+from datetime import datetime, timezone
+from functools import cached_property
+from contextvars import ContextVar
+from dataclasses import field, asdict, dataclass
 
 import boto3
-from botocore.exceptions import ClientError
+from botocore.config import Config as BotoConfig
+from botocore.exceptions import ClientError, BotoCoreError, NoCredentialsError
 
+from credproxy.mfa import MFA_MAX_ATTEMPTS, StdinMFAProvider
+from credproxy.sso import SSORoleProvider, SSOTokenProvider
+from credproxy.clock import SYSTEM_CLOCK
+from credproxy.saml import SAMLAssertionProvider
+from credproxy.static import static_credentials
+from credproxy.retry import NO_CLIENT_RETRIES, StsRetryPolicy, is_throttling
 from credproxy.logger import LOG
+from credproxy.metrics import (
+    record_refresh,
+    track_credentials_expiry,
+    record_sts_assume_duration,
+    untrack_credentials_expiry,
+)
+from credproxy.tracing import span, tracing_enabled, set_span_attribute
+from credproxy.sts_http import StsHttpOptions
+from credproxy.rate_limit import RateLimitExceeded, TokenBucketRateLimiter
+from credproxy.singleflight import SingleFlight
+from credproxy.web_identity import WebIdentityTokenProvider
+from credproxy.circuit_breaker import CircuitOpen, CircuitStatus, StsCircuitBreaker
 
 
 if TYPE_CHECKING:
-    from credproxy.config import Config, ServiceConfig
+    from collections.abc import Mapping, Callable
+
+    from credproxy.mfa import MFAProvider
+    from credproxy.clock import Clock
+    from credproxy.config import (
+        Config,
+        SSOAuthConfig,
+        SAMLAuthConfig,
+        ServiceConfig,
+        RateLimitConfig,
+        AssumedRoleConfig,
+        CircuitBreakerConfig,
+        WebIdentityAuthConfig,
+        SourceCredentialsConfig,
+    )
+    from credproxy.providers import CredentialsProvider
+    from credproxy.disk_cache import DiskCredentialsCache
+
+
+# Seconds between background checks for credentials entering the refresh window
+REFRESH_CHECK_INTERVAL = 15
+# Seconds to wait on shutdown for an in-progress refresh before abandoning it
+REFRESHER_STOP_TIMEOUT = 2
+# Seconds before retrying a throttled refresh, doubled on every throttled attempt
+# up to credentials.retry_delay
+THROTTLING_BACKOFF_BASE = 2
+# Seconds before fetching again credentials failing at startup, doubled on every
+# attempt up to credentials.retry_delay
+STARTUP_BACKOFF_BASE = 1
+# Longest DurationSeconds STS allows for sessions assumed with role credentials
+ROLE_CHAINING_MAX_DURATION = 3600
+
+# Environment variable of the AWS SDKs choosing between global and regional STS
+STS_REGIONAL_ENDPOINTS_ENV = "AWS_STS_REGIONAL_ENDPOINTS"
+
+# UTC RFC3339 without fractional seconds, as served by the ECS agent
+EXPIRATION_FORMAT = "%Y-%m-%dT%H:%M:%SZ"
+
+
+class MFAPromptRequired(Exception):
+    """Raised when credentials cannot be obtained without prompting for MFA."""
+
+
+class CredentialsTimeout(TimeoutError):
+    """Raised when credentials are not obtained within the request timeout."""
+
+    def __init__(self, timeout: float):
+        self.timeout = timeout
+        super().__init__(f"Timed out after {timeout} seconds getting credentials")
+
+
+class CredentialsStarting(Exception):
+    """Raised when credentials failing at startup are not fetched again yet."""
+
+    def __init__(self, service_name: str, retry_after: float):
+        self.retry_after = retry_after
+        super().__init__(
+            f"Credentials of {service_name} are not available yet, "
+            f"retrying in {retry_after:.0f} seconds"
+        )
+
+
+class CredentialSourcesError(BotoCoreError):
+    """Raised when no source of the fallback chain of a service works."""
+
+    fmt = "All credential sources of {service_name} failed: {reasons}"
+
+    def __init__(self, service_name: str, failures: list[tuple[str, str]]):
+        self.failures = failures
+        super().__init__(
+            service_name=service_name,
+            reasons="; ".join(f"{source}: {reason}" for source, reason in failures),
+        )
+
+
+@dataclass
+class ContainerCredentialsResponse:
+    """Credentials body served by the ECS container credentials endpoint.
+
+    Field names match the JSON keys the AWS SDKs expect when reading
+    AWS_CONTAINER_CREDENTIALS_FULL_URI.
+    """
+
+    AccessKeyId: str
+    SecretAccessKey: str
+    Token: str
+    Expiration: str
+    RoleArn: str
+
+
+@dataclass
+class CredentialProcessResponse:
+    """Credentials printed for the AWS CLI credential_process setting.
+
+    Field names match the JSON keys of the version 1 credential_process output.
+    """
+
+    AccessKeyId: str
+    SecretAccessKey: str
+    SessionToken: str
+    Expiration: str
+    Version: int = 1
+
+    @classmethod
+    def from_container_credentials(
+        cls, credentials: dict
+    ) -> CredentialProcessResponse:
+        """Build the output from a container credentials response body."""
+        return cls(
+            AccessKeyId=credentials["AccessKeyId"],
+            SecretAccessKey=credentials["SecretAccessKey"],
+            SessionToken=credentials["Token"],
+            Expiration=credentials["Expiration"],
+        )
 
 
 @dataclass
@@ -26,10 +165,28 @@ class ServiceCredentialsManager:
     aws_secret_access_key: str
     session_token: str
     expiry: float
+    role_arn: str = ""
+    cache_key: str | None = None  # Fingerprint of the role chain
+    # Seconds added to the refresh window, drawn within the jitter band
+    refresh_offset: float = 0.0
+    # When the credentials were obtained from STS
+    obtained_at: float = field(default_factory=time.time)
+    # Role session ID of STS, the principal CloudTrail records calls made with
+    assumed_role_id: str | None = None
+    # Clock the expiry is checked against
+    clock: Clock = field(default=SYSTEM_CLOCK, repr=False, compare=False)
 
-    def is_expired(self) -> bool:
-        """Check if credentials are expired."""
-        return time.time() > self.expiry
+    def is_expired(self, skew: float = 0.0) -> bool:
+        """Check if credentials are expired, skew seconds before their expiry."""
+        return self.clock.now() > self.expiry - skew
+
+    def needs_refresh(self, refresh_window: float, skew: float = 0.0) -> bool:
+        """Check if credentials expire within refresh_window seconds.
+
+        The window stacks with skew, refreshing skew seconds earlier.
+        """
+        window = max(refresh_window + self.refresh_offset, 0) + skew
+        return self.clock.now() > self.expiry - window
 
     def get_sensitive_values(self) -> list[str]:
         """Get list of sensitive values that should be sanitized.
@@ -43,31 +200,170 @@ class ServiceCredentialsManager:
             self.session_token,
         ]
 
+    @cached_property
+    def expiration(self) -> str:
+        """Expiration of the credentials, formatted as served to the clients."""
+        expiration = datetime.fromtimestamp(self.expiry, tz=timezone.utc)
+        return expiration.strftime(EXPIRATION_FORMAT)
+
+    @cached_property
+    def response_body(self) -> bytes:
+        """JSON body of the response, marshaled once per set of credentials.
+
+        Marshaled as Flask marshals JSON responses, served as it is on every
+        cache hit.
+        """
+        body = json.dumps(self.to_dict(), separators=(",", ":"), sort_keys=True)
+        return f"{body}\n".encode()
+
+    def to_response(self) -> ContainerCredentialsResponse:
+        """Build the ECS container credentials response for these credentials."""
+        return ContainerCredentialsResponse(
+            AccessKeyId=self.aws_access_key_id,
+            SecretAccessKey=self.aws_secret_access_key,
+            Token=self.session_token,
+            Expiration=self.expiration,
+            RoleArn=self.role_arn,
+        )
+
     def to_dict(self) -> dict:
         """Convert to dictionary format for API response."""
-        from datetime import datetime, timezone
+        return asdict(self.to_response())
 
-        expiration_iso = datetime.fromtimestamp(self.expiry, tz=timezone.utc).strftime(
-            "%Y-%m-%dT%H:%M:%S.%fZ"
-        )
-        return {
-            "AccessKeyId": self.aws_access_key_id,
-            "SecretAccessKey": self.aws_secret_access_key,
-            "Token": self.session_token,
-            "Expiration": expiration_iso,
-        }
+
+@dataclass
+class CredentialsLookup:
+    """How the credentials of a get_credentials call were obtained."""
+
+    cache: str  # "hit" or "miss"
+    sts_duration: float | None = None  # Seconds spent assuming roles on a miss
+    assumed_role_id: str | None = None  # Role session ID of the credentials
+
+
+@dataclass
+class ServiceCredentialsStatus:
+    """State of the cached credentials of a service, without any secret value."""
+
+    source: str  # Method of the source credentials, or of the source in use
+    role_arn: str
+    cache: str  # "empty", "valid" or "expired"
+    expiry: float | None = None
+    last_refresh: float | None = None  # When the cached credentials were obtained
+    last_error: str | None = None  # Why the last role assumption failed, if it did
+    refreshing: bool = False
+
+
+# Lookup of the last get_credentials call in the current context, for request logs
+CREDENTIALS_LOOKUP: ContextVar[CredentialsLookup | None] = ContextVar(
+    "credentials_lookup", default=None
+)
 
 
 class CredentialsHandler:
     """Simple credentials handler with caching and expiry."""
 
-    def __init__(self, config: Config):
+    def __init__(
+        self,
+        config: Config,
+        mfa_provider: MFAProvider | None = None,
+        disk_cache: DiskCredentialsCache | None = None,
+        source_providers: Mapping[str, CredentialsProvider] | None = None,
+        clock: Clock = SYSTEM_CLOCK,
+    ):
         self.config = config
+        # Time the expiry of credentials is checked against
+        self.clock = clock
+        self.mfa_provider = mfa_provider or StdinMFAProvider()
+        # Providers of the application, by service, replacing configured methods
+        self.source_providers = dict(source_providers or {})
+        # Encrypted copy of the cache, reused after a restart
+        self.disk_cache = disk_cache
+        # Regional STS endpoints have lower latency and do not depend on
+        # us-east-1, unless the global endpoint is explicitly requested
+        os.environ.setdefault(STS_REGIONAL_ENDPOINTS_ENV, "regional")
         self.cache: dict[str, ServiceCredentialsManager] = {}
         self._cache_lock = threading.RLock()
+        # Readiness: any credentials obtained, and services whose last fetch
+        # failed with why
+        self._credentials_obtained = False
+        self._failed_services: dict[str, str] = {}
         self._cleanup_thread: threading.Thread | None = None
         self._stop_cleanup = threading.Event()
+        self._refreshing: set[str] = set()
+        # Throttled attempts and time of the next refresh, by service name
+        self._refresh_backoff: dict[str, tuple[int, float]] = {}
+        self._refresh_lock = threading.Lock()
+        # Notified whenever a refresh in flight completes
+        self._refresh_done = threading.Condition(self._refresh_lock)
+        # Role assumptions in flight, shared by concurrent misses and refreshes
+        self._fetches = SingleFlight()
+        self._refresher_thread: threading.Thread | None = None
+        self._stop_refresher = threading.Event()
+        # Services failing at startup, with when they are fetched again
+        self._starting: dict[str, float] = {}
+        self._startup_thread: threading.Thread | None = None
+        self._sso_providers: dict[tuple[str, str], SSOTokenProvider] = {}
+        self._sso_lock = threading.Lock()
+        # Keyed by token file, role ARN, session name, region, endpoint and options
+        self._web_identity_providers: dict[
+            tuple[str, str, str, str | None, str | None, StsHttpOptions | None],
+            WebIdentityTokenProvider,
+        ] = {}
+        self._web_identity_lock = threading.Lock()
+        self._saml_providers: dict[tuple, SAMLAssertionProvider] = {}
+        self._saml_lock = threading.Lock()
+        # Source of the fallback chain of a service used last, with _cache_lock
+        self._credential_sources: dict[str, SourceCredentialsConfig] = {}
+        # Limiter of the client requests, with the settings it was created from
+        self._rate_limiter: TokenBucketRateLimiter | None = None
+        self._rate_limit_config: RateLimitConfig | None = None
+        self._rate_limiter_lock = threading.Lock()
+        # Circuit breakers of STS by region, with the settings they were created from
+        self._sts_circuits: dict[str, StsCircuitBreaker] = {}
+        self._circuit_breaker_config: CircuitBreakerConfig | None = None
+        self._sts_circuits_lock = threading.Lock()
+        self._load_disk_cache()
         self._start_cache_cleanup()
+        self._start_refresher()
+
+    def _load_disk_cache(self) -> None:
+        """Cache the unexpired credentials of the disk cache for every service."""
+        if self.disk_cache is None:
+            return
+        from credproxy.sanitizer import register_sensitive_value
+
+        for service_name, service_config in list(self.config.services.items()):
+            cache_key = self._cache_key(service_config)
+            stored = self.disk_cache.load(service_name, cache_key)
+            if stored is None:
+                continue
+            service_creds = ServiceCredentialsManager(
+                **stored,
+                cache_key=cache_key,
+                refresh_offset=self._refresh_offset(),
+                clock=self.clock,
+            )
+            for value in service_creds.get_sensitive_values():
+                register_sensitive_value(value)
+            with self._cache_lock:
+                self.cache[service_name] = service_creds
+                self._credentials_obtained = True
+            track_credentials_expiry(service_name, service_creds.expiry)
+            LOG.info("Loaded cached credentials of %s from disk", service_name)
+
+    def _store_on_disk(
+        self, service_name: str, service_creds: ServiceCredentialsManager
+    ) -> None:
+        """Write credentials to the disk cache, if any, logging failures."""
+        if self.disk_cache is None:
+            return
+        stored = asdict(service_creds)
+        del stored["cache_key"], stored["refresh_offset"], stored["clock"]
+        try:
+            self.disk_cache.store(service_name, service_creds.cache_key, stored)
+        except OSError as error:
+            LOG.error("Failed to write the credentials of %s to disk", service_name)
+            LOG.exception(error)
 
     def _start_cache_cleanup(self) -> None:
         """Start background thread for periodic cache cleanup."""
@@ -85,17 +381,10 @@ class CredentialsHandler:
                         expired_services = [
                             service_name
                             for service_name, creds in self.cache.items()
-                            if creds.is_expired()
+                            if creds.is_expired(self._expiry_skew)
                         ]
                         for service_name in expired_services:
-                            # Unregister sensitive values before removing from cache
-                            from credproxy.sanitizer import unregister_sensitive_value
-
-                            creds = self.cache[service_name]
-                            for value in creds.get_sensitive_values():
-                                unregister_sensitive_value(value)
-
-                            del self.cache[service_name]
+                            self._evict(service_name)
                             LOG.debug(
                                 "Removed expired credentials from cache: %s",
                                 service_name,
@@ -116,8 +405,278 @@ class CredentialsHandler:
         self._cleanup_thread.start()
         LOG.debug("Started background cache cleanup thread")
 
+    def _start_refresher(self) -> None:
+        """Start background thread refreshing credentials ahead of expiry."""
+
+        def refresh_expiring():
+            """Periodically refresh credentials entering the refresh window."""
+            while not self._stop_refresher.wait(timeout=REFRESH_CHECK_INTERVAL):
+                try:
+                    refresh_window = self.config.credentials.refresh_buffer_seconds
+                    with self._cache_lock:
+                        expiring_services = [
+                            service_name
+                            for service_name, creds in self.cache.items()
+                            if not creds.is_expired(self._expiry_skew)
+                            and creds.needs_refresh(refresh_window, self._expiry_skew)
+                            and not self._requires_mfa_prompt(service_name)
+                        ]
+                    for service_name in expiring_services:
+                        if self._stop_refresher.is_set():
+                            break
+                        if self._claim_refresh(service_name):
+                            self._refresh_credentials(service_name)
+                except Exception as error:
+                    LOG.error("Error during proactive credentials refresh")
+                    LOG.exception(error)
+
+        self._refresher_thread = threading.Thread(
+            target=refresh_expiring, daemon=True, name="credentials-refresher"
+        )
+        self._refresher_thread.start()
+        LOG.debug("Started background credentials refresher thread")
+
+    def start_startup_fetches(
+        self, max_wait: float = 0, on_give_up: Callable[[], object] | None = None
+    ) -> None:
+        """Fetch the credentials of every service in the background.
+
+        Services failing are fetched again with exponential backoff until their
+        credentials are obtained, their cache misses raising CredentialsStarting
+        meanwhile. on_give_up is called once max_wait seconds elapsed with
+        services still failing, unless max_wait is 0.
+        """
+
+        def fetch_all():
+            deadline = time.monotonic() + max_wait if max_wait else None
+            with self._cache_lock:
+                pending = [
+                    service_name
+                    for service_name in self.config.services
+                    if service_name not in self.cache
+                    and not self._requires_mfa_prompt(service_name)
+                ]
+            attempts = 0
+            while pending and not self._stop_refresher.is_set():
+                attempts += 1
+                pending = [
+                    service_name
+                    for service_name in pending
+                    if not self._fetched_at_startup(service_name)
+                ]
+                if not pending:
+                    LOG.info("Obtained the credentials of every service")
+                    return
+                if deadline is not None and time.monotonic() >= deadline:
+                    LOG.error(
+                        "Credentials of %s still failing after %d seconds",
+                        ", ".join(pending),
+                        max_wait,
+                    )
+                    if on_give_up:
+                        on_give_up()
+                    return
+                delay = min(
+                    STARTUP_BACKOFF_BASE * 2 ** (attempts - 1),
+                    self.config.credentials.retry_delay,
+                )
+                if deadline is not None:
+                    delay = min(delay, max(deadline - time.monotonic(), 0))
+                with self._cache_lock:
+                    for service_name in pending:
+                        self._starting[service_name] = time.time() + delay
+                LOG.warning(
+                    "Fetching the credentials of %s again in %.0f seconds",
+                    ", ".join(pending),
+                    delay,
+                )
+                self._stop_refresher.wait(timeout=delay)
+
+        self._startup_thread = threading.Thread(
+            target=fetch_all, daemon=True, name="startup-fetches"
+        )
+        self._startup_thread.start()
+        LOG.debug("Started background startup fetches thread")
+
+    def _fetched_at_startup(self, service_name: str) -> bool:
+        """Fetch the credentials of a service at startup, unless already cached.
+
+        Services removed from the configuration count as fetched.
+        """
+        with self._cache_lock:
+            cached = self.cache.get(service_name)
+            if service_name not in self.config.services or (
+                cached and not cached.is_expired(self._expiry_skew)
+            ):
+                self._starting.pop(service_name, None)
+                return True
+        try:
+            self._fetch_shared(service_name)
+        except Exception as error:
+            LOG.warning(
+                "Failed to get the credentials of %s at startup: %s",
+                service_name,
+                error,
+            )
+            return False
+        with self._cache_lock:
+            self._starting.pop(service_name, None)
+        return True
+
+    def _startup_retry_after(self, service_name: str) -> float | None:
+        """Get the seconds until a service failing at startup is fetched again."""
+        with self._cache_lock:
+            next_attempt = self._starting.get(service_name)
+        if next_attempt is None:
+            return None
+        retry_after = next_attempt - time.time()
+        return retry_after if retry_after > 0 else None
+
+    def _claim_refresh(self, service_name: str) -> bool:
+        """Mark a refresh as in flight, returning False if one already is."""
+        with self._refresh_lock:
+            if service_name in self._refreshing:
+                return False
+            # Wait for the backoff of a throttled refresh to elapse
+            backoff = self._refresh_backoff.get(service_name)
+            if backoff and time.time() < backoff[1]:
+                return False
+            self._refreshing.add(service_name)
+            return True
+
+    def _back_off_refresh(self, service_name: str) -> float:
+        """Delay the next refresh of a throttled service, returning the delay."""
+        with self._refresh_lock:
+            attempts = self._refresh_backoff.get(service_name, (0, 0.0))[0] + 1
+            longest = min(
+                THROTTLING_BACKOFF_BASE * 2 ** (attempts - 1),
+                self.config.credentials.retry_delay,
+            )
+            # At least half the backoff, so throttled instances spread out
+            delay = random.uniform(longest / 2, longest)
+            self._refresh_backoff[service_name] = (attempts, time.time() + delay)
+        return delay
+
+    def _schedule_refresh(self, service_name: str) -> None:
+        """Refresh credentials in the background unless already in flight."""
+        if not self._claim_refresh(service_name):
+            LOG.debug("Refresh in progress or backing off for %s", service_name)
+            return
+        threading.Thread(
+            target=self._refresh_credentials,
+            args=(service_name,),
+            daemon=True,
+            name=f"refresh-{service_name}",
+        ).start()
+
+    def _refresh_credentials(self, service_name: str) -> None:
+        """Re-assume the role for a claimed service, keeping cache on failure."""
+        try:
+            LOG.info("Proactively rotating credentials for %s", service_name)
+            self._fetch_shared(service_name)
+            record_refresh("success")
+            with self._refresh_lock:
+                self._refresh_backoff.pop(service_name, None)
+        except Exception as error:
+            # Cached credentials are still valid, keep serving them
+            record_refresh("failure")
+            if isinstance(error, CircuitOpen):
+                with self._refresh_lock:
+                    attempts = self._refresh_backoff.get(service_name, (0, 0.0))[0]
+                    self._refresh_backoff[service_name] = (
+                        attempts,
+                        time.time() + error.retry_after,
+                    )
+                LOG.warning(
+                    "Not refreshing %s while %s, serving cached credentials",
+                    service_name,
+                    error,
+                )
+            elif is_throttling(error):
+                LOG.warning(
+                    "STS throttled the refresh of %s, retrying in %.1f seconds and "
+                    "serving cached credentials",
+                    service_name,
+                    self._back_off_refresh(service_name),
+                )
+            else:
+                LOG.error(
+                    "Failed to refresh credentials for %s, serving cached credentials",
+                    service_name,
+                )
+                LOG.exception(error)
+        finally:
+            self._release_refresh(service_name)
+
+    def _release_refresh(self, service_name: str) -> None:
+        """Mark the refresh of a service as completed, waking up waiting calls."""
+        with self._refresh_done:
+            self._refreshing.discard(service_name)
+            self._refresh_done.notify_all()
+
+    def force_refresh(self, service_name: str) -> ServiceCredentialsManager:
+        """Assume the role of a service again, replacing its cached credentials.
+
+        A refresh already in flight is waited for, so that the role is never
+        assumed twice at once, and the backoff of throttled refreshes is ignored.
+        Cached credentials are kept if the role cannot be assumed.
+        """
+        if self._requires_mfa_prompt(service_name):
+            raise MFAPromptRequired(
+                f"Assuming the role of {service_name} prompts for an MFA code"
+            )
+        with self._refresh_done:
+            while service_name in self._refreshing:
+                self._refresh_done.wait()
+            self._refreshing.add(service_name)
+        try:
+            LOG.info("Forcing the refresh of credentials for %s", service_name)
+            service_creds = self._fetch_shared(service_name)
+            with self._refresh_lock:
+                self._refresh_backoff.pop(service_name, None)
+            return service_creds
+        finally:
+            self._release_refresh(service_name)
+
+    def _evict(self, service_name: str) -> bool:
+        """Remove the cached credentials of a service, if any."""
+        with self._cache_lock:
+            creds = self.cache.pop(service_name, None)
+            self._failed_services.pop(service_name, None)
+        if self.disk_cache is not None:
+            self.disk_cache.remove(service_name)
+        if creds is None:
+            return False
+        # Unregister sensitive values once removed from cache
+        from credproxy.sanitizer import unregister_sensitive_value
+
+        for value in creds.get_sensitive_values():
+            unregister_sensitive_value(value)
+        untrack_credentials_expiry(service_name)
+        return True
+
+    def discard_credentials(self, service_name: str) -> None:
+        """Discard the cached credentials of a service removed or changed."""
+        if self._evict(service_name):
+            LOG.info("Discarded cached credentials of %s", service_name)
+
     def cleanup(self) -> None:
         """Clean up resources during graceful shutdown."""
+        # Stop the refresher thread
+        if self._refresher_thread:
+            LOG.info("Stopping credentials refresher thread")
+            self._stop_refresher.set()
+            self._refresher_thread.join(timeout=REFRESHER_STOP_TIMEOUT)
+            if self._refresher_thread.is_alive():
+                # Blocked on an STS call, the daemon thread is abandoned
+                LOG.warning("Abandoning credentials refresh waiting on STS")
+            else:
+                LOG.info("Credentials refresher thread stopped")
+
+        if self._startup_thread:
+            # Stopped with the refresher, unless waiting on STS
+            self._startup_thread.join(timeout=REFRESHER_STOP_TIMEOUT)
+
         # Stop the cleanup thread
         if self._cleanup_thread:
             LOG.info("Stopping cache cleanup thread")
@@ -135,35 +694,157 @@ class CredentialsHandler:
                 # Unregister all sensitive values
                 from credproxy.sanitizer import unregister_sensitive_value
 
-                for creds in self.cache.values():
+                for service_name, creds in self.cache.items():
                     for value in creds.get_sensitive_values():
                         unregister_sensitive_value(value)
+                    untrack_credentials_expiry(service_name)
 
                 self.cache.clear()
                 LOG.info("Credential cache cleared successfully")
             else:
                 LOG.info("No cached credentials to clean up")
 
-    def get_credentials(self, service_name: str) -> dict:
-        """Get credentials for a service, using cache if not expired."""
+    def get_credentials(self, service_name: str, client: str | None = None) -> dict:
+        """Get credentials for a service, using cache if not expired.
+
+        Cached credentials within the refresh window are still served while a
+        single background refresh re-assumes the role, and concurrent misses
+        share a single role assumption. Requests of a client are rate limited if
+        configured, raising RateLimitExceeded. Misses not answered within the
+        request timeout raise CredentialsTimeout, the role assumption going on.
+        Misses of services failing since startup raise CredentialsStarting until
+        their backoff elapsed.
+        """
+        return self.get_credentials_entry(service_name, client).to_dict()
+
+    def get_credentials_entry(
+        self, service_name: str, client: str | None = None
+    ) -> ServiceCredentialsManager:
+        """Get the cache entry of the credentials of a service, as get_credentials.
+
+        On cache hits, the entry is served as it is, with its response body
+        marshaled when the credentials were obtained.
+        """
+        with span("credentials.lookup", {"credproxy.service": service_name}):
+            return self._lookup_credentials(service_name, client)
+
+    def _check_rate_limit(self, client: str | None, sts_call: bool) -> None:
+        """Take a request of the client from its rate limit.
+
+        Only counted once per request: before the cache lookup, or before
+        calling STS when the rate limit only applies to STS requests.
+        """
+        rate_limit = self.config.credentials.rate_limit
+        if client is None or rate_limit is None:
+            return
+        if rate_limit.sts_requests_only != sts_call:
+            return
+        with self._rate_limiter_lock:
+            # Settings changed by a configuration reload
+            if rate_limit != self._rate_limit_config:
+                self._rate_limiter = TokenBucketRateLimiter(
+                    rate_limit.requests_per_second, rate_limit.burst
+                )
+                self._rate_limit_config = rate_limit
+            rate_limiter = self._rate_limiter
+        retry_after = rate_limiter.acquire(client)
+        if retry_after:
+            raise RateLimitExceeded(retry_after)
+
+    def _lookup_credentials(
+        self, service_name: str, client: str | None
+    ) -> ServiceCredentialsManager:
+        """Get credentials for a service from the cache, or assume its role."""
+        self._check_rate_limit(client, sts_call=False)
+        service_config = self.config.services[service_name]
+        if tracing_enabled():
+            role_arn = service_config.assumed_role.RoleArn
+            set_span_attribute("credproxy.role_arn", role_arn)
+        # Entries are replaced rather than modified, hits only lock to read them
         with self._cache_lock:
-            # Check cache first
-            if service_name in self.cache:
-                cached = self.cache[service_name]
-                if not cached.is_expired():
-                    LOG.debug("Using cached credentials for %s", service_name)
-                    return cached.to_dict()
-                else:
-                    # Cache expired
-                    pass
-            else:
-                # Not in cache
-                pass
+            cached = self.cache.get(service_name)
+
+        if (
+            cached
+            and cached.cache_key is not None
+            and cached.cache_key != self._cache_key(service_config)
+        ):
+            LOG.info("Role chain changed for %s, discarding cache", service_name)
+            cached = None
+
+        if cached and not cached.is_expired(self._expiry_skew):
+            if cached.needs_refresh(
+                self.config.credentials.refresh_buffer_seconds, self._expiry_skew
+            ) and not self._requires_mfa_prompt(service_name):
+                self._schedule_refresh(service_name)
+            LOG.debug("Using cached credentials for %s", service_name)
+            CREDENTIALS_LOOKUP.set(
+                CredentialsLookup(cache="hit", assumed_role_id=cached.assumed_role_id)
+            )
+            set_span_attribute("credproxy.cache", "hit")
+            return cached
+
+        # Failing since startup, fetched again once the backoff elapsed
+        retry_after = self._startup_retry_after(service_name)
+        if retry_after is not None:
+            raise CredentialsStarting(service_name, retry_after)
 
         # Generate new credentials
+        self._check_rate_limit(client, sts_call=True)
         LOG.info("Generating new credentials for %s", service_name)
+        set_span_attribute("credproxy.cache", "miss")
+        start_time = time.perf_counter()
+        # Operators may take longer than the timeout to enter an MFA code
+        timeout = (
+            None
+            if self._requires_mfa_prompt(service_name)
+            else self.config.credentials.request_timeout
+        )
+        try:
+            service_creds = self._fetch_shared(service_name, timeout)
+        except TimeoutError as error:
+            LOG.warning("Timed out getting credentials for %s", service_name)
+            raise CredentialsTimeout(timeout) from error
+        CREDENTIALS_LOOKUP.set(
+            CredentialsLookup(
+                cache="miss",
+                sts_duration=time.perf_counter() - start_time,
+                assumed_role_id=service_creds.assumed_role_id,
+            )
+        )
+        return service_creds
+
+    def _fetch_shared(
+        self, service_name: str, timeout: float | None = None
+    ) -> ServiceCredentialsManager:
+        """Assume the role for a service, or wait for the fetch already in flight.
+
+        Concurrent misses and refreshes of a service share a single role
+        assumption, and its credentials or error. Raises TimeoutError when not
+        done within timeout seconds.
+        """
+        return self._fetches.do(
+            service_name, lambda: self._fetch_credentials(service_name), timeout
+        )
+
+    def _fetch_credentials(self, service_name: str) -> ServiceCredentialsManager:
+        """Assume the role for a service and store the result in the cache."""
         service_config = self.config.services[service_name]
-        credentials = self._assume_role(service_config)
+        static_config = service_config.source_credentials.static
+        try:
+            if static_config and service_name not in self.source_providers:
+                # Served as they are, no role is assumed
+                credentials = static_credentials(static_config)
+            else:
+                credentials = self._assume_role(service_config)
+        except Exception as error:
+            from credproxy.sanitizer import sanitize_exception_message
+
+            with self._cache_lock:
+                self._failed_services[service_name] = sanitize_exception_message(
+                    str(error)
+                )
+            raise
 
         # Register temporary credentials for sanitization
         from credproxy.sanitizer import register_sensitive_value
@@ -179,16 +860,389 @@ class CredentialsHandler:
             aws_secret_access_key=credentials["SecretAccessKey"],
             session_token=credentials["SessionToken"],
             expiry=expiry_time,
+            role_arn=service_config.assumed_role.RoleArn,
+            cache_key=self._cache_key(service_config),
+            refresh_offset=self._refresh_offset(),
+            obtained_at=self.clock.now(),
+            clock=self.clock,
+            assumed_role_id=credentials.get("AssumedRoleId"),
         )
 
+        if service_creds.needs_refresh(
+            self.config.credentials.refresh_buffer_seconds, self._expiry_skew
+        ):
+            LOG.warning(
+                "Credentials for %s expire within the refresh window, "
+                "consider lowering refresh_buffer_seconds or expiry_skew_seconds",
+                service_name,
+            )
+
+        if self.config.services.get(service_name) is not service_config:
+            # Removed or changed by a reload while assuming the role
+            LOG.info("Service %s changed, not caching its credentials", service_name)
+            return service_creds
+
         with self._cache_lock:
             self.cache[service_name] = service_creds
-        return service_creds.to_dict()
+            self._credentials_obtained = True
+            self._failed_services.pop(service_name, None)
+        track_credentials_expiry(service_name, service_creds.expiry)
+        self._store_on_disk(service_name, service_creds)
+        return service_creds
+
+    def last_updated(self, service_name: str) -> float | None:
+        """Get when the cached credentials of a service were obtained, if any."""
+        with self._cache_lock:
+            cached = self.cache.get(service_name)
+        return cached.obtained_at if cached else None
+
+    def readiness(self) -> tuple[bool, dict[str, float]]:
+        """Check credentials can be served, with the expiry of cached credentials.
+
+        Ready once any credentials were obtained, until a service whose last
+        fetch failed has no valid credentials left in the cache, and while no
+        circuit breaker of STS is open.
+        """
+        circuit_open = any(circuit.is_open() for circuit in self._circuits())
+        with self._cache_lock:
+            expiries = {
+                service_name: creds.expiry
+                for service_name, creds in self.cache.items()
+                if not creds.is_expired(self._expiry_skew)
+            }
+            unavailable = [
+                service_name
+                for service_name in self._failed_services
+                if service_name in self.config.services
+                and service_name not in expiries
+            ]
+            ready = self._credentials_obtained and not unavailable
+            return ready and not circuit_open, expiries
+
+    def credentials_status(self) -> dict[str, ServiceCredentialsStatus]:
+        """Get the state of the cached credentials of every service."""
+        statuses = {}
+        for service_name, service_config in list(self.config.services.items()):
+            source = self._chosen_source(service_name)
+            source_credentials = service_config.source_credentials
+            if service_name in self.source_providers:
+                source_name = "provider"
+            elif source is None and source_credentials.sources:
+                source_name = "sources"
+            else:
+                source_name = self.credential_source_name(
+                    source or source_credentials
+                )
+            with self._cache_lock:
+                cached = self.cache.get(service_name)
+                last_error = self._failed_services.get(service_name)
+            with self._refresh_lock:
+                refreshing = service_name in self._refreshing
+            if cached is None:
+                cache_state = "empty"
+            else:
+                expired = cached.is_expired(self._expiry_skew)
+                cache_state = "expired" if expired else "valid"
+            statuses[service_name] = ServiceCredentialsStatus(
+                source=source_name,
+                role_arn=service_config.assumed_role.RoleArn,
+                cache=cache_state,
+                expiry=cached.expiry if cached else None,
+                last_refresh=cached.obtained_at if cached else None,
+                last_error=last_error,
+                refreshing=refreshing or self._fetches.in_flight(service_name),
+            )
+        return statuses
+
+    def credential_sources(self) -> dict[str, str]:
+        """Get the name of the source used last by services with a fallback chain."""
+        return {
+            service_name: self.credential_source_name(source)
+            for service_name in list(self.config.services)
+            if (source := self._chosen_source(service_name)) is not None
+        }
+
+    def _chosen_source(self, service_name: str) -> SourceCredentialsConfig | None:
+        """Get the source of the fallback chain of a service used last, if any."""
+        service_config = self.config.services.get(service_name)
+        sources = service_config and service_config.source_credentials.sources
+        with self._cache_lock:
+            source = self._credential_sources.get(service_name)
+        # Ignored once the chain no longer has it, as changed by a reload
+        return source if sources and source in sources else None
+
+    @staticmethod
+    def credential_source_name(source: SourceCredentialsConfig) -> str:
+        """Name a source of a fallback chain by its method."""
+        for method in ("iam_profile", "iam_keys", "sso", "web_identity", "saml"):
+            if getattr(source, method):
+                return method
+        return "default"
+
+    @property
+    def _expiry_skew(self) -> float:
+        """Seconds credentials are considered expired before their expiry."""
+        return self.config.credentials.expiry_skew_seconds
+
+    def _refresh_offset(self) -> float:
+        """Draw the random refresh window offset of new credentials."""
+        jitter = self.config.credentials.refresh_jitter_seconds
+        return random.uniform(-jitter, jitter) if jitter else 0.0
+
+    @staticmethod
+    def _cache_key(service_config: ServiceConfig) -> str:
+        """Fingerprint the full role chain of a service for caching."""
+        hops = [
+            [
+                role_config.RoleArn,
+                role_config.ExternalId,
+                role_config.SourceIdentity,
+                # Differently tagged sessions of a role are distinct
+                sorted((tag["Key"], tag["Value"]) for tag in role_config.Tags or []),
+                sorted(role_config.TransitiveTagKeys or []),
+            ]
+            for role_config in [*service_config.role_chain, service_config.assumed_role]
+        ]
+        # Hashed so ExternalId values never end up in cache entries in clear
+        return hashlib.sha256(json.dumps(hops).encode()).hexdigest()
 
     def _assume_role(self, service_config: ServiceConfig) -> dict:
-        """Assume role for service and return credentials."""
+        """Assume role for service and return credentials.
+
+        Roles in role_chain are assumed in order first, each one using the
+        credentials of the previous hop. Any failing hop fails the whole chain.
+        The credentials have the AssumedRoleId of the last hop.
+        """
         # Get service name for metrics
-        service_name = next(
+        service_name = self._service_name(service_config)
+        hops = [*service_config.role_chain, service_config.assumed_role]
+        # Shared by all STS calls, so retries of the chain end with the request
+        retry_policy = self._sts_retry_policy(service_config)
+
+        # Get AWS config for this service
+        aws_config = self._get_aws_config(service_config, retry_policy)
+        profile_name = aws_config.pop("profile_name", None)
+        # Every hop uses the configured STS endpoint, proxy and CA bundle
+        endpoint_config = {
+            key: aws_config[key]
+            for key in ("endpoint_url", "verify")
+            if key in aws_config
+        }
+        # STS calls are only retried by the retry policy
+        client_config = BotoConfig(
+            retries=NO_CLIENT_RETRIES, proxies=aws_config.pop("proxies", None)
+        )
+
+        credentials = None
+        # Web identity, SAML and SSO source credentials are role sessions already
+        source_credentials = (
+            self._chosen_source(service_name) or service_config.source_credentials
+        )
+        provider = self.source_providers.get(service_name)
+        role_session_source = (
+            provider.role_session
+            if provider
+            else bool(
+                source_credentials.web_identity
+                or source_credentials.saml
+                or source_credentials.sso
+            )
+        )
+        for hop, role_config in enumerate(hops, start=1):
+            try:
+                if credentials:
+                    # Chained hop: use the credentials of the previous role
+                    sts_client = boto3.client(
+                        "sts",
+                        region_name=aws_config["region_name"],
+                        aws_access_key_id=credentials["AccessKeyId"],
+                        aws_secret_access_key=credentials["SecretAccessKey"],
+                        aws_session_token=credentials["SessionToken"],
+                        config=client_config,
+                        **endpoint_config,
+                    )
+                elif profile_name:
+                    # Create STS client with profile if specified
+                    session = boto3.Session(profile_name=profile_name)
+                    sts_client = session.client(
+                        "sts", config=client_config, **aws_config
+                    )
+                else:
+                    sts_client = boto3.client("sts", config=client_config, **aws_config)
+
+                response = self._call_assume_role(
+                    sts_client,
+                    role_config,
+                    retry_policy,
+                    chained=credentials is not None or role_session_source,
+                )
+                credentials = response["Credentials"]
+                assumed_role_user = response.get("AssumedRoleUser", {})
+
+            except ClientError as error:
+                LOG.error(
+                    "Failed to assume role %s (hop %d/%d) for %s: %s",
+                    role_config.RoleArn,
+                    hop,
+                    len(hops),
+                    service_name,
+                    str(error),
+                )
+                error_code = error.response.get("Error", {}).get("Code")
+                if role_config.Tags and error_code == "AccessDenied":
+                    LOG.error(
+                        "Session tags %s of role %s may be denied by its trust "
+                        "policy (sts:TagSession) or a service control policy",
+                        [tag["Key"] for tag in role_config.Tags],
+                        role_config.RoleArn,
+                    )
+                raise
+
+        return {**credentials, "AssumedRoleId": assumed_role_user.get("AssumedRoleId")}
+
+    def _call_assume_role(
+        self,
+        sts_client,
+        role_config: AssumedRoleConfig,
+        retry_policy: StsRetryPolicy | None = None,
+        chained: bool = False,
+    ) -> dict:
+        """Call STS AssumeRole, prompting for an MFA token code when required.
+
+        STS rejects invalid MFA codes with AccessDenied, in which case the code is
+        prompted again up to MFA_MAX_ATTEMPTS times before the error is raised.
+        Chained sessions, assumed with role credentials, last at most one hour.
+        """
+        # Convert dataclass to dict and filter out None values for boto3 API call
+        assumed_role_dict = asdict(role_config)
+        assume_role_params = {
+            k: v for k, v in assumed_role_dict.items() if v is not None
+        }
+        if chained and role_config.DurationSeconds > ROLE_CHAINING_MAX_DURATION:
+            # STS rejects longer chained sessions rather than shortening them
+            LOG.warning(
+                "Clamping DurationSeconds of role %s from %d to %d seconds, "
+                "the maximum of role chaining",
+                role_config.RoleArn,
+                role_config.DurationSeconds,
+                ROLE_CHAINING_MAX_DURATION,
+            )
+            assume_role_params["DurationSeconds"] = ROLE_CHAINING_MAX_DURATION
+        if not self._mfa_prompt_required(role_config):
+            return self._timed_assume_role(
+                sts_client, assume_role_params, retry_policy
+            )
+
+        for attempt in range(1, MFA_MAX_ATTEMPTS + 1):
+            assume_role_params["TokenCode"] = self.mfa_provider.token_code(
+                role_config.SerialNumber
+            )
+            try:
+                return self._timed_assume_role(
+                    sts_client, assume_role_params, retry_policy
+                )
+            except ClientError as error:
+                error_code = error.response.get("Error", {}).get("Code")
+                if error_code != "AccessDenied" or attempt == MFA_MAX_ATTEMPTS:
+                    raise
+                LOG.warning(
+                    "MFA code for %s was rejected (attempt %d/%d), prompting again",
+                    role_config.SerialNumber,
+                    attempt,
+                    MFA_MAX_ATTEMPTS,
+                )
+
+    @staticmethod
+    def _timed_assume_role(
+        sts_client,
+        assume_role_params: dict,
+        retry_policy: StsRetryPolicy | None = None,
+    ) -> dict:
+        """Call STS AssumeRole, recording the duration of every attempt."""
+
+        def assume_role() -> dict:
+            start_time = time.perf_counter()
+            try:
+                with span(
+                    "sts.AssumeRole",
+                    {"credproxy.role_arn": assume_role_params["RoleArn"]},
+                ):
+                    return sts_client.assume_role(**assume_role_params)
+            finally:
+                record_sts_assume_duration(time.perf_counter() - start_time)
+
+        if retry_policy is None:
+            return assume_role()
+        return retry_policy.call("AssumeRole", assume_role)
+
+    def _sts_retry_policy(self, service_config: ServiceConfig) -> StsRetryPolicy:
+        """Build the retry policy of the STS calls of one credentials request."""
+        credentials_config = self.config.credentials
+        return StsRetryPolicy(
+            max_attempts=credentials_config.sts_max_attempts,
+            max_delay=credentials_config.retry_delay,
+            deadline=time.monotonic() + credentials_config.request_timeout,
+            circuit=self._sts_circuit(service_config),
+        )
+
+    def _sts_circuit(self, service_config: ServiceConfig) -> StsCircuitBreaker | None:
+        """Get the circuit breaker of the STS region of a service, None if disabled."""
+        aws_defaults = self.config.aws_defaults
+        region = (
+            service_config.source_credentials.region
+            or (aws_defaults and aws_defaults.region)
+            or "default"
+        )
+        circuit_breaker = self.config.credentials.circuit_breaker
+        with self._sts_circuits_lock:
+            # Settings changed by a configuration reload
+            if circuit_breaker != self._circuit_breaker_config:
+                self._sts_circuits = {}
+                self._circuit_breaker_config = circuit_breaker
+            if circuit_breaker is None:
+                return None
+            if region not in self._sts_circuits:
+                self._sts_circuits[region] = StsCircuitBreaker(
+                    region,
+                    circuit_breaker.failure_threshold,
+                    circuit_breaker.window_seconds,
+                    circuit_breaker.cooldown_seconds,
+                )
+            return self._sts_circuits[region]
+
+    def _circuits(self) -> list[StsCircuitBreaker]:
+        """Get the circuit breakers of STS, unless disabled by a reload."""
+        with self._sts_circuits_lock:
+            if self.config.credentials.circuit_breaker != self._circuit_breaker_config:
+                return []
+            return list(self._sts_circuits.values())
+
+    def circuits_status(self) -> dict[str, CircuitStatus]:
+        """Get the state of the circuit breaker of STS of every region called."""
+        return {circuit.region: circuit.status() for circuit in self._circuits()}
+
+    @staticmethod
+    def _mfa_prompt_required(role_config: AssumedRoleConfig) -> bool:
+        """Check if a token code must be obtained from the MFA provider."""
+        return bool(role_config.SerialNumber) and not role_config.TokenCode
+
+    def _requires_mfa_prompt(self, service_name: str) -> bool:
+        """Check if assuming the roles of a service prompts for an MFA code.
+
+        Such services are not refreshed ahead of expiry, so the operator is only
+        prompted once the cached session has expired.
+        """
+        service_config = self.config.services.get(service_name)
+        if service_config is None:
+            return False
+        return any(
+            self._mfa_prompt_required(role_config)
+            for role_config in [*service_config.role_chain, service_config.assumed_role]
+        )
+
+    def _service_name(self, service_config: ServiceConfig) -> str:
+        """Get the name of a service from its configuration."""
+        return next(
             (
                 name
                 for name, config in self.config.services.items()
@@ -197,41 +1251,91 @@ class CredentialsHandler:
             "unknown",
         )
 
-        try:
-            # Get AWS config for this service
-            aws_config = self._get_aws_config(service_config)
-
-            # Create STS client with profile if specified
-            profile_name = aws_config.pop("profile_name", None)
-            if profile_name:
-                session = boto3.Session(profile_name=profile_name)
-                sts_client = session.client("sts", **aws_config)
-            else:
-                sts_client = boto3.client("sts", **aws_config)
+    def _get_aws_config(
+        self,
+        service_config: ServiceConfig,
+        retry_policy: StsRetryPolicy | None = None,
+    ) -> dict:
+        """Get AWS configuration for a service."""
+        service_creds = service_config.source_credentials
+        provider = self.source_providers.get(self._service_name(service_config))
+        if provider:
+            return self._source_aws_config(
+                service_creds, self.config.aws_defaults, retry_policy, provider
+            )
+        if service_creds and service_creds.sources:
+            return self._source_chain_aws_config(service_config, retry_policy)
+        return self._source_aws_config(
+            service_creds, self.config.aws_defaults, retry_policy
+        )
 
-            # Convert dataclass to dict and filter out None values for boto3 API call
-            assumed_role_dict = asdict(service_config.assumed_role)
-            assume_role_params = {
-                k: v for k, v in assumed_role_dict.items() if v is not None
-            }
+    def _source_chain_aws_config(
+        self,
+        service_config: ServiceConfig,
+        retry_policy: StsRetryPolicy | None = None,
+    ) -> dict:
+        """Get AWS configuration of the first source of a chain providing credentials.
 
-            # Assume role
-            response = sts_client.assume_role(**assume_role_params)
+        The source used last is tried first, then the others in order. Raises
+        CredentialSourcesError with why each source failed when none works.
+        """
+        service_name = self._service_name(service_config)
+        source_credentials = service_config.source_credentials
+        chosen = self._chosen_source(service_name)
+        sources = [
+            *([chosen] if chosen else []),
+            *(source for source in source_credentials.sources if source != chosen),
+        ]
 
-            # AWS operation successful - no detailed metrics needed
+        failures = []
+        for source in sources:
+            source_name = self.credential_source_name(source)
+            try:
+                # Sources use the region and STS endpoint of the chain
+                aws_config = self._source_aws_config(
+                    source, source_credentials, retry_policy
+                )
+                self._check_source_credentials(aws_config)
+            except Exception as error:
+                LOG.warning(
+                    "Credential source %s of %s failed: %s",
+                    source_name,
+                    service_name,
+                    error,
+                )
+                failures.append((source_name, str(error)))
+                continue
 
-            return response["Credentials"]
+            if source != chosen:
+                LOG.info("Using credential source %s for %s", source_name, service_name)
+                with self._cache_lock:
+                    self._credential_sources[service_name] = source
+            return aws_config
 
-        except ClientError as error:
-            # AWS operation failed - no detailed metrics needed
+        with self._cache_lock:
+            self._credential_sources.pop(service_name, None)
+        raise CredentialSourcesError(service_name, failures)
 
-            LOG.error("Failed to assume role for %s: %s", service_name, str(error))
-            raise
+    @staticmethod
+    def _check_source_credentials(aws_config: dict) -> None:
+        """Check the SDK finds credentials for a source without keys of its own."""
+        if "aws_access_key_id" in aws_config:
+            return
+        session = boto3.Session(profile_name=aws_config.get("profile_name"))
+        if session.get_credentials() is None:
+            raise NoCredentialsError()
 
-    def _get_aws_config(self, service_config: ServiceConfig) -> dict:
-        """Get AWS configuration for a service."""
-        service_creds = service_config.source_credentials
-        default_creds = self.config.aws_defaults
+    def _source_aws_config(
+        self,
+        service_creds: SourceCredentialsConfig | None,
+        default_creds: SourceCredentialsConfig | None,
+        retry_policy: StsRetryPolicy | None = None,
+        provider: CredentialsProvider | None = None,
+    ) -> dict:
+        """Get AWS configuration of source credentials, falling back to defaults.
+
+        A provider given replaces the method of the source credentials.
+        """
 
         # Use or operator for clean fallbacks
         region = (service_creds and service_creds.region) or (
@@ -243,11 +1347,42 @@ class CredentialsHandler:
         keys = (service_creds and service_creds.iam_keys) or (
             default_creds and default_creds.iam_keys
         )
+        sso_config = (service_creds and service_creds.sso) or (
+            default_creds and default_creds.sso
+        )
+        web_identity_config = (service_creds and service_creds.web_identity) or (
+            default_creds and default_creds.web_identity
+        )
+        saml_config = (service_creds and service_creds.saml) or (
+            default_creds and default_creds.saml
+        )
+        sts_endpoint = (service_creds and service_creds.sts_endpoint) or (
+            default_creds and default_creds.sts_endpoint
+        )
+        http_options = StsHttpOptions(
+            http_proxy=(service_creds and service_creds.http_proxy)
+            or (default_creds and default_creds.http_proxy),
+            ca_bundle=(service_creds and service_creds.ca_bundle)
+            or (default_creds and default_creds.ca_bundle),
+            insecure_skip_verify=bool(
+                (service_creds and service_creds.insecure_skip_verify)
+                or (default_creds and default_creds.insecure_skip_verify)
+            ),
+        )
 
         aws_config = {"region_name": region}
+        if sts_endpoint:
+            aws_config["endpoint_url"] = sts_endpoint
+        if http_options.verify is not None:
+            aws_config["verify"] = http_options.verify
+        if http_options.proxies:
+            aws_config["proxies"] = http_options.proxies
 
         # Auto-detect auth method based on presence of config objects
-        if profile_config and profile_config.profile_name:
+        if provider:
+            # Provider of the application, replacing the configured method
+            aws_config.update(provider.retrieve(retry_policy).to_aws_config())
+        elif profile_config and profile_config.profile_name:
             # IAM profile authentication
             aws_config["profile_name"] = profile_config.profile_name
         elif keys:
@@ -260,6 +1395,100 @@ class CredentialsHandler:
             )
             if hasattr(keys, "session_token") and keys.session_token:
                 aws_config["aws_session_token"] = keys.session_token
-        # If neither profile_config nor keys present, use default SDK behavior
+        elif sso_config:
+            # SSO authentication, using the permission set role credentials
+            sso_provider = SSORoleProvider(
+                self._sso_token_provider(sso_config),
+                sso_config.account_id,
+                sso_config.role_name,
+            )
+            aws_config.update(sso_provider.retrieve(retry_policy).to_aws_config())
+        elif web_identity_config:
+            # Web identity authentication, token file read again on every call
+            aws_config.update(
+                self._web_identity_token_provider(
+                    web_identity_config, region, sts_endpoint, http_options
+                )
+                .retrieve(retry_policy)
+                .to_aws_config()
+            )
+        elif saml_config:
+            # SAML authentication, the role session reused until it expires
+            aws_config.update(
+                self._saml_assertion_provider(
+                    saml_config, region, sts_endpoint, http_options
+                )
+                .retrieve(retry_policy)
+                .to_aws_config()
+            )
+        # If no auth method is present, use default SDK behavior
 
         return aws_config
+
+    def _sso_token_provider(self, sso_config: SSOAuthConfig) -> SSOTokenProvider:
+        """Get the token provider shared by all services of an SSO start URL."""
+        provider_key = (sso_config.start_url, sso_config.sso_region)
+        with self._sso_lock:
+            if provider_key not in self._sso_providers:
+                self._sso_providers[provider_key] = SSOTokenProvider(
+                    sso_config.start_url, sso_config.sso_region
+                )
+            return self._sso_providers[provider_key]
+
+    def _web_identity_token_provider(
+        self,
+        web_identity_config: WebIdentityAuthConfig,
+        region: str | None,
+        sts_endpoint: str | None = None,
+        http_options: StsHttpOptions | None = None,
+    ) -> WebIdentityTokenProvider:
+        """Get the provider of a web identity token file and role."""
+        provider_key = (
+            web_identity_config.token_file,
+            web_identity_config.role_arn,
+            web_identity_config.role_session_name,
+            region,
+            sts_endpoint,
+            http_options,
+        )
+        with self._web_identity_lock:
+            if provider_key not in self._web_identity_providers:
+                self._web_identity_providers[provider_key] = WebIdentityTokenProvider(
+                    web_identity_config.token_file,
+                    web_identity_config.role_arn,
+                    web_identity_config.role_session_name,
+                    region,
+                    sts_endpoint,
+                    http_options,
+                )
+            return self._web_identity_providers[provider_key]
+
+    def _saml_assertion_provider(
+        self,
+        saml_config: SAMLAuthConfig,
+        region: str | None,
+        sts_endpoint: str | None = None,
+        http_options: StsHttpOptions | None = None,
+    ) -> SAMLAssertionProvider:
+        """Get the provider of a SAML assertion and role, keeping its session."""
+        provider_key = (
+            saml_config.principal_arn,
+            saml_config.role_arn,
+            saml_config.assertion_file,
+            saml_config.assertion_command,
+            region,
+            sts_endpoint,
+            http_options,
+        )
+        with self._saml_lock:
+            if provider_key not in self._saml_providers:
+                self._saml_providers[provider_key] = SAMLAssertionProvider(
+                    saml_config.principal_arn,
+                    saml_config.role_arn,
+                    saml_config.assertion_file,
+                    saml_config.assertion_command,
+                    region,
+                    sts_endpoint,
+                    http_options,
+                )
+            return self._saml_providers[provider_key]
@@ -0,0 +1,169 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Encrypted on-disk cache of credentials, reused after a restart.
+
+Entries are encrypted and authenticated with AES-256-GCM, from the optional
+cryptography package, so altered entries are detected rather than decrypted.
+The key is a random key file, created on first use. Kept outside of the cache
+directory, such as on a secrets volume, reading the cache does not decrypt it.
+Kept in the cache directory, as by default, it only obfuscates the entries:
+anyone able to read the entries can read the key too.
+"""
+
+from __future__ import annotations
+
+import os
+import json
+import time
+import hashlib
+import secrets
+import tempfile
+from pathlib import Path
+from contextlib import suppress
+
+from credproxy.logger import LOG
+
+
+try:
+    from cryptography.exceptions import InvalidTag
+    from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+except ImportError:  # The disk cache is optional
+    InvalidTag = AESGCM = None
+
+
+# Key file in the cache directory when no key file is set
+KEY_FILE_NAME = "cache.key"
+# Size of the AES-256 keys
+KEY_SIZE = 32
+ENTRY_SUFFIX = ".cache"
+# Size of the AES-GCM nonces, random for every entry
+NONCE_SIZE = 12
+
+
+class DiskCredentialsCache:
+    """Credentials of each service and cache key, encrypted in a directory.
+
+    Raises ImportError without the cryptography package, and OSError or
+    ValueError when the directory or its key file cannot be used.
+    """
+
+    def __init__(self, cache_dir: str, key_file: str | None = None):
+        if AESGCM is None:
+            raise ImportError("The disk cache requires the cryptography package")
+        self.cache_dir = Path(cache_dir)
+        self.cache_dir.mkdir(mode=0o700, parents=True, exist_ok=True)
+        self.key_file = Path(key_file) if key_file else self.cache_dir / KEY_FILE_NAME
+        if self.key_file.resolve().parent == self.cache_dir.resolve():
+            LOG.warning(
+                "The key file of cache directory %s is in the directory, so the "
+                "entries are only obfuscated. Set credentials.cache_key_file to "
+                "keep it elsewhere",
+                cache_dir,
+            )
+        self._aead = AESGCM(self._read_or_create_key())
+
+    def _read_or_create_key(self) -> bytes:
+        """Read the key file, creating it on first use."""
+        key_file = self.key_file
+        if not key_file.exists():
+            key_file.parent.mkdir(mode=0o700, parents=True, exist_ok=True)
+            file_descriptor, temp_path = tempfile.mkstemp(dir=key_file.parent)
+            try:
+                with os.fdopen(file_descriptor, "wb") as temp_file:
+                    temp_file.write(secrets.token_bytes(KEY_SIZE))
+                # Fails if another instance created the key file in the meantime
+                with suppress(FileExistsError):
+                    os.link(temp_path, key_file)
+            finally:
+                os.unlink(temp_path)
+        key = key_file.read_bytes()
+        if len(key) != KEY_SIZE:
+            raise ValueError(f"Invalid cache key file {key_file}")
+        return key
+
+    @staticmethod
+    def _service_prefix(service_name: str) -> str:
+        # Service names come from file names too, so they are not used as is
+        return hashlib.sha256(service_name.encode()).hexdigest()[:32]
+
+    def _entry_path(self, service_name: str, cache_key: str) -> Path:
+        return self.cache_dir / (
+            f"{self._service_prefix(service_name)}.{cache_key}{ENTRY_SUFFIX}"
+        )
+
+    def _encrypt(self, plaintext: bytes) -> bytes:
+        nonce = secrets.token_bytes(NONCE_SIZE)
+        return nonce + self._aead.encrypt(nonce, plaintext, None)
+
+    def _decrypt(self, data: bytes) -> bytes:
+        """Decrypt an entry, raising ValueError if it was altered."""
+        try:
+            return self._aead.decrypt(data[:NONCE_SIZE], data[NONCE_SIZE:], None)
+        except InvalidTag as error:
+            raise ValueError("Cache entry failed authentication") from error
+
+    def load(self, service_name: str, cache_key: str) -> dict | None:
+        """Load the unexpired credentials of a service, None if there are none.
+
+        Unreadable, altered and expired entries are ignored.
+        """
+        path = self._entry_path(service_name, cache_key)
+        try:
+            entry = json.loads(self._decrypt(path.read_bytes()))
+            if entry["service_name"] != service_name or entry["cache_key"] != cache_key:
+                raise ValueError("Cache entry of another service")
+            credentials = entry["credentials"]
+            if time.time() > credentials["expiry"]:
+                raise ValueError("Expired cache entry")
+        except FileNotFoundError:
+            return None
+        except (OSError, ValueError, KeyError, TypeError) as error:
+            LOG.debug("Ignoring cache entry %s: %s", path.name, error)
+            return None
+        return credentials
+
+    def store(self, service_name: str, cache_key: str, credentials: dict) -> None:
+        """Write the credentials of a service, replacing its previous entries."""
+        entry = {
+            "service_name": service_name,
+            "cache_key": cache_key,
+            "credentials": credentials,
+        }
+        path = self._entry_path(service_name, cache_key)
+        # Created owner-only, renamed so readers never see a partial entry
+        file_descriptor, temp_path = tempfile.mkstemp(dir=self.cache_dir)
+        try:
+            with os.fdopen(file_descriptor, "wb") as temp_file:
+                temp_file.write(self._encrypt(json.dumps(entry).encode()))
+                temp_file.flush()
+                os.fsync(temp_file.fileno())
+            os.replace(temp_path, path)
+        except BaseException:
+            with suppress(OSError):
+                os.unlink(temp_path)
+            raise
+        self.remove(service_name, keep=path)
+
+    def remove(self, service_name: str, keep: Path | None = None) -> None:
+        """Remove the entries of a service, except the keep one."""
+        for path in self.cache_dir.glob(
+            f"{self._service_prefix(service_name)}.*{ENTRY_SUFFIX}"
+        ):
+            if path != keep:
+                with suppress(OSError):
+                    path.unlink()
+
+
+def create_disk_cache(
+    cache_dir: str | None, key_file: str | None = None
+) -> DiskCredentialsCache | None:
+    """Create the disk cache of cache_dir, None if not set or unusable."""
+    if not cache_dir:
+        return None
+    try:
+        return DiskCredentialsCache(cache_dir, key_file)
+    except (ImportError, OSError, ValueError) as error:
+        LOG.error("Cannot use cache directory %s, not caching on disk", cache_dir)
+        LOG.exception(error)
+        return None
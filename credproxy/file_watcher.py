@@ -342,6 +342,7 @@ class ServiceFileHandler(FileSystemEventHandler):
             # Create ServiceConfig object using existing config parsing logic
             source_creds_data = service_data.get("source_credentials", {})
             assumed_role_data = service_data.get("assumed_role", {})
+            role_chain_data = service_data.get("role_chain", [])
 
             # Merge with defaults if available
             merged_source_creds_data = source_creds_data
@@ -356,15 +357,22 @@ class ServiceFileHandler(FileSystemEventHandler):
                 )
 
             service_config = ServiceConfig(
-                auth_token=service_data["auth_token"],
+                auth_token=self.config._read_service_auth_token(service_data),
                 source_credentials=self.config._create_source_credentials_config(
-                    merged_source_creds_data
+                    merged_source_creds_data, service_name
+                ),
+                assumed_role=self.config._create_assumed_role_config(
+                    assumed_role_data, service_name
                 ),
-                assumed_role=self.config._create_assumed_role_config(assumed_role_data),
                 source_file=str(
                     Path(file_path).resolve()
                 ),  # Track which file loaded this service
+                role_chain=self.config._create_role_chain_config(
+                    role_chain_data, service_name
+                ),
+                auth_token_file=service_data.get("auth_token_file"),
             )
+            self.config._validate_partition(service_name, service_config)
             LOG.info("Successfully created service configuration for %s", service_name)
             return service_name, service_config
 
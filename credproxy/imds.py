@@ -0,0 +1,335 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""EC2 instance metadata service (IMDS) emulation endpoints."""
+
+from __future__ import annotations
+
+import time
+import secrets
+import threading
+from datetime import datetime, timezone
+from functools import wraps
+from dataclasses import asdict, dataclass
+
+from flask import Blueprint, g, jsonify, request, current_app
+
+from credproxy.logger import LOG
+from credproxy.routes import audit_vend, credentials_error_details
+from credproxy.sanitizer import register_sensitive_value, unregister_sensitive_value
+from credproxy.credentials_handler import EXPIRATION_FORMAT
+
+
+IMDS_TOKEN_HEADER = "X-aws-ec2-metadata-token"
+IMDS_TOKEN_TTL_HEADER = "X-aws-ec2-metadata-token-ttl-seconds"
+
+# Limits enforced by the real IMDS for session token TTLs
+MIN_TOKEN_TTL_SECONDS = 1
+MAX_TOKEN_TTL_SECONDS = 21600
+
+IMDS_MODE_OPTIONAL = "v2-optional"
+IMDS_MODE_REQUIRED = "v2-required"
+
+# Credentials listing and detail paths, the detail path ending with a role name
+SECURITY_CREDENTIALS_PATH = "/latest/meta-data/iam/security-credentials/"
+
+# Paths SDKs detect the region of the instance with
+IDENTITY_DOCUMENT_PATH = "/latest/dynamic/instance-identity/document"
+PLACEMENT_PATH = "/latest/meta-data/placement/"
+
+# Version of the instance identity document format
+IDENTITY_DOCUMENT_VERSION = "2017-09-30"
+
+
+# Create a Blueprint for IMDS routes
+imds_bp = Blueprint("imds", __name__)
+
+
+@dataclass
+class IMDSCredentialsResponse:
+    """Credentials body served by the IMDS security-credentials endpoint.
+
+    Field names match the JSON keys of the EC2 instance metadata service.
+    """
+
+    LastUpdated: str
+    AccessKeyId: str
+    SecretAccessKey: str
+    Token: str
+    Expiration: str
+    Code: str = "Success"
+    Type: str = "AWS-HMAC"
+
+
+@dataclass
+class InstanceIdentityDocument:
+    """Instance identity document served by the IMDS dynamic data endpoint.
+
+    Field names match the JSON keys of the EC2 instance metadata service.
+    """
+
+    accountId: str
+    architecture: str
+    availabilityZone: str
+    imageId: str
+    instanceId: str
+    instanceType: str
+    privateIp: str
+    region: str
+    version: str = IDENTITY_DOCUMENT_VERSION
+
+
+class IMDSTokenStore:
+    """Thread-safe store of issued IMDSv2 session tokens and their expiry."""
+
+    def __init__(self):
+        self._tokens: dict[str, float] = {}
+        self._lock = threading.Lock()
+
+    def issue(self, ttl_seconds: int) -> str:
+        """Issue a new session token valid for ttl_seconds."""
+        token = secrets.token_urlsafe(32)
+        register_sensitive_value(token)
+        with self._lock:
+            self._purge_expired()
+            self._tokens[token] = time.time() + ttl_seconds
+        return token
+
+    def is_valid(self, token: str) -> bool:
+        """Check the token was issued by this store and has not expired."""
+        with self._lock:
+            expiry = self._tokens.get(token)
+            if expiry is None:
+                return False
+            if time.time() >= expiry:
+                del self._tokens[token]
+                unregister_sensitive_value(token)
+                return False
+            return True
+
+    def __len__(self) -> int:
+        with self._lock:
+            return len(self._tokens)
+
+    def _purge_expired(self) -> None:
+        """Drop expired tokens. Must be called with the lock held."""
+        now = time.time()
+        expired = [token for token, expiry in self._tokens.items() if now >= expiry]
+        for token in expired:
+            del self._tokens[token]
+            unregister_sensitive_value(token)
+
+
+def _parse_token_ttl(raw_ttl: str | None) -> int | None:
+    """Parse the requested token TTL, returning None when missing or invalid."""
+    if raw_ttl is None:
+        return None
+    try:
+        ttl = int(raw_ttl)
+    except ValueError:
+        return None
+    if not MIN_TOKEN_TTL_SECONDS <= ttl <= MAX_TOKEN_TTL_SECONDS:
+        return None
+    return ttl
+
+
+def imds_token_required(view):
+    """Enforce the IMDSv2 session token according to the configured mode.
+
+    A token that is provided must always be valid. A missing token is only
+    accepted when running in v2-optional mode.
+    """
+
+    @wraps(view)
+    def wrapper(*args, **kwargs):
+        config = current_app.config.get("credproxy_config")
+        token_store: IMDSTokenStore = current_app.config.get("imds_token_store")
+        token = request.headers.get(IMDS_TOKEN_HEADER)
+
+        if token is None:
+            if config.imds.mode == IMDS_MODE_REQUIRED:
+                LOG.warning("IMDS request missing session token in v2-required mode")
+                return "", 401
+        elif not token_store.is_valid(token):
+            LOG.warning("IMDS request with invalid or expired session token")
+            return "", 401
+
+        return view(*args, **kwargs)
+
+    return wrapper
+
+
+def _get_imds_service():
+    """Return the (name, ServiceConfig) served over IMDS, or None."""
+    config = current_app.config.get("credproxy_config")
+    service_name = config.imds.service
+    if not service_name or service_name not in config.services:
+        return None
+    g.service_name = service_name
+    return service_name, config.services[service_name]
+
+
+def role_name_from_arn(role_arn: str) -> str:
+    """Return the friendly role name (last path element) of a role ARN."""
+    return role_arn.rsplit("/", 1)[-1]
+
+
+@imds_bp.route("/latest/api/token", methods=["PUT"])
+def put_token():
+    """Issue an IMDSv2 session token."""
+    # Like the real IMDS, refuse tokens to requests relayed through a proxy
+    if request.headers.get("X-Forwarded-For"):
+        LOG.warning("Rejecting IMDS token request with X-Forwarded-For header")
+        return "", 403
+
+    ttl = _parse_token_ttl(request.headers.get(IMDS_TOKEN_TTL_HEADER))
+    if ttl is None:
+        LOG.warning("IMDS token request with missing or invalid TTL")
+        return "", 400
+
+    token_store: IMDSTokenStore = current_app.config.get("imds_token_store")
+    token = token_store.issue(ttl)
+    LOG.debug("Issued IMDS session token with TTL %d seconds", ttl)
+    return token, 200, {"Content-Type": "text/plain", IMDS_TOKEN_TTL_HEADER: str(ttl)}
+
+
+def _format_time(timestamp: float) -> str:
+    return datetime.fromtimestamp(timestamp, tz=timezone.utc).strftime(
+        EXPIRATION_FORMAT
+    )
+
+
+def _error_response(code: str, message: str, status: int):
+    """Respond with an error in the IMDS credentials format."""
+    body = {"Code": code, "Message": message, "LastUpdated": _format_time(time.time())}
+    return jsonify(body), status
+
+
+@imds_bp.route(SECURITY_CREDENTIALS_PATH, methods=["GET"])
+@imds_token_required
+def list_security_credentials():
+    """List the role name available through IMDS."""
+    imds_service = _get_imds_service()
+    if imds_service is None:
+        LOG.warning("IMDS service is not configured or unknown")
+        return "", 404
+
+    _, service_config = imds_service
+    role_name = role_name_from_arn(service_config.assumed_role.RoleArn)
+    return role_name, 200, {"Content-Type": "text/plain"}
+
+
+@imds_bp.route(f"{SECURITY_CREDENTIALS_PATH}<role_name>", methods=["GET"])
+@imds_token_required
+def get_security_credentials(role_name: str):
+    """Serve the credentials of the role listed, in the IMDS format."""
+    imds_service = _get_imds_service()
+    if imds_service is None:
+        LOG.warning("IMDS service is not configured or unknown")
+        return "", 404
+
+    service_name, service_config = imds_service
+    if role_name != role_name_from_arn(service_config.assumed_role.RoleArn):
+        LOG.warning("IMDS request for role %s, which is not listed", role_name)
+        return "", 404
+
+    credentials_handler = current_app.config.get("credentials_handler")
+    try:
+        credentials = credentials_handler.get_credentials(
+            service_name, request.remote_addr
+        )
+    except Exception as error:
+        details, status, headers = credentials_error_details(error, service_name)
+        response, status = _error_response(details["code"], details["message"], status)
+        return response, status, headers
+
+    config = current_app.config.get("credproxy_config")
+    audit_vend(config, service_name, credentials["Expiration"], "imds")
+    last_updated = credentials_handler.last_updated(service_name) or time.time()
+    response = IMDSCredentialsResponse(
+        LastUpdated=_format_time(last_updated),
+        AccessKeyId=credentials["AccessKeyId"],
+        SecretAccessKey=credentials["SecretAccessKey"],
+        Token=credentials["Token"],
+        Expiration=credentials["Expiration"],
+    )
+    return jsonify(asdict(response))
+
+
+def _imds_region() -> str | None:
+    """Get the region of the instance, else of the IMDS service source credentials."""
+    config = current_app.config.get("credproxy_config")
+    if config.imds.identity.region:
+        return config.imds.identity.region
+    service_name = config.imds.service
+    if service_name in config.services:
+        source_credentials = config.services[service_name].source_credentials
+        if source_credentials and source_credentials.region:
+            return source_credentials.region
+    return config.aws_defaults.region if config.aws_defaults else None
+
+
+def _imds_availability_zone(region: str) -> str:
+    """Get the availability zone of the instance, the first of region if not set."""
+    config = current_app.config.get("credproxy_config")
+    return config.imds.identity.availability_zone or f"{region}a"
+
+
+def _imds_account_id() -> str | None:
+    """Get the account of the instance, else of the IMDS service role."""
+    config = current_app.config.get("credproxy_config")
+    if config.imds.identity.account_id:
+        return config.imds.identity.account_id
+    service_name = config.imds.service
+    if service_name in config.services:
+        # arn:partition:iam::account-id:role/name
+        return config.services[service_name].assumed_role.RoleArn.split(":")[4]
+    return None
+
+
+@imds_bp.route(IDENTITY_DOCUMENT_PATH, methods=["GET"])
+@imds_token_required
+def get_instance_identity_document():
+    """Serve the instance identity document, with the region and account."""
+    region = _imds_region()
+    account_id = _imds_account_id()
+    if not region or not account_id:
+        LOG.warning("IMDS identity document requested without region or account")
+        return "", 404
+
+    identity = current_app.config.get("credproxy_config").imds.identity
+    document = InstanceIdentityDocument(
+        accountId=account_id,
+        architecture=identity.architecture,
+        availabilityZone=_imds_availability_zone(region),
+        imageId=identity.image_id,
+        instanceId=identity.instance_id,
+        instanceType=identity.instance_type,
+        privateIp=identity.private_ip,
+        region=region,
+    )
+    return jsonify(asdict(document))
+
+
+@imds_bp.route(f"{PLACEMENT_PATH}region", methods=["GET"])
+@imds_token_required
+def get_placement_region():
+    """Serve the region of the instance."""
+    region = _imds_region()
+    if not region:
+        LOG.warning("IMDS region requested without region configured")
+        return "", 404
+    return region, 200, {"Content-Type": "text/plain"}
+
+
+# botocore reads the zone, with a trailing slash, to detect the region
+@imds_bp.route(f"{PLACEMENT_PATH}availability-zone", methods=["GET"])
+@imds_bp.route(f"{PLACEMENT_PATH}availability-zone/", methods=["GET"])
+@imds_token_required
+def get_placement_availability_zone():
+    """Serve the availability zone of the instance."""
+    region = _imds_region()
+    if not region:
+        LOG.warning("IMDS availability zone requested without region configured")
+        return "", 404
+    return _imds_availability_zone(region), 200, {"Content-Type": "text/plain"}
@@ -3,22 +3,32 @@
 
 from __future__ import annotations
 
+import sys
 import json
 import logging as logthings
+from datetime import datetime, timezone
 
 from flask import g, request
 
+from credproxy.sanitizer import sanitize_string
+
 from . import __version__, __git_commit__
-from .settings import LOG_LEVEL
+from .settings import LOG_LEVEL, LOG_FORMAT
+
+
+# Formats selectable with --log-format or CREDPROXY_LOG_FORMAT
+LOG_FORMATS = ("json", "text")
+# Probe endpoints kept out of the access logs unless they fail
+HEALTH_CHECK_PATHS = ("/health", "/healthz", "/readyz")
+_log_format = LOG_FORMAT
 
 
 class SimpleJsonFormatter(logthings.Formatter):
     """Simple JSON formatter that always includes essential fields."""
 
-    def format(self, record: logthings.LogRecord) -> str:
+    def record_data(self, record: logthings.LogRecord) -> dict:
+        """Build the structured fields of a log record."""
         # Sanitize the message before processing
-        from credproxy.sanitizer import sanitize_string
-
         sanitized_message = sanitize_string(record.getMessage())
 
         data = {
@@ -47,6 +57,10 @@ class SimpleJsonFormatter(logthings.Formatter):
         if hasattr(record, "service") and record.service:
             data["service"] = record.service
 
+        # Add credentials request details if present
+        if hasattr(record, "credentials") and record.credentials:
+            data["credentials"] = record.credentials
+
         # Always include exception information if present (sanitized)
         if record.exc_info:
             exception_text = self.formatException(record.exc_info)
@@ -54,7 +68,65 @@ class SimpleJsonFormatter(logthings.Formatter):
         elif record.exc_text:
             data["exception"] = sanitize_string(record.exc_text)
 
-        return json.dumps(data, separators=(",", ":"), default=str)
+        return data
+
+    def format(self, record: logthings.LogRecord) -> str:
+        # Sanitize the whole line so that context fields cannot leak secrets
+        return sanitize_string(
+            json.dumps(self.record_data(record), separators=(",", ":"), default=str)
+        )
+
+
+class SimpleTextFormatter(SimpleJsonFormatter):
+    """Human readable formatter with the same fields as SimpleJsonFormatter."""
+
+    def format(self, record: logthings.LogRecord) -> str:
+        data = self.record_data(record)
+        timestamp = datetime.fromtimestamp(data.pop("timestamp"), timezone.utc)
+        line = (
+            f"{timestamp.isoformat(timespec='milliseconds')} "
+            f"{data.pop('levelname')} {data.pop('name')}: {data.pop('message')}"
+        )
+        exception = data.pop("exception", None)
+
+        fields = " ".join(
+            f"{key}={value}"
+            for key, value in _flatten_fields(data)
+            if value not in (None, "")
+        )
+        if fields:
+            line = f"{line} {fields}"
+        if exception:
+            line = f"{line}\n{exception}"
+        return sanitize_string(line)
+
+
+def _flatten_fields(data: dict, prefix: str = "") -> list[tuple[str, object]]:
+    """Flatten nested dicts into dotted key and value pairs."""
+    fields: list[tuple[str, object]] = []
+    for key, value in data.items():
+        if isinstance(value, dict):
+            fields.extend(_flatten_fields(value, f"{prefix}{key}."))
+        else:
+            fields.append((f"{prefix}{key}", value))
+    return fields
+
+
+def get_formatter(log_format: str | None = None) -> logthings.Formatter:
+    """Return the formatter of a log format, defaulting to the configured one."""
+    if (log_format or _log_format) == "text":
+        return SimpleTextFormatter()
+    return SimpleJsonFormatter()
+
+
+def set_log_format(log_format: str) -> None:
+    """Switch the credproxy loggers to the json or text log format."""
+    global _log_format
+    if log_format not in LOG_FORMATS:
+        raise ValueError(f"Unsupported log format: {log_format}")
+    _log_format = log_format
+    for handler in logthings.getLogger("credproxy").handlers:
+        handler.setFormatter(get_formatter())
 
 
 class RequestContextFilter(logthings.Filter):
@@ -140,7 +212,11 @@ class HealthCheckFilter(logthings.Filter):
 
         message = record.getMessage()
         # Check if this is a health check request
-        if "GET /health " in message or "HEAD /health " in message:
+        if any(
+            f"{method} {path} " in message
+            for method in ("GET", "HEAD")
+            for path in HEALTH_CHECK_PATHS
+        ):
             # Only allow health check logs if they contain error status codes
             # HTTP status codes 4xx and 5xx indicate errors
             return any(
@@ -173,8 +249,8 @@ class FlaskDevelopmentWarningFilter(logthings.Filter):
 
 
 def setup_logging():
-    """Setup simple JSON logging."""
-    formatter = SimpleJsonFormatter()
+    """Setup simple JSON (or text) logging."""
+    formatter = get_formatter()
 
     handler = logthings.StreamHandler()
     handler.setFormatter(formatter)
@@ -194,6 +270,15 @@ def setup_logging():
     return logger
 
 
+def flush_logs() -> None:
+    """Flush the log handlers and standard streams, before exiting."""
+    for logger in (logthings.getLogger("credproxy"), logthings.getLogger("werkzeug")):
+        for handler in logger.handlers:
+            handler.flush()
+    for stream in (sys.stdout, sys.stderr):
+        stream.flush()
+
+
 def setup_json_logging(app, *, level: int = logthings.INFO) -> None:
     """Setup JSON logging for Flask app."""
     # Flush any automatically added handlers
@@ -204,7 +289,7 @@ def setup_json_logging(app, *, level: int = logthings.INFO) -> None:
         log_level_name = LOG_LEVEL.upper()
         level = getattr(logthings, log_level_name, logthings.INFO)
 
-    formatter = SimpleJsonFormatter()
+    formatter = get_formatter()
 
     handler = logthings.StreamHandler()
     handler.setFormatter(formatter)
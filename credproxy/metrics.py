@@ -5,6 +5,7 @@
 
 from __future__ import annotations
 
+import time
 from typing import TYPE_CHECKING
 
 import prometheus_client
@@ -54,6 +55,39 @@ REQUEST_DURATION = Histogram(
     registry=REGISTRY,
 )
 
+# Credentials vended to clients, labelled by the configured role ARN only so the
+# cardinality is bounded by the configuration rather than by requests
+CREDENTIALS_SERVED_TOTAL = Counter(
+    "credproxy_credentials_served_total",
+    "Total number of credentials served to clients",
+    ["role"],
+    registry=REGISTRY,
+)
+
+# STS AssumeRole call latency
+STS_ASSUME_DURATION = Histogram(
+    "credproxy_sts_assume_duration_seconds",
+    "STS AssumeRole call duration in seconds",
+    buckets=(0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0),
+    registry=REGISTRY,
+)
+
+# Background credential refreshes ahead of expiry
+REFRESH_TOTAL = Counter(
+    "credproxy_refresh_total",
+    "Total number of credential refreshes ahead of expiry",
+    ["result"],
+    registry=REGISTRY,
+)
+
+# Time left before the cached credentials of each service expire
+CREDENTIALS_EXPIRY = Gauge(
+    "credproxy_credentials_expiry_seconds",
+    "Seconds until the cached credentials expire",
+    ["service_name"],
+    registry=REGISTRY,
+)
+
 # Service discovery metrics - for tracking managed services over time
 ACTIVE_SERVICES = Gauge(
     "credproxy_active_services_total",
@@ -121,3 +155,33 @@ def record_request(
 def update_active_services(count: int) -> None:
     """Update the active services count."""
     ACTIVE_SERVICES.set(count)
+
+
+def record_credentials_served(role: str) -> None:
+    """Record credentials served to a client for a role."""
+    CREDENTIALS_SERVED_TOTAL.labels(role=role).inc()
+
+
+def record_sts_assume_duration(duration: float) -> None:
+    """Record the duration of an STS AssumeRole call."""
+    STS_ASSUME_DURATION.observe(duration)
+
+
+def record_refresh(result: str) -> None:
+    """Record the result (success or failure) of a credentials refresh."""
+    REFRESH_TOTAL.labels(result=result).inc()
+
+
+def track_credentials_expiry(service_name: str, expiry: float) -> None:
+    """Report the time to expiry of the credentials cached for a service."""
+    CREDENTIALS_EXPIRY.labels(service_name=service_name).set_function(
+        lambda: max(expiry - time.time(), 0.0)
+    )
+
+
+def untrack_credentials_expiry(service_name: str) -> None:
+    """Stop reporting expiry for credentials evicted from the cache."""
+    try:
+        CREDENTIALS_EXPIRY.remove(service_name)
+    except KeyError:
+        pass
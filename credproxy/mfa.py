@@ -0,0 +1,39 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""MFA token code providers used when assuming roles that require MFA."""
+
+from __future__ import annotations
+
+import threading
+from typing import TYPE_CHECKING, Protocol
+
+
+if TYPE_CHECKING:
+    from collections.abc import Callable
+
+
+# Attempts given to enter a valid MFA code before the error is returned
+MFA_MAX_ATTEMPTS = 3
+
+
+class MFAProvider(Protocol):
+    """Supplies the current MFA token code for an MFA device."""
+
+    def token_code(self, serial_number: str) -> str:
+        """Return the token code for the MFA device serial_number."""
+        ...
+
+
+class StdinMFAProvider:
+    """Prompt the operator for MFA token codes on the terminal."""
+
+    def __init__(self, prompt: Callable[[str], str] = input):
+        self._prompt = prompt
+        # Only one prompt at a time when several requests need a code
+        self._lock = threading.Lock()
+
+    def token_code(self, serial_number: str) -> str:
+        """Prompt for the token code of the MFA device serial_number."""
+        with self._lock:
+            return self._prompt(f"Enter MFA code for {serial_number}: ").strip()
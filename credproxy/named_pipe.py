@@ -0,0 +1,302 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Serve the CredProxy application on a Windows named pipe.
+
+Named pipes replace Unix domain sockets for local clients on Windows. The Win32
+API is called with ctypes, only loaded once the server starts, so that other
+platforms neither load it nor need any dependency for it.
+"""
+
+from __future__ import annotations
+
+import io
+import re
+import sys
+import ctypes
+import threading
+import socketserver
+from typing import TYPE_CHECKING
+
+from werkzeug.serving import WSGIRequestHandler
+
+from credproxy.logger import LOG
+
+
+if TYPE_CHECKING:
+    from flask import Flask
+
+
+# Names of local named pipes, such as \\.\pipe\credproxy
+PIPE_NAME_PATTERN = re.compile(r"^\\\\\.\\pipe\\[^\\]+$")
+
+PIPE_ACCESS_DUPLEX = 0x00000003
+FILE_FLAG_FIRST_PIPE_INSTANCE = 0x00080000
+PIPE_REJECT_REMOTE_CLIENTS = 0x00000008
+PIPE_UNLIMITED_INSTANCES = 255
+PIPE_BUFFER_SIZE = 65536
+GENERIC_READ_WRITE = 0x80000000 | 0x40000000
+OPEN_EXISTING = 3
+TOKEN_QUERY = 0x0008
+TOKEN_USER = 1
+SDDL_REVISION_1 = 1
+ERROR_BROKEN_PIPE = 109
+ERROR_PIPE_CONNECTED = 535
+INVALID_HANDLE_VALUE = ctypes.c_void_p(-1).value
+
+
+def is_supported() -> bool:
+    """Check named pipes can be served on this platform."""
+    return sys.platform == "win32"
+
+
+class _Win32:
+    """Win32 functions serving the named pipe, loaded on Windows only."""
+
+    def __init__(self):
+        from ctypes import wintypes
+
+        self.kernel32 = ctypes.WinDLL("kernel32", use_last_error=True)
+        self.advapi32 = ctypes.WinDLL("advapi32", use_last_error=True)
+        self.kernel32.CreateNamedPipeW.restype = wintypes.HANDLE
+        self.kernel32.CreateFileW.restype = wintypes.HANDLE
+        self.kernel32.GetCurrentProcess.restype = wintypes.HANDLE
+        for function in ("ConnectNamedPipe", "ReadFile", "WriteFile", "CloseHandle"):
+            getattr(self.kernel32, function).restype = wintypes.BOOL
+
+    def check(self, succeeded: bool) -> None:
+        """Raise the last Win32 error of a call which did not succeed."""
+        if not succeeded:
+            raise ctypes.WinError(ctypes.get_last_error())
+
+    def current_user_sid(self) -> str:
+        """Get the SID of the user running the process, as a string."""
+        from ctypes import wintypes
+
+        token = wintypes.HANDLE()
+        self.check(
+            self.advapi32.OpenProcessToken(
+                self.kernel32.GetCurrentProcess(), TOKEN_QUERY, ctypes.byref(token)
+            )
+        )
+        try:
+            size = wintypes.DWORD()
+            self.advapi32.GetTokenInformation(
+                token, TOKEN_USER, None, 0, ctypes.byref(size)
+            )
+            token_user = ctypes.create_string_buffer(size.value)
+            self.check(
+                self.advapi32.GetTokenInformation(
+                    token, TOKEN_USER, token_user, size, ctypes.byref(size)
+                )
+            )
+            # TOKEN_USER starts with the pointer to the SID of the user
+            sid = ctypes.cast(token_user, ctypes.POINTER(ctypes.c_void_p))[0]
+            string_sid = wintypes.LPWSTR()
+            self.check(
+                self.advapi32.ConvertSidToStringSidW(
+                    ctypes.c_void_p(sid), ctypes.byref(string_sid)
+                )
+            )
+            try:
+                return string_sid.value
+            finally:
+                self.kernel32.LocalFree(string_sid)
+        finally:
+            self.kernel32.CloseHandle(token)
+
+
+class _SecurityAttributes(ctypes.Structure):
+    _fields_ = [
+        ("nLength", ctypes.c_uint32),
+        ("lpSecurityDescriptor", ctypes.c_void_p),
+        ("bInheritHandle", ctypes.c_int),
+    ]
+
+
+class _PipeReader(io.RawIOBase):
+    """Raw reads of a connected pipe instance, for the buffered request reader."""
+
+    def __init__(self, win32: _Win32, handle: int):
+        self._win32 = win32
+        self._handle = handle
+
+    def readable(self) -> bool:
+        return True
+
+    def readinto(self, buffer) -> int:
+        read = ctypes.c_uint32()
+        data = (ctypes.c_char * len(buffer)).from_buffer(buffer)
+        if not self._win32.kernel32.ReadFile(
+            self._handle, data, len(buffer), ctypes.byref(read), None
+        ):
+            error = ctypes.get_last_error()
+            if error == ERROR_BROKEN_PIPE:
+                # Closed by the client
+                return 0
+            raise ctypes.WinError(error)
+        return read.value
+
+
+class PipeConnection:
+    """Connected pipe instance, used by the request handler as its socket."""
+
+    def __init__(self, win32: _Win32, handle: int):
+        self._win32 = win32
+        self._handle: int | None = handle
+
+    def makefile(self, mode: str = "rb", buffering: int = -1) -> io.BufferedReader:
+        """Get the reader of the requests, writes going through sendall."""
+        return io.BufferedReader(_PipeReader(self._win32, self._handle))
+
+    def sendall(self, data: bytes) -> None:
+        """Write all of data to the client."""
+        view = memoryview(data)
+        while view:
+            written = ctypes.c_uint32()
+            self._win32.check(
+                self._win32.kernel32.WriteFile(
+                    self._handle,
+                    bytes(view),
+                    len(view),
+                    ctypes.byref(written),
+                    None,
+                )
+            )
+            view = view[written.value :]
+
+    def close(self) -> None:
+        """Disconnect the client once it read the response, closing the instance."""
+        if self._handle is None:
+            return
+        kernel32 = self._win32.kernel32
+        kernel32.FlushFileBuffers(self._handle)
+        kernel32.DisconnectNamedPipe(self._handle)
+        kernel32.CloseHandle(self._handle)
+        self._handle = None
+
+
+class _PipeWSGIServer(socketserver.ThreadingMixIn, socketserver.BaseServer):
+    """Server of the connections to pipe instances, as werkzeug servers are."""
+
+    daemon_threads = True
+    # Attributes werkzeug request handlers read from their server
+    multithread = True
+    multiprocess = False
+    passthrough_errors = False
+    ssl_context = None
+
+    def __init__(self, app: Flask, path: str, win32: _Win32):
+        super().__init__(("localhost", 0), WSGIRequestHandler)
+        self.app = app
+        self.path = path
+        self._win32 = win32
+        self._stopping = threading.Event()
+        self._descriptor = ctypes.c_void_p()
+        # Only the user running CredProxy is granted access to the pipe
+        sddl = f"D:P(A;;GA;;;{win32.current_user_sid()})"
+        win32.check(
+            win32.advapi32.ConvertStringSecurityDescriptorToSecurityDescriptorW(
+                sddl, SDDL_REVISION_1, ctypes.byref(self._descriptor), None
+            )
+        )
+        self._security_attributes = _SecurityAttributes(
+            ctypes.sizeof(_SecurityAttributes), self._descriptor, 0
+        )
+        # Failing if the pipe exists, so that no other process serves on it
+        self._listening = self._create_instance(FILE_FLAG_FIRST_PIPE_INSTANCE)
+
+    def _create_instance(self, flags: int = 0) -> int:
+        """Create an instance of the pipe, waiting for the next client."""
+        handle = self._win32.kernel32.CreateNamedPipeW(
+            self.path,
+            PIPE_ACCESS_DUPLEX | flags,
+            PIPE_REJECT_REMOTE_CLIENTS,
+            PIPE_UNLIMITED_INSTANCES,
+            PIPE_BUFFER_SIZE,
+            PIPE_BUFFER_SIZE,
+            0,
+            ctypes.byref(self._security_attributes),
+        )
+        self._win32.check(handle != INVALID_HANDLE_VALUE)
+        return handle
+
+    def get_request(self) -> tuple[PipeConnection, tuple[str, int]]:
+        """Wait for a client, an instance always waiting for the next one."""
+        if not self._win32.kernel32.ConnectNamedPipe(self._listening, None):
+            error = ctypes.get_last_error()
+            if error != ERROR_PIPE_CONNECTED:
+                raise ctypes.WinError(error)
+        connected, self._listening = self._listening, self._create_instance()
+        return PipeConnection(self._win32, connected), ("<local>", 0)
+
+    def serve(self) -> None:
+        """Serve clients until stopped, each connection in its own thread."""
+        while not self._stopping.is_set():
+            try:
+                connection, client_address = self.get_request()
+            except OSError as error:
+                if self._stopping.is_set():
+                    break
+                LOG.error("Failed to accept a named pipe connection")
+                LOG.exception(error)
+                continue
+            if self._stopping.is_set():
+                connection.close()
+                break
+            self.process_request(connection, client_address)
+
+    def stop(self) -> None:
+        """Stop serving, connecting to the pipe to end the wait for a client."""
+        self._stopping.set()
+        handle = self._win32.kernel32.CreateFileW(
+            self.path, GENERIC_READ_WRITE, 0, None, OPEN_EXISTING, 0, None
+        )
+        if handle != INVALID_HANDLE_VALUE:
+            self._win32.kernel32.CloseHandle(handle)
+
+    def server_close(self) -> None:
+        """Close the instance waiting for clients, removing the pipe."""
+        super().server_close()
+        self._win32.kernel32.CloseHandle(self._listening)
+        self._win32.kernel32.LocalFree(self._descriptor)
+
+    def shutdown_request(self, request: PipeConnection) -> None:
+        request.close()
+
+
+class NamedPipeServer:
+    """Serve a Flask app on a Windows named pipe restricted to the current user."""
+
+    def __init__(self, app: Flask, path: str):
+        self.app = app
+        self.path = path
+        self._server: _PipeWSGIServer | None = None
+        self._thread: threading.Thread | None = None
+
+    def start(self) -> None:
+        """Create the pipe restricted to the current user and serve in background."""
+        if not is_supported():
+            raise OSError(f"Named pipes are only supported on Windows, not {self.path}")
+        if not PIPE_NAME_PATTERN.match(self.path):
+            raise ValueError(f"{self.path} is not a named pipe such as \\\\.\\pipe\\x")
+
+        self._server = _PipeWSGIServer(self.app, self.path, _Win32())
+        self._thread = threading.Thread(
+            target=self._server.serve, daemon=True, name="named-pipe"
+        )
+        self._thread.start()
+        LOG.info("Serving on named pipe %s", self.path)
+
+    def stop(self) -> None:
+        """Stop serving and remove the pipe."""
+        if self._server is None:
+            return
+
+        LOG.info("Stopping named pipe server on %s", self.path)
+        self._server.stop()
+        if self._thread:
+            self._thread.join(timeout=5)
+        self._server.server_close()
+        self._server = None
+        LOG.info("Removed named pipe %s", self.path)
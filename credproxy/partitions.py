@@ -0,0 +1,46 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""AWS partitions of regions and ARNs.
+
+Roles of isolated partitions, such as aws-cn or aws-us-gov, can only be assumed
+with the STS endpoints of their partition, which the SDK resolves from the
+region. The region of a service must be in the partition of its ARNs.
+"""
+
+from __future__ import annotations
+
+from functools import lru_cache
+
+import boto3
+from botocore.exceptions import BotoCoreError
+
+
+@lru_cache(maxsize=64)
+def region_partition(region: str) -> str | None:
+    """Get the partition of a region with the SDK endpoint resolver, if known."""
+    try:
+        return boto3.Session().get_partition_for_region(region)
+    except BotoCoreError:
+        return None
+
+
+def arn_partition(arn: str) -> str:
+    """Get the partition of an ARN, such as aws-us-gov for arn:aws-us-gov:iam::..."""
+    return arn.split(":", 2)[1]
+
+
+def check_partition(region: str, arns: list[str]) -> None:
+    """Check ARNs are in the partition of region, raising ValueError otherwise.
+
+    Regions unknown to the SDK are not checked.
+    """
+    partition = region_partition(region)
+    if partition is None:
+        return
+    for arn in arns:
+        if arn_partition(arn) != partition:
+            raise ValueError(
+                f"{arn} is in partition {arn_partition(arn)}, but region {region} "
+                f"is in partition {partition}"
+            )
@@ -0,0 +1,111 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Child processes of CredProxy, terminated on shutdown.
+
+Commands such as SAML assertion commands are tracked while they run, so that
+shutdown does not leave them behind. Running as PID 1 in a container, processes
+orphaned by those commands are reparented to CredProxy, which reaps them once
+they exit instead of leaving zombies.
+"""
+
+from __future__ import annotations
+
+import os
+import signal
+import threading
+import subprocess
+
+from credproxy.logger import LOG
+
+
+# Seconds children get to exit on SIGTERM before being killed
+TERMINATE_TIMEOUT = 5.0
+
+# Commands running, by process ID
+_children: dict[int, subprocess.Popen] = {}
+_children_lock = threading.Lock()
+# Set once orphans are reaped, running as PID 1
+_reaping = False
+
+
+def run_command(args: list[str], timeout: float) -> str:
+    """Run a command and return its output, as subprocess.run with check=True.
+
+    stdin and stderr are left to the command. Raises CalledProcessError when it
+    fails, and TimeoutExpired once killed after timeout seconds.
+    """
+    # Registered before the orphan reaper can see the command exit
+    with _children_lock:
+        process = subprocess.Popen(args, stdout=subprocess.PIPE, text=True)
+        _children[process.pid] = process
+    with process:
+        try:
+            stdout, _ = process.communicate(timeout=timeout)
+        except subprocess.TimeoutExpired:
+            process.kill()
+            process.communicate()
+            raise
+        finally:
+            with _children_lock:
+                _children.pop(process.pid, None)
+            if _reaping:
+                # Orphans exited while the command was the one to be waited on
+                reap_orphans()
+    if process.returncode:
+        raise subprocess.CalledProcessError(process.returncode, args, stdout)
+    return stdout
+
+
+def terminate_children(timeout: float = TERMINATE_TIMEOUT) -> None:
+    """Terminate the commands still running, killing the ones not exiting."""
+    with _children_lock:
+        children = list(_children.values())
+    for child in children:
+        LOG.info("Terminating child process %d", child.pid)
+        child.terminate()
+    for child in children:
+        try:
+            child.wait(timeout=timeout)
+        except subprocess.TimeoutExpired:
+            LOG.warning("Killing child process %d", child.pid)
+            child.kill()
+
+
+def reap_orphans(signum: int | None = None, frame: object = None) -> None:
+    """Reap the exited processes which are not commands of run_command.
+
+    Commands are waited on by run_command, to get their exit code. Reaping is
+    skipped while a command is started, run_command reaping once it completes.
+    """
+    # Not blocking, the signal handler may interrupt the thread holding the lock
+    if not _children_lock.acquire(blocking=False):
+        return
+    try:
+        while True:
+            try:
+                # Peeks at the exited process, only reaping it when not a command
+                result = os.waitid(os.P_ALL, 0, os.WEXITED | os.WNOHANG | os.WNOWAIT)
+            except ChildProcessError:
+                return
+            if result is None or result.si_pid in _children:
+                return
+            os.waitpid(result.si_pid, 0)
+    finally:
+        _children_lock.release()
+
+
+def install_orphan_reaper() -> bool:
+    """Reap orphaned processes on SIGCHLD when running as PID 1.
+
+    Returns whether the reaper was installed.
+    """
+    global _reaping
+    if os.getpid() != 1 or not hasattr(os, "waitid"):
+        return False
+    LOG.info("Running as PID 1, reaping orphaned processes")
+    _reaping = True
+    signal.signal(signal.SIGCHLD, reap_orphans)
+    # Processes exited before the handler was installed
+    reap_orphans()
+    return True
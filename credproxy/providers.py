@@ -0,0 +1,72 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Providers of the source credentials roles are assumed with.
+
+The web identity, SAML and SSO sources are providers, and applications using
+CredProxy as a library can plug in their own, such as a client of an internal
+secrets system, with init_app(config, source_providers={"my-app": provider}).
+
+Contract of the providers:
+
+- retrieve is called from the request threads and the refresher thread, at
+  the same time for different services sharing a provider. It must be thread
+  safe, and may block, bounded by credentials.request_timeout.
+- retrieve is called before every role assumption. Providers may reuse their
+  credentials until they expire, with an expiration when they do.
+- Errors are raised as exceptions, and answered by the container and IMDS
+  endpoints with the status of routes.credentials_error_details: botocore
+  ClientError and BotoCoreError as STS errors are, with their AWS error code
+  and 403 when denied, 400 when invalid or 502 otherwise, TokenLifetimeError
+  with 503, and any other exception with 500.
+"""
+
+from __future__ import annotations
+
+import time
+from abc import ABC, abstractmethod
+from typing import TYPE_CHECKING
+from dataclasses import dataclass
+
+
+if TYPE_CHECKING:
+    from credproxy.retry import StsRetryPolicy
+
+
+@dataclass(frozen=True)
+class SourceCredentials:
+    """Credentials of a provider, with their expiration if they expire."""
+
+    access_key_id: str
+    secret_access_key: str
+    session_token: str | None = None
+    expiration: float | None = None  # Epoch seconds, None for long-term keys
+
+    def is_expired(self, margin: float = 0.0) -> bool:
+        """Check the credentials expire within margin seconds."""
+        return self.expiration is not None and time.time() + margin >= self.expiration
+
+    def to_aws_config(self) -> dict:
+        """Get the boto3 client parameters of the credentials."""
+        aws_config = {
+            "aws_access_key_id": self.access_key_id,
+            "aws_secret_access_key": self.secret_access_key,
+        }
+        if self.session_token:
+            aws_config["aws_session_token"] = self.session_token
+        return aws_config
+
+
+class CredentialsProvider(ABC):
+    """Provider of the source credentials of services."""
+
+    # Role sessions credentials, the roles assumed with them lasting an hour at most
+    role_session: bool = False
+
+    @abstractmethod
+    def retrieve(self, retry_policy: StsRetryPolicy | None = None) -> SourceCredentials:
+        """Get the source credentials.
+
+        Calls to AWS may be retried with retry_policy, which bounds the retries
+        to the request the credentials are retrieved for.
+        """
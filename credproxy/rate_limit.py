@@ -0,0 +1,66 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Token bucket rate limiting of the credential requests of each client.
+
+Each client has a bucket of burst tokens, refilled at requests_per_second. A
+request takes a token, and is rejected while the bucket of its client is empty.
+"""
+
+from __future__ import annotations
+
+import math
+import time
+import threading
+
+
+# Clients tracked at once before those with a refilled bucket are forgotten
+MAX_TRACKED_CLIENTS = 10000
+
+
+class RateLimitExceeded(Exception):
+    """Raised when a client sent more requests than its rate limit allows."""
+
+    def __init__(self, retry_after: float):
+        super().__init__(f"Rate limit exceeded, retry in {retry_after:.2f} seconds")
+        self.retry_after = retry_after
+
+
+class TokenBucketRateLimiter:
+    """Rate limiter of requests, with a token bucket by client."""
+
+    def __init__(self, requests_per_second: float, burst: int | None = None):
+        self.requests_per_second = requests_per_second
+        self.burst = burst or max(1, math.ceil(requests_per_second))
+        # Tokens left and monotonic time they were counted at, by client
+        self._buckets: dict[str, tuple[float, float]] = {}
+        self._lock = threading.Lock()
+
+    def acquire(self, client: str) -> float:
+        """Take a token of the client bucket.
+
+        Returns 0 when the request is allowed, else the seconds until the
+        bucket has a token again.
+        """
+        now = time.monotonic()
+        with self._lock:
+            tokens, counted_at = self._buckets.get(client, (self.burst, now))
+            tokens = min(
+                self.burst, tokens + (now - counted_at) * self.requests_per_second
+            )
+            if tokens < 1:
+                self._buckets[client] = (tokens, now)
+                return (1 - tokens) / self.requests_per_second
+            new_client = client not in self._buckets
+            if new_client and len(self._buckets) >= MAX_TRACKED_CLIENTS:
+                self._forget_refilled(now)
+            self._buckets[client] = (tokens - 1, now)
+            return 0.0
+
+    def _forget_refilled(self, now: float) -> None:
+        """Forget the clients whose bucket refilled, as if never seen."""
+        self._buckets = {
+            client: (tokens, counted_at)
+            for client, (tokens, counted_at) in self._buckets.items()
+            if tokens + (now - counted_at) * self.requests_per_second < self.burst
+        }
@@ -0,0 +1,105 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Retry policy of STS calls.
+
+Only transient failures are retried: throttling, server errors and network
+errors. Errors caused by the request itself, such as AccessDenied or
+ValidationError, are raised on the first attempt.
+"""
+
+from __future__ import annotations
+
+import time
+import random
+from typing import TYPE_CHECKING, TypeVar
+from dataclasses import dataclass
+
+from botocore.exceptions import ClientError, HTTPClientError
+from botocore.exceptions import ConnectionError as BotoConnectionError
+
+from credproxy.logger import LOG
+
+
+if TYPE_CHECKING:
+    from collections.abc import Callable
+
+    from credproxy.circuit_breaker import StsCircuitBreaker
+
+
+T = TypeVar("T")
+
+# Error codes of STS throttling requests
+THROTTLING_ERROR_CODES = ("Throttling", "ThrottlingException", "RequestLimitExceeded")
+# Seconds of the longest delay before the first retry, doubled on every retry
+RETRY_BACKOFF_BASE = 0.5
+# Retries of the botocore clients, disabled as STS calls are retried by the policy
+NO_CLIENT_RETRIES = {"total_max_attempts": 1}
+
+
+def is_throttling(error: Exception) -> bool:
+    """Check if STS rejected a call because of request rate limits."""
+    return (
+        isinstance(error, ClientError)
+        and error.response.get("Error", {}).get("Code") in THROTTLING_ERROR_CODES
+    )
+
+
+def is_retryable(error: Exception) -> bool:
+    """Check if an STS call failed for a transient reason."""
+    if isinstance(error, ClientError):
+        status_code = error.response.get("ResponseMetadata", {}).get(
+            "HTTPStatusCode", 0
+        )
+        return is_throttling(error) or status_code >= 500
+    return isinstance(error, (BotoConnectionError, HTTPClientError))
+
+
+@dataclass
+class StsRetryPolicy:
+    """Retry transient STS failures with exponential backoff and full jitter."""
+
+    max_attempts: int = 3
+    max_delay: float = 60.0  # Longest delay between two attempts
+    deadline: float | None = None  # time.monotonic() no retry may wait past
+    # Breaker of the region, failing attempts fast with CircuitOpen while open
+    circuit: StsCircuitBreaker | None = None
+
+    def call(self, operation: str, function: Callable[[], T]) -> T:
+        """Call function, retrying it while it fails for a transient reason."""
+        attempt = 1
+        while True:
+            probe = self.circuit.before_call() if self.circuit else False
+            try:
+                result = function()
+            except Exception as error:
+                if self.circuit:
+                    self.circuit.record(probe, error)
+                if not is_retryable(error) or attempt >= self.max_attempts:
+                    raise
+                delay = random.uniform(
+                    0, min(RETRY_BACKOFF_BASE * 2 ** (attempt - 1), self.max_delay)
+                )
+                if self.deadline is not None and (
+                    time.monotonic() + delay > self.deadline
+                ):
+                    LOG.debug(
+                        "Not retrying %s after attempt %d, past the request deadline",
+                        operation,
+                        attempt,
+                    )
+                    raise
+                LOG.debug(
+                    "Retrying %s in %.2f seconds after attempt %d/%d failed: %s",
+                    operation,
+                    delay,
+                    attempt,
+                    self.max_attempts,
+                    error,
+                )
+                time.sleep(delay)
+                attempt += 1
+            else:
+                if self.circuit:
+                    self.circuit.record(probe)
+                return result
@@ -3,15 +3,60 @@
 
 from __future__ import annotations
 
-from flask import Blueprint, g, jsonify, request
+import hmac
+import math
+from datetime import datetime, timezone
 
+from flask import Blueprint, g, jsonify, request, current_app
+from botocore.exceptions import ClientError, BotoCoreError
+
+from credproxy.tls import CLIENT_CN_ENVIRON
+from credproxy.audit import AuditRecord, token_fingerprint
 from credproxy.logger import LOG
-from credproxy.metrics import get_metrics
+from credproxy.tokens import TokenLifetimeError
+from credproxy.metrics import get_metrics, record_credentials_served
+from credproxy.rate_limit import RateLimitExceeded
+from credproxy.circuit_breaker import CircuitOpen
+from credproxy.credentials_handler import (
+    CREDENTIALS_LOOKUP,
+    EXPIRATION_FORMAT,
+    MFAPromptRequired,
+    CredentialsTimeout,
+    CredentialsStarting,
+)
 
 
 # Create a Blueprint for API routes
 api_bp = Blueprint("api", __name__)
 
+# Endpoints serving credentials, whose requests are recorded in the metrics
+CREDENTIALS_ENDPOINTS = ("api.get_credentials", "api.get_service_credentials")
+
+# STS error codes of role assumptions denied, answered with 403
+ACCESS_DENIED_ERROR_CODES = (
+    "AccessDenied",
+    "AccessDeniedException",
+    "ExpiredToken",
+    "ExpiredTokenException",
+    "IDPRejectedClaim",
+    "InvalidClientTokenId",
+    "InvalidIdentityToken",
+    "RegionDisabledException",
+)
+# STS error codes of invalid role assumption parameters, answered with 400
+VALIDATION_ERROR_CODES = (
+    "InvalidParameterValue",
+    "MalformedPolicyDocument",
+    "MalformedPolicyDocumentException",
+    "PackedPolicyTooLarge",
+    "PackedPolicyTooLargeException",
+    "ValidationError",
+)
+
+
+def _format_expiry(expiry: float) -> str:
+    return datetime.fromtimestamp(expiry, tz=timezone.utc).strftime(EXPIRATION_FORMAT)
+
 
 @api_bp.route("/health", methods=["GET", "HEAD"])
 def health_check():
@@ -29,58 +74,376 @@ def health_check():
     return jsonify({"status": "healthy", "services": len(config.services)})
 
 
-@api_bp.route("/v1/credentials", methods=["GET"])
-def get_credentials():
-    """Get AWS credentials for a service."""
+@api_bp.route("/healthz", methods=["GET", "HEAD"])
+def liveness_check():
+    """Liveness endpoint, answering as long as the server is running."""
+    return jsonify({"status": "alive"})
+
+
+@api_bp.route("/readyz", methods=["GET", "HEAD"])
+def readiness_check():
+    """Readiness endpoint, answering 503 while no credentials can be served."""
     from flask import current_app
 
-    config = current_app.config.get("credproxy_config")
     credentials_handler = current_app.config.get("credentials_handler")
+    ready, expiries = credentials_handler.readiness()
 
-    provided_token = request.headers.get("Authorization")
+    expirations = {
+        service_name: _format_expiry(expiry)
+        for service_name, expiry in sorted(expiries.items())
+    }
+    body = {
+        "status": "ready" if ready else "not ready",
+        # Earliest expiry of the cached credentials
+        "expiration": (
+            expirations[min(expiries, key=expiries.get)] if expiries else None
+        ),
+        "services": expirations,
+        # Source used last by the services with a credential source fallback chain
+        "sources": dict(sorted(credentials_handler.credential_sources().items())),
+    }
+    return jsonify(body), 200 if ready else 503
 
-    # Use simplified logger for structured logging
 
-    if not provided_token:
-        LOG.warning("Request missing Authorization header")
-        return jsonify({"error": "Authorization header required"}), 401
+def _lookup_token(config, provided_token: str) -> str | None:
+    """Get the service of the Authorization token, logging unknown tokens."""
+    LOG.debug(
+        "Attempting token validation",
+        extra={
+            "token_prefix": provided_token[:8] + "...",
+            "total_services": len(config.services),
+            "available_services": list(config.services.keys()),
+        },
+    )
 
-    try:
-        LOG.debug(
-            "Attempting token validation",
+    service_name = config.get_service_name_by_token(provided_token)
+    if not service_name:
+        LOG.warning(
+            "Invalid authorization token",
             extra={
                 "token_prefix": provided_token[:8] + "...",
                 "total_services": len(config.services),
                 "available_services": list(config.services.keys()),
             },
         )
+    return service_name
+
+
+def _lookup_client(config, provided_token: str | None) -> str | None:
+    """Get the client of the token, else of the client certificate."""
+    if provided_token:
+        client_name = config.get_client_name_by_token(provided_token)
+        if client_name:
+            return client_name
+    common_name = request.environ.get(CLIENT_CN_ENVIRON)
+    return config.get_client_name_by_cn(common_name) if common_name else None
+
+
+def sts_error_details(error: ClientError | BotoCoreError) -> tuple[dict, int]:
+    """Get the AWS error code, message and status of a failed role assumption."""
+    if isinstance(error, ClientError):
+        details = error.response.get("Error", {})
+        code = details.get("Code", "Unknown")
+        message = details.get("Message", str(error))
+    else:
+        # STS could not be reached or did not answer
+        code, message = type(error).__name__, str(error)
+
+    if code in ACCESS_DENIED_ERROR_CODES:
+        status = 403
+    elif code in VALIDATION_ERROR_CODES:
+        status = 400
+    else:
+        status = 502
+    return {"code": code, "message": message}, status
+
+
+def credentials_error_details(
+    error: Exception, service_name: str
+) -> tuple[dict, int, dict]:
+    """Get the error code, message, status and headers of credentials not served.
+
+    Shared by the container and IMDS endpoints. The AWS SDKs read the code and
+    message of credentials errors, so that applications see why no credentials
+    were provided, and retry after Retry-After when set.
+    """
+    if isinstance(error, RateLimitExceeded):
+        LOG.warning(
+            "Rate limit exceeded for service %s",
+            service_name,
+            extra={"remote": request.remote_addr},
+        )
+        retry_after = {"Retry-After": str(math.ceil(error.retry_after))}
+        return {"code": "Throttling", "message": "Rate exceeded"}, 429, retry_after
+    if isinstance(error, CredentialsTimeout):
+        # Answered before the SDK of the client gives up on its own timeout
+        return {"code": "RequestTimeout", "message": str(error)}, 504, {}
+    if isinstance(error, (CredentialsStarting, CircuitOpen)):
+        # Clients retry once the credentials are fetched again, or STS is
+        # called again
+        retry_after = {"Retry-After": str(math.ceil(error.retry_after))}
+        return {"code": "ServiceUnavailable", "message": str(error)}, 503, retry_after
+    if isinstance(error, TokenLifetimeError):
+        # Token lifecycle problem of the source, rather than a denied role
+        LOG.error("Source token of service %s cannot be used", service_name)
+        LOG.exception(error)
+        return {"code": error.code, "message": str(error)}, 503, {}
+    if isinstance(error, (ClientError, BotoCoreError)):
+        LOG.error("Failed to assume role for service %s", service_name)
+        LOG.exception(error)
+        details, status = sts_error_details(error)
+        return details, status, {}
+    LOG.error("Error getting credentials for service %s", service_name)
+    LOG.exception(error)
+    return {"code": "InternalError", "message": "Internal server error"}, 500, {}
 
-        service_name = config.get_service_name_by_token(provided_token)
-        if not service_name:
-            LOG.warning(
-                "Invalid authorization token",
-                extra={
-                    "token_prefix": provided_token[:8] + "...",
-                    "total_services": len(config.services),
-                    "available_services": list(config.services.keys()),
-                },
-            )
-            return jsonify({"error": "Invalid authorization token"}), 401
 
+def audit_vend(config, service_name: str, expiration: str, endpoint: str) -> None:
+    """Record the credentials vended to the client of the request, if auditing."""
+    audit_log = current_app.config.get("audit_log")
+    if audit_log is None:
+        return
+    token = request.headers.get("Authorization")
+    lookup = CREDENTIALS_LOOKUP.get()
+    audit_log.record(
+        AuditRecord(
+            service=service_name,
+            role_arn=config.services[service_name].assumed_role.RoleArn,
+            assumed_role_id=lookup.assumed_role_id if lookup else None,
+            cache=lookup.cache if lookup else None,
+            expiration=expiration,
+            endpoint=endpoint,
+            remote=request.remote_addr,
+            client=_lookup_client(config, token),
+            token_sha256=token_fingerprint(token),
+            client_cn=request.environ.get(CLIENT_CN_ENVIRON),
+        )
+    )
+
+
+def _provide_credentials(config, credentials_handler, service_name: str):
+    """Respond with the credentials of service_name."""
+    try:
         # Set service context in Flask g for logging
         service = config.services[service_name]
         g.service_name = service_name
         g.service_source_file = service.source_file
 
-        LOG.info("Providing credentials for service")
+        CREDENTIALS_LOOKUP.set(None)
+        # Clients are told apart by their token, else by their address
+        client = request.headers.get("Authorization") or request.remote_addr
+        credentials = credentials_handler.get_credentials_entry(service_name, client)
+        record_credentials_served(service.assumed_role.RoleArn)
 
-        credentials = credentials_handler.get_credentials(service_name)
-        return jsonify(credentials)
+        lookup = CREDENTIALS_LOOKUP.get()
+        LOG.info(
+            "Providing credentials for service",
+            extra={
+                "credentials": {
+                    "remote": request.remote_addr,
+                    "role": service.assumed_role.RoleArn,
+                    "cache": lookup.cache if lookup else None,
+                    "sts_duration": lookup.sts_duration if lookup else None,
+                }
+            },
+        )
+        audit_vend(config, service_name, credentials.expiration, "container")
+        # Marshaled once per set of credentials rather than on every request
+        return current_app.response_class(
+            credentials.response_body, mimetype="application/json"
+        )
 
     except Exception as error:
-        LOG.error("Error getting credentials")
+        body, status, headers = credentials_error_details(error, service_name)
+        return jsonify(body), status, headers
+
+
+@api_bp.route("/v1/credentials", methods=["GET"])
+def get_credentials():
+    """Get AWS credentials for the service of the authorization token."""
+    from flask import current_app
+
+    config = current_app.config.get("credproxy_config")
+    credentials_handler = current_app.config.get("credentials_handler")
+
+    # Sent by the AWS SDKs from AWS_CONTAINER_AUTHORIZATION_TOKEN
+    provided_token = request.headers.get("Authorization")
+
+    if not provided_token:
+        LOG.warning("Request missing Authorization header")
+        return jsonify({"error": "Authorization header required"}), 403
+
+    service_name = _lookup_token(config, provided_token)
+    if not service_name:
+        return jsonify({"error": "Invalid authorization token"}), 403
+
+    return _provide_credentials(config, credentials_handler, service_name)
+
+
+@api_bp.route("/v1/credentials/<service_name>", methods=["GET"])
+def get_service_credentials(service_name: str):
+    """Get AWS credentials for the service named in the path.
+
+    Clients are only served the services of their allowlist, and service tokens
+    only their own service.
+    """
+    from flask import current_app
+
+    config = current_app.config.get("credproxy_config")
+    credentials_handler = current_app.config.get("credentials_handler")
+
+    provided_token = request.headers.get("Authorization")
+
+    client_name = _lookup_client(config, provided_token)
+    if client_name:
+        client = config.clients.get(client_name)
+        # Checked first so clients cannot probe the services outside of their
+        # allowlist. Removed by a reload since the lookup, denied as an empty one
+        if not client or service_name not in client.services:
+            LOG.warning(
+                "Client %s is not allowed service %s", client_name, service_name
+            )
+            g.denied_reason = "not_allowed"
+            return jsonify({"error": f"Service {service_name} not allowed"}), 403
+        if service_name not in config.services:
+            LOG.warning("Request for unknown service %s", service_name)
+            return jsonify({"error": f"Unknown service {service_name}"}), 404
+        return _provide_credentials(config, credentials_handler, service_name)
+
+    if not provided_token:
+        LOG.warning("Request missing Authorization header")
+        return jsonify({"error": "Authorization header required"}), 403
+
+    token_service_name = _lookup_token(config, provided_token)
+    # Unknown tokens are rejected first so services cannot be probed without one
+    if not token_service_name:
+        return jsonify({"error": "Invalid authorization token"}), 403
+
+    if service_name not in config.services:
+        LOG.warning("Request for unknown service %s", service_name)
+        return jsonify({"error": f"Unknown service {service_name}"}), 404
+
+    if token_service_name != service_name:
+        LOG.warning(
+            "Token of service %s used for service %s",
+            token_service_name,
+            service_name,
+        )
+        return jsonify({"error": "Invalid authorization token"}), 403
+
+    return _provide_credentials(config, credentials_handler, service_name)
+
+
+def _check_admin_token(config):
+    """Get the error response of admin requests without the admin token, if any."""
+    admin_token = config.server.admin_token
+    if not admin_token:
+        return jsonify({"error": "Admin endpoints are disabled"}), 404
+
+    provided_token = request.headers.get("Authorization", "")
+    if not hmac.compare_digest(provided_token.encode(), admin_token.encode()):
+        LOG.warning("Invalid admin token", extra={"remote": request.remote_addr})
+        return jsonify({"error": "Invalid authorization token"}), 403
+    return None
+
+
+def _refresh_service(credentials_handler, service_name: str) -> tuple[dict, int]:
+    """Force the refresh of a service, with its new expiry or why it failed."""
+    try:
+        service_creds = credentials_handler.force_refresh(service_name)
+    except MFAPromptRequired as error:
+        LOG.warning("Not refreshing credentials for service %s", service_name)
+        return {"code": "MFARequired", "message": str(error)}, 409
+    except (ClientError, BotoCoreError) as error:
+        LOG.error("Failed to refresh credentials for service %s", service_name)
+        LOG.exception(error)
+        return sts_error_details(error)
+    except Exception as error:
+        LOG.error("Error refreshing credentials for service %s", service_name)
         LOG.exception(error)
-        return jsonify({"error": "Internal server error"}), 500
+        return {"code": "InternalError", "message": "Internal server error"}, 500
+    return {"expiration": _format_expiry(service_creds.expiry)}, 200
+
+
+@api_bp.route("/admin/refresh/<service_name>", methods=["POST"])
+def refresh_service_credentials(service_name: str):
+    """Assume the role of a service again, replacing its cached credentials."""
+    from flask import current_app
+
+    config = current_app.config.get("credproxy_config")
+    credentials_handler = current_app.config.get("credentials_handler")
+
+    error_response = _check_admin_token(config)
+    if error_response:
+        return error_response
+
+    if service_name not in config.services:
+        LOG.warning("Refresh of unknown service %s", service_name)
+        return jsonify({"error": f"Unknown service {service_name}"}), 404
+
+    body, status = _refresh_service(credentials_handler, service_name)
+    return jsonify({"service": service_name, **body}), status
+
+
+@api_bp.route("/admin/refresh", methods=["POST"])
+def refresh_all_credentials():
+    """Assume the roles of all the services again, answering 502 if any failed."""
+    from flask import current_app
+
+    config = current_app.config.get("credproxy_config")
+    credentials_handler = current_app.config.get("credentials_handler")
+
+    error_response = _check_admin_token(config)
+    if error_response:
+        return error_response
+
+    results = {
+        service_name: _refresh_service(credentials_handler, service_name)
+        for service_name in list(config.services)
+    }
+    failed = any(status != 200 for _, status in results.values())
+    body = {"services": {name: result for name, (result, _) in results.items()}}
+    return jsonify(body), 502 if failed else 200
+
+
+def _format_time(timestamp: float | None) -> str | None:
+    return _format_expiry(timestamp) if timestamp is not None else None
+
+
+@api_bp.route("/admin/status", methods=["GET"])
+def credentials_status():
+    """Describe the cached credentials of every service, without secret values."""
+    from flask import current_app
+
+    config = current_app.config.get("credproxy_config")
+    credentials_handler = current_app.config.get("credentials_handler")
+
+    error_response = _check_admin_token(config)
+    if error_response:
+        return error_response
+
+    statuses = credentials_handler.credentials_status()
+    body = {
+        "services": {
+            service_name: {
+                "source": status.source,
+                "role_arn": status.role_arn,
+                "cache": status.cache,
+                "expiration": _format_time(status.expiry),
+                "last_refresh": _format_time(status.last_refresh),
+                "last_error": status.last_error,
+                "refreshing": status.refreshing,
+            }
+            for service_name, status in sorted(statuses.items())
+        },
+        "sts_circuits": {
+            region: status.to_dict()
+            for region, status in sorted(
+                credentials_handler.circuits_status().items()
+            )
+        },
+    }
+    return jsonify(body)
 
 
 def register_metrics_route(app, config):
@@ -6,58 +6,178 @@
 from __future__ import annotations
 
 import sys
+import json
 import signal
+import threading
 import logging as logthings
 from typing import TYPE_CHECKING
+from dataclasses import asdict
 
 
 if TYPE_CHECKING:
     import argparse
+    from collections.abc import Callable
 
     from flask import Flask
 
 
 from credproxy.app import init_app
-from credproxy.config import Config
-from credproxy.logger import LOG
+from credproxy.tls import ReloadableSSLContext
+from credproxy.audit import AuditLog
+from credproxy.config import Config, TLSConfig, RateLimitConfig, SourceCredentialsConfig
+from credproxy.logger import LOG, flush_logs
+from credproxy.server import CredProxyServer
+from credproxy.tracing import shutdown_tracing
+from credproxy.version import build_info
+from credproxy.processes import terminate_children, install_orphan_reaper
+from credproxy.disk_cache import create_disk_cache
+from credproxy.named_pipe import NamedPipeServer
+from credproxy.unix_socket import UnixSocketServer
+from credproxy.credentials_handler import CredentialsHandler, CredentialProcessResponse
 
 
 # Global flag for graceful shutdown
 shutdown_requested = False
+# Set by the signal handlers to stop serving and drain in-flight requests
+shutdown_event = threading.Event()
+# Set on SIGHUP to run the reload callbacks
+reload_event = threading.Event()
+# Called from the reloader thread on SIGHUP
+reload_callbacks: list[Callable[[], object]] = []
+# Set when credentials still failed credentials.startup_max_wait after startup
+startup_failed = threading.Event()
+# Seconds between checks of the reloader thread for shutdown
+RELOAD_CHECK_INTERVAL = 1.0
 
 
 def setup_signal_handlers() -> None:
     """Setup signal handlers for graceful shutdown."""
 
     def signal_handler(signum, frame):
-        """Handle shutdown signals gracefully."""
+        """Handle shutdown signals by starting the drain of the server."""
         global shutdown_requested
         if not shutdown_requested:
             shutdown_requested = True
             LOG.info("Received signal %d, initiating graceful shutdown...", signum)
+            # The server stops accepting connections and drains from run_server
+            shutdown_event.set()
 
-            # Get access to the Flask app for cleanup
-            try:
-                from flask import current_app
-
-                if current_app and hasattr(current_app, "config"):
-                    credentials_handler = current_app.config.get("credentials_handler")
-                    if credentials_handler and hasattr(credentials_handler, "cleanup"):
-                        credentials_handler.cleanup()
-
-                    file_watcher = current_app.config.get("file_watcher")
-                    if file_watcher and hasattr(file_watcher, "stop"):
-                        file_watcher.stop()
-            except Exception:
-                # Ignore cleanup errors during shutdown
-                pass
-
-            LOG.info("Graceful shutdown completed")
-            sys.exit(0)
+    def reload_handler(signum, frame):
+        """Handle reload signals, reloading outside of the signal handler."""
+        LOG.info("Received signal %d, reloading", signum)
+        reload_event.set()
 
     # Register signal handlers
+    install_orphan_reaper()
     signal.signal(signal.SIGTERM, signal_handler)
     signal.signal(signal.SIGINT, signal_handler)
+    # SIGHUP is not available on Windows
+    if hasattr(signal, "SIGHUP"):
+        signal.signal(signal.SIGHUP, reload_handler)
+
+
+def give_up_startup() -> None:
+    """Stop serving, credentials still failing after the startup wait."""
+    startup_failed.set()
+    shutdown_event.set()
+
+
+def run_reload_callbacks() -> None:
+    """Run every reload callback, logging the ones failing."""
+    for callback in list(reload_callbacks):
+        try:
+            callback()
+        except Exception as error:
+            LOG.error("Reload failed")
+            LOG.exception(error)
+
+
+def start_reloader() -> threading.Thread:
+    """Start the thread running the reload callbacks on SIGHUP until shutdown."""
+
+    def reload_on_signal():
+        while not shutdown_event.is_set():
+            if reload_event.wait(timeout=RELOAD_CHECK_INTERVAL):
+                reload_event.clear()
+                run_reload_callbacks()
+
+    thread = threading.Thread(target=reload_on_signal, daemon=True, name="reloader")
+    thread.start()
+    return thread
+
+
+def reload_config(app: Flask, args: argparse.Namespace) -> bool:
+    """Read the configuration file again and apply the services changed.
+
+    Services of the file added, removed or changed are applied, keeping the
+    cached credentials of the unchanged ones. The current configuration is
+    kept if the file fails to load or validate.
+    """
+    config: Config = app.config["credproxy_config"]
+    credentials_handler: CredentialsHandler = app.config["credentials_handler"]
+    try:
+        reloaded = Config.from_file(args.config)
+        apply_cli_overrides(reloaded, args)
+    except Exception as error:
+        LOG.error(
+            "Failed to reload configuration from %s, keeping the current one",
+            args.config,
+        )
+        LOG.exception(error)
+        return False
+
+    # Services of the dynamic services directories are left to the file watcher
+    source_files = {service.source_file for service in reloaded.services.values()}
+    current = {
+        service_name: service
+        for service_name, service in config.services.items()
+        if service.source_file in source_files
+    }
+    for service_name in current.keys() - reloaded.services.keys():
+        config.remove_service(service_name)
+        credentials_handler.discard_credentials(service_name)
+    for service_name, service in reloaded.services.items():
+        if service_name not in current:
+            config.add_service(service_name, service)
+        elif service != current[service_name]:
+            config.update_service(service_name, service)
+            credentials_handler.discard_credentials(service_name)
+
+    config.credentials = reloaded.credentials
+    config.aws_defaults = reloaded.aws_defaults
+    config.imds = reloaded.imds
+    config.clients = reloaded.clients
+    for setting in ("server", "metrics", "dynamic_services"):
+        if getattr(reloaded, setting) != getattr(config, setting):
+            LOG.warning("Changes of %s settings take effect after a restart", setting)
+    LOG.info("Reloaded configuration from %s", args.config)
+    return True
+
+
+def stop_background_services(app: Flask) -> None:
+    """Stop the credentials refresher and file watcher of the app."""
+    try:
+        credentials_handler = app.config.get("credentials_handler")
+        if credentials_handler and hasattr(credentials_handler, "cleanup"):
+            credentials_handler.cleanup()
+
+        file_watcher = app.config.get("file_watcher")
+        if file_watcher and hasattr(file_watcher, "stop"):
+            file_watcher.stop()
+
+        audit_log = app.config.get("audit_log")
+        if isinstance(audit_log, AuditLog):
+            audit_log.close()
+
+        # Commands such as SAML assertion commands are not left running
+        terminate_children()
+
+        # Export the spans not exported yet
+        shutdown_tracing()
+    except Exception as error:
+        # Ignore cleanup errors during shutdown
+        LOG.error("Error stopping background services")
+        LOG.exception(error)
 
 
 def validate_config_file(config_path: str) -> bool:
@@ -81,21 +201,145 @@ def setup_cli_logging(log_level: str) -> None:
         handler.setLevel(level)
 
 
+def apply_cli_overrides(config: Config, args: argparse.Namespace) -> None:
+    """Apply command-line overrides on top of the loaded configuration."""
+    if getattr(args, "imds_mode", None):
+        config.imds.mode = args.imds_mode
+    if getattr(args, "refresh_window", None) is not None:
+        config.credentials.refresh_buffer_seconds = args.refresh_window
+    if getattr(args, "refresh_jitter", None) is not None:
+        config.credentials.refresh_jitter_seconds = args.refresh_jitter
+    if getattr(args, "expiry_skew", None) is not None:
+        config.credentials.expiry_skew_seconds = args.expiry_skew
+    if getattr(args, "sts_max_attempts", None) is not None:
+        config.credentials.sts_max_attempts = args.sts_max_attempts
+    if getattr(args, "request_timeout", None) is not None:
+        config.credentials.request_timeout = args.request_timeout
+    if getattr(args, "startup_max_wait", None) is not None:
+        config.credentials.startup_max_wait = args.startup_max_wait
+    if getattr(args, "cache_dir", None):
+        config.credentials.cache_dir = args.cache_dir
+    if getattr(args, "cache_key_file", None):
+        config.credentials.cache_key_file = args.cache_key_file
+    if getattr(args, "rate_limit", None):
+        if config.credentials.rate_limit is None:
+            config.credentials.rate_limit = RateLimitConfig(args.rate_limit)
+        config.credentials.rate_limit.requests_per_second = args.rate_limit
+    rate_burst = getattr(args, "rate_burst", None)
+    rate_limit_sts_only = getattr(args, "rate_limit_sts_only", None)
+    if rate_burst or rate_limit_sts_only:
+        if config.credentials.rate_limit is None:
+            raise ValueError(
+                "--rate-burst and --rate-limit-sts-only require a rate limit, "
+                "set with --rate-limit or credentials.rate_limit"
+            )
+        if rate_burst:
+            config.credentials.rate_limit.burst = rate_burst
+        if rate_limit_sts_only:
+            config.credentials.rate_limit.sts_requests_only = True
+    if getattr(args, "listen_unix", None):
+        config.server.unix_socket = args.listen_unix
+    if getattr(args, "listen_pipe", None):
+        config.server.named_pipe = args.listen_pipe
+    if getattr(args, "audit_log", None):
+        config.server.audit_log = args.audit_log
+    if getattr(args, "metrics_addr", None):
+        config.metrics.prometheus.enabled = True
+        config.metrics.prometheus.host, config.metrics.prometheus.port = (
+            args.metrics_addr
+        )
+    if getattr(args, "tracing", None):
+        config.metrics.tracing.enabled = True
+    if getattr(args, "shutdown_timeout", None) is not None:
+        config.server.shutdown_timeout = args.shutdown_timeout
+    if getattr(args, "tls_cert", None) and getattr(args, "tls_key", None):
+        config.server.tls = TLSConfig(
+            cert_file=args.tls_cert,
+            key_file=args.tls_key,
+            client_ca_file=config.server.tls and config.server.tls.client_ca_file,
+        )
+    if getattr(args, "tls_client_ca", None):
+        if config.server.tls is None:
+            raise ValueError("--tls-client-ca requires --tls-cert and --tls-key")
+        config.server.tls.client_ca_file = args.tls_client_ca
+    for key in ("region", "sts_endpoint"):
+        if getattr(args, key, None):
+            override_source_credentials(config, key, getattr(args, key))
+    if getattr(args, "region", None):
+        # Checked when loaded against the regions of the configuration file
+        for service_name, service_config in config.services.items():
+            config._validate_partition(service_name, service_config)
+
+
+def override_source_credentials(config: Config, key: str, value: str) -> None:
+    """Set a source credentials setting of the defaults and of every service."""
+    # Also applies to the dynamic services, merged with aws_defaults when loaded
+    if config.aws_defaults is None:
+        config.aws_defaults = SourceCredentialsConfig()
+    for source_credentials in [
+        config.aws_defaults,
+        *(service.source_credentials for service in config.services.values()),
+    ]:
+        setattr(source_credentials, key, value)
+
+
+def print_process_credentials(args: argparse.Namespace) -> int:
+    """Print the credentials of a service for the AWS CLI credential_process."""
+    credentials_handler: CredentialsHandler | None = None
+    try:
+        config = Config.from_file(args.config)
+        apply_cli_overrides(config, args)
+        if args.service not in config.services:
+            LOG.error("Service %s is not defined in %s", args.service, args.config)
+            return 1
+
+        # Resolved as for the server, without serving or refreshing afterwards
+        disk_cache = create_disk_cache(
+            config.credentials.cache_dir, config.credentials.cache_key_file
+        )
+        credentials_handler = CredentialsHandler(config, disk_cache=disk_cache)
+        credentials = credentials_handler.get_credentials(args.service)
+    except Exception as error:
+        LOG.error("Failed to get credentials for service %s", args.service)
+        LOG.exception(error)
+        return 1
+    finally:
+        if credentials_handler:
+            credentials_handler.cleanup()
+
+    response = CredentialProcessResponse.from_container_credentials(credentials)
+    # Only the credentials are written on stdout, logs go to stderr
+    sys.stdout.write(json.dumps(asdict(response)) + "\n")
+    sys.stdout.flush()
+    return 0
+
+
 def run_server(args: argparse.Namespace) -> int:
     """Run the CredProxy server with the given arguments."""
+    app: Flask | None = None
+    unix_server: UnixSocketServer | None = None
+    pipe_server: NamedPipeServer | None = None
     try:
-        # Setup signal handlers for graceful shutdown
+        # Setup signal handlers for graceful shutdown, before binding any socket
         setup_signal_handlers()
 
         # Run the main application
-        LOG.info("CredProxy CLI")
+        info = build_info()
+        LOG.info(
+            "Starting CredProxy %s (git commit %s, built %s, Python %s)",
+            info.version,
+            info.git_commit,
+            info.build_date,
+            info.python_version,
+        )
         LOG.info("Configuration file: %s", args.config)
 
         LOG.info("Running CredProxy with Flask server")
 
         # Load config and create Flask app
         config = Config.from_file(args.config)
-        app: Flask = init_app(config)
+        apply_cli_overrides(config, args)
+        app = init_app(config)
 
         # Override debug mode if --dev flag is set
         debug_mode = config.server.debug or args.dev
@@ -121,7 +365,47 @@ def run_server(args: argparse.Namespace) -> int:
             except Exception as error:
                 LOG.error("Failed to start metrics server: %s", error)
 
-        app.run(host=config.server.host, port=config.server.port, debug=debug_mode)
+        # Configuration and certificates are read again on SIGHUP
+        reload_callbacks.append(lambda: reload_config(app, args))
+        tls_context: ReloadableSSLContext | None = None
+        if config.server.tls:
+            tls_context = ReloadableSSLContext(config.server.tls)
+            reload_callbacks.append(tls_context.reload)
+        # Reopened once rotated, such as by logrotate
+        audit_log = app.config.get("audit_log")
+        if isinstance(audit_log, AuditLog):
+            reload_callbacks.append(audit_log.reopen)
+
+        # Created first so requests on every listener are drained on shutdown
+        app.debug = debug_mode
+        server = CredProxyServer(
+            app,
+            config.server.host,
+            config.server.port,
+            shutdown_timeout=config.server.shutdown_timeout,
+            ssl_context=tls_context.context if tls_context else None,
+            read_timeout=config.server.read_timeout,
+            write_timeout=config.server.write_timeout,
+            idle_timeout=config.server.idle_timeout,
+        )
+        start_reloader()
+
+        if config.server.unix_socket:
+            unix_server = UnixSocketServer(app, config.server.unix_socket)
+            unix_server.start()
+        if config.server.named_pipe:
+            pipe_server = NamedPipeServer(app, config.server.named_pipe)
+            pipe_server.start()
+
+        # Listening meanwhile, not ready until the credentials are obtained
+        startup_failed.clear()
+        app.config["credentials_handler"].start_startup_fetches(
+            config.credentials.startup_max_wait, give_up_startup
+        )
+
+        if not server.serve_until(shutdown_event) or startup_failed.is_set():
+            # Let orchestrators know some requests were cut short or startup failed
+            return 1
 
     except KeyboardInterrupt:
         LOG.info("Shutting down gracefully...")
@@ -129,5 +413,15 @@ def run_server(args: argparse.Namespace) -> int:
     except Exception as error:
         LOG.error("Fatal error: %s", str(error))
         return 1
+    finally:
+        reload_callbacks.clear()
+        if unix_server:
+            unix_server.stop()
+        if pipe_server:
+            pipe_server.stop()
+        if app is not None:
+            stop_background_services(app)
+            LOG.info("Graceful shutdown completed")
+        flush_logs()
 
     return 0
@@ -0,0 +1,230 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""SAML federation source credentials.
+
+The SAML response of the identity provider is read from a file, or printed by a
+command such as a login helper, and exchanged with AssumeRoleWithSAML. Getting
+an assertion may require a login, so the role session is reused until shortly
+before it expires rather than getting a new assertion for every refresh.
+
+Assertions are checked before calling STS so that expired assertions, and
+assertions not listing the role, fail with a clear error. Encrypted assertions
+cannot be read and are sent unchecked.
+"""
+
+from __future__ import annotations
+
+import time
+import shlex
+import base64
+import binascii
+import threading
+import subprocess
+from typing import TYPE_CHECKING
+from datetime import datetime
+from xml.etree import ElementTree
+
+import boto3
+from botocore import UNSIGNED
+from botocore.config import Config as BotoConfig
+
+from credproxy.retry import NO_CLIENT_RETRIES
+from credproxy.logger import LOG
+from credproxy.tracing import span
+from credproxy.sts_http import StsHttpOptions
+from credproxy.providers import SourceCredentials, CredentialsProvider
+from credproxy.processes import run_command
+from credproxy.sanitizer import register_sensitive_value
+
+
+if TYPE_CHECKING:
+    from credproxy.retry import StsRetryPolicy
+
+
+SAML_ASSERTION_NAMESPACE = "urn:oasis:names:tc:SAML:2.0:assertion"
+SAML_NAMESPACES = {"saml": SAML_ASSERTION_NAMESPACE}
+# Attribute listing the roles of the assertion, as "role ARN,principal ARN" values
+SAML_ROLE_ATTRIBUTE = "https://aws.amazon.com/SAML/Attributes/Role"
+# Seconds given to the assertion command, which may wait for a login
+ASSERTION_COMMAND_TIMEOUT = 120
+# Role sessions are reused until this many seconds before they expire
+SESSION_EXPIRY_MARGIN = 300
+
+
+class SAMLAssertionError(ValueError):
+    """Raised when a SAML assertion cannot be used to assume the role."""
+
+
+def encode_assertion(data: str) -> str:
+    """Get the base64 encoded SAML response of data, as XML or base64 already."""
+    data = data.strip()
+    if not data:
+        raise SAMLAssertionError("The SAML response is empty")
+    if data.startswith("<"):
+        return base64.b64encode(data.encode()).decode()
+    # Encoded responses are often wrapped over several lines
+    encoded = "".join(data.split())
+    try:
+        base64.b64decode(encoded, validate=True)
+    except binascii.Error as error:
+        raise SAMLAssertionError("The SAML response is not XML or base64") from error
+    return encoded
+
+
+def _parse_time(value: str) -> float:
+    try:
+        return datetime.fromisoformat(value).timestamp()
+    except ValueError as error:
+        raise SAMLAssertionError(f"Invalid SAML assertion time {value}") from error
+
+
+def check_assertion(
+    encoded: str, role_arn: str, principal_arn: str, now: float | None = None
+) -> None:
+    """Check the SAML response has not expired and lists the role.
+
+    Raises SAMLAssertionError otherwise. Responses without a readable assertion,
+    such as encrypted assertions, are not checked.
+    """
+    try:
+        root = ElementTree.fromstring(base64.b64decode(encoded))
+    except ElementTree.ParseError as error:
+        raise SAMLAssertionError("The SAML response is not valid XML") from error
+    assertion = (
+        root
+        if root.tag == f"{{{SAML_ASSERTION_NAMESPACE}}}Assertion"
+        else root.find("saml:Assertion", SAML_NAMESPACES)
+    )
+    if assertion is None:
+        LOG.debug("No readable assertion in the SAML response, not checking it")
+        return
+
+    now = time.time() if now is None else now
+    for element in (
+        assertion.find("saml:Conditions", SAML_NAMESPACES),
+        *assertion.iterfind(".//saml:SubjectConfirmationData", SAML_NAMESPACES),
+    ):
+        not_on_or_after = element.get("NotOnOrAfter") if element is not None else None
+        if not_on_or_after and _parse_time(not_on_or_after) <= now:
+            raise SAMLAssertionError(f"The SAML assertion expired at {not_on_or_after}")
+
+    roles = [
+        {arn.strip() for arn in value.text.split(",")}
+        for value in assertion.iterfind(
+            f".//saml:Attribute[@Name='{SAML_ROLE_ATTRIBUTE}']/saml:AttributeValue",
+            SAML_NAMESPACES,
+        )
+        if value.text
+    ]
+    if {role_arn, principal_arn} not in roles:
+        raise SAMLAssertionError(
+            f"The SAML assertion does not list role {role_arn} of {principal_arn}"
+        )
+
+
+class SAMLAssertionProvider(CredentialsProvider):
+    """Assume a role with the SAML assertion of a file or command."""
+
+    role_session = True
+
+    def __init__(
+        self,
+        principal_arn: str,
+        role_arn: str,
+        assertion_file: str | None = None,
+        assertion_command: str | None = None,
+        region: str | None = None,
+        sts_endpoint: str | None = None,
+        http_options: StsHttpOptions | None = None,
+    ):
+        self.principal_arn = principal_arn
+        self.role_arn = role_arn
+        self.assertion_file = assertion_file
+        self.assertion_command = assertion_command
+        self.region = region
+        self.sts_endpoint = sts_endpoint
+        self.http_options = http_options or StsHttpOptions()
+        # Credentials of the current role session
+        self._credentials: SourceCredentials | None = None
+        # Only one assertion is obtained at a time, and reused by waiting calls
+        self._lock = threading.Lock()
+
+    def read_assertion(self) -> str:
+        """Get the base64 encoded SAML response of the file or command."""
+        if self.assertion_command:
+            try:
+                # stdin and stderr are left to the command, to prompt for a login
+                data = run_command(
+                    shlex.split(self.assertion_command), ASSERTION_COMMAND_TIMEOUT
+                )
+            except subprocess.CalledProcessError as error:
+                raise SAMLAssertionError(
+                    f"SAML assertion command exited with code {error.returncode}"
+                ) from error
+            except subprocess.TimeoutExpired as error:
+                raise SAMLAssertionError(
+                    "SAML assertion command did not complete within "
+                    f"{ASSERTION_COMMAND_TIMEOUT} seconds"
+                ) from error
+        else:
+            with open(self.assertion_file, encoding="utf-8") as assertion_file:
+                data = assertion_file.read()
+
+        assertion = encode_assertion(data)
+        register_sensitive_value(assertion)
+        return assertion
+
+    def retrieve(self, retry_policy: StsRetryPolicy | None = None) -> SourceCredentials:
+        """Get the credentials of role_arn, with AssumeRoleWithSAML when expiring."""
+        with self._lock:
+            if self._credentials and not self._credentials.is_expired(
+                SESSION_EXPIRY_MARGIN
+            ):
+                LOG.debug("Reusing SAML role session of %s", self.role_arn)
+                return self._credentials
+
+            assertion = self.read_assertion()
+            check_assertion(assertion, self.role_arn, self.principal_arn)
+            # The SAML assertion is the only proof of identity, the call is not
+            # signed with any other credentials
+            sts_client = boto3.client(
+                "sts",
+                region_name=self.region,
+                endpoint_url=self.sts_endpoint,
+                verify=self.http_options.verify,
+                config=BotoConfig(
+                    signature_version=UNSIGNED,
+                    retries=NO_CLIENT_RETRIES,
+                    proxies=self.http_options.proxies,
+                ),
+            )
+
+            def assume_role_with_saml() -> dict:
+                with span(
+                    "sts.AssumeRoleWithSAML", {"credproxy.role_arn": self.role_arn}
+                ):
+                    return sts_client.assume_role_with_saml(
+                        RoleArn=self.role_arn,
+                        PrincipalArn=self.principal_arn,
+                        SAMLAssertion=assertion,
+                    )
+
+            if retry_policy is None:
+                response = assume_role_with_saml()
+            else:
+                response = retry_policy.call(
+                    "AssumeRoleWithSAML", assume_role_with_saml
+                )
+
+            credentials = response["Credentials"]
+            register_sensitive_value(credentials["AccessKeyId"])
+            register_sensitive_value(credentials["SecretAccessKey"])
+            register_sensitive_value(credentials["SessionToken"])
+            self._credentials = SourceCredentials(
+                access_key_id=credentials["AccessKeyId"],
+                secret_access_key=credentials["SecretAccessKey"],
+                session_token=credentials["SessionToken"],
+                expiration=credentials["Expiration"].timestamp(),
+            )
+            return self._credentials
@@ -0,0 +1,225 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Serve the CredProxy application over TCP with graceful shutdown."""
+
+from __future__ import annotations
+
+import threading
+from typing import TYPE_CHECKING
+
+from werkzeug.wsgi import ClosingIterator
+from werkzeug.serving import WSGIRequestHandler, make_server
+
+from credproxy.tls import CLIENT_CN_ENVIRON, client_common_name
+from credproxy.logger import LOG
+
+
+if TYPE_CHECKING:
+    import ssl
+    from collections.abc import Callable, Iterable
+
+    from flask import Flask
+    from werkzeug.serving import BaseWSGIServer
+
+
+# Seconds given to in-flight requests to complete on shutdown
+DEFAULT_SHUTDOWN_TIMEOUT = 10.0
+# Seconds between checks that the server thread is still serving
+SERVE_CHECK_INTERVAL = 1.0
+# Seconds a connection may block on a read or a write, and stay idle
+DEFAULT_READ_TIMEOUT = 10.0
+DEFAULT_WRITE_TIMEOUT = 10.0
+DEFAULT_IDLE_TIMEOUT = 60.0
+
+
+class InFlightRequests:
+    """WSGI middleware counting requests whose response is not fully sent."""
+
+    def __init__(self, wsgi_app: Callable):
+        self.wsgi_app = wsgi_app
+        self._count = 0
+        self._idle = threading.Condition()
+
+    def __call__(self, environ: dict, start_response: Callable) -> Iterable[bytes]:
+        with self._idle:
+            self._count += 1
+        try:
+            response = self.wsgi_app(environ, start_response)
+        except BaseException:
+            self._finished()
+            raise
+        # The server closes the response once the body is written to the client
+        return ClosingIterator(response, self._finished)
+
+    @property
+    def count(self) -> int:
+        """Number of requests currently being handled."""
+        with self._idle:
+            return self._count
+
+    def wait_idle(self, timeout: float) -> bool:
+        """Wait for all in-flight requests to complete, False on timeout."""
+        with self._idle:
+            return self._idle.wait_for(lambda: self._count == 0, timeout=timeout)
+
+    def _finished(self) -> None:
+        with self._idle:
+            self._count -= 1
+            if self._count == 0:
+                self._idle.notify_all()
+
+
+class TimeoutRequestHandler(WSGIRequestHandler):
+    """Request handler closing connections of clients too slow to send or read.
+
+    Bounds how long a connection holds its thread, so that clients sending
+    requests one byte at a time cannot exhaust the threads of the listener.
+    The timeouts apply to each read or write of the socket.
+    """
+
+    read_timeout: float = DEFAULT_READ_TIMEOUT
+    write_timeout: float = DEFAULT_WRITE_TIMEOUT
+    idle_timeout: float = DEFAULT_IDLE_TIMEOUT
+
+    def setup(self) -> None:
+        # Applied by StreamRequestHandler to the socket of the connection
+        self.timeout = self.read_timeout
+        self._requests_handled = 0
+        super().setup()
+
+    def handle_one_request(self) -> None:
+        if self._requests_handled:
+            # Kept alive connection waiting for its next request line
+            self.connection.settimeout(self.idle_timeout)
+        super().handle_one_request()
+        self._requests_handled += 1
+
+    def parse_request(self) -> bool:
+        self.connection.settimeout(self.read_timeout)
+        return super().parse_request()
+
+    def send_response(self, code: int, message: str | None = None) -> None:
+        self.connection.settimeout(self.write_timeout)
+        super().send_response(code, message)
+
+    def make_environ(self) -> dict:
+        environ = super().make_environ()
+        # Identifies clients of the allowlists with mutual TLS
+        environ[CLIENT_CN_ENVIRON] = client_common_name(self.connection)
+        return environ
+
+    @classmethod
+    def with_timeouts(
+        cls, read_timeout: float, write_timeout: float, idle_timeout: float
+    ) -> type[TimeoutRequestHandler]:
+        """Create a request handler class with the given timeouts."""
+        return type(
+            cls.__name__,
+            (cls,),
+            {
+                "read_timeout": read_timeout,
+                "write_timeout": write_timeout,
+                "idle_timeout": idle_timeout,
+            },
+        )
+
+
+class CredProxyServer:
+    """Serve a Flask app over TCP, draining in-flight requests on shutdown."""
+
+    def __init__(
+        self,
+        app: Flask,
+        host: str,
+        port: int,
+        shutdown_timeout: float = DEFAULT_SHUTDOWN_TIMEOUT,
+        ssl_context: ssl.SSLContext | None = None,
+        read_timeout: float = DEFAULT_READ_TIMEOUT,
+        write_timeout: float = DEFAULT_WRITE_TIMEOUT,
+        idle_timeout: float = DEFAULT_IDLE_TIMEOUT,
+    ):
+        self.app = app
+        self.host = host
+        self.port = port
+        self.shutdown_timeout = shutdown_timeout
+        self.ssl_context = ssl_context
+        self.request_handler = TimeoutRequestHandler.with_timeouts(
+            read_timeout, write_timeout, idle_timeout
+        )
+        # Count requests of every listener serving the app, unix socket included
+        self.in_flight = InFlightRequests(app.wsgi_app)
+        app.wsgi_app = self.in_flight
+        self._server: BaseWSGIServer | None = None
+        self._thread: threading.Thread | None = None
+
+    def start(self) -> None:
+        """Bind the TCP socket and serve in background."""
+        self._server = make_server(
+            self.host,
+            self.port,
+            self.app,
+            threaded=True,
+            request_handler=self.request_handler,
+            ssl_context=self.ssl_context,
+        )
+        self._thread = threading.Thread(
+            target=self._server.serve_forever, daemon=True, name="http-server"
+        )
+        self._thread.start()
+        # Resolves the port when binding to port 0
+        self.port = self._server.port
+        LOG.info(
+            "Serving on %s://%s:%d",
+            "https" if self.ssl_context else "http",
+            self.host,
+            self.port,
+        )
+
+    def serve_until(self, stop_event: threading.Event) -> bool:
+        """Serve until stop_event is set, then shut down.
+
+        Returns False if in-flight requests did not complete within the
+        shutdown timeout.
+        """
+        if stop_event.is_set():
+            LOG.info("Shutdown requested before serving")
+            return True
+        self.start()
+        while not stop_event.wait(timeout=SERVE_CHECK_INTERVAL):
+            if not self._thread.is_alive():
+                LOG.error("HTTP server stopped unexpectedly")
+                break
+        return self.shutdown()
+
+    def shutdown(self) -> bool:
+        """Stop accepting connections and drain in-flight requests.
+
+        Returns False if requests were still in flight after the timeout.
+        """
+        if self._server is None:
+            return True
+
+        # Requests arriving on already accepted connections are turned away
+        self.app.config["_shutdown_requested"] = True
+        LOG.info("Stopping HTTP server on %s:%d", self.host, self.port)
+        self._server.shutdown()
+        self._server.server_close()
+        self._server = None
+
+        in_flight = self.in_flight.count
+        if in_flight:
+            LOG.info(
+                "Waiting up to %.1f seconds for %d in-flight requests",
+                self.shutdown_timeout,
+                in_flight,
+            )
+        if not self.in_flight.wait_idle(self.shutdown_timeout):
+            LOG.error(
+                "Shutdown timed out after %.1f seconds with %d requests in flight",
+                self.shutdown_timeout,
+                self.in_flight.count,
+            )
+            return False
+        LOG.info("All in-flight requests completed")
+        return True
@@ -0,0 +1,66 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Role session name templates.
+
+Session names are recorded by CloudTrail with every call made with the role
+session, so they default to naming the user and host running CredProxy. The
+variables of a template are expanded once, when the service is loaded.
+"""
+
+from __future__ import annotations
+
+import re
+import socket
+import getpass
+
+from credproxy.logger import LOG
+
+
+DEFAULT_SESSION_NAME = "credproxy-{{.User}}-{{.Hostname}}"
+# Longest RoleSessionName accepted by STS
+MAX_SESSION_NAME_LENGTH = 64
+TEMPLATE_VARIABLE_PATTERN = re.compile(r"\{\{ *\.(User|Hostname|ProfileName) *\}\}")
+# Characters STS does not accept in a RoleSessionName
+INVALID_CHARACTERS_PATTERN = re.compile(r"[^a-zA-Z0-9+=,.@_-]")
+
+
+def _current_user() -> str:
+    try:
+        return getpass.getuser()
+    except (KeyError, OSError):
+        # No user name for the UID, as in containers run with an arbitrary UID
+        return "unknown"
+
+
+def expand_session_name(template: str, profile_name: str) -> str:
+    """Expand the variables of a session name template for a service.
+
+    Characters STS does not accept are replaced with dashes and names longer
+    than STS accepts are truncated, with a warning rather than failing.
+    """
+    variables = {
+        "User": _current_user,
+        "Hostname": socket.gethostname,
+        "ProfileName": lambda: profile_name,
+    }
+    session_name = TEMPLATE_VARIABLE_PATTERN.sub(
+        lambda match: variables[match.group(1)](), template
+    )
+
+    valid_session_name = INVALID_CHARACTERS_PATTERN.sub("-", session_name)
+    if valid_session_name != session_name:
+        LOG.warning(
+            "Replaced characters not allowed by STS in session name %s of %s",
+            session_name,
+            profile_name,
+        )
+    if len(valid_session_name) > MAX_SESSION_NAME_LENGTH:
+        LOG.warning(
+            "Truncating session name %s of %s to %d characters",
+            valid_session_name,
+            profile_name,
+            MAX_SESSION_NAME_LENGTH,
+        )
+        valid_session_name = valid_session_name[:MAX_SESSION_NAME_LENGTH]
+    return valid_session_name
@@ -50,6 +50,12 @@ def get_log_level(namespace: str) -> str:
     return _validate_log_level(raw_level)
 
 
+def get_log_format(namespace: str) -> str:
+    """Get log format (json or text) from environment with fallback to json."""
+    raw_format = os.environ.get(f"{namespace}LOG_FORMAT", "json").lower().strip()
+    return raw_format if raw_format in {"json", "text"} else "json"
+
+
 def get_log_health_checks(namespace: str) -> bool:
     """Get health check logging setting from environment."""
     raw_value = os.environ.get(f"{namespace}LOG_HEALTH_CHECKS", "").lower().strip()
@@ -65,4 +71,5 @@ TAG_SEPARATOR: str = get_tag_separator(NAMESPACE)
 
 # Logging
 LOG_LEVEL: str = get_log_level(NAMESPACE)
+LOG_FORMAT: str = get_log_format(NAMESPACE)
 LOG_HEALTH_CHECKS: bool = get_log_health_checks(NAMESPACE)
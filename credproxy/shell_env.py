@@ -0,0 +1,104 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Shell exports pointing the AWS SDKs at the credentials of a service.
+
+The URI is the one of the TCP listener of the configuration, as the server reads
+it, so that eval "$(credproxy env --profile my-app)" sets up a shell to use the
+running CredProxy.
+"""
+
+from __future__ import annotations
+
+import sys
+import ipaddress
+from typing import TYPE_CHECKING
+from urllib.parse import quote
+
+from credproxy.config import Config
+from credproxy.logger import LOG
+from credproxy.runner import apply_cli_overrides
+from credproxy.shells import export_line
+
+
+if TYPE_CHECKING:
+    import argparse
+
+
+# Hosts besides loopback the SDKs get container credentials from over HTTP
+CONTAINER_HOSTS = ("169.254.170.2", "169.254.170.23", "fd00:ec2::23")
+# Addresses listened on for every interface, reached on loopback
+WILDCARD_HOSTS = {"": "127.0.0.1", "0.0.0.0": "127.0.0.1", "::": "::1"}
+
+
+def sdk_accepts_host(host: str) -> bool:
+    """Check the SDKs get container credentials from host over plain HTTP."""
+    if host == "localhost" or host in CONTAINER_HOSTS:
+        return True
+    try:
+        return ipaddress.ip_address(host).is_loopback
+    except ValueError:
+        return False
+
+
+def credentials_uri(config: Config, service_name: str) -> str | None:
+    """Get the credentials URI of a service, None when the SDKs would reject it."""
+    host = WILDCARD_HOSTS.get(config.server.host, config.server.host)
+    scheme = "https" if config.server.tls else "http"
+    if scheme == "http" and not sdk_accepts_host(host):
+        return None
+    netloc = f"[{host}]" if ":" in host else host
+    return (
+        f"{scheme}://{netloc}:{config.server.port}"
+        f"/v1/credentials/{quote(service_name, safe='')}"
+    )
+
+
+def service_variables(config: Config, service_name: str) -> dict[str, str] | None:
+    """Get the environment variables of a service, None without a usable URI."""
+    uri = credentials_uri(config, service_name)
+    if uri is None:
+        return None
+    variables = {"AWS_CONTAINER_CREDENTIALS_FULL_URI": uri}
+    service = config.services[service_name]
+    # The SDKs read the file again, following the rotations of the token
+    if service.auth_token_file:
+        variables["AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"] = service.auth_token_file
+    elif service.auth_token:
+        variables["AWS_CONTAINER_AUTHORIZATION_TOKEN"] = service.auth_token
+    return variables
+
+
+def run_env(args: argparse.Namespace) -> int:
+    """Print the exports of the variables of a service, returning 1 on failure."""
+    try:
+        config = Config.from_file(args.config)
+        apply_cli_overrides(config, args)
+    except Exception as error:
+        LOG.error("Failed to load configuration file %s", args.config)
+        LOG.exception(error)
+        return 1
+    if args.service not in config.services:
+        LOG.error("Service %s is not defined in %s", args.service, args.config)
+        return 1
+
+    for listener in (config.server.unix_socket, config.server.named_pipe):
+        if listener:
+            LOG.warning(
+                "The AWS SDKs cannot get credentials from %s, using the TCP "
+                "listener instead",
+                listener,
+            )
+    variables = service_variables(config, args.service)
+    if variables is None:
+        LOG.error(
+            "The AWS SDKs only get credentials over plain HTTP from loopback "
+            "addresses, not from %s. Listen on 127.0.0.1 or enable server.tls",
+            config.server.host,
+        )
+        return 1
+
+    lines = [export_line(args.shell, name, value) for name, value in variables.items()]
+    sys.stdout.write("\n".join(lines) + "\n")
+    sys.stdout.flush()
+    return 0
@@ -0,0 +1,26 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Syntax of the environment variable exports of the shells of the env command.
+
+Kept apart from shell_env, so that the CLI lists the shells without importing
+the server modules.
+"""
+
+from __future__ import annotations
+
+import shlex
+
+
+SHELLS = ("bash", "fish", "powershell")
+
+
+def export_line(shell: str, name: str, value: str) -> str:
+    """Format the export of an environment variable in the syntax of shell."""
+    if shell == "fish":
+        escaped = value.replace("\\", "\\\\").replace("'", "\\'")
+        return f"set -gx {name} '{escaped}';"
+    if shell == "powershell":
+        escaped = value.replace("'", "''")
+        return f"$Env:{name} = '{escaped}'"
+    return f"export {name}={shlex.quote(value)}"
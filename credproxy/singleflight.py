@@ -0,0 +1,88 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Collapsing of concurrent calls for the same key into a single call.
+
+When many clients miss the cache of a service at once, such as containers
+started together, only the first one assumes the role. The others wait for it
+and get its credentials, or its error.
+"""
+
+from __future__ import annotations
+
+import threading
+import contextvars
+from typing import TYPE_CHECKING, Any
+
+
+if TYPE_CHECKING:
+    from collections.abc import Callable, Hashable
+
+
+class _Call:
+    """Call in flight, with its outcome once done."""
+
+    def __init__(self):
+        self.done = threading.Event()
+        self.result: Any = None
+        self.error: BaseException | None = None
+
+
+class SingleFlight:
+    """Run one call at a time per key, sharing its outcome with waiting callers."""
+
+    def __init__(self):
+        self._calls: dict[Hashable, _Call] = {}
+        self._lock = threading.Lock()
+
+    def do(
+        self,
+        key: Hashable,
+        function: Callable[[], Any],
+        timeout: float | None = None,
+    ) -> Any:
+        """Call function, or wait for the call in flight for key and share it.
+
+        Exceptions of the call are raised to every caller sharing it. With a
+        timeout, function runs in a thread of its own and TimeoutError is raised
+        to callers still waiting after timeout seconds, the call going on.
+        """
+        with self._lock:
+            call = self._calls.get(key)
+            leader = call is None
+            if leader:
+                call = self._calls[key] = _Call()
+
+        if leader and timeout is None:
+            self._run(key, call, function)
+        elif leader:
+            # Run with the context of the caller, as its tracing span
+            context = contextvars.copy_context()
+            threading.Thread(
+                target=context.run,
+                args=(self._run, key, call, function),
+                daemon=True,
+                name=f"singleflight-{key}",
+            ).start()
+
+        if not call.done.wait(timeout):
+            raise TimeoutError(f"Call for {key} still running after {timeout} seconds")
+        if call.error is not None:
+            raise call.error
+        return call.result
+
+    def in_flight(self, key: Hashable) -> bool:
+        """Check if a call for key is in flight."""
+        with self._lock:
+            return key in self._calls
+
+    def _run(self, key: Hashable, call: _Call, function: Callable[[], Any]) -> None:
+        try:
+            call.result = function()
+        except BaseException as error:
+            call.error = error
+        finally:
+            # Callers arriving from now on start a new call
+            with self._lock:
+                del self._calls[key]
+            call.done.set()
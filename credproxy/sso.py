@@ -0,0 +1,286 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""IAM Identity Center (SSO) source credentials.
+
+SSO access tokens are obtained with the sso-oidc device authorization flow and
+cached under ~/.aws/sso/cache in the same format as the AWS CLI, so a token
+obtained by either tool is reused by the other.
+"""
+
+from __future__ import annotations
+
+import os
+import json
+import time
+import hashlib
+import tempfile
+import threading
+from typing import TYPE_CHECKING
+from pathlib import Path
+from datetime import datetime, timezone, timedelta
+
+import boto3
+from botocore.exceptions import ClientError
+
+from credproxy.logger import LOG
+from credproxy.tokens import TokenLifetimeError, check_token_lifetime
+from credproxy.providers import SourceCredentials, CredentialsProvider
+from credproxy.sanitizer import register_sensitive_value
+
+
+if TYPE_CHECKING:
+    from collections.abc import Callable
+
+    from credproxy.retry import StsRetryPolicy
+
+
+SSO_CACHE_DIR = Path.home() / ".aws" / "sso" / "cache"
+DEVICE_CODE_GRANT_TYPE = "urn:ietf:params:oauth:grant-type:device_code"
+CLIENT_NAME = "credproxy"
+
+# Cached tokens and registrations expiring within this margin are not reused
+EXPIRY_MARGIN_SECONDS = 60
+# Polling back-off requested by sso-oidc with SlowDownException
+SLOW_DOWN_SECONDS = 5
+
+
+def _format_timestamp(value: datetime) -> str:
+    """Format a datetime as the UTC timestamp used in AWS CLI cache files."""
+    return value.astimezone(timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def _is_fresh(expires_at: str | None) -> bool:
+    """Check a cache file timestamp is not expired or about to expire."""
+    if not expires_at:
+        return False
+    try:
+        expiry = datetime.fromisoformat(expires_at.replace("Z", "+00:00"))
+    except ValueError:
+        return False
+    if expiry.tzinfo is None:
+        expiry = expiry.replace(tzinfo=timezone.utc)
+    margin = timedelta(seconds=EXPIRY_MARGIN_SECONDS)
+    return expiry - margin > datetime.now(timezone.utc)
+
+
+class SSOTokenProvider:
+    """Obtain and cache SSO access tokens for one start URL."""
+
+    def __init__(
+        self,
+        start_url: str,
+        sso_region: str,
+        cache_dir: Path | None = None,
+        notify: Callable[[str], None] | None = None,
+    ):
+        self.start_url = start_url
+        self.sso_region = sso_region
+        self.cache_dir = cache_dir or SSO_CACHE_DIR
+        self._notify = notify or LOG.warning
+        # Only one device authorization at a time per start URL
+        self._lock = threading.Lock()
+
+    @property
+    def token_cache_path(self) -> Path:
+        """Token cache file, named like the AWS CLI after the start URL."""
+        cache_key = hashlib.sha1(self.start_url.encode("utf-8")).hexdigest()
+        return self.cache_dir / f"{cache_key}.json"
+
+    @property
+    def registration_cache_path(self) -> Path:
+        """Client registration cache file shared with the AWS CLI."""
+        return self.cache_dir / f"botocore-client-id-{self.sso_region}.json"
+
+    def access_token(self) -> str:
+        """Return a valid SSO access token, signing in when none is cached."""
+        with self._lock:
+            cached = self._read_cache(self.token_cache_path)
+            if (
+                cached
+                and cached.get("startUrl") == self.start_url
+                and cached.get("accessToken")
+                and _is_fresh(cached.get("expiresAt"))
+                and self._cached_token_valid(cached["accessToken"])
+            ):
+                LOG.debug("Using cached SSO token for %s", self.start_url)
+                register_sensitive_value(cached["accessToken"])
+                return cached["accessToken"]
+            return self._device_authorization()
+
+    def _cached_token_valid(self, access_token: str) -> bool:
+        """Check the claims of a cached token, if a JWT, allow using it."""
+        try:
+            check_token_lifetime(
+                access_token, f"SSO token cached at {self.token_cache_path}"
+            )
+        except TokenLifetimeError as error:
+            LOG.warning("%s, signing in again", error)
+            return False
+        return True
+
+    def invalidate(self) -> None:
+        """Drop the cached token, forcing a new sign in on next use."""
+        with self._lock:
+            try:
+                self.token_cache_path.unlink()
+            except FileNotFoundError:
+                pass
+
+    def role_credentials(self, account_id: str, role_name: str) -> SourceCredentials:
+        """Get the credentials of an SSO permission set role.
+
+        A cached token rejected by SSO (for example after signing out) is
+        dropped and the device flow started again once.
+        """
+        sso_client = boto3.client("sso", region_name=self.sso_region)
+        try:
+            response = sso_client.get_role_credentials(
+                roleName=role_name,
+                accountId=account_id,
+                accessToken=self.access_token(),
+            )
+        except ClientError as error:
+            if error.response.get("Error", {}).get("Code") != "UnauthorizedException":
+                raise
+            LOG.warning("SSO token for %s was rejected, signing in again", role_name)
+            self.invalidate()
+            response = sso_client.get_role_credentials(
+                roleName=role_name,
+                accountId=account_id,
+                accessToken=self.access_token(),
+            )
+
+        role_credentials = response["roleCredentials"]
+        register_sensitive_value(role_credentials["accessKeyId"])
+        register_sensitive_value(role_credentials["secretAccessKey"])
+        register_sensitive_value(role_credentials["sessionToken"])
+        # Milliseconds since the epoch
+        expiration = role_credentials.get("expiration")
+        return SourceCredentials(
+            access_key_id=role_credentials["accessKeyId"],
+            secret_access_key=role_credentials["secretAccessKey"],
+            session_token=role_credentials["sessionToken"],
+            expiration=expiration / 1000 if expiration else None,
+        )
+
+    def _device_authorization(self) -> str:
+        """Run the device authorization flow and cache the resulting token."""
+        oidc_client = boto3.client("sso-oidc", region_name=self.sso_region)
+        registration = self._client_registration(oidc_client)
+
+        authorization = oidc_client.start_device_authorization(
+            clientId=registration["clientId"],
+            clientSecret=registration["clientSecret"],
+            startUrl=self.start_url,
+        )
+        self._notify(
+            f"To sign in to AWS SSO, open {authorization['verificationUriComplete']} "
+            f"and confirm the code {authorization['userCode']}"
+        )
+
+        interval = authorization.get("interval") or SLOW_DOWN_SECONDS
+        deadline = time.time() + authorization["expiresIn"]
+        while time.time() < deadline:
+            time.sleep(interval)
+            try:
+                token = oidc_client.create_token(
+                    grantType=DEVICE_CODE_GRANT_TYPE,
+                    deviceCode=authorization["deviceCode"],
+                    clientId=registration["clientId"],
+                    clientSecret=registration["clientSecret"],
+                )
+            except ClientError as error:
+                error_code = error.response.get("Error", {}).get("Code")
+                if error_code == "AuthorizationPendingException":
+                    continue
+                if error_code == "SlowDownException":
+                    interval += SLOW_DOWN_SECONDS
+                    continue
+                raise
+
+            register_sensitive_value(token["accessToken"])
+            # A new token outside of its claims lifetime means a drifting clock
+            check_token_lifetime(token["accessToken"], f"SSO token of {self.start_url}")
+            expires_at = datetime.now(timezone.utc) + timedelta(
+                seconds=token["expiresIn"]
+            )
+            self._write_cache(
+                self.token_cache_path,
+                {
+                    "startUrl": self.start_url,
+                    "region": self.sso_region,
+                    "accessToken": token["accessToken"],
+                    "expiresAt": _format_timestamp(expires_at),
+                },
+            )
+            LOG.info("Signed in to AWS SSO for %s", self.start_url)
+            return token["accessToken"]
+
+        raise TimeoutError(
+            f"SSO device authorization for {self.start_url} was not approved in time"
+        )
+
+    def _client_registration(self, oidc_client) -> dict:
+        """Return the cached sso-oidc client registration or register a new one."""
+        cached = self._read_cache(self.registration_cache_path)
+        if cached and _is_fresh(cached.get("expiresAt")):
+            register_sensitive_value(cached["clientSecret"])
+            return cached
+
+        response = oidc_client.register_client(
+            clientName=CLIENT_NAME, clientType="public"
+        )
+        register_sensitive_value(response["clientSecret"])
+        registration = {
+            "clientId": response["clientId"],
+            "clientSecret": response["clientSecret"],
+            "expiresAt": _format_timestamp(
+                datetime.fromtimestamp(response["clientSecretExpiresAt"], timezone.utc)
+            ),
+        }
+        self._write_cache(self.registration_cache_path, registration)
+        return registration
+
+    @staticmethod
+    def _read_cache(path: Path) -> dict | None:
+        """Read a JSON cache file, ignoring missing or corrupt files."""
+        try:
+            with open(path, encoding="utf-8") as cache_file:
+                return json.load(cache_file)
+        except FileNotFoundError:
+            return None
+        except (OSError, ValueError) as error:
+            LOG.warning("Ignoring unreadable SSO cache file %s: %s", path, error)
+            return None
+
+    @staticmethod
+    def _write_cache(path: Path, data: dict) -> None:
+        """Atomically write a JSON cache file readable by its owner only."""
+        path.parent.mkdir(mode=0o700, parents=True, exist_ok=True)
+        # mkstemp creates the file with 0600 permissions
+        fd, temp_path = tempfile.mkstemp(dir=path.parent, suffix=".tmp")
+        try:
+            with os.fdopen(fd, "w", encoding="utf-8") as cache_file:
+                json.dump(data, cache_file)
+            os.replace(temp_path, path)
+        except BaseException:
+            os.unlink(temp_path)
+            raise
+
+
+class SSORoleProvider(CredentialsProvider):
+    """Get the credentials of a permission set role, with a shared token provider."""
+
+    role_session = True
+
+    def __init__(
+        self, token_provider: SSOTokenProvider, account_id: str, role_name: str
+    ):
+        self.token_provider = token_provider
+        self.account_id = account_id
+        self.role_name = role_name
+
+    def retrieve(self, retry_policy: StsRetryPolicy | None = None) -> SourceCredentials:
+        """Get the role credentials, signing in when no token is cached."""
+        return self.token_provider.role_credentials(self.account_id, self.role_name)
@@ -0,0 +1,57 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Static source credentials, served without calling STS.
+
+Meant for integration tests without AWS access. The keys are served as the
+credentials of the assumed role, with an expiration made up from the duration
+so that the refresher reads them again, picking up keys rotated in the
+environment.
+"""
+
+from __future__ import annotations
+
+import os
+from typing import TYPE_CHECKING
+from datetime import datetime, timezone, timedelta
+
+
+if TYPE_CHECKING:
+    from credproxy.config import StaticAuthConfig
+
+
+# Environment variables the keys are read from when not configured
+ACCESS_KEY_ID_ENV = "AWS_ACCESS_KEY_ID"
+SECRET_ACCESS_KEY_ENV = "AWS_SECRET_ACCESS_KEY"
+SESSION_TOKEN_ENV = "AWS_SESSION_TOKEN"
+
+
+class StaticCredentialsError(Exception):
+    """Raised when static credentials are neither configured nor in the environment."""
+
+
+def static_credentials(static_config: StaticAuthConfig) -> dict:
+    """Get the static credentials, as returned by STS AssumeRole.
+
+    Session tokens are optional, long-term keys being served without one.
+    """
+    if static_config.aws_access_key_id:
+        access_key_id = static_config.aws_access_key_id
+        secret_access_key = static_config.aws_secret_access_key
+        session_token = static_config.session_token
+    else:
+        access_key_id = os.environ.get(ACCESS_KEY_ID_ENV)
+        secret_access_key = os.environ.get(SECRET_ACCESS_KEY_ENV)
+        session_token = os.environ.get(SESSION_TOKEN_ENV)
+        if not access_key_id or not secret_access_key:
+            raise StaticCredentialsError(
+                f"{ACCESS_KEY_ID_ENV} and {SECRET_ACCESS_KEY_ENV} must be set for "
+                "static credentials without keys"
+            )
+    return {
+        "AccessKeyId": access_key_id,
+        "SecretAccessKey": secret_access_key,
+        "SessionToken": session_token or "",
+        "Expiration": datetime.now(timezone.utc)
+        + timedelta(seconds=static_config.duration),
+    }
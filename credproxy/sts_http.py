@@ -0,0 +1,35 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""HTTP options of the STS clients of source credentials.
+
+In locked-down networks STS is reached through an outbound proxy, with the
+certificates verified against a corporate CA bundle. botocore gives every client
+its own pool of connections, so the options of the clients of a source never
+apply to the clients of the others.
+"""
+
+from __future__ import annotations
+
+from dataclasses import dataclass
+
+
+@dataclass(frozen=True)
+class StsHttpOptions:
+    """Outbound proxy and TLS verification of STS clients."""
+
+    http_proxy: str | None = None
+    ca_bundle: str | None = None  # Path to a PEM file, the botocore one if None
+    insecure_skip_verify: bool = False
+
+    @property
+    def proxies(self) -> dict | None:
+        """Get the proxies of the botocore config, None for the environment ones."""
+        if not self.http_proxy:
+            return None
+        return {"http": self.http_proxy, "https": self.http_proxy}
+
+    @property
+    def verify(self) -> str | bool | None:
+        """Get the verify argument of boto3 clients, None for the default."""
+        return False if self.insecure_skip_verify else self.ca_bundle
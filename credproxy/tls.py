@@ -0,0 +1,88 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""TLS of the TCP listener, with certificates reloaded without a restart.
+
+The listening socket keeps the SSL context it was wrapped with, so every
+handshake is handed over to the most recently loaded context from its server
+name callback, which OpenSSL calls whether or not the client sent SNI.
+"""
+
+from __future__ import annotations
+
+import ssl
+import threading
+from typing import TYPE_CHECKING
+
+from credproxy.logger import LOG
+
+
+if TYPE_CHECKING:
+    from credproxy.config import TLSConfig
+
+
+# WSGI environ key of the common name of verified client certificates
+CLIENT_CN_ENVIRON = "credproxy.client_cn"
+
+
+def client_common_name(connection: object) -> str | None:
+    """Get the common name of the verified certificate of a client connection."""
+    if not isinstance(connection, ssl.SSLSocket):
+        return None
+    # Empty when the certificate was not verified, without client_ca_file
+    certificate = connection.getpeercert() or {}
+    for relative_name in certificate.get("subject", ()):
+        for key, value in relative_name:
+            if key == "commonName":
+                return value
+    return None
+
+
+def create_ssl_context(tls_config: TLSConfig) -> ssl.SSLContext:
+    """Create the server SSL context of the TLS settings.
+
+    Raises OSError, or its ssl.SSLError subclass, when a file cannot be loaded.
+    """
+    context = ssl.SSLContext(ssl.PROTOCOL_TLS_SERVER)
+    context.minimum_version = ssl.TLSVersion.TLSv1_2
+    context.load_cert_chain(tls_config.cert_file, tls_config.key_file)
+    if tls_config.client_ca_file:
+        # Clients without a certificate signed by the CA fail the handshake
+        context.verify_mode = ssl.CERT_REQUIRED
+        context.load_verify_locations(cafile=tls_config.client_ca_file)
+    return context
+
+
+class ReloadableSSLContext:
+    """Server SSL context whose certificates can be reloaded from their files."""
+
+    def __init__(self, tls_config: TLSConfig):
+        self.tls_config = tls_config
+        self._current = create_ssl_context(tls_config)
+        self._lock = threading.Lock()
+        # Wraps the listening socket, handshakes use the current context
+        self.context = create_ssl_context(tls_config)
+        self.context.sni_callback = self._use_current_context
+
+    def _use_current_context(
+        self, ssl_object: ssl.SSLObject, server_name: str | None, context
+    ) -> None:
+        with self._lock:
+            ssl_object.context = self._current
+
+    def reload(self) -> bool:
+        """Load the certificates again, keeping the current ones on failure."""
+        try:
+            reloaded = create_ssl_context(self.tls_config)
+        except OSError as error:
+            LOG.error(
+                "Failed to reload TLS certificate %s, keeping the current one",
+                self.tls_config.cert_file,
+            )
+            LOG.exception(error)
+            return False
+
+        with self._lock:
+            self._current = reloaded
+        LOG.info("Reloaded TLS certificate %s", self.tls_config.cert_file)
+        return True
@@ -0,0 +1,85 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Lifetime of the tokens exchanged for credentials.
+
+Web identity tokens, and SSO access tokens which are JWTs, have their exp and nbf
+claims checked before being used, so that expired tokens and tokens not valid yet
+fail with an error saying so rather than an opaque InvalidIdentityToken, telling
+token lifecycle problems apart from trust policies denying the role. The claims
+are only read, verifying the token is left to AWS.
+"""
+
+from __future__ import annotations
+
+import json
+import time
+import base64
+from datetime import datetime, timezone
+
+
+# Drift tolerated between the clock of the token issuer and the local one
+CLOCK_LEEWAY_SECONDS = 30
+# Error codes answered to clients, distinct from the InvalidIdentityToken of STS
+EXPIRED_TOKEN_CODE = "ExpiredSourceToken"
+NOT_YET_VALID_TOKEN_CODE = "SourceTokenNotYetValid"
+
+
+class TokenLifetimeError(ValueError):
+    """Token expired, or not valid yet, according to its claims."""
+
+    def __init__(self, message: str, code: str):
+        super().__init__(message)
+        self.code = code
+
+
+def jwt_claims(token: str) -> dict | None:
+    """Decode the claims of a JWT without verifying it, None if not a JWT."""
+    parts = token.split(".")
+    if len(parts) != 3:
+        return None
+    payload = parts[1] + "=" * (-len(parts[1]) % 4)
+    try:
+        claims = json.loads(base64.urlsafe_b64decode(payload))
+    except ValueError:
+        return None
+    return claims if isinstance(claims, dict) else None
+
+
+def _format_time(timestamp: float) -> str:
+    return datetime.fromtimestamp(timestamp, timezone.utc).strftime(
+        "%Y-%m-%dT%H:%M:%SZ"
+    )
+
+
+def _numeric_claim(claims: dict, name: str) -> float | None:
+    value = claims.get(name)
+    if isinstance(value, bool) or not isinstance(value, (int, float)):
+        return None
+    return value
+
+
+def check_token_lifetime(
+    token: str, description: str, now: float | None = None
+) -> None:
+    """Raise TokenLifetimeError when now is outside the exp and nbf of a JWT.
+
+    Tokens which are not JWTs, and claims which are not set, are not checked.
+    """
+    claims = jwt_claims(token)
+    if not claims:
+        return
+    now = time.time() if now is None else now
+
+    expires_at = _numeric_claim(claims, "exp")
+    if expires_at is not None and now >= expires_at + CLOCK_LEEWAY_SECONDS:
+        raise TokenLifetimeError(
+            f"{description} expired at {_format_time(expires_at)}", EXPIRED_TOKEN_CODE
+        )
+    not_before = _numeric_claim(claims, "nbf")
+    if not_before is not None and now < not_before - CLOCK_LEEWAY_SECONDS:
+        raise TokenLifetimeError(
+            f"{description} is not valid until {_format_time(not_before)}, "
+            "check the clock of this host",
+            NOT_YET_VALID_TOKEN_CODE,
+        )
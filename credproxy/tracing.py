@@ -0,0 +1,187 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Optional OpenTelemetry tracing of requests, cache lookups and STS calls.
+
+Tracing requires the opentelemetry-sdk and opentelemetry-exporter-otlp packages,
+the exporter being configured with the standard OTEL_EXPORTER_OTLP_* environment
+variables. The trace context of incoming requests is extracted from their
+traceparent header, so the spans of botocore, when instrumented by
+opentelemetry-instrumentation-botocore, nest under the STS call spans.
+"""
+
+from __future__ import annotations
+
+import os
+from typing import TYPE_CHECKING, Any
+from contextlib import nullcontext
+
+from flask import g, request
+
+from credproxy.logger import LOG
+
+
+if TYPE_CHECKING:
+    from contextlib import AbstractContextManager
+
+    from flask import Flask, Response
+
+
+try:
+    from opentelemetry import trace, context, propagate
+except ImportError:  # Tracing is optional
+    trace = context = propagate = None
+try:
+    from opentelemetry.sdk.trace import TracerProvider
+    from opentelemetry.sdk.resources import SERVICE_NAME, Resource
+    from opentelemetry.sdk.trace.export import BatchSpanProcessor
+except ImportError:
+    TracerProvider = Resource = BatchSpanProcessor = SERVICE_NAME = None
+try:
+    from opentelemetry.exporter.otlp.proto.http.trace_exporter import (
+        OTLPSpanExporter as HTTPSpanExporter,
+    )
+except ImportError:
+    HTTPSpanExporter = None
+try:
+    from opentelemetry.exporter.otlp.proto.grpc.trace_exporter import (
+        OTLPSpanExporter as GRPCSpanExporter,
+    )
+except ImportError:  # Only installed with the grpc extra of the exporter
+    GRPCSpanExporter = None
+try:
+    from opentelemetry.instrumentation.botocore import BotocoreInstrumentor
+except ImportError:
+    BotocoreInstrumentor = None
+
+
+# Instrumentation name of the CredProxy spans
+TRACER_NAME = "credproxy"
+# Service name of the spans unless set by OTEL_SERVICE_NAME
+DEFAULT_SERVICE_NAME = "credproxy"
+
+_tracer = None
+_tracer_provider = None
+
+
+def _otlp_span_exporter():
+    """Create the OTLP span exporter of OTEL_EXPORTER_OTLP_PROTOCOL."""
+    protocol = os.environ.get(
+        "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL",
+        os.environ.get("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf"),
+    )
+    span_exporter = GRPCSpanExporter if protocol == "grpc" else HTTPSpanExporter
+    if span_exporter is None:
+        raise ImportError(f"No OTLP span exporter of protocol {protocol} installed")
+    return span_exporter()
+
+
+def init_tracing(span_exporter=None) -> bool:
+    """Start exporting spans, returning False if tracing is not available."""
+    global _tracer, _tracer_provider
+    if _tracer is not None:
+        return True
+    try:
+        if TracerProvider is None:
+            raise ImportError("opentelemetry-sdk is not installed")
+        span_exporter = span_exporter or _otlp_span_exporter()
+    except ImportError as error:
+        LOG.error(
+            "Tracing requires the opentelemetry-sdk and opentelemetry-exporter-otlp "
+            "packages, continuing without tracing"
+        )
+        LOG.exception(error)
+        return False
+
+    # OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES are read by Resource.create
+    attributes = {}
+    if not os.environ.get("OTEL_SERVICE_NAME"):
+        attributes[SERVICE_NAME] = DEFAULT_SERVICE_NAME
+    _tracer_provider = TracerProvider(resource=Resource.create(attributes))
+    _tracer_provider.add_span_processor(BatchSpanProcessor(span_exporter))
+    trace.set_tracer_provider(_tracer_provider)
+    _tracer = _tracer_provider.get_tracer(TRACER_NAME)
+
+    if BotocoreInstrumentor is not None:
+        BotocoreInstrumentor().instrument(tracer_provider=_tracer_provider)
+    else:
+        LOG.debug("opentelemetry-instrumentation-botocore not installed")
+    LOG.info("OpenTelemetry tracing enabled")
+    return True
+
+
+def shutdown_tracing() -> None:
+    """Export the pending spans and stop tracing."""
+    global _tracer, _tracer_provider
+    if _tracer_provider is not None:
+        _tracer_provider.shutdown()
+    _tracer = _tracer_provider = None
+
+
+def tracing_enabled() -> bool:
+    """Check if spans are exported."""
+    return _tracer is not None
+
+
+def span(name: str, attributes: dict[str, Any] | None = None) -> AbstractContextManager:
+    """Start a span of the current trace, doing nothing without tracing."""
+    if _tracer is None:
+        return nullcontext()
+    return _tracer.start_as_current_span(name, attributes=attributes)
+
+
+def set_span_attribute(key: str, value: Any) -> None:
+    """Set an attribute of the current span, if any."""
+    if _tracer is not None:
+        trace.get_current_span().set_attribute(key, value)
+
+
+def instrument_app(app: Flask) -> None:
+    """Trace every request of the app, continuing the trace of its headers.
+
+    Registered before the other request hooks so that their work is traced.
+    """
+
+    @app.before_request
+    def start_request_span() -> None:
+        if _tracer is None:
+            return
+        parent = propagate.extract(request.headers)
+        route = request.url_rule.rule if request.url_rule else request.path
+        request_span = _tracer.start_span(
+            f"{request.method} {route}",
+            context=parent,
+            kind=trace.SpanKind.SERVER,
+            attributes={
+                "http.request.method": request.method,
+                "http.route": route,
+                "url.path": request.path,
+            },
+        )
+        g.tracing_span = request_span
+        # Current for the STS calls made while handling the request
+        g.tracing_token = context.attach(
+            trace.set_span_in_context(request_span, parent)
+        )
+
+    @app.after_request
+    def record_response_status(response: Response) -> Response:
+        request_span = g.get("tracing_span")
+        if request_span is not None:
+            request_span.set_attribute(
+                "http.response.status_code", response.status_code
+            )
+            if response.status_code >= 500:
+                request_span.set_status(trace.StatusCode.ERROR)
+        return response
+
+    @app.teardown_request
+    def end_request_span(error: BaseException | None) -> None:
+        request_span = g.pop("tracing_span", None)
+        if request_span is None:
+            return
+        if error is not None:
+            request_span.record_exception(error)
+            request_span.set_status(trace.StatusCode.ERROR)
+        context.detach(g.pop("tracing_token"))
+        request_span.end()
@@ -0,0 +1,84 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Serve the CredProxy application on a Unix domain socket."""
+
+from __future__ import annotations
+
+import os
+import stat
+import threading
+from typing import TYPE_CHECKING
+
+from werkzeug.serving import make_server
+
+from credproxy.logger import LOG
+
+
+if TYPE_CHECKING:
+    from flask import Flask
+    from werkzeug.serving import BaseWSGIServer
+
+
+# Only the invoking user may connect to the socket
+UNIX_SOCKET_MODE = 0o600
+
+
+class UnixSocketServer:
+    """Serve a Flask app on a Unix domain socket restricted to its owner."""
+
+    def __init__(self, app: Flask, path: str):
+        self.app = app
+        self.path = path
+        self._server: BaseWSGIServer | None = None
+        self._thread: threading.Thread | None = None
+
+    def start(self) -> None:
+        """Bind the socket with owner-only permissions and serve in background."""
+        self._remove_stale_socket()
+
+        # Create the socket without group/other permissions so there is no
+        # window where another user could connect before the chmod
+        previous_umask = os.umask(0o177)
+        try:
+            self._server = make_server(
+                f"unix://{self.path}", 0, self.app, threaded=True
+            )
+        finally:
+            os.umask(previous_umask)
+        os.chmod(self.path, UNIX_SOCKET_MODE)
+
+        self._thread = threading.Thread(
+            target=self._server.serve_forever, daemon=True, name="unix-socket"
+        )
+        self._thread.start()
+        LOG.info("Serving on unix socket %s", self.path)
+
+    def stop(self) -> None:
+        """Stop serving and remove the socket file."""
+        if self._server is None:
+            return
+
+        LOG.info("Stopping unix socket server on %s", self.path)
+        self._server.shutdown()
+        self._server.server_close()
+        if self._thread:
+            self._thread.join(timeout=5)
+        self._server = None
+
+        try:
+            os.unlink(self.path)
+        except FileNotFoundError:
+            pass
+        LOG.info("Removed unix socket %s", self.path)
+
+    def _remove_stale_socket(self) -> None:
+        """Remove a socket left by a previous run, refusing to delete other files."""
+        try:
+            mode = os.lstat(self.path).st_mode
+        except FileNotFoundError:
+            return
+        if not stat.S_ISSOCK(mode):
+            raise FileExistsError(f"{self.path} exists and is not a unix socket")
+        LOG.warning("Removing stale unix socket %s", self.path)
+        os.unlink(self.path)
@@ -0,0 +1,170 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Validation of a configuration file, service by service.
+
+Each service is checked against the JSON schema and loaded on its own, so that
+the errors of every service are reported at once rather than the first one
+only. With check_assume, the role of each valid service is also assumed once.
+"""
+
+from __future__ import annotations
+
+import sys
+import json
+from typing import TYPE_CHECKING, Any
+from dataclasses import dataclass
+
+import jsonschema
+from botocore.exceptions import ClientError
+
+from credproxy.config import SCHEMA_PATH, Config
+from credproxy.logger import LOG
+from credproxy.runner import apply_cli_overrides
+from credproxy.sanitizer import sanitize_exception_message
+from credproxy.substitutions import substitute_variables
+from credproxy.credentials_handler import CredentialsHandler
+
+
+if TYPE_CHECKING:
+    import argparse
+    from collections.abc import Sequence
+
+
+# Columns of the results table
+RESULTS_HEADER = ("SERVICE", "RESULT", "DETAILS")
+# Schema validators whose error messages repeat the whole value checked
+COMBINING_VALIDATORS = ("anyOf", "oneOf", "not")
+
+
+@dataclass
+class ServiceValidation:
+    """Validation result of a service."""
+
+    service_name: str
+    ok: bool
+    details: str
+
+
+def _schema_error_message(error: jsonschema.ValidationError, path: Sequence) -> str:
+    """Describe a schema error at path, relative to what it belongs to."""
+    message = error.message
+    if error.validator in COMBINING_VALIDATORS:
+        message = "does not match any of the allowed forms"
+        if isinstance(error.validator_value, dict):
+            message = error.validator_value.get("description", message)
+    location = " -> ".join(str(part) for part in path) or "root"
+    return f"{location}: {sanitize_exception_message(message)}"
+
+
+def schema_errors(config_data: Any) -> tuple[list[str], dict[str, list[str]]]:
+    """Get the schema errors of the configuration, and those of each service."""
+    with open(SCHEMA_PATH, encoding="utf-8") as f:
+        schema = json.load(f)
+
+    config_errors: list[str] = []
+    service_errors: dict[str, list[str]] = {}
+    for error in jsonschema.Draft7Validator(schema).iter_errors(config_data):
+        path = list(error.absolute_path)
+        if len(path) >= 2 and path[0] == "services":
+            service_errors.setdefault(path[1], []).append(
+                _schema_error_message(error, path[2:])
+            )
+        else:
+            config_errors.append(_schema_error_message(error, path))
+    return config_errors, service_errors
+
+
+def _describe_error(error: Exception) -> str:
+    """Describe why a service failed, with the AWS error code of STS errors."""
+    if isinstance(error, ClientError):
+        details = error.response.get("Error", {})
+        message = f"{details.get('Code', 'Unknown')}: {details.get('Message', '')}"
+    else:
+        message = str(error) or type(error).__name__
+    return sanitize_exception_message(message)
+
+
+def _validate_service(
+    config_data: dict,
+    config_path: str,
+    service_name: str,
+    args: argparse.Namespace,
+) -> ServiceValidation:
+    """Load the configuration of a service on its own, assuming its role if asked."""
+    try:
+        services = {service_name: config_data["services"][service_name]}
+        config = Config.from_dict({**config_data, "services": services}, config_path)
+        apply_cli_overrides(config, args)
+    except Exception as error:
+        return ServiceValidation(service_name, False, _describe_error(error))
+
+    role_arn = config.services[service_name].assumed_role.RoleArn
+    if not getattr(args, "check_assume", False):
+        return ServiceValidation(service_name, True, role_arn)
+
+    credentials_handler = CredentialsHandler(config)
+    try:
+        credentials_handler.get_credentials(service_name)
+    except Exception as error:
+        LOG.debug("Failed to assume role of service %s: %s", service_name, error)
+        return ServiceValidation(service_name, False, _describe_error(error))
+    finally:
+        credentials_handler.cleanup()
+    return ServiceValidation(service_name, True, f"Assumed {role_arn}")
+
+
+def validate_config(
+    config_data: Any, config_path: str, args: argparse.Namespace
+) -> tuple[list[str], list[ServiceValidation]]:
+    """Validate the configuration, with the result of each of its services."""
+    config_errors, service_errors = schema_errors(substitute_variables(config_data))
+    services_data = {}
+    if isinstance(config_data, dict) and isinstance(config_data.get("services"), dict):
+        services_data = config_data["services"]
+
+    results = []
+    for service_name in services_data:
+        if service_name in service_errors:
+            results.append(
+                ServiceValidation(
+                    service_name, False, "; ".join(service_errors[service_name])
+                )
+            )
+        else:
+            results.append(
+                _validate_service(config_data, config_path, service_name, args)
+            )
+    return config_errors, results
+
+
+def format_results(config_errors: list[str], results: list[ServiceValidation]) -> str:
+    """Format the results as a table, followed by the configuration errors."""
+    rows = [RESULTS_HEADER] + [
+        (result.service_name, "OK" if result.ok else "FAIL", result.details)
+        for result in results
+    ]
+    widths = [max(len(row[column]) for row in rows) for column in range(2)]
+    lines = [
+        f"{name:<{widths[0]}}  {status:<{widths[1]}}  {details}".rstrip()
+        for name, status, details in rows
+    ]
+    lines.extend(f"Configuration error at {error}" for error in config_errors)
+    return "\n".join(lines) + "\n"
+
+
+def run_validate(args: argparse.Namespace) -> int:
+    """Print the validation of each service, returning 1 if any failed."""
+    try:
+        config_data, config_path = Config.read_config_file(args.config)
+    except Exception as error:
+        LOG.error("Failed to read configuration file %s", args.config)
+        LOG.exception(error)
+        return 1
+
+    config_errors, results = validate_config(config_data, config_path, args)
+    if not results and not config_errors:
+        LOG.warning("No services defined in %s", config_path)
+    sys.stdout.write(format_results(config_errors, results))
+    sys.stdout.flush()
+    return 1 if config_errors or not all(result.ok for result in results) else 0
@@ -0,0 +1,63 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Build information of the running CredProxy, for support requests.
+
+The git commit and build date are written to credproxy/_build_info.py by the
+Docker build, and are "development" and "unknown" when running from source.
+"""
+
+from __future__ import annotations
+
+import sys
+import json
+import platform
+from dataclasses import asdict, dataclass
+
+from credproxy import __version__, __build_date__, __git_commit__
+
+
+# Response header of the credentials endpoints with the version of CredProxy
+VERSION_HEADER = "X-Credproxy-Version"
+VERSION_OUTPUT_FORMATS = ("text", "json")
+
+
+@dataclass
+class BuildInfo:
+    """Version and build of CredProxy."""
+
+    version: str
+    git_commit: str
+    build_date: str
+    python_version: str
+
+
+def build_info() -> BuildInfo:
+    """Get the build information of the running CredProxy."""
+    return BuildInfo(
+        version=__version__,
+        git_commit=__git_commit__,
+        build_date=__build_date__,
+        python_version=platform.python_version(),
+    )
+
+
+def format_build_info(info: BuildInfo, output: str = "text") -> str:
+    """Format the build information as text lines, or as a JSON object."""
+    if output == "json":
+        return json.dumps(asdict(info))
+    return "\n".join(
+        [
+            f"credproxy {info.version}",
+            f"Git commit: {info.git_commit}",
+            f"Build date: {info.build_date}",
+            f"Python: {info.python_version}",
+        ]
+    )
+
+
+def print_version(output: str = "text") -> int:
+    """Print the build information on stdout."""
+    sys.stdout.write(format_build_info(build_info(), output) + "\n")
+    sys.stdout.flush()
+    return 0
@@ -0,0 +1,163 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Web identity token file source credentials.
+
+Kubernetes projected service account tokens (IRSA) are rotated by the kubelet
+rewriting the token file, so the token is read again before every
+AssumeRoleWithWebIdentity call rather than once at startup.
+"""
+
+from __future__ import annotations
+
+import os
+import time
+import threading
+from typing import TYPE_CHECKING
+
+import boto3
+from botocore import UNSIGNED
+from botocore.config import Config as BotoConfig
+
+from credproxy.retry import NO_CLIENT_RETRIES
+from credproxy.logger import LOG
+from credproxy.tokens import TokenLifetimeError, check_token_lifetime
+from credproxy.tracing import span
+from credproxy.sts_http import StsHttpOptions
+from credproxy.providers import SourceCredentials, CredentialsProvider
+from credproxy.sanitizer import register_sensitive_value
+
+
+if TYPE_CHECKING:
+    from credproxy.retry import StsRetryPolicy
+
+
+# Reads of a token file caught mid-rewrite are retried this many times
+TOKEN_READ_ATTEMPTS = 3
+TOKEN_READ_RETRY_DELAY = 0.1
+
+
+def _looks_like_jwt(token: str) -> bool:
+    """Check the token has the three dot separated parts of a JWT."""
+    parts = token.split(".")
+    return len(parts) == 3 and all(parts)
+
+
+class WebIdentityTokenProvider(CredentialsProvider):
+    """Assume a role with the web identity token read from a file."""
+
+    role_session = True
+
+    def __init__(
+        self,
+        token_file: str,
+        role_arn: str,
+        role_session_name: str,
+        region: str | None = None,
+        sts_endpoint: str | None = None,
+        http_options: StsHttpOptions | None = None,
+    ):
+        self.token_file = token_file
+        self.role_arn = role_arn
+        self.role_session_name = role_session_name
+        self.region = region
+        self.sts_endpoint = sts_endpoint
+        self.http_options = http_options or StsHttpOptions()
+        self._token_mtime: int | None = None
+        self._lock = threading.Lock()
+
+    def read_token(self) -> str:
+        """Read the current token, retrying while the file is being rewritten."""
+        with self._lock:
+            last_error: Exception | None = None
+            for attempt in range(1, TOKEN_READ_ATTEMPTS + 1):
+                try:
+                    # Read fully into memory so a concurrent rewrite cannot
+                    # change the token while it is in use
+                    with open(self.token_file, encoding="utf-8") as token_file:
+                        token = token_file.read().strip()
+                        mtime = os.fstat(token_file.fileno()).st_mtime_ns
+                except FileNotFoundError as error:
+                    # The kubelet swaps the token symlink, briefly removing it
+                    last_error = error
+                else:
+                    if not _looks_like_jwt(token):
+                        last_error = ValueError(
+                            f"{self.token_file} does not contain a web identity token"
+                        )
+                    else:
+                        register_sensitive_value(token)
+                        try:
+                            check_token_lifetime(
+                                token, f"Web identity token at {self.token_file}"
+                            )
+                        except TokenLifetimeError as error:
+                            # Read again, the token may be being rotated
+                            last_error = error
+                        else:
+                            self._track_rotation(mtime)
+                            return token
+
+                if attempt < TOKEN_READ_ATTEMPTS:
+                    LOG.debug(
+                        "Retrying read of web identity token %s (%d/%d)",
+                        self.token_file,
+                        attempt,
+                        TOKEN_READ_ATTEMPTS,
+                    )
+                    time.sleep(TOKEN_READ_RETRY_DELAY)
+
+            raise last_error
+
+    def retrieve(self, retry_policy: StsRetryPolicy | None = None) -> SourceCredentials:
+        """Get the credentials of role_arn with AssumeRoleWithWebIdentity."""
+        # The web identity token is the only proof of identity, the call is
+        # not signed with any other credentials
+        sts_client = boto3.client(
+            "sts",
+            region_name=self.region,
+            endpoint_url=self.sts_endpoint,
+            verify=self.http_options.verify,
+            config=BotoConfig(
+                signature_version=UNSIGNED,
+                retries=NO_CLIENT_RETRIES,
+                proxies=self.http_options.proxies,
+            ),
+        )
+
+        def assume_role_with_web_identity() -> dict:
+            # Read on every attempt, the token may be rotated in between
+            token = self.read_token()
+            with span(
+                "sts.AssumeRoleWithWebIdentity",
+                {"credproxy.role_arn": self.role_arn},
+            ):
+                return sts_client.assume_role_with_web_identity(
+                    RoleArn=self.role_arn,
+                    RoleSessionName=self.role_session_name,
+                    WebIdentityToken=token,
+                )
+
+        if retry_policy is None:
+            response = assume_role_with_web_identity()
+        else:
+            response = retry_policy.call(
+                "AssumeRoleWithWebIdentity", assume_role_with_web_identity
+            )
+
+        credentials = response["Credentials"]
+        register_sensitive_value(credentials["AccessKeyId"])
+        register_sensitive_value(credentials["SecretAccessKey"])
+        register_sensitive_value(credentials["SessionToken"])
+        return SourceCredentials(
+            access_key_id=credentials["AccessKeyId"],
+            secret_access_key=credentials["SecretAccessKey"],
+            session_token=credentials["SessionToken"],
+            expiration=credentials["Expiration"].timestamp(),
+        )
+
+    def _track_rotation(self, mtime: int) -> None:
+        """Log when the token file changed since it was last read."""
+        if self._token_mtime is not None and mtime != self._token_mtime:
+            LOG.info("Web identity token %s was rotated", self.token_file)
+        self._token_mtime = mtime
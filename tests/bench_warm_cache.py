@@ -0,0 +1,94 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Benchmark of the reads of valid cached credentials.
+
+Compares marshaling the credentials on every request, as done before the cache
+entries held their response body, with serving the body marshaled once.
+Run with ``make bench``, or ``python -m tests.bench_warm_cache``.
+"""
+
+from __future__ import annotations
+
+import json
+import time
+import timeit
+import tracemalloc
+from collections.abc import Callable
+
+from credproxy.config import Config
+from credproxy.credentials_handler import CredentialsHandler, ServiceCredentialsManager
+
+
+ITERATIONS = 100_000
+ROLE_ARN = "arn:aws:iam::123456789012:role/MyAppRole"
+
+
+def _warm_handler() -> CredentialsHandler:
+    """Build a handler with valid cached credentials for my-app."""
+    config = Config.from_dict(
+        {
+            "services": {
+                "my-app": {
+                    "auth_token": "my-app-token",
+                    "source_credentials": {"region": "us-east-1"},
+                    "assumed_role": {"RoleArn": ROLE_ARN},
+                }
+            }
+        }
+    )
+    handler = CredentialsHandler(config)
+    handler.cache["my-app"] = ServiceCredentialsManager(
+        aws_access_key_id="ASIAMYAPPKEY",
+        aws_secret_access_key="my-app-secret",
+        session_token="my-app-session-token" * 20,
+        expiry=time.time() + 3600,
+        role_arn=ROLE_ARN,
+        cache_key=handler._cache_key(config.services["my-app"]),
+    )
+    return handler
+
+
+def _peak_bytes(operation: Callable[[], object]) -> int:
+    """Measure the peak of the memory allocated by a single operation."""
+    tracemalloc.start()
+    try:
+        operation()
+        tracemalloc.reset_peak()
+        before = tracemalloc.get_traced_memory()[0]
+        operation()
+        return tracemalloc.get_traced_memory()[1] - before
+    finally:
+        tracemalloc.stop()
+
+
+def benchmark(name: str, operation: Callable[[], object]) -> tuple[float, int]:
+    """Print and return the ns/op and peak B/op of an operation."""
+    seconds = min(timeit.repeat(operation, number=ITERATIONS, repeat=5))
+    ns_per_op = seconds / ITERATIONS * 1e9
+    bytes_per_op = _peak_bytes(operation)
+    print(f"{name:<24} {ns_per_op:>10.0f} ns/op {bytes_per_op:>8d} peak B/op")
+    return ns_per_op, bytes_per_op
+
+
+def main() -> None:
+    handler = _warm_handler()
+    try:
+        benchmark(
+            "marshal per request",
+            lambda: json.dumps(
+                handler.get_credentials("my-app"),
+                separators=(",", ":"),
+                sort_keys=True,
+            ).encode(),
+        )
+        benchmark(
+            "precomputed body",
+            lambda: handler.get_credentials_entry("my-app").response_body,
+        )
+    finally:
+        handler.cleanup()
+
+
+if __name__ == "__main__":
+    main()
@@ -9,6 +9,8 @@ import random
 import string
 from typing import Any
 
+from credproxy.config import Config
+
 
 class MockAWSGenerator:
     """Generate mock AWS credentials and identifiers for testing."""
@@ -302,3 +304,45 @@ def mock_external_id() -> str:
 
     chars = string.ascii_letters + string.digits + "-_@"
     return "".join(random.choices(chars, k=32))
+
+
+def mock_jwt(**claims: Any) -> str:
+    """Generate an unsigned mock JWT with the given claims."""
+    import json
+    import base64
+
+    def encode(data: dict) -> str:
+        return base64.urlsafe_b64encode(json.dumps(data).encode()).decode().rstrip("=")
+
+    return f"{encode({'alg': 'RS256'})}.{encode(claims)}.c2lnbmF0dXJl"
+
+
+def mock_config(
+    services: tuple[str, ...] = ("my-app",),
+    source_credentials: dict | None = None,
+    assumed_role: dict | None = None,
+    role_chain: list[dict] | None = None,
+    **sections: Any,
+) -> Config:
+    """Build a configuration of services sharing the given source and role settings.
+
+    Each service is authenticated with "<name>-token", gets credentials in
+    us-west-2 and assumes the role named after it, my-app assuming MyAppRole,
+    unless overridden. The other sections, such as server or credentials, are
+    set as given.
+    """
+    services_data: dict[str, Any] = {}
+    for name in services:
+        role_name = "".join(part.capitalize() for part in name.split("-")) + "Role"
+        services_data[name] = {
+            "auth_token": f"{name}-token",
+            "source_credentials": {"region": "us-west-2", **(source_credentials or {})},
+            "assumed_role": {
+                "RoleArn": f"arn:aws:iam::{MockAWSGenerator.MOCK_ACCOUNT_ID}:role/"
+                f"{role_name}",
+                **(assumed_role or {}),
+            },
+        }
+        if role_chain:
+            services_data[name]["role_chain"] = role_chain
+    return Config.from_dict({**sections, "services": services_data})
@@ -0,0 +1,151 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the audit trail of the credentials vended."""
+
+from __future__ import annotations
+
+import os
+import json
+import stat
+import hashlib
+from datetime import datetime, timezone
+from unittest.mock import MagicMock, patch
+
+from credproxy.app import init_app
+from tests.mock_aws import mock_config
+from credproxy.audit import AUDIT_FILE_MODE, AuditLog, AuditRecord
+from credproxy.config import Config
+
+
+ROLE_ARN = "arn:aws:iam::123456789012:role/MyAppRole"
+ASSUMED_ROLE_ID = "AROA123456789EXAMPLE:my-app-session"
+
+
+def _mock_sts_client() -> MagicMock:
+    sts_client = MagicMock()
+    sts_client.assume_role.return_value = {
+        "Credentials": {
+            "AccessKeyId": "ASIAMYAPPKEY",
+            "SecretAccessKey": "my-app-secret",
+            "SessionToken": "my-app-session-token",
+            "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+        },
+        "AssumedRoleUser": {
+            "AssumedRoleId": ASSUMED_ROLE_ID,
+            "Arn": "arn:aws:sts::123456789012:assumed-role/MyAppRole/my-app-session",
+        },
+    }
+    return sts_client
+
+
+def _read_records(path) -> list[dict]:
+    with open(path, encoding="utf-8") as audit_file:
+        return [json.loads(line) for line in audit_file]
+
+
+def _record(service: str = "my-app") -> AuditRecord:
+    return AuditRecord(
+        service=service,
+        role_arn=ROLE_ARN,
+        assumed_role_id=ASSUMED_ROLE_ID,
+        cache="hit",
+        expiration="2099-01-01T00:00:00Z",
+        endpoint="container",
+    )
+
+
+class TestAuditLog:
+    """Test the audit file."""
+
+    def test_appends_to_owner_only_file(self, tmp_path):
+        """Test records are appended to existing content, readable by the owner."""
+        path = tmp_path / "audit.log"
+        path.write_text('{"previous":"record"}\n')
+        os.chmod(path, AUDIT_FILE_MODE)
+        audit_log = AuditLog(str(path))
+        audit_log.record(_record())
+        audit_log.close()
+
+        records = _read_records(path)
+        assert records[0] == {"previous": "record"}
+        assert list(records[1])[0] == "timestamp"
+        assert records[1]["timestamp"].endswith("Z")
+        assert records[1]["assumed_role_id"] == ASSUMED_ROLE_ID
+
+        new_path = tmp_path / "new-audit.log"
+        AuditLog(str(new_path)).close()
+        assert stat.S_IMODE(os.stat(new_path).st_mode) == AUDIT_FILE_MODE
+
+    def test_reopened_after_rotation(self, tmp_path):
+        """Test records go to a new file once the file was moved and reopened."""
+        path = tmp_path / "audit.log"
+        audit_log = AuditLog(str(path))
+        audit_log.record(_record("before"))
+        os.rename(path, tmp_path / "audit.log.1")
+        audit_log.reopen()
+        audit_log.record(_record("after"))
+        audit_log.close()
+
+        assert [r["service"] for r in _read_records(tmp_path / "audit.log.1")] == [
+            "before"
+        ]
+        assert [r["service"] for r in _read_records(path)] == ["after"]
+
+
+class TestAuditVends:
+    """Test the credentials vended are recorded."""
+
+    def test_vends_recorded_without_secrets(self, tmp_path):
+        """Test misses and hits are recorded with the role session ID."""
+        path = tmp_path / "audit.log"
+        app = init_app(
+            mock_config(
+                source_credentials={"iam_profile": {"profile_name": "my-app"}},
+                server={"audit_log": str(path)},
+                imds={"enabled": True, "service": "my-app"},
+            )
+        )
+
+        with (
+            app.test_client() as client,
+            patch(
+                "credproxy.credentials_handler.boto3.Session",
+                return_value=MagicMock(
+                    client=MagicMock(return_value=_mock_sts_client())
+                ),
+            ),
+        ):
+            for _ in range(2):
+                response = client.get(
+                    "/v1/credentials", headers={"Authorization": "my-app-token"}
+                )
+                assert response.status_code == 200
+            imds_response = client.get(
+                "/latest/meta-data/iam/security-credentials/MyAppRole"
+            )
+            assert imds_response.status_code == 200
+        app.config["credentials_handler"].cleanup()
+        app.config["audit_log"].close()
+
+        records = _read_records(path)
+        assert [(r["cache"], r["endpoint"]) for r in records] == [
+            ("miss", "container"),
+            ("hit", "container"),
+            ("hit", "imds"),
+        ]
+        assert records[0]["service"] == "my-app"
+        assert records[0]["role_arn"] == ROLE_ARN
+        assert records[0]["assumed_role_id"] == ASSUMED_ROLE_ID
+        assert records[0]["expiration"] == "2099-01-01T00:00:00Z"
+        assert records[0]["token_sha256"] == (
+            hashlib.sha256(b"my-app-token").hexdigest()
+        )
+        assert records[2]["token_sha256"] is None
+        content = path.read_text()
+        for secret in ("my-app-token", "my-app-secret", "my-app-session-token"):
+            assert secret not in content
+
+    def test_not_recorded_without_audit_log(self):
+        """Test no audit log is opened unless configured."""
+        assert init_app(Config()).config["audit_log"] is None
@@ -0,0 +1,244 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the circuit breaker of STS calls."""
+
+from __future__ import annotations
+
+import time
+from datetime import datetime, timezone, timedelta
+from unittest.mock import patch
+
+import pytest
+from botocore.exceptions import ClientError
+
+from credproxy.app import init_app
+from tests.mock_aws import mock_config
+from credproxy.retry import StsRetryPolicy
+from credproxy.config import Config
+from credproxy.circuit_breaker import CircuitOpen, StsCircuitBreaker
+
+
+ADMIN_TOKEN = "admin-token-0123456789"
+
+
+def _throttling() -> ClientError:
+    return ClientError(
+        {
+            "Error": {"Code": "Throttling", "Message": "Rate exceeded"},
+            "ResponseMetadata": {"HTTPStatusCode": 400},
+        },
+        "AssumeRole",
+    )
+
+
+def _assume_role_response() -> dict:
+    return {
+        "Credentials": {
+            "AccessKeyId": "ASIACIRCUITKEY",
+            "SecretAccessKey": "circuit-secret",
+            "SessionToken": "circuit-session-token",
+            "Expiration": datetime.now(timezone.utc) + timedelta(hours=1),
+        }
+    }
+
+
+def _config(circuit_breaker: dict) -> Config:
+    return mock_config(
+        ("warm-app", "cold-app"),
+        server={"admin_token": ADMIN_TOKEN},
+        credentials={"sts_max_attempts": 1, "circuit_breaker": circuit_breaker},
+    )
+
+
+def _later(seconds: float):
+    """Patch the monotonic clock to run seconds ahead."""
+    monotonic = time.monotonic
+    return patch("time.monotonic", side_effect=lambda: monotonic() + seconds)
+
+
+def _fail(circuit: StsCircuitBreaker, error: Exception | None = None) -> None:
+    circuit.record(circuit.before_call(), error or _throttling())
+
+
+class TestStsCircuitBreaker:
+    """Test the states of the circuit breaker."""
+
+    def test_opens_after_consecutive_failures(self):
+        """Test the circuit opens after failure_threshold failures in a row."""
+        circuit = StsCircuitBreaker("us-west-2", failure_threshold=3)
+        for _ in range(2):
+            _fail(circuit)
+        assert circuit.status().state == "closed"
+
+        _fail(circuit)
+        with pytest.raises(CircuitOpen) as error:
+            circuit.before_call()
+
+        status = circuit.status()
+        assert (status.state, status.consecutive_failures) == ("open", 3)
+        assert 0 < error.value.retry_after <= 30
+
+    @pytest.mark.parametrize(
+        "outcome",
+        [
+            None,
+            ClientError(
+                {"Error": {"Code": "AccessDenied", "Message": "Not authorized"}},
+                "AssumeRole",
+            ),
+        ],
+    )
+    def test_answers_reset_failures(self, outcome):
+        """Test a call answered by STS, granted or not, resets the failures."""
+        circuit = StsCircuitBreaker("us-west-2", failure_threshold=2)
+        _fail(circuit)
+        circuit.record(circuit.before_call(), outcome)
+        _fail(circuit)
+
+        assert circuit.status().state == "closed"
+        assert circuit.status().consecutive_failures == 1
+
+    def test_failures_outside_window(self):
+        """Test failures further apart than the window are not consecutive."""
+        circuit = StsCircuitBreaker("us-west-2", failure_threshold=2, window_seconds=10)
+        _fail(circuit)
+        with _later(11):
+            _fail(circuit)
+            status = circuit.status()
+
+        assert (status.state, status.consecutive_failures) == ("closed", 1)
+
+    def test_local_errors_ignored(self):
+        """Test errors raised before reaching STS are not failures of STS."""
+        circuit = StsCircuitBreaker("us-west-2", failure_threshold=1)
+        _fail(circuit, ValueError("Invalid token file"))
+
+        assert circuit.status().state == "closed"
+        assert circuit.status().consecutive_failures == 0
+
+    def test_single_probe_closes(self):
+        """Test a single call is allowed after the cooldown, closing the circuit."""
+        circuit = StsCircuitBreaker("us-west-2", 1, cooldown_seconds=5)
+        _fail(circuit)
+
+        with _later(6):
+            assert circuit.status().state == "half-open"
+            probe = circuit.before_call()
+            with pytest.raises(CircuitOpen) as error:
+                circuit.before_call()
+            circuit.record(probe)
+
+        assert probe
+        assert error.value.retry_after == 1.0
+        assert circuit.status().state == "closed"
+        assert not circuit.before_call()
+
+    def test_failed_probe_reopens(self):
+        """Test the circuit opens for another cooldown when the probe fails."""
+        circuit = StsCircuitBreaker("us-west-2", 3, cooldown_seconds=5)
+        for _ in range(3):
+            _fail(circuit)
+
+        with _later(6):
+            _fail(circuit)
+            with pytest.raises(CircuitOpen):
+                circuit.before_call()
+            assert circuit.status().state == "open"
+
+    def test_retries_stop_once_open(self):
+        """Test the retry policy stops calling STS once the circuit opens."""
+        circuit = StsCircuitBreaker("us-west-2", failure_threshold=2)
+        calls = []
+
+        def function():
+            calls.append(1)
+            raise _throttling()
+
+        with patch("time.sleep"), pytest.raises(CircuitOpen):
+            StsRetryPolicy(max_attempts=5, circuit=circuit).call(
+                "AssumeRole", function
+            )
+
+        assert len(calls) == 2
+
+
+class TestCircuitBreakerEndpoints:
+    """Test the answers of the endpoints while the circuit is open."""
+
+    def test_open_circuit(self):
+        """Test misses get 503 while warm cache reads are served, until a probe."""
+        app = init_app(_config({"failure_threshold": 1, "cooldown_seconds": 10}))
+        handler = app.config["credentials_handler"]
+
+        with app.test_client() as client, patch("boto3.client") as mock_client:
+            assume_role = mock_client.return_value.assume_role
+            assume_role.return_value = _assume_role_response()
+            warm = {"Authorization": "warm-app-token"}
+            cold = {"Authorization": "cold-app-token"}
+            assert client.get("/v1/credentials", headers=warm).status_code == 200
+
+            assume_role.side_effect = _throttling()
+            throttled = client.get("/v1/credentials", headers=cold)
+            failing_fast = client.get("/v1/credentials", headers=cold)
+            cached = client.get("/v1/credentials", headers=warm)
+            ready = client.get("/readyz")
+            status = client.get(
+                "/admin/status", headers={"Authorization": ADMIN_TOKEN}
+            )
+            assert assume_role.call_count == 2
+
+            assume_role.side_effect = None
+            with _later(11):
+                probed = client.get("/v1/credentials", headers=cold)
+            closed_ready = client.get("/readyz")
+        handler.cleanup()
+
+        assert throttled.status_code == 502
+        assert failing_fast.status_code == 503
+        assert failing_fast.get_json()["code"] == "ServiceUnavailable"
+        assert 1 <= int(failing_fast.headers["Retry-After"]) <= 10
+        assert cached.status_code == 200
+        assert ready.status_code == 503
+        circuit = status.get_json()["sts_circuits"]["us-west-2"]
+        assert (circuit["state"], circuit["consecutive_failures"]) == ("open", 1)
+        assert probed.status_code == 200
+        assert closed_ready.status_code == 200
+
+    def test_disabled_by_default(self):
+        """Test STS is called on every miss without a circuit breaker."""
+        config = _config({})
+        config.credentials.circuit_breaker = None
+        app = init_app(config)
+        handler = app.config["credentials_handler"]
+
+        cold = {"Authorization": "cold-app-token"}
+
+        with app.test_client() as client, patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.side_effect = _throttling()
+            for _ in range(3):
+                client.get("/v1/credentials", headers=cold)
+            status = client.get(
+                "/admin/status", headers={"Authorization": ADMIN_TOKEN}
+            )
+        handler.cleanup()
+
+        assert mock_client.return_value.assume_role.call_count == 3
+        assert status.get_json()["sts_circuits"] == {}
+
+
+class TestCircuitBreakerConfig:
+    """Test the loading of the circuit breaker settings."""
+
+    def test_defaults(self):
+        """Test an empty circuit_breaker enables it with the default settings."""
+        circuit_breaker = _config({}).credentials.circuit_breaker
+
+        assert circuit_breaker.failure_threshold == 5
+        assert circuit_breaker.window_seconds == 60
+        assert circuit_breaker.cooldown_seconds == 30
+
+    def test_invalid_threshold(self):
+        """Test a threshold below 1 fails validation."""
+        with pytest.raises(ValueError):
+            _config({"failure_threshold": 0})
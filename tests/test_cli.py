@@ -6,13 +6,18 @@
 
 from __future__ import annotations
 
+import io
 import os
+import sys
+import json
 import tempfile
+import subprocess
 from unittest.mock import patch
 
 import yaml
 import pytest
 
+from credproxy import __version__
 from credproxy.cli import main, create_parser
 from tests.mock_aws import mock_role_arn, mock_access_key_id, mock_secret_access_key
 from credproxy.runner import validate_config_file
@@ -39,6 +44,165 @@ class TestCLI:
         assert args.validate_only is True
         assert args.log_level == "DEBUG"
 
+    def test_refresh_window_argument(self):
+        """Test refresh window argument parsing and validation."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).refresh_window is None
+        assert parser.parse_args(["--refresh-window", "120"]).refresh_window == 120
+
+        with pytest.raises(SystemExit):
+            parser.parse_args(["--refresh-window", "-1"])
+
+    def test_refresh_jitter_argument(self):
+        """Test refresh jitter argument parsing and validation."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).refresh_jitter is None
+        assert parser.parse_args(["--refresh-jitter", "30"]).refresh_jitter == 30
+
+        with pytest.raises(SystemExit):
+            parser.parse_args(["--refresh-jitter", "-1"])
+
+    def test_sts_max_attempts_argument(self):
+        """Test STS max attempts argument parsing and validation."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).sts_max_attempts is None
+        assert parser.parse_args(["--sts-max-attempts", "5"]).sts_max_attempts == 5
+
+        with pytest.raises(SystemExit):
+            parser.parse_args(["--sts-max-attempts", "0"])
+
+    def test_request_timeout_argument(self):
+        """Test request timeout argument parsing and validation."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).request_timeout is None
+        assert parser.parse_args(["--request-timeout", "10"]).request_timeout == 10
+
+        with pytest.raises(SystemExit):
+            parser.parse_args(["--request-timeout", "0"])
+
+    def test_listen_unix_argument(self):
+        """Test unix socket listen argument parsing."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).listen_unix is None
+        args = parser.parse_args(["--listen-unix", "/run/credproxy.sock"])
+        assert args.listen_unix == "/run/credproxy.sock"
+
+    def test_listen_pipe_argument(self):
+        """Test named pipe listen argument parsing, on Windows only."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).listen_pipe is None
+        with patch("credproxy.cli.sys.platform", "win32"):
+            args = parser.parse_args(["--listen-pipe", r"\\.\pipe\credproxy"])
+            assert args.listen_pipe == r"\\.\pipe\credproxy"
+            with pytest.raises(SystemExit):
+                parser.parse_args(["--listen-pipe", "credproxy"])
+        with patch("credproxy.cli.sys.platform", "linux"):
+            with pytest.raises(SystemExit):
+                parser.parse_args(["--listen-pipe", r"\\.\pipe\credproxy"])
+
+    def test_log_format_argument(self):
+        """Test log format argument parsing."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).log_format is None
+        assert parser.parse_args(["--log-format", "text"]).log_format == "text"
+
+        with pytest.raises(SystemExit):
+            parser.parse_args(["--log-format", "xml"])
+
+    def test_shutdown_timeout_argument(self):
+        """Test shutdown timeout argument parsing and validation."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).shutdown_timeout is None
+        args = parser.parse_args(["--shutdown-timeout", "2.5"])
+        assert args.shutdown_timeout == 2.5
+
+        for value in ("-1", "soon"):
+            with pytest.raises(SystemExit):
+                parser.parse_args(["--shutdown-timeout", value])
+
+    def test_credentials_command_arguments(self):
+        """Test the credentials command accepts --profile and a trailing --config."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).command is None
+        args = parser.parse_args(["credentials", "--service", "my-app"])
+        assert args.command == "credentials"
+        assert args.service == "my-app"
+        assert args.config == "/credproxy/config.yaml"
+
+        args = parser.parse_args(
+            ["credentials", "--profile", "my-app", "--config", "test.yaml"]
+        )
+        assert args.service == "my-app"
+        assert args.config == "test.yaml"
+
+        with pytest.raises(SystemExit):
+            parser.parse_args(["credentials"])
+
+    def test_env_command_arguments(self):
+        """Test the env command takes a service and a shell, bash by default."""
+        parser = create_parser()
+
+        args = parser.parse_args(["env", "--profile", "my-app"])
+        assert args.command == "env"
+        assert args.service == "my-app"
+        assert args.shell == "bash"
+        args = parser.parse_args(["env", "--profile", "my-app", "--shell", "fish"])
+        assert args.shell == "fish"
+
+        for argv in (["env"], ["env", "--profile", "a", "--shell", "zsh"]):
+            with pytest.raises(SystemExit):
+                parser.parse_args(argv)
+
+    def test_server_modules_imported_lazily(self):
+        """Test the CLI imports the server modules only when running them."""
+        code = (
+            "import sys, credproxy.cli; "
+            "print(sorted({'credproxy.runner', 'credproxy.app'} & set(sys.modules)))"
+        )
+        result = subprocess.run(
+            [sys.executable, "-c", code],
+            capture_output=True,
+            text=True,
+            check=True,
+            env={**os.environ, "PYTHONPATH": os.pathsep.join(sys.path)},
+        )
+
+        assert result.stdout.strip() == "[]"
+
+    def test_sts_endpoint_argument(self):
+        """Test the STS endpoint argument must be an http(s) URL."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).sts_endpoint is None
+        args = parser.parse_args(["--sts-endpoint", "https://sts.amazonaws.com"])
+        assert args.sts_endpoint == "https://sts.amazonaws.com"
+
+        with pytest.raises(SystemExit):
+            parser.parse_args(["--sts-endpoint", "sts.amazonaws.com"])
+
+    def test_metrics_addr_argument(self):
+        """Test metrics address argument parsing and validation."""
+        parser = create_parser()
+
+        assert parser.parse_args([]).metrics_addr is None
+        args = parser.parse_args(["--metrics-addr", "127.0.0.1:9100"])
+        assert args.metrics_addr == ("127.0.0.1", 9100)
+        args = parser.parse_args(["--metrics-addr", "[::1]:9100"])
+        assert args.metrics_addr == ("::1", 9100)
+
+        for value in ("9100", ":9100", "localhost:http", "localhost:70000"):
+            with pytest.raises(SystemExit):
+                parser.parse_args(["--metrics-addr", value])
+
     def test_log_level_argument(self):
         """Test log level argument parsing."""
         parser = create_parser()
@@ -114,8 +278,8 @@ class TestCLI:
         finally:
             os.unlink(temp_file)
 
-    @patch("flask.Flask.run")
-    def test_main_run_app(self, mock_flask_run):
+    @patch("credproxy.runner.CredProxyServer.serve_until", return_value=True)
+    def test_main_run_app(self, mock_serve_until):
         """Test main function running the application."""
         mock_access_key = mock_access_key_id()
         mock_secret_key = mock_secret_access_key()
@@ -150,7 +314,7 @@ class TestCLI:
         try:
             result = main(["--config", temp_file, "--log-level", "WARNING"])
             assert result == 0
-            mock_flask_run.assert_called_once()
+            mock_serve_until.assert_called_once()
         finally:
             os.unlink(temp_file)
 
@@ -226,7 +390,10 @@ class TestCLI:
             temp_file = f.name
 
         try:
-            with patch("flask.Flask.run", side_effect=KeyboardInterrupt()):
+            with patch(
+                "credproxy.runner.CredProxyServer.serve_until",
+                side_effect=KeyboardInterrupt(),
+            ):
                 result = main(["--config", temp_file])
                 assert result == 0
         finally:
@@ -237,12 +404,37 @@ class TestCLI:
         result = main(["--config", "nonexistent.yaml"])
         assert result == 1
 
-    def test_main_version(self):
-        """Test main function with version argument."""
+    def test_main_tls_cert_requires_key(self):
+        """Test --tls-cert without --tls-key is rejected."""
         with pytest.raises(SystemExit) as exc_info:
-            main(["--version"])
+            main(["--tls-cert", "/etc/credproxy/tls.pem"])
 
-        assert exc_info.value.code == 0
+        assert exc_info.value.code == 2
+
+    def test_main_version(self):
+        """Test main function with version argument."""
+        with patch("sys.stdout", new_callable=io.StringIO) as stdout:
+            assert main(["--version"]) == 0
+
+        lines = stdout.getvalue().splitlines()
+        assert lines[0] == f"credproxy {__version__}"
+        assert [line.split(":")[0] for line in lines[1:]] == [
+            "Git commit",
+            "Build date",
+            "Python",
+        ]
+
+    @pytest.mark.parametrize(
+        "argv", [["--version", "-o", "json"], ["version", "-o", "json"]]
+    )
+    def test_version_json(self, argv):
+        """Test the build information is printed as JSON with -o json."""
+        with patch("sys.stdout", new_callable=io.StringIO) as stdout:
+            assert main(argv) == 0
+
+        info = json.loads(stdout.getvalue())
+        assert info["version"] == __version__
+        assert set(info) == {"version", "git_commit", "build_date", "python_version"}
 
     def test_dev_flag_sets_debug_log_level(self):
         """Test that --dev flag sets log level to DEBUG when not explicitly set."""
@@ -277,10 +469,12 @@ class TestCLI:
             temp_file = f.name
 
         try:
-            with patch("flask.Flask.run") as mock_flask_run:
+            with patch(
+                "credproxy.runner.CredProxyServer.serve_until", return_value=True
+            ) as mock_serve_until:
                 result = main(["--config", temp_file, "--dev"])
                 assert result == 0
-                mock_flask_run.assert_called_once()
+                mock_serve_until.assert_called_once()
         finally:
             os.unlink(temp_file)
 
@@ -317,12 +511,14 @@ class TestCLI:
             temp_file = f.name
 
         try:
-            with patch("flask.Flask.run") as mock_flask_run:
+            with patch(
+                "credproxy.runner.CredProxyServer.serve_until", return_value=True
+            ) as mock_serve_until:
                 result = main(
                     ["--config", temp_file, "--dev", "--log-level", "WARNING"]
                 )
                 assert result == 0
-                mock_flask_run.assert_called_once()
+                mock_serve_until.assert_called_once()
         finally:
             os.unlink(temp_file)
 
@@ -8,6 +8,7 @@ from __future__ import annotations
 
 import os
 import tempfile
+from unittest.mock import patch
 
 import yaml
 import pytest
@@ -273,6 +274,153 @@ class TestServiceConfig:
         finally:
             os.unlink(temp_file)
 
+    def test_service_config_role_chain(self):
+        """Test role_chain hops are parsed in order."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "chained-service": {
+                        "auth_token": "chain-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "role_chain": [
+                            {
+                                "RoleArn": "arn:aws:iam::111111111111:role/RoleA",
+                                "ExternalId": "hop-one-id",
+                            }
+                        ],
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::222222222222:role/RoleB"
+                        },
+                    },
+                },
+            }
+        )
+
+        service = config.services["chained-service"]
+        assert [hop.RoleArn for hop in service.role_chain] == [
+            "arn:aws:iam::111111111111:role/RoleA"
+        ]
+        assert service.role_chain[0].ExternalId == "hop-one-id"
+        assert service.assumed_role.RoleArn == "arn:aws:iam::222222222222:role/RoleB"
+
+    def test_service_config_without_role_chain(self):
+        """Test services without role_chain have an empty chain."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "test-service": {
+                        "auth_token": "test-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {"RoleArn": mock_role_arn()},
+                    },
+                },
+            }
+        )
+
+        assert config.services["test-service"].role_chain == []
+
+    def test_service_config_sts_endpoint(self):
+        """Test sts_endpoint is inherited from aws_defaults like region."""
+        config = Config.from_dict(
+            {
+                "aws_defaults": {
+                    "region": "us-gov-west-1",
+                    "sts_endpoint": "https://sts.us-gov-west-1.amazonaws.com",
+                },
+                "services": {
+                    "test-service": {
+                        "auth_token": "test-token",
+                        "source_credentials": {},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws-us-gov:iam::123456789012:role/GovRole"
+                        },
+                    },
+                },
+            }
+        )
+
+        source_credentials = config.services["test-service"].source_credentials
+        assert source_credentials.region == "us-gov-west-1"
+        assert source_credentials.sts_endpoint == (
+            "https://sts.us-gov-west-1.amazonaws.com"
+        )
+
+    @pytest.mark.parametrize(
+        "sts_endpoint", ["sts.amazonaws.com", "https://", "https://host:port"]
+    )
+    def test_invalid_sts_endpoint_rejected(self, sts_endpoint):
+        """Test an STS endpoint which is not an http(s) URL fails loading."""
+        with pytest.raises(ValueError):
+            Config.from_dict(
+                {
+                    "services": {
+                        "test-service": {
+                            "auth_token": "test-token",
+                            "source_credentials": {"sts_endpoint": sts_endpoint},
+                            "assumed_role": {"RoleArn": mock_role_arn()},
+                        },
+                    },
+                }
+            )
+
+    def test_transitive_tag_keys_must_be_tags(self):
+        """Test TransitiveTagKeys not found in Tags fail loading."""
+        with pytest.raises(ValueError, match="TransitiveTagKeys"):
+            Config.from_dict(
+                {
+                    "services": {
+                        "tagged-service": {
+                            "auth_token": "tagged-token",
+                            "source_credentials": {"region": "us-west-2"},
+                            "assumed_role": {
+                                "RoleArn": mock_role_arn(),
+                                "Tags": [{"Key": "team", "Value": "platform"}],
+                                "TransitiveTagKeys": ["team", "project"],
+                            },
+                        },
+                    },
+                }
+            )
+
+    def test_source_identity_parsed(self):
+        """Test SourceIdentity is loaded with the role."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "traced-service": {
+                        "auth_token": "traced-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": mock_role_arn(),
+                            "SourceIdentity": "jane.doe@example.com",
+                        },
+                    },
+                },
+            }
+        )
+
+        assumed_role = config.services["traced-service"].assumed_role
+        assert assumed_role.SourceIdentity == "jane.doe@example.com"
+
+    @pytest.mark.parametrize("source_identity", ["j", "jane doe", "jane/doe", "x" * 65])
+    def test_invalid_source_identity_rejected(self, source_identity):
+        """Test a SourceIdentity STS would reject fails loading."""
+        with pytest.raises(ValueError, match="SourceIdentity"):
+            Config.from_dict(
+                {
+                    "services": {
+                        "traced-service": {
+                            "auth_token": "traced-token",
+                            "source_credentials": {"region": "us-west-2"},
+                            "assumed_role": {
+                                "RoleArn": mock_role_arn(),
+                                "SourceIdentity": source_identity,
+                            },
+                        },
+                    },
+                }
+            )
+
 
 class TestAuthMethodConfigs:
     """Test authentication method configuration classes."""
@@ -644,3 +792,79 @@ class TestConfigEdgeCasesAndCoverage:
 
         finally:
             os.unlink(temp_file)
+
+
+class TestAuthTokenFile:
+    """Test service auth tokens read from a file."""
+
+    def _config(self, auth: dict) -> Config:
+        return Config.from_dict(
+            {
+                "services": {
+                    "file-service": {
+                        **auth,
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {"RoleArn": mock_role_arn()},
+                    }
+                }
+            }
+        )
+
+    def test_token_read_from_file(self, tmp_path):
+        """Test the service token is read from auth_token_file."""
+        token_file = tmp_path / "token"
+        token_file.write_text("file-token\n")
+        config = self._config({"auth_token_file": str(token_file)})
+
+        assert config.services["file-service"].auth_token == "file-token"
+        assert config.get_service_name_by_token("file-token") == "file-service"
+
+    def test_token_file_reloaded(self, tmp_path):
+        """Test a rewritten token file replaces the previous token."""
+        token_file = tmp_path / "token"
+        token_file.write_text("first-token")
+        config = self._config({"auth_token_file": str(token_file)})
+        assert config.get_service_name_by_token("first-token") == "file-service"
+
+        token_file.write_text("second-token")
+        os.utime(token_file, ns=(0, 1_000_000_000))
+
+        assert config.get_service_name_by_token("second-token") == "file-service"
+        assert config.get_service_name_by_token("first-token") is None
+
+    def test_unreadable_token_file_keeps_token(self, tmp_path):
+        """Test the last token read is kept when the file disappears."""
+        token_file = tmp_path / "token"
+        token_file.write_text("kept-token")
+        config = self._config({"auth_token_file": str(token_file)})
+        token_file.unlink()
+
+        assert config.get_service_name_by_token("kept-token") == "file-service"
+
+    def test_empty_token_file_rejected(self, tmp_path):
+        """Test an empty token file fails loading the configuration."""
+        token_file = tmp_path / "token"
+        token_file.write_text("\n")
+
+        with pytest.raises(ValueError, match="is empty"):
+            self._config({"auth_token_file": str(token_file)})
+
+    def test_token_and_token_file_exclusive(self, tmp_path):
+        """Test auth_token and auth_token_file cannot both be set."""
+        with pytest.raises(ValueError, match="Configuration validation failed"):
+            self._config(
+                {"auth_token": "token", "auth_token_file": str(tmp_path / "token")}
+            )
+
+    def test_token_compared_in_constant_time(self):
+        """Test tokens are compared with hmac.compare_digest."""
+        config = self._config({"auth_token": "constant-time-token"})
+
+        with patch(
+            "credproxy.config.hmac.compare_digest", return_value=False
+        ) as mock_compare:
+            assert config.get_service_name_by_token("constant-time-token") is None
+
+        mock_compare.assert_called_once_with(
+            b"constant-time-token", b"constant-time-token"
+        )
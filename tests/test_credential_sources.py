@@ -0,0 +1,187 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for source credentials fallback chains."""
+
+from __future__ import annotations
+
+from datetime import datetime, timezone
+from unittest.mock import MagicMock, patch
+
+import pytest
+
+from credproxy.app import init_app
+from tests.mock_aws import mock_config
+from credproxy.config import Config
+from credproxy.credentials_handler import CredentialsHandler, CredentialSourcesError
+
+
+WEB_IDENTITY_SOURCE = {
+    "web_identity": {
+        "token_file": "/var/run/secrets/eks.amazonaws.com/serviceaccount/token",
+        "role_arn": "arn:aws:iam::123456789012:role/IrsaRole",
+    }
+}
+IAM_KEYS_SOURCE = {
+    "iam_keys": {
+        "aws_access_key_id": "AKIA1111111111111111",
+        "aws_secret_access_key": "laptop" + "0" * 34,
+    }
+}
+
+
+def _failing_web_identity() -> MagicMock:
+    """Build a web identity provider whose token file is missing."""
+    provider = MagicMock()
+    provider.retrieve.side_effect = FileNotFoundError("No token file")
+    return provider
+
+
+class TestSourcesConfig:
+    """Test parsing fallback chains."""
+
+    def test_sources_parsed(self):
+        """Test each source is parsed, empty ones using the SDK defaults."""
+        config = mock_config(
+            source_credentials={"sources": [WEB_IDENTITY_SOURCE, IAM_KEYS_SOURCE, {}]}
+        )
+        sources = config.services["my-app"].source_credentials.sources
+
+        assert sources[0].web_identity.role_arn == (
+            "arn:aws:iam::123456789012:role/IrsaRole"
+        )
+        assert sources[1].iam_keys.aws_access_key_id == "AKIA1111111111111111"
+        assert [CredentialsHandler.credential_source_name(s) for s in sources] == [
+            "web_identity",
+            "iam_keys",
+            "default",
+        ]
+
+    def test_sources_replace_default_method(self):
+        """Test a service chain replaces the method of aws_defaults."""
+        config = mock_config(
+            source_credentials={"sources": [IAM_KEYS_SOURCE]},
+            aws_defaults={"region": "eu-west-1", "iam_profile": {"profile_name": "x"}},
+        )
+        source_credentials = config.services["my-app"].source_credentials
+
+        assert source_credentials.iam_profile is None
+        assert len(source_credentials.sources) == 1
+
+    def test_sources_exclusive_with_methods(self):
+        """Test the schema rejects a chain next to a method, or two methods."""
+        with pytest.raises(ValueError):
+            mock_config(
+                source_credentials={
+                    "sources": [{**WEB_IDENTITY_SOURCE, **IAM_KEYS_SOURCE}]
+                }
+            )
+        with pytest.raises(ValueError):
+            Config.from_dict(
+                {
+                    "services": {
+                        "my-app": {
+                            "auth_token": "my-app-token",
+                            "source_credentials": {
+                                "sources": [IAM_KEYS_SOURCE],
+                                **IAM_KEYS_SOURCE,
+                            },
+                            "assumed_role": {
+                                "RoleArn": "arn:aws:iam::123456789012:role/MyAppRole"
+                            },
+                        }
+                    }
+                }
+            )
+
+
+class TestSourcesFallback:
+    """Test trying the sources of a chain in order."""
+
+    def test_first_working_source_used_and_kept(self):
+        """Test failing sources are skipped, and the winner tried first after."""
+        config = mock_config(
+            source_credentials={"sources": [WEB_IDENTITY_SOURCE, IAM_KEYS_SOURCE]}
+        )
+        handler = CredentialsHandler(config)
+        provider = _failing_web_identity()
+
+        with patch.object(
+            handler, "_web_identity_token_provider", return_value=provider
+        ):
+            first = handler._get_aws_config(config.services["my-app"])
+            second = handler._get_aws_config(config.services["my-app"])
+
+        assert first == second == {
+            "region_name": "us-west-2",
+            "aws_access_key_id": "AKIA1111111111111111",
+            "aws_secret_access_key": "laptop" + "0" * 34,
+        }
+        assert provider.retrieve.call_count == 1
+        assert handler.credential_sources() == {"my-app": "iam_keys"}
+        handler.cleanup()
+
+    def test_all_sources_failed(self):
+        """Test the error lists why every source failed."""
+        config = mock_config(
+            source_credentials={"sources": [WEB_IDENTITY_SOURCE, {}]}
+        )
+        handler = CredentialsHandler(config)
+        session = MagicMock()
+        session.get_credentials.return_value = None
+
+        with (
+            patch.object(
+                handler,
+                "_web_identity_token_provider",
+                return_value=_failing_web_identity(),
+            ),
+            patch("credproxy.credentials_handler.boto3.Session", return_value=session),
+        ):
+            with pytest.raises(CredentialSourcesError) as error:
+                handler._get_aws_config(config.services["my-app"])
+
+        assert error.value.failures == [
+            ("web_identity", "No token file"),
+            ("default", "Unable to locate credentials"),
+        ]
+        assert str(error.value) == (
+            "All credential sources of my-app failed: "
+            "web_identity: No token file; default: Unable to locate credentials"
+        )
+        assert handler.credential_sources() == {}
+        handler.cleanup()
+
+    def test_chosen_source_in_readiness(self):
+        """Test /readyz shows the source the credentials were obtained with."""
+        config = mock_config(
+            source_credentials={"sources": [WEB_IDENTITY_SOURCE, IAM_KEYS_SOURCE]}
+        )
+        app = init_app(config)
+        handler = app.config["credentials_handler"]
+        sts_client = MagicMock()
+        sts_client.assume_role.return_value = {
+            "Credentials": {
+                "AccessKeyId": "ASIAMYAPPKEY",
+                "SecretAccessKey": "my-app-secret",
+                "SessionToken": "my-app-session-token",
+                "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+            }
+        }
+
+        with (
+            app.test_client() as client,
+            patch.object(
+                handler,
+                "_web_identity_token_provider",
+                return_value=_failing_web_identity(),
+            ),
+            patch(
+                "credproxy.credentials_handler.boto3.client", return_value=sts_client
+            ),
+        ):
+            client.get("/v1/credentials", headers={"Authorization": "my-app-token"})
+            ready = client.get("/readyz")
+
+        assert ready.get_json()["sources"] == {"my-app": "iam_keys"}
+        handler.cleanup()
@@ -6,13 +6,42 @@
 
 from __future__ import annotations
 
+import os
+import json
 import time
+import threading
 from datetime import datetime, timezone, timedelta
 from unittest.mock import MagicMock, patch
 
 import pytest
+from botocore.exceptions import ClientError
 
-from credproxy.credentials_handler import CredentialsHandler, ServiceCredentialsManager
+from credproxy.app import init_app
+from tests.mock_aws import mock_config
+from credproxy.clock import Clock
+from credproxy.mfa import MFA_MAX_ATTEMPTS
+from credproxy.retry import NO_CLIENT_RETRIES
+from credproxy.config import Config, AssumedRoleConfig
+from credproxy.metrics import REGISTRY
+from credproxy.credentials_handler import (
+    CREDENTIALS_LOOKUP,
+    CredentialsLookup,
+    CredentialsHandler,
+    ServiceCredentialsManager,
+)
+
+
+class FakeClock(Clock):
+    """Clock only moving forward when told to."""
+
+    def __init__(self):
+        self.time = time.time()
+
+    def now(self) -> float:
+        return self.time
+
+    def advance(self, seconds: float) -> None:
+        self.time += seconds
 
 
 class TestServiceCredentialsManager:
@@ -57,6 +86,73 @@ class TestServiceCredentialsManager:
         assert result["Token"] == "testtoken"
         assert "Expiration" in result
 
+    def test_to_dict_ecs_response_shape(self):
+        """Test the response carries RoleArn and a UTC RFC3339 Expiration."""
+        manager = ServiceCredentialsManager(
+            aws_access_key_id="TESTKEY",
+            aws_secret_access_key="testsecret",
+            session_token="testtoken",
+            expiry=datetime(2030, 1, 2, 3, 4, 5, 678, tzinfo=timezone.utc).timestamp(),
+            role_arn="arn:aws:iam::123456789012:role/TestRole",
+        )
+
+        result = manager.to_dict()
+
+        assert set(result) == {
+            "AccessKeyId",
+            "SecretAccessKey",
+            "Token",
+            "Expiration",
+            "RoleArn",
+        }
+        assert result["Expiration"] == "2030-01-02T03:04:05Z"
+        assert result["RoleArn"] == "arn:aws:iam::123456789012:role/TestRole"
+
+    def test_needs_refresh(self):
+        """Test needs_refresh honours the refresh window."""
+        manager = ServiceCredentialsManager(
+            aws_access_key_id="test",
+            aws_secret_access_key="test",
+            session_token="test",
+            expiry=time.time() + 600,
+        )
+        assert manager.needs_refresh(300) is False
+        assert manager.needs_refresh(900) is True
+
+    def test_needs_refresh_with_offset(self):
+        """Test the refresh offset moves the refresh point of the credentials."""
+        manager = ServiceCredentialsManager(
+            aws_access_key_id="test",
+            aws_secret_access_key="test",
+            session_token="test",
+            expiry=time.time() + 600,
+            refresh_offset=400,
+        )
+        assert manager.needs_refresh(300) is True
+        manager.refresh_offset = -400
+        assert manager.needs_refresh(900) is False
+
+    def test_expiry_skew(self):
+        """Test the skew is taken off the expiry, and stacks with the window."""
+        clock = FakeClock()
+        manager = ServiceCredentialsManager(
+            aws_access_key_id="test",
+            aws_secret_access_key="test",
+            session_token="test",
+            expiry=clock.now() + 600,
+            clock=clock,
+        )
+        assert manager.is_expired(skew=30) is False
+        assert manager.needs_refresh(300, skew=30) is False
+
+        clock.advance(280)
+        assert manager.needs_refresh(300) is False
+        assert manager.needs_refresh(300, skew=30) is True
+
+        clock.advance(300)
+        assert manager.is_expired() is False
+        assert manager.is_expired(skew=30) is True
+
 
 class TestCredentialsHandler:
     """Test CredentialsHandler class."""
@@ -91,10 +187,74 @@ class TestCredentialsHandler:
         handler.cleanup()
         assert handler.cache == {}
 
+    def test_discard_credentials(self):
+        """Test discarded credentials are removed from the cache and sanitizer."""
+        handler = CredentialsHandler(MagicMock())
+        handler.cache["service1"] = ServiceCredentialsManager(
+            aws_access_key_id="DISCARDEDKEY",
+            aws_secret_access_key="discardedsecret",
+            session_token="discardedtoken",
+            expiry=time.time() + 3600,
+        )
+
+        with patch("credproxy.sanitizer.unregister_sensitive_value") as mock_unregister:
+            handler.discard_credentials("service1")
+            handler.discard_credentials("unknown")
+
+        assert handler.cache == {}
+        assert mock_unregister.call_count == 3
+        handler.cleanup()
+
+    def test_removed_service_not_cached(self):
+        """Test credentials of a service removed while assuming are not cached."""
+        mock_service = MagicMock()
+        mock_service.role_chain = []
+        mock_service.source_credentials.static = None
+        mock_service.assumed_role = AssumedRoleConfig(
+            RoleArn="arn:aws:iam::123456789012:role/TestRole"
+        )
+        mock_config = MagicMock()
+        mock_config.services = {"test-service": mock_service}
+        mock_config.credentials.refresh_buffer_seconds = 300
+        mock_config.credentials.refresh_jitter_seconds = 0
+        mock_config.credentials.expiry_skew_seconds = 0
+        mock_config.credentials.request_timeout = 30
+        handler = CredentialsHandler(mock_config)
+
+        def assume_role(service_config):
+            del mock_config.services["test-service"]
+            return _sts_credentials("REMOVEDKEY", timedelta(hours=1))
+
+        with patch.object(handler, "_assume_role", side_effect=assume_role):
+            result = handler.get_credentials("test-service")
+
+        assert result["AccessKeyId"] == "REMOVEDKEY"
+        assert handler.cache == {}
+        handler.cleanup()
+
+    def test_cleanup_abandons_stuck_refresher(self):
+        """Test cleanup does not wait on a refresher blocked on STS."""
+        handler = CredentialsHandler(MagicMock())
+        release = threading.Event()
+        handler._refresher_thread = threading.Thread(target=release.wait, daemon=True)
+        handler._refresher_thread.start()
+
+        with patch("credproxy.credentials_handler.REFRESHER_STOP_TIMEOUT", 0.1):
+            start = time.monotonic()
+            handler.cleanup()
+
+        assert time.monotonic() - start < 1
+        assert handler._refresher_thread.is_alive()
+        release.set()
+
     def test_get_credentials_cache_hit(self):
         """Test getting credentials from cache."""
         mock_config = MagicMock()
         mock_config.services = {"test-service": MagicMock()}
+        mock_config.credentials.refresh_buffer_seconds = 300
+        mock_config.credentials.refresh_jitter_seconds = 0
+        mock_config.credentials.expiry_skew_seconds = 0
+        mock_config.credentials.request_timeout = 30
 
         handler = CredentialsHandler(mock_config)
 
@@ -136,6 +296,10 @@ class TestCredentialsHandler:
         mock_config.services = {"test-service": mock_service}
         mock_config.aws_defaults = MagicMock()
         mock_config.aws_defaults.iam_profile = None
+        mock_config.credentials.refresh_buffer_seconds = 300
+        mock_config.credentials.refresh_jitter_seconds = 0
+        mock_config.credentials.expiry_skew_seconds = 0
+        mock_config.credentials.request_timeout = 30
 
         handler = CredentialsHandler(mock_config)
 
@@ -184,6 +348,10 @@ class TestCredentialsHandler:
         mock_config = MagicMock()
         mock_config.services = {"test-service": mock_service}
         mock_config.aws_defaults = None
+        mock_config.credentials.refresh_buffer_seconds = 300
+        mock_config.credentials.refresh_jitter_seconds = 0
+        mock_config.credentials.expiry_skew_seconds = 0
+        mock_config.credentials.request_timeout = 30
 
         handler = CredentialsHandler(mock_config)
 
@@ -366,12 +534,21 @@ class TestCredentialsHandler:
         mock_service = MagicMock()
         mock_service.source_credentials.iam_profile.profile_name = "test-profile"
         mock_service.source_credentials.region = "us-west-2"
+        mock_service.source_credentials.sts_endpoint = None
+        mock_service.source_credentials.http_proxy = None
+        mock_service.source_credentials.ca_bundle = None
+        mock_service.source_credentials.insecure_skip_verify = False
         mock_service.source_credentials.iam_keys = None
+        mock_service.source_credentials.sources = None
 
         mock_config = MagicMock()
         mock_config.aws_defaults = MagicMock()
         mock_config.aws_defaults.iam_profile = None
         mock_config.aws_defaults.iam_keys = None
+        mock_config.aws_defaults.sts_endpoint = None
+        mock_config.aws_defaults.http_proxy = None
+        mock_config.aws_defaults.ca_bundle = None
+        mock_config.aws_defaults.insecure_skip_verify = False
 
         handler = CredentialsHandler(mock_config)
 
@@ -387,12 +564,21 @@ class TestCredentialsHandler:
         mock_service.source_credentials.iam_keys.aws_secret_access_key = "test-secret"
         mock_service.source_credentials.iam_keys.session_token = "test-token"
         mock_service.source_credentials.region = "us-west-2"
+        mock_service.source_credentials.sts_endpoint = None
+        mock_service.source_credentials.http_proxy = None
+        mock_service.source_credentials.ca_bundle = None
+        mock_service.source_credentials.insecure_skip_verify = False
         mock_service.source_credentials.iam_profile = None
+        mock_service.source_credentials.sources = None
 
         mock_config = MagicMock()
         mock_config.aws_defaults = MagicMock()
         mock_config.aws_defaults.iam_profile = None
         mock_config.aws_defaults.iam_keys = None
+        mock_config.aws_defaults.sts_endpoint = None
+        mock_config.aws_defaults.http_proxy = None
+        mock_config.aws_defaults.ca_bundle = None
+        mock_config.aws_defaults.insecure_skip_verify = False
 
         handler = CredentialsHandler(mock_config)
 
@@ -416,6 +602,10 @@ class TestCredentialsHandler:
         mock_default_aws.iam_keys.aws_secret_access_key = "default-secret"
         mock_default_aws.iam_keys.session_token = None  # Explicitly set to None
         mock_default_aws.region = "us-east-1"
+        mock_default_aws.sts_endpoint = None
+        mock_default_aws.http_proxy = None
+        mock_default_aws.ca_bundle = None
+        mock_default_aws.insecure_skip_verify = False
         mock_default_aws.iam_profile = None
 
         mock_config = MagicMock()
@@ -437,6 +627,14 @@ class TestCredentialsHandler:
         mock_service = MagicMock()
         mock_service.source_credentials.iam_keys = None
         mock_service.source_credentials.iam_profile = None
+        mock_service.source_credentials.sso = None
+        mock_service.source_credentials.web_identity = None
+        mock_service.source_credentials.saml = None
+        mock_service.source_credentials.sources = None
+        mock_service.source_credentials.sts_endpoint = None
+        mock_service.source_credentials.http_proxy = None
+        mock_service.source_credentials.ca_bundle = None
+        mock_service.source_credentials.insecure_skip_verify = False
         mock_service.source_credentials.region = "us-west-2"
 
         mock_config = MagicMock()
@@ -500,3 +698,887 @@ class TestCredentialsHandler:
             assert result["AccessKeyId"] == "NEWKEY"
             assert result["SecretAccessKey"] == "newsecret"
             assert result["SessionToken"] == "newtoken"
+
+
+def _sts_credentials(access_key: str, lifetime: timedelta) -> dict:
+    """Build an STS Credentials response block."""
+    return {
+        "AccessKeyId": access_key,
+        "SecretAccessKey": f"{access_key}-secret",
+        "SessionToken": f"{access_key}-token",
+        "Expiration": datetime.now(timezone.utc) + lifetime,
+    }
+
+
+def _wait_for_refresh(handler: CredentialsHandler, service_name: str) -> None:
+    """Wait for an in-flight background refresh to complete."""
+    deadline = time.time() + 5
+    while time.time() < deadline:
+        with handler._refresh_lock:
+            if service_name not in handler._refreshing:
+                return
+        time.sleep(0.01)
+    raise AssertionError("Background refresh did not complete")
+
+
+class TestRefreshAhead:
+    """Test refresh-ahead caching of credentials."""
+
+    def _handler_with_cached(self, expires_in: float) -> CredentialsHandler:
+        mock_service = MagicMock()
+        mock_service.role_chain = []
+        mock_service.source_credentials.static = None
+        mock_service.assumed_role = AssumedRoleConfig(
+            RoleArn="arn:aws:iam::123456789012:role/TestRole"
+        )
+        mock_config = MagicMock()
+        mock_config.services = {"test-service": mock_service}
+        mock_config.credentials.refresh_buffer_seconds = 300
+        mock_config.credentials.refresh_jitter_seconds = 0
+        mock_config.credentials.expiry_skew_seconds = 0
+        mock_config.credentials.request_timeout = 30
+        handler = CredentialsHandler(mock_config)
+        handler.cache["test-service"] = ServiceCredentialsManager(
+            aws_access_key_id="CACHEDKEY",
+            aws_secret_access_key="cachedsecret",
+            session_token="cachedtoken",
+            expiry=time.time() + expires_in,
+        )
+        return handler
+
+    def test_cached_outside_window_not_refreshed(self):
+        """Test credentials far from expiry are served without refresh."""
+        handler = self._handler_with_cached(3600)
+
+        with patch.object(handler, "_assume_role") as mock_assume:
+            result = handler.get_credentials("test-service")
+
+        assert result["AccessKeyId"] == "CACHEDKEY"
+        mock_assume.assert_not_called()
+        handler.cleanup()
+
+    def test_cached_inside_window_served_and_refreshed(self):
+        """Test credentials in the window are served while refreshing."""
+        handler = self._handler_with_cached(120)
+
+        with patch.object(
+            handler,
+            "_assume_role",
+            return_value=_sts_credentials("NEWKEY", timedelta(hours=1)),
+        ) as mock_assume:
+            result = handler.get_credentials("test-service")
+            # Readers get the cached credentials immediately
+            assert result["AccessKeyId"] == "CACHEDKEY"
+            _wait_for_refresh(handler, "test-service")
+
+        mock_assume.assert_called_once()
+        assert handler.cache["test-service"].aws_access_key_id == "NEWKEY"
+        handler.cleanup()
+
+    def test_single_refresh_in_flight(self):
+        """Test concurrent reads trigger a single refresh."""
+        handler = self._handler_with_cached(120)
+        release = threading.Event()
+
+        def slow_assume(service_config):
+            release.wait(timeout=5)
+            return _sts_credentials("NEWKEY", timedelta(hours=1))
+
+        with patch.object(
+            handler, "_assume_role", side_effect=slow_assume
+        ) as mock_assume:
+            for _ in range(10):
+                result = handler.get_credentials("test-service")
+                assert result["AccessKeyId"] == "CACHEDKEY"
+            release.set()
+            _wait_for_refresh(handler, "test-service")
+
+        assert mock_assume.call_count == 1
+        handler.cleanup()
+
+    def test_refresh_failure_keeps_valid_credentials(self):
+        """Test a failed refresh falls back to the still-valid credentials."""
+        handler = self._handler_with_cached(120)
+
+        with patch.object(
+            handler, "_assume_role", side_effect=Exception("STS unavailable")
+        ):
+            handler.get_credentials("test-service")
+            _wait_for_refresh(handler, "test-service")
+            result = handler.get_credentials("test-service")
+
+        assert result["AccessKeyId"] == "CACHEDKEY"
+        handler.cleanup()
+
+    def test_refresh_offset_within_jitter_band(self):
+        """Test new credentials refresh at a random point of the jitter band."""
+        handler = self._handler_with_cached(3600)
+        handler.config.credentials.refresh_jitter_seconds = 60
+
+        offsets = {handler._refresh_offset() for _ in range(50)}
+
+        assert all(-60 <= offset <= 60 for offset in offsets)
+        assert len(offsets) > 1
+        handler.config.credentials.refresh_jitter_seconds = 0
+        assert handler._refresh_offset() == 0.0
+        handler.cleanup()
+
+    def test_throttled_refresh_backs_off(self):
+        """Test throttled refreshes are retried with a growing backoff."""
+        handler = self._handler_with_cached(120)
+        handler.config.credentials.retry_delay = 60
+        throttled = ClientError(
+            {"Error": {"Code": "Throttling", "Message": "Rate exceeded"}},
+            "AssumeRole",
+        )
+
+        with patch.object(handler, "_assume_role", side_effect=throttled):
+            handler.get_credentials("test-service")
+            _wait_for_refresh(handler, "test-service")
+            # Served from cache without a new refresh while backing off
+            result = handler.get_credentials("test-service")
+            assert handler._claim_refresh("test-service") is False
+
+            attempts, retry_at = handler._refresh_backoff["test-service"]
+            assert attempts == 1
+            assert 1 <= retry_at - time.time() <= 2
+
+            handler._refresh_backoff["test-service"] = (attempts, 0.0)
+            handler.get_credentials("test-service")
+            _wait_for_refresh(handler, "test-service")
+
+        assert result["AccessKeyId"] == "CACHEDKEY"
+        attempts, retry_at = handler._refresh_backoff["test-service"]
+        assert attempts == 2
+        assert 2 <= retry_at - time.time() <= 4
+        handler.cleanup()
+
+    def test_refresh_results_recorded(self):
+        """Test refresh outcomes and cached expiry are exported as metrics."""
+        handler = self._handler_with_cached(120)
+        failures = {"result": "failure"}
+        before = REGISTRY.get_sample_value("credproxy_refresh_total", failures) or 0.0
+
+        with patch.object(
+            handler, "_assume_role", side_effect=Exception("STS unavailable")
+        ):
+            handler.get_credentials("test-service")
+            _wait_for_refresh(handler, "test-service")
+
+        assert (
+            REGISTRY.get_sample_value("credproxy_refresh_total", failures)
+            == before + 1
+        )
+
+        with patch.object(
+            handler,
+            "_assume_role",
+            return_value=_sts_credentials("NEWKEY", timedelta(hours=1)),
+        ):
+            handler.get_credentials("test-service")
+            _wait_for_refresh(handler, "test-service")
+
+        expiry = REGISTRY.get_sample_value(
+            "credproxy_credentials_expiry_seconds", {"service_name": "test-service"}
+        )
+        assert 3500 < expiry <= 3600
+
+        handler.cleanup()
+        assert (
+            REGISTRY.get_sample_value(
+                "credproxy_credentials_expiry_seconds",
+                {"service_name": "test-service"},
+            )
+            is None
+        )
+
+    def test_lookup_recorded(self):
+        """Test get_credentials records cache hits and STS latency of misses."""
+        handler = self._handler_with_cached(3600)
+
+        handler.get_credentials("test-service")
+        assert CREDENTIALS_LOOKUP.get() == CredentialsLookup(cache="hit")
+
+        handler.cache.clear()
+        with patch.object(
+            handler,
+            "_assume_role",
+            return_value=_sts_credentials("NEWKEY", timedelta(hours=1)),
+        ):
+            handler.get_credentials("test-service")
+
+        lookup = CREDENTIALS_LOOKUP.get()
+        assert lookup.cache == "miss"
+        assert lookup.sts_duration >= 0
+        handler.cleanup()
+
+    def test_claim_refresh_is_exclusive(self):
+        """Test a refresh cannot be claimed twice for the same service."""
+        handler = self._handler_with_cached(3600)
+
+        assert handler._claim_refresh("test-service") is True
+        assert handler._claim_refresh("test-service") is False
+        handler.cleanup()
+
+    def test_force_refresh_replaces_cached(self):
+        """Test a forced refresh re-assumes the role ignoring the backoff."""
+        handler = self._handler_with_cached(3600)
+        handler._refresh_backoff["test-service"] = (3, time.time() + 60)
+
+        with patch.object(
+            handler,
+            "_assume_role",
+            return_value=_sts_credentials("FORCEDKEY", timedelta(hours=1)),
+        ):
+            service_creds = handler.force_refresh("test-service")
+
+        assert service_creds.aws_access_key_id == "FORCEDKEY"
+        assert handler.cache["test-service"] is service_creds
+        assert "test-service" not in handler._refresh_backoff
+        assert "test-service" not in handler._refreshing
+        handler.cleanup()
+
+    def test_warm_reads_marshaled_once(self):
+        """Test cache hits serve the body marshaled once, refreshes a new one."""
+        handler = self._handler_with_cached(3600)
+
+        with patch(
+            "credproxy.credentials_handler.json.dumps", wraps=json.dumps
+        ) as mock_dumps:
+            bodies = [
+                handler.get_credentials_entry("test-service").response_body
+                for _ in range(3)
+            ]
+        assert mock_dumps.call_count == 1
+        assert bodies[0] is bodies[1] is bodies[2]
+        assert json.loads(bodies[0])["AccessKeyId"] == "CACHEDKEY"
+
+        with patch.object(
+            handler,
+            "_assume_role",
+            return_value=_sts_credentials("FORCEDKEY", timedelta(hours=1)),
+        ):
+            handler.force_refresh("test-service")
+        body = handler.get_credentials_entry("test-service").response_body
+        assert json.loads(body)["AccessKeyId"] == "FORCEDKEY"
+        handler.cleanup()
+
+    def test_force_refresh_waits_for_refresh_in_flight(self):
+        """Test a forced refresh is not concurrent with a background refresh."""
+        handler = self._handler_with_cached(120)
+        release = threading.Event()
+        calls = []
+
+        def slow_assume(service_config):
+            calls.append(service_config)
+            release.wait(timeout=5)
+            return _sts_credentials(f"KEY{len(calls)}", timedelta(hours=1))
+
+        with patch.object(handler, "_assume_role", side_effect=slow_assume):
+            handler.get_credentials("test-service")
+            forced = threading.Thread(
+                target=handler.force_refresh, args=("test-service",)
+            )
+            forced.start()
+            time.sleep(0.05)
+            # Still waiting for the background refresh
+            assert len(calls) == 1
+            release.set()
+            forced.join(timeout=5)
+
+        assert len(calls) == 2
+        assert handler.cache["test-service"].aws_access_key_id == "KEY2"
+        handler.cleanup()
+
+
+def _startup_config() -> Config:
+    """Build a configuration of one service fetched at startup."""
+    return mock_config(("test-service",), source_credentials={"region": "us-east-1"})
+
+
+def _wait_until(condition) -> None:
+    """Wait for a condition set by a background thread."""
+    deadline = time.time() + 5
+    while not condition():
+        if time.time() > deadline:
+            raise AssertionError("Condition not met in time")
+        time.sleep(0.01)
+
+
+class TestExpirySkew:
+    """Test credentials expiring early by credentials.expiry_skew_seconds."""
+
+    def test_skewed_expiry_fetches_new_credentials(self):
+        """Test credentials within the skew of their expiry are not served."""
+        clock = FakeClock()
+        config = _startup_config()
+        config.credentials.refresh_buffer_seconds = 0
+        config.credentials.expiry_skew_seconds = 30
+        handler = CredentialsHandler(config, clock=clock)
+        expiration = datetime.fromtimestamp(clock.now() + 600, tz=timezone.utc)
+
+        with patch.object(
+            handler,
+            "_assume_role",
+            side_effect=[
+                {**_sts_credentials("FIRSTKEY", timedelta()), "Expiration": expiration},
+                _sts_credentials("SECONDKEY", timedelta(hours=1)),
+            ],
+        ) as mock_assume:
+            first = handler.get_credentials("test-service")
+            clock.advance(560)
+            assert handler.get_credentials("test-service") == first
+            clock.advance(20)
+            second = handler.get_credentials("test-service")
+
+        assert first["AccessKeyId"] == "FIRSTKEY"
+        # Sent with the expiration of STS, not the skewed one
+        assert first["Expiration"] == expiration.strftime("%Y-%m-%dT%H:%M:%SZ")
+        assert second["AccessKeyId"] == "SECONDKEY"
+        assert mock_assume.call_count == 2
+        handler.cleanup()
+
+
+class TestStartupFetches:
+    """Test fetching the credentials of every service at startup."""
+
+    def test_failing_service_fetched_again_until_obtained(self):
+        """Test failures at startup are retried until credentials are obtained."""
+        handler = CredentialsHandler(_startup_config())
+        responses = [
+            Exception("STS unavailable"),
+            Exception("STS unavailable"),
+            _sts_credentials("STARTKEY", timedelta(hours=1)),
+        ]
+
+        with (
+            patch("credproxy.credentials_handler.STARTUP_BACKOFF_BASE", 0.01),
+            patch.object(
+                handler, "_assume_role", side_effect=responses
+            ) as mock_assume,
+        ):
+            assert handler.readiness()[0] is False
+            handler.start_startup_fetches()
+            _wait_until(lambda: not handler._startup_thread.is_alive())
+
+        assert mock_assume.call_count == 3
+        assert handler.readiness()[0] is True
+        assert handler.get_credentials("test-service")["AccessKeyId"] == "STARTKEY"
+        handler.cleanup()
+
+    def test_miss_during_backoff_answered_with_retry_after(self):
+        """Test requests are answered 503 until the service is fetched again."""
+        app = init_app(_startup_config())
+        handler = app.config["credentials_handler"]
+
+        with (
+            app.test_client() as client,
+            patch("credproxy.credentials_handler.STARTUP_BACKOFF_BASE", 30),
+            patch.object(
+                handler, "_assume_role", side_effect=Exception("STS unavailable")
+            ) as mock_assume,
+        ):
+            handler.start_startup_fetches()
+            _wait_until(lambda: "test-service" in handler._starting)
+            response = client.get(
+                "/v1/credentials", headers={"Authorization": "test-service-token"}
+            )
+            ready = client.get("/readyz")
+
+        assert response.status_code == 503
+        assert response.get_json()["code"] == "ServiceUnavailable"
+        assert 1 <= int(response.headers["Retry-After"]) <= 30
+        assert ready.status_code == 503
+        # Answered without calling STS again
+        assert mock_assume.call_count == 1
+        handler.cleanup()
+
+    def test_gives_up_after_max_wait(self):
+        """Test on_give_up is called once services still fail after max_wait."""
+        handler = CredentialsHandler(_startup_config())
+        gave_up = threading.Event()
+
+        with (
+            patch("credproxy.credentials_handler.STARTUP_BACKOFF_BASE", 0.01),
+            patch.object(
+                handler, "_assume_role", side_effect=Exception("STS unavailable")
+            ),
+        ):
+            handler.start_startup_fetches(max_wait=0.1, on_give_up=gave_up.set)
+            assert gave_up.wait(timeout=5)
+
+        handler.cleanup()
+
+    def test_cached_services_not_fetched(self):
+        """Test services with cached credentials are not fetched at startup."""
+        handler = CredentialsHandler(_startup_config())
+        handler.cache["test-service"] = ServiceCredentialsManager(
+            aws_access_key_id="CACHEDKEY",
+            aws_secret_access_key="cachedsecret",
+            session_token="cachedtoken",
+            expiry=time.time() + 3600,
+        )
+
+        with patch.object(handler, "_assume_role") as mock_assume:
+            handler.start_startup_fetches()
+            _wait_until(lambda: not handler._startup_thread.is_alive())
+
+        mock_assume.assert_not_called()
+        handler.cleanup()
+
+
+class TestConcurrentMisses:
+    """Test concurrent misses of a cold cache share a single role assumption."""
+
+    def test_cold_cache_assumed_once(self):
+        """Test 50 concurrent requests of a service make a single STS call."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "cold-service": {
+                        "auth_token": "cold-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::123456789012:role/ColdRole"
+                        },
+                    }
+                }
+            }
+        )
+        barrier = threading.Barrier(50, timeout=5)
+        sts_client = MagicMock()
+
+        def slow_assume_role(**kwargs):
+            # Leaves the other requests the time to miss the cache too
+            time.sleep(0.2)
+            return {"Credentials": _sts_credentials("COLDKEY", timedelta(hours=1))}
+
+        sts_client.assume_role.side_effect = slow_assume_role
+        results = []
+
+        def request():
+            barrier.wait()
+            results.append(handler.get_credentials("cold-service"))
+
+        with patch(
+            "credproxy.credentials_handler.boto3.client", return_value=sts_client
+        ):
+            handler = CredentialsHandler(config)
+            threads = [threading.Thread(target=request) for _ in range(50)]
+            for thread in threads:
+                thread.start()
+            for thread in threads:
+                thread.join(timeout=5)
+
+        assert sts_client.assume_role.call_count == 1
+        assert len(results) == 50
+        assert {result["AccessKeyId"] for result in results} == {"COLDKEY"}
+        handler.cleanup()
+
+
+def _chained_config(role_b_external_id: str | None = None) -> Config:
+    """Create a configuration chaining RoleA into RoleB."""
+    assumed_role = {"RoleArn": "arn:aws:iam::222222222222:role/RoleB"}
+    if role_b_external_id:
+        assumed_role["ExternalId"] = role_b_external_id
+    return mock_config(
+        ("chained-service",),
+        assumed_role=assumed_role,
+        role_chain=[
+            {
+                "RoleArn": "arn:aws:iam::111111111111:role/RoleA",
+                "ExternalId": "hop-one-id",
+            }
+        ],
+    )
+
+
+class TestRoleChaining:
+    """Test assuming roles through a role_chain."""
+
+    def test_chain_feeds_credentials_to_next_hop(self):
+        """Test each hop assumes the next role with the previous credentials."""
+        handler = CredentialsHandler(_chained_config("hop-two-id"))
+
+        with patch("boto3.client") as mock_client:
+            mock_sts_client = mock_client.return_value
+            mock_sts_client.assume_role.side_effect = [
+                {"Credentials": _sts_credentials("ROLEAKEY", timedelta(hours=1))},
+                {"Credentials": _sts_credentials("ROLEBKEY", timedelta(hours=1))},
+            ]
+
+            result = handler.get_credentials("chained-service")
+
+        assert result["AccessKeyId"] == "ROLEBKEY"
+        assert result["RoleArn"] == "arn:aws:iam::222222222222:role/RoleB"
+
+        first_hop, second_hop = mock_sts_client.assume_role.call_args_list
+        assert first_hop.kwargs["RoleArn"] == "arn:aws:iam::111111111111:role/RoleA"
+        assert first_hop.kwargs["ExternalId"] == "hop-one-id"
+        assert second_hop.kwargs["RoleArn"] == "arn:aws:iam::222222222222:role/RoleB"
+        assert second_hop.kwargs["ExternalId"] == "hop-two-id"
+
+        # The second STS client is built from the first hop credentials
+        client_kwargs = mock_client.call_args_list[1].kwargs
+        assert client_kwargs.pop("config").retries == NO_CLIENT_RETRIES
+        assert client_kwargs == {
+            "region_name": "us-west-2",
+            "aws_access_key_id": "ROLEAKEY",
+            "aws_secret_access_key": "ROLEAKEY-secret",
+            "aws_session_token": "ROLEAKEY-token",
+        }
+        handler.cleanup()
+
+    def test_sts_endpoint_used_by_every_hop(self):
+        """Test the configured STS endpoint is used for every hop of the chain."""
+        config = _chained_config()
+        config.services[
+            "chained-service"
+        ].source_credentials.sts_endpoint = "https://vpce.sts.us-west-2.amazonaws.com"
+        handler = CredentialsHandler(config)
+
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.side_effect = [
+                {"Credentials": _sts_credentials("ROLEAKEY", timedelta(hours=1))},
+                {"Credentials": _sts_credentials("ROLEBKEY", timedelta(hours=1))},
+            ]
+
+            handler.get_credentials("chained-service")
+
+        assert [call.kwargs["endpoint_url"] for call in mock_client.call_args_list] == [
+            "https://vpce.sts.us-west-2.amazonaws.com",
+            "https://vpce.sts.us-west-2.amazonaws.com",
+        ]
+        handler.cleanup()
+
+    def test_chained_duration_clamped(self):
+        """Test chained hops request at most the role chaining maximum duration."""
+        config = _chained_config()
+        service = config.services["chained-service"]
+        for role_config in [*service.role_chain, service.assumed_role]:
+            role_config.DurationSeconds = 43200
+        handler = CredentialsHandler(config)
+
+        with patch("boto3.client") as mock_client:
+            mock_sts_client = mock_client.return_value
+            mock_sts_client.assume_role.side_effect = [
+                {"Credentials": _sts_credentials("ROLEAKEY", timedelta(hours=12))},
+                {"Credentials": _sts_credentials("ROLEBKEY", timedelta(hours=1))},
+            ]
+
+            handler.get_credentials("chained-service")
+
+        assert [
+            call.kwargs["DurationSeconds"]
+            for call in mock_sts_client.assume_role.call_args_list
+        ] == [43200, 3600]
+        handler.cleanup()
+
+    def test_regional_sts_endpoints_by_default(self):
+        """Test regional STS endpoints are used unless set otherwise."""
+        with patch.dict("os.environ", clear=True):
+            CredentialsHandler(_chained_config()).cleanup()
+            assert os.environ["AWS_STS_REGIONAL_ENDPOINTS"] == "regional"
+
+        with patch.dict("os.environ", {"AWS_STS_REGIONAL_ENDPOINTS": "legacy"}):
+            CredentialsHandler(_chained_config()).cleanup()
+            assert os.environ["AWS_STS_REGIONAL_ENDPOINTS"] == "legacy"
+
+    def test_chain_fails_atomically(self):
+        """Test a failing hop fails the chain and caches nothing."""
+        handler = CredentialsHandler(_chained_config())
+
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.side_effect = [
+                {"Credentials": _sts_credentials("ROLEAKEY", timedelta(hours=1))},
+                ClientError(
+                    {"Error": {"Code": "AccessDenied", "Message": "Not authorized"}},
+                    "AssumeRole",
+                ),
+            ]
+
+            with pytest.raises(ClientError):
+                handler.get_credentials("chained-service")
+
+        assert "chained-service" not in handler.cache
+        handler.cleanup()
+
+    def test_cache_keyed_on_full_chain(self):
+        """Test cached credentials are discarded when the chain changes."""
+        config = _chained_config()
+        handler = CredentialsHandler(config)
+
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.side_effect = lambda **kwargs: {
+                "Credentials": _sts_credentials(
+                    kwargs["RoleArn"].rsplit("/", 1)[-1].upper(), timedelta(hours=1)
+                )
+            }
+
+            handler.get_credentials("chained-service")
+            handler.get_credentials("chained-service")
+            assert mock_client.return_value.assume_role.call_count == 2
+
+            config.services["chained-service"].role_chain[0].ExternalId = "rotated"
+            result = handler.get_credentials("chained-service")
+
+        assert result["AccessKeyId"] == "ROLEB"
+        assert mock_client.return_value.assume_role.call_count == 4
+        handler.cleanup()
+
+    def test_cache_keyed_on_session_tags(self):
+        """Test differently tagged sessions do not share cached credentials."""
+        config = _chained_config()
+        handler = CredentialsHandler(config)
+        assumed_role = config.services["chained-service"].assumed_role
+
+        assumed_role.Tags = [{"Key": "team", "Value": "platform"}]
+        tagged_key = handler._cache_key(config.services["chained-service"])
+        assumed_role.Tags = [{"Key": "team", "Value": "data"}]
+
+        assert handler._cache_key(config.services["chained-service"]) != tagged_key
+        handler.cleanup()
+
+    def test_session_tags_passed_to_assume_role(self):
+        """Test Tags and TransitiveTagKeys are sent to STS AssumeRole."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "tagged-service": {
+                        "auth_token": "tagged-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::123456789012:role/TaggedRole",
+                            "Tags": {"team": "platform", "env": "prod"},
+                            "TransitiveTagKeys": ["team"],
+                        },
+                    }
+                }
+            }
+        )
+        handler = CredentialsHandler(config)
+
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.return_value = {
+                "Credentials": _sts_credentials("TAGGEDKEY", timedelta(hours=1))
+            }
+            handler.get_credentials("tagged-service")
+
+        call_kwargs = mock_client.return_value.assume_role.call_args.kwargs
+        assert call_kwargs["Tags"] == [
+            {"Key": "team", "Value": "platform"},
+            {"Key": "env", "Value": "prod"},
+        ]
+        assert call_kwargs["TransitiveTagKeys"] == ["team"]
+        handler.cleanup()
+
+    def test_cache_keyed_on_source_identity(self):
+        """Test sessions of different source identities are cached separately."""
+        config = _chained_config()
+        handler = CredentialsHandler(config)
+        assumed_role = config.services["chained-service"].assumed_role
+
+        assumed_role.SourceIdentity = "jane.doe"
+        jane_key = handler._cache_key(config.services["chained-service"])
+        assumed_role.SourceIdentity = "john.doe"
+
+        assert handler._cache_key(config.services["chained-service"]) != jane_key
+        handler.cleanup()
+
+    def test_external_id_and_source_identity_passed_to_every_hop(self):
+        """Test ExternalId and SourceIdentity of each hop are sent to STS."""
+        config = _chained_config("hop-two-id")
+        service = config.services["chained-service"]
+        service.role_chain[0].SourceIdentity = "jane.doe@example.com"
+        service.assumed_role.SourceIdentity = "jane.doe@example.com"
+        handler = CredentialsHandler(config)
+
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.side_effect = [
+                {"Credentials": _sts_credentials("ROLEAKEY", timedelta(hours=1))},
+                {"Credentials": _sts_credentials("ROLEBKEY", timedelta(hours=1))},
+            ]
+            handler.get_credentials("chained-service")
+
+        hops = [
+            (call.kwargs["ExternalId"], call.kwargs["SourceIdentity"])
+            for call in mock_client.return_value.assume_role.call_args_list
+        ]
+        assert hops == [
+            ("hop-one-id", "jane.doe@example.com"),
+            ("hop-two-id", "jane.doe@example.com"),
+        ]
+        handler.cleanup()
+
+
+def _throttling() -> Exception:
+    """Build the STS error of a throttled request."""
+    return ClientError(
+        {"Error": {"Code": "Throttling", "Message": "Rate exceeded"}}, "AssumeRole"
+    )
+
+
+class TestStsRetries:
+    """Test retrying STS calls failing for transient reasons."""
+
+    def test_throttled_hop_retried(self):
+        """Test only the throttled hop of a chain is called again."""
+        handler = CredentialsHandler(_chained_config())
+
+        with (
+            patch("boto3.client") as mock_client,
+            patch("credproxy.retry.time.sleep") as mock_sleep,
+        ):
+            mock_client.return_value.assume_role.side_effect = [
+                {"Credentials": _sts_credentials("ROLEAKEY", timedelta(hours=1))},
+                _throttling(),
+                {"Credentials": _sts_credentials("ROLEBKEY", timedelta(hours=1))},
+            ]
+
+            result = handler.get_credentials("chained-service")
+
+        assert result["AccessKeyId"] == "ROLEBKEY"
+        role_arns = [
+            call.kwargs["RoleArn"]
+            for call in mock_client.return_value.assume_role.call_args_list
+        ]
+        assert role_arns == [
+            "arn:aws:iam::111111111111:role/RoleA",
+            "arn:aws:iam::222222222222:role/RoleB",
+            "arn:aws:iam::222222222222:role/RoleB",
+        ]
+        mock_sleep.assert_called_once()
+        handler.cleanup()
+
+    def test_attempts_limited_by_sts_max_attempts(self):
+        """Test STS is called at most credentials.sts_max_attempts times."""
+        config = _chained_config()
+        config.credentials.sts_max_attempts = 2
+        handler = CredentialsHandler(config)
+
+        with (
+            patch("boto3.client") as mock_client,
+            patch("credproxy.retry.time.sleep"),
+        ):
+            mock_client.return_value.assume_role.side_effect = _throttling()
+
+            with pytest.raises(ClientError):
+                handler.get_credentials("chained-service")
+
+        assert mock_client.return_value.assume_role.call_count == 2
+        handler.cleanup()
+
+
+def _mfa_config() -> Config:
+    """Create a configuration for a role requiring MFA."""
+    return mock_config(
+        ("mfa-service",),
+        assumed_role={"SerialNumber": "arn:aws:iam::123456789012:mfa/operator"},
+    )
+
+
+def _access_denied() -> Exception:
+    """Build the ClientError STS raises for an invalid MFA code."""
+    return ClientError(
+        {"Error": {"Code": "AccessDenied", "Message": "MultiFactorAuthentication"}},
+        "AssumeRole",
+    )
+
+
+class TestMFA:
+    """Test role assumption with MFA token codes."""
+
+    def test_token_code_passed_to_assume_role(self):
+        """Test SerialNumber and the provided TokenCode are sent to STS."""
+        mfa_provider = MagicMock()
+        mfa_provider.token_code.return_value = "123456"
+        handler = CredentialsHandler(_mfa_config(), mfa_provider=mfa_provider)
+
+        with patch("boto3.client") as mock_client:
+            mock_sts_client = mock_client.return_value
+            mock_sts_client.assume_role.return_value = {
+                "Credentials": _sts_credentials("MFAKEY", timedelta(hours=1))
+            }
+
+            handler.get_credentials("mfa-service")
+            handler.get_credentials("mfa-service")
+
+        # The session is cached, the operator is only prompted once
+        mfa_provider.token_code.assert_called_once_with(
+            "arn:aws:iam::123456789012:mfa/operator"
+        )
+        call_kwargs = mock_sts_client.assume_role.call_args.kwargs
+        assert call_kwargs["SerialNumber"] == "arn:aws:iam::123456789012:mfa/operator"
+        assert call_kwargs["TokenCode"] == "123456"
+        handler.cleanup()
+
+    def test_invalid_code_prompts_again(self):
+        """Test an AccessDenied for a bad code re-prompts the operator."""
+        mfa_provider = MagicMock()
+        mfa_provider.token_code.side_effect = ["000000", "123456"]
+        handler = CredentialsHandler(_mfa_config(), mfa_provider=mfa_provider)
+
+        with patch("boto3.client") as mock_client:
+            mock_sts_client = mock_client.return_value
+            mock_sts_client.assume_role.side_effect = [
+                _access_denied(),
+                {"Credentials": _sts_credentials("MFAKEY", timedelta(hours=1))},
+            ]
+
+            result = handler.get_credentials("mfa-service")
+
+        assert result["AccessKeyId"] == "MFAKEY"
+        assert mfa_provider.token_code.call_count == 2
+        assert mock_sts_client.assume_role.call_args.kwargs["TokenCode"] == "123456"
+        handler.cleanup()
+
+    def test_access_denied_raised_after_max_attempts(self):
+        """Test the STS error is raised once all attempts are used."""
+        mfa_provider = MagicMock()
+        mfa_provider.token_code.return_value = "000000"
+        handler = CredentialsHandler(_mfa_config(), mfa_provider=mfa_provider)
+
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.side_effect = _access_denied()
+
+            with pytest.raises(ClientError):
+                handler.get_credentials("mfa-service")
+
+        assert mfa_provider.token_code.call_count == MFA_MAX_ATTEMPTS
+        assert "mfa-service" not in handler.cache
+        handler.cleanup()
+
+    def test_static_token_code_not_prompted(self):
+        """Test a configured TokenCode is used without prompting."""
+        config = _mfa_config()
+        config.services["mfa-service"].assumed_role.TokenCode = "654321"
+        mfa_provider = MagicMock()
+        handler = CredentialsHandler(config, mfa_provider=mfa_provider)
+
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.return_value = {
+                "Credentials": _sts_credentials("MFAKEY", timedelta(hours=1))
+            }
+            handler.get_credentials("mfa-service")
+
+        mfa_provider.token_code.assert_not_called()
+        handler.cleanup()
+
+    def test_mfa_services_not_refreshed_ahead(self):
+        """Test services needing an MFA prompt are not refreshed early."""
+        mfa_provider = MagicMock()
+        handler = CredentialsHandler(_mfa_config(), mfa_provider=mfa_provider)
+        handler.cache["mfa-service"] = ServiceCredentialsManager(
+            aws_access_key_id="CACHEDKEY",
+            aws_secret_access_key="cachedsecret",
+            session_token="cachedtoken",
+            expiry=time.time() + 60,
+        )
+
+        with patch.object(handler, "_schedule_refresh") as mock_schedule:
+            result = handler.get_credentials("mfa-service")
+
+        assert result["AccessKeyId"] == "CACHEDKEY"
+        mock_schedule.assert_not_called()
+        mfa_provider.token_code.assert_not_called()
+        handler.cleanup()
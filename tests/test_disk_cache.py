@@ -0,0 +1,162 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the encrypted on-disk credentials cache."""
+
+from __future__ import annotations
+
+import os
+import sys
+import time
+from datetime import datetime, timezone, timedelta
+from unittest.mock import patch
+
+from tests.mock_aws import mock_config
+from credproxy.disk_cache import DiskCredentialsCache, create_disk_cache
+from credproxy.credentials_handler import CredentialsHandler
+
+
+def _credentials(expires_in: float = 3600) -> dict:
+    return {
+        "aws_access_key_id": "ASIADISKCACHEKEY",
+        "aws_secret_access_key": "disk-cache-secret",
+        "session_token": "disk-cache-session-token",
+        "expiry": time.time() + expires_in,
+        "role_arn": "arn:aws:iam::123456789012:role/MyAppRole",
+    }
+
+
+class TestDiskCredentialsCache:
+    """Test storing and loading encrypted credentials."""
+
+    def test_stored_credentials_loaded(self, tmp_path):
+        """Test credentials are loaded back, by another instance too."""
+        DiskCredentialsCache(str(tmp_path)).store("my-app", "key", _credentials())
+
+        loaded = DiskCredentialsCache(str(tmp_path)).load("my-app", "key")
+
+        assert loaded["aws_access_key_id"] == "ASIADISKCACHEKEY"
+        assert DiskCredentialsCache(str(tmp_path)).load("my-app", "other") is None
+        assert DiskCredentialsCache(str(tmp_path)).load("other-app", "key") is None
+
+    def test_entries_encrypted_and_private(self, tmp_path):
+        """Test entries are owner-only and do not contain the credentials."""
+        DiskCredentialsCache(str(tmp_path)).store("my-app", "key", _credentials())
+
+        (entry,) = tmp_path.glob("*.cache")
+        assert b"disk-cache-secret" not in entry.read_bytes()
+        if sys.platform != "win32":
+            assert entry.stat().st_mode & 0o777 == 0o600
+            assert (tmp_path / "cache.key").stat().st_mode & 0o777 == 0o600
+        # No temporary file is left behind
+        assert {path.name for path in tmp_path.iterdir()} == {"cache.key", entry.name}
+
+    def test_previous_entries_replaced(self, tmp_path):
+        """Test storing credentials removes the entries of older cache keys."""
+        cache = DiskCredentialsCache(str(tmp_path))
+        cache.store("my-app", "old-key", _credentials())
+        cache.store("other-app", "key", _credentials())
+        cache.store("my-app", "new-key", _credentials())
+
+        assert cache.load("my-app", "old-key") is None
+        assert cache.load("my-app", "new-key") is not None
+        assert cache.load("other-app", "key") is not None
+
+    def test_altered_and_expired_entries_ignored(self, tmp_path):
+        """Test corrupted and expired entries are ignored."""
+        cache = DiskCredentialsCache(str(tmp_path))
+        cache.store("my-app", "key", _credentials())
+        (entry,) = tmp_path.glob("*.cache")
+        data = bytearray(entry.read_bytes())
+        data[20] ^= 0xFF
+        entry.write_bytes(bytes(data))
+        assert cache.load("my-app", "key") is None
+
+        entry.write_bytes(b"truncated")
+        assert cache.load("my-app", "key") is None
+
+        cache.store("my-app", "key", _credentials(expires_in=-1))
+        assert cache.load("my-app", "key") is None
+
+    def test_key_file_outside_directory(self, tmp_path):
+        """Test a key file set elsewhere is used, entries needing that key."""
+        cache_dir = str(tmp_path / "cache")
+        key_file = tmp_path / "secrets" / "cache.key"
+        with patch("credproxy.disk_cache.LOG") as mock_log:
+            cache = DiskCredentialsCache(cache_dir, str(key_file))
+        cache.store("my-app", "key", _credentials())
+
+        mock_log.warning.assert_not_called()
+        assert key_file.stat().st_size == 32
+        assert not (tmp_path / "cache" / "cache.key").exists()
+        assert DiskCredentialsCache(cache_dir, str(key_file)).load("my-app", "key")
+        assert DiskCredentialsCache(cache_dir).load("my-app", "key") is None
+
+    def test_key_file_in_directory_warned(self, tmp_path):
+        """Test a warning is logged when the key file is in the cache directory."""
+        with patch("credproxy.disk_cache.LOG") as mock_log:
+            DiskCredentialsCache(str(tmp_path))
+
+        mock_log.warning.assert_called_once()
+
+    def test_cryptography_missing(self, tmp_path):
+        """Test the disk cache is disabled without the cryptography package."""
+        with patch("credproxy.disk_cache.AESGCM", None):
+            assert create_disk_cache(str(tmp_path)) is None
+        assert not (tmp_path / "cache.key").exists()
+
+    def test_unusable_directory_disabled(self, tmp_path):
+        """Test the disk cache is disabled if its directory cannot be used."""
+        not_a_directory = tmp_path / "file"
+        not_a_directory.write_text("")
+
+        assert create_disk_cache(None) is None
+        assert create_disk_cache(str(not_a_directory)) is None
+
+
+class TestHandlerDiskCache:
+    """Test the credentials handler reusing credentials after a restart."""
+
+    def test_credentials_reused_after_restart(self, tmp_path):
+        """Test credentials obtained before a restart are served without STS."""
+        handler = CredentialsHandler(
+            mock_config(), disk_cache=DiskCredentialsCache(str(tmp_path))
+        )
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.return_value = {
+                "Credentials": {
+                    "AccessKeyId": "ASIABEFORERESTART",
+                    "SecretAccessKey": "before-restart-secret",
+                    "SessionToken": "before-restart-token",
+                    "Expiration": datetime.now(timezone.utc) + timedelta(hours=1),
+                }
+            }
+            handler.get_credentials("my-app")
+        handler.cleanup()
+
+        restarted = CredentialsHandler(
+            mock_config(), disk_cache=DiskCredentialsCache(str(tmp_path))
+        )
+        with patch("boto3.client") as mock_client:
+            result = restarted.get_credentials("my-app")
+
+        assert result["AccessKeyId"] == "ASIABEFORERESTART"
+        mock_client.assert_not_called()
+        assert restarted.readiness()[0] is True
+        restarted.cleanup()
+
+    def test_discarded_credentials_removed_from_disk(self, tmp_path):
+        """Test discarding credentials also removes them from the disk cache."""
+        disk_cache = DiskCredentialsCache(str(tmp_path))
+        config = mock_config()
+        disk_cache.store(
+            "my-app",
+            CredentialsHandler._cache_key(config.services["my-app"]),
+            _credentials(),
+        )
+        handler = CredentialsHandler(config, disk_cache=disk_cache)
+
+        handler.discard_credentials("my-app")
+
+        assert os.listdir(tmp_path) == ["cache.key"]
+        handler.cleanup()
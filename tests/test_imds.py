@@ -0,0 +1,391 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for IMDS emulation endpoints and session tokens."""
+
+from __future__ import annotations
+
+import threading
+from datetime import datetime, timezone
+from unittest.mock import patch
+
+from botocore.exceptions import ClientError
+
+from credproxy import __version__
+from credproxy.app import init_app
+from credproxy.imds import (
+    IMDS_TOKEN_HEADER,
+    IMDS_TOKEN_TTL_HEADER,
+    IMDSTokenStore,
+    role_name_from_arn,
+)
+from tests.mock_aws import mock_config
+from credproxy.config import Config
+from credproxy.version import VERSION_HEADER
+
+
+def _imds_config(mode: str = "v2-optional", identity: dict | None = None) -> Config:
+    """Create a configuration with IMDS emulation enabled."""
+    imds = {"enabled": True, "mode": mode, "service": "imds-service"}
+    if identity:
+        imds["identity"] = identity
+    return mock_config(
+        ("imds-service",),
+        source_credentials={"region": "us-east-1"},
+        # Listed by its name, without its path
+        assumed_role={"RoleArn": "arn:aws:iam::123456789012:role/path/ImdsRole"},
+        imds=imds,
+    )
+
+
+class TestIMDSTokenStore:
+    """Test IMDSv2 session token storage."""
+
+    def test_issue_and_validate(self):
+        """Test an issued token is valid."""
+        store = IMDSTokenStore()
+        token = store.issue(60)
+
+        assert store.is_valid(token) is True
+        assert store.is_valid("unknown-token") is False
+
+    def test_token_expiry_enforced(self):
+        """Test tokens are rejected once their TTL has elapsed."""
+        store = IMDSTokenStore()
+        with patch("credproxy.imds.time.time", return_value=1000.0):
+            token = store.issue(10)
+        with patch("credproxy.imds.time.time", return_value=1009.0):
+            assert store.is_valid(token) is True
+        with patch("credproxy.imds.time.time", return_value=1010.0):
+            assert store.is_valid(token) is False
+        assert len(store) == 0
+
+    def test_expired_tokens_purged_on_issue(self):
+        """Test expired tokens are dropped when issuing new ones."""
+        store = IMDSTokenStore()
+        with patch("credproxy.imds.time.time", return_value=1000.0):
+            store.issue(1)
+        with patch("credproxy.imds.time.time", return_value=2000.0):
+            store.issue(1)
+        assert len(store) == 1
+
+    def test_concurrent_issue(self):
+        """Test tokens issued concurrently are all unique and valid."""
+        store = IMDSTokenStore()
+        tokens: list[str] = []
+        lock = threading.Lock()
+
+        def issue_tokens():
+            for _ in range(50):
+                token = store.issue(60)
+                with lock:
+                    tokens.append(token)
+
+        threads = [threading.Thread(target=issue_tokens) for _ in range(8)]
+        for thread in threads:
+            thread.start()
+        for thread in threads:
+            thread.join()
+
+        assert len(set(tokens)) == 400
+        assert len(store) == 400
+        assert all(store.is_valid(token) for token in tokens)
+
+
+class TestIMDSRoutes:
+    """Test IMDS HTTP endpoints."""
+
+    def test_imds_routes_disabled_by_default(self):
+        """Test IMDS routes are not registered unless enabled."""
+        app = init_app(Config())
+
+        with app.test_client() as client:
+            response = client.put(
+                "/latest/api/token", headers={IMDS_TOKEN_TTL_HEADER: "60"}
+            )
+            assert response.status_code in (404, 405)
+
+    def test_put_token_returns_token_and_ttl(self):
+        """Test PUT /latest/api/token returns a session token."""
+        app = init_app(_imds_config())
+
+        with app.test_client() as client:
+            response = client.put(
+                "/latest/api/token", headers={IMDS_TOKEN_TTL_HEADER: "21600"}
+            )
+            assert response.status_code == 200
+            assert response.get_data(as_text=True)
+            assert response.headers[IMDS_TOKEN_TTL_HEADER] == "21600"
+
+    def test_put_token_requires_valid_ttl(self):
+        """Test missing or out of range TTL headers are rejected."""
+        app = init_app(_imds_config())
+
+        with app.test_client() as client:
+            assert client.put("/latest/api/token").status_code == 400
+            for ttl in ("0", "21601", "abc"):
+                response = client.put(
+                    "/latest/api/token", headers={IMDS_TOKEN_TTL_HEADER: ttl}
+                )
+                assert response.status_code == 400
+
+    def test_put_token_rejects_forwarded_requests(self):
+        """Test token requests relayed through a proxy are refused."""
+        app = init_app(_imds_config())
+
+        with app.test_client() as client:
+            response = client.put(
+                "/latest/api/token",
+                headers={IMDS_TOKEN_TTL_HEADER: "60", "X-Forwarded-For": "10.0.0.1"},
+            )
+            assert response.status_code == 403
+
+    def test_list_credentials_with_token(self):
+        """Test the role listing is served with a valid token."""
+        app = init_app(_imds_config("v2-required"))
+
+        with app.test_client() as client:
+            token = client.put(
+                "/latest/api/token", headers={IMDS_TOKEN_TTL_HEADER: "60"}
+            ).get_data(as_text=True)
+            response = client.get(
+                "/latest/meta-data/iam/security-credentials/",
+                headers={IMDS_TOKEN_HEADER: token},
+            )
+            assert response.status_code == 200
+            assert response.get_data(as_text=True) == "ImdsRole"
+            assert response.headers[VERSION_HEADER] == __version__
+
+    def test_v2_required_rejects_missing_token(self):
+        """Test v2-required mode rejects requests without a token."""
+        app = init_app(_imds_config("v2-required"))
+
+        with app.test_client() as client:
+            response = client.get("/latest/meta-data/iam/security-credentials/")
+            assert response.status_code == 401
+
+    def test_v2_optional_accepts_missing_token(self):
+        """Test v2-optional mode serves requests without a token."""
+        app = init_app(_imds_config("v2-optional"))
+
+        with app.test_client() as client:
+            response = client.get("/latest/meta-data/iam/security-credentials/")
+            assert response.status_code == 200
+
+    def test_invalid_token_rejected_in_optional_mode(self):
+        """Test a provided but invalid token is rejected even in v2-optional."""
+        app = init_app(_imds_config("v2-optional"))
+
+        with app.test_client() as client:
+            response = client.get(
+                "/latest/meta-data/iam/security-credentials/",
+                headers={IMDS_TOKEN_HEADER: "not-a-real-token"},
+            )
+            assert response.status_code == 401
+
+    def test_expired_token_rejected(self):
+        """Test an expired token is rejected."""
+        app = init_app(_imds_config("v2-required"))
+
+        with app.test_client() as client:
+            with patch("credproxy.imds.time.time", return_value=1000.0):
+                token = client.put(
+                    "/latest/api/token", headers={IMDS_TOKEN_TTL_HEADER: "5"}
+                ).get_data(as_text=True)
+            with patch("credproxy.imds.time.time", return_value=1006.0):
+                response = client.get(
+                    "/latest/meta-data/iam/security-credentials/",
+                    headers={IMDS_TOKEN_HEADER: token},
+                )
+            assert response.status_code == 401
+
+    def test_unknown_imds_service(self):
+        """Test listing returns 404 when the IMDS service does not exist."""
+        config = _imds_config()
+        config.imds.service = "missing-service"
+        app = init_app(config)
+
+        with app.test_client() as client:
+            response = client.get("/latest/meta-data/iam/security-credentials/")
+            assert response.status_code == 404
+
+
+class TestIMDSCredentials:
+    """Test the role listing and credentials detail steps of IMDS clients."""
+
+    CREDENTIALS = {
+        "AccessKeyId": "ASIAIMDSKEY",
+        "SecretAccessKey": "imds-secret",
+        "SessionToken": "imds-session-token",
+        "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+    }
+
+    def test_listed_role_credentials(self):
+        """Test the role listed is served in the IMDS credentials format."""
+        app = init_app(_imds_config("v2-required"))
+        handler = app.config["credentials_handler"]
+
+        with (
+            app.test_client() as client,
+            patch.object(handler, "_assume_role", return_value=self.CREDENTIALS),
+        ):
+            headers = {
+                IMDS_TOKEN_HEADER: client.put(
+                    "/latest/api/token", headers={IMDS_TOKEN_TTL_HEADER: "60"}
+                ).get_data(as_text=True)
+            }
+            role_name = client.get(
+                "/latest/meta-data/iam/security-credentials/", headers=headers
+            ).get_data(as_text=True)
+            response = client.get(
+                f"/latest/meta-data/iam/security-credentials/{role_name}",
+                headers=headers,
+            )
+
+        obtained_at = datetime.fromtimestamp(
+            handler.cache["imds-service"].obtained_at, tz=timezone.utc
+        )
+        assert response.status_code == 200
+        assert response.get_json() == {
+            "Code": "Success",
+            "LastUpdated": obtained_at.strftime("%Y-%m-%dT%H:%M:%SZ"),
+            "Type": "AWS-HMAC",
+            "AccessKeyId": "ASIAIMDSKEY",
+            "SecretAccessKey": "imds-secret",
+            "Token": "imds-session-token",
+            "Expiration": "2099-01-01T00:00:00Z",
+        }
+
+    def test_unlisted_role_not_found(self):
+        """Test roles other than the one listed are not found."""
+        app = init_app(_imds_config())
+        handler = app.config["credentials_handler"]
+
+        with (
+            app.test_client() as client,
+            patch.object(handler, "_assume_role") as mock_assume,
+        ):
+            other = client.get("/latest/meta-data/iam/security-credentials/Other")
+            # Role names are case sensitive, as in IAM
+            case = client.get("/latest/meta-data/iam/security-credentials/imdsrole")
+
+        assert other.status_code == 404
+        assert case.status_code == 404
+        mock_assume.assert_not_called()
+
+    def test_sts_error_in_imds_format(self):
+        """Test failed role assumptions answer the STS error code."""
+        app = init_app(_imds_config())
+        handler = app.config["credentials_handler"]
+        denied = ClientError(
+            {"Error": {"Code": "AccessDenied", "Message": "Not authorized"}},
+            "AssumeRole",
+        )
+
+        with (
+            app.test_client() as client,
+            patch.object(handler, "_assume_role", side_effect=denied),
+        ):
+            response = client.get(
+                "/latest/meta-data/iam/security-credentials/ImdsRole"
+            )
+
+        assert response.status_code == 403
+        assert response.get_json()["Code"] == "AccessDenied"
+        assert response.get_json()["Message"] == "Not authorized"
+
+
+class TestIMDSIdentity:
+    """Test the instance identity document and placement paths."""
+
+    def test_identity_defaults_to_imds_service(self):
+        """Test the region and account are those of the IMDS service."""
+        app = init_app(_imds_config())
+
+        with app.test_client() as client:
+            document = client.get("/latest/dynamic/instance-identity/document")
+            region = client.get("/latest/meta-data/placement/region")
+            zone = client.get("/latest/meta-data/placement/availability-zone/")
+
+        assert document.get_json() == {
+            "accountId": "123456789012",
+            "architecture": "x86_64",
+            "availabilityZone": "us-east-1a",
+            "imageId": "ami-00000000000000000",
+            "instanceId": "i-00000000000000000",
+            "instanceType": "t3.micro",
+            "privateIp": "127.0.0.1",
+            "region": "us-east-1",
+            "version": "2017-09-30",
+        }
+        assert region.get_data(as_text=True) == "us-east-1"
+        assert zone.get_data(as_text=True) == "us-east-1a"
+
+    def test_configured_identity(self):
+        """Test configured fields replace the ones of the IMDS service."""
+        config = _imds_config(
+            identity={
+                "region": "eu-west-3",
+                "availability_zone": "eu-west-3c",
+                "account_id": "210987654321",
+                "architecture": "arm64",
+            }
+        )
+        app = init_app(config)
+
+        with app.test_client() as client:
+            document = client.get("/latest/dynamic/instance-identity/document")
+            zone = client.get("/latest/meta-data/placement/availability-zone")
+
+        assert document.get_json()["accountId"] == "210987654321"
+        assert document.get_json()["architecture"] == "arm64"
+        assert document.get_json()["availabilityZone"] == "eu-west-3c"
+        assert document.get_json()["region"] == "eu-west-3"
+        assert zone.get_data(as_text=True) == "eu-west-3c"
+
+    def test_identity_without_region_not_found(self):
+        """Test the paths answer 404 when no region is known."""
+        config = _imds_config()
+        config.services["imds-service"].source_credentials.region = None
+        app = init_app(config)
+
+        with app.test_client() as client:
+            assert client.get("/latest/meta-data/placement/region").status_code == 404
+            document = client.get("/latest/dynamic/instance-identity/document")
+            assert document.status_code == 404
+
+    def test_identity_requires_token_in_v2_required_mode(self):
+        """Test the identity paths enforce the session token like credentials."""
+        app = init_app(_imds_config("v2-required"))
+
+        with app.test_client() as client:
+            assert client.get("/latest/meta-data/placement/region").status_code == 401
+            token = client.put(
+                "/latest/api/token", headers={IMDS_TOKEN_TTL_HEADER: "60"}
+            ).get_data(as_text=True)
+            response = client.get(
+                "/latest/dynamic/instance-identity/document",
+                headers={IMDS_TOKEN_HEADER: token},
+            )
+            assert response.status_code == 200
+
+
+class TestIMDSHelpers:
+    """Test IMDS helper functions."""
+
+    def test_role_name_from_arn(self):
+        """Test role name extraction from ARNs with and without paths."""
+        assert role_name_from_arn("arn:aws:iam::123456789012:role/MyRole") == "MyRole"
+        assert (
+            role_name_from_arn("arn:aws:iam::123456789012:role/a/b/PathRole")
+            == "PathRole"
+        )
+
+    def test_imds_config_defaults(self):
+        """Test IMDS configuration defaults."""
+        config = Config()
+        assert config.imds.enabled is False
+        assert config.imds.mode == "v2-optional"
+        assert config.imds.service is None
+        assert config.imds.identity.region is None
+        assert config.imds.identity.instance_id == "i-00000000000000000"
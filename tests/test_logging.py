@@ -6,19 +6,27 @@
 
 from __future__ import annotations
 
+import io
 import json
 import logging as logthings
 from unittest.mock import Mock
 
+import pytest
+
 from credproxy.logger import (
+    LOG,
     HealthCheckFilter,
+    SimpleTextFormatter,
     SimpleJsonFormatter as ServiceAwareJsonFormatter,
     RequestContextFilter,
     WerkzeugAccessLogFilter,
     FlaskDevelopmentWarningFilter,
+    get_formatter,
     setup_logging,
+    set_log_format,
     setup_json_logging,
 )
+from credproxy.sanitizer import register_sensitive_value
 
 
 class TestServiceAwareJsonFormatter:
@@ -165,6 +173,117 @@ class TestServiceAwareJsonFormatter:
         assert parsed["exception"] == "Traceback: ValueError: test error"
 
 
+class TestSimpleTextFormatter:
+    """Test SimpleTextFormatter."""
+
+    def test_text_format(self):
+        """Test text lines carry the message and dotted context fields."""
+        formatter = SimpleTextFormatter()
+        record = logthings.LogRecord(
+            name="credproxy.routes",
+            level=logthings.WARNING,
+            pathname="test.py",
+            lineno=1,
+            msg="Serving %s",
+            args=("credentials",),
+            exc_info=None,
+        )
+        record.request = {"remote": "127.0.0.1", "request_id": "abc123"}
+        record.credentials = {"cache": "hit", "sts_duration": None}
+
+        formatted = formatter.format(record)
+
+        assert "WARNING credproxy: Serving credentials" in formatted
+        assert "request.remote=127.0.0.1" in formatted
+        assert "request.request_id=abc123" in formatted
+        assert "credentials.cache=hit" in formatted
+        assert "sts_duration" not in formatted
+        assert not formatted.startswith("{")
+
+    def test_credentials_context_in_json(self):
+        """Test credentials request details are included in JSON output."""
+        record = logthings.LogRecord(
+            name="credproxy",
+            level=logthings.INFO,
+            pathname="test.py",
+            lineno=1,
+            msg="Providing credentials for service",
+            args=(),
+            exc_info=None,
+        )
+        record.credentials = {"role": "arn:aws:iam::123456789012:role/R"}
+
+        parsed = json.loads(ServiceAwareJsonFormatter().format(record))
+
+        assert parsed["credentials"]["role"] == "arn:aws:iam::123456789012:role/R"
+
+    def test_set_log_format(self):
+        """Test switching the log format updates the credproxy handlers."""
+        try:
+            set_log_format("text")
+            assert isinstance(get_formatter(), SimpleTextFormatter)
+            assert all(
+                isinstance(handler.formatter, SimpleTextFormatter)
+                for handler in LOG.handlers
+            )
+        finally:
+            set_log_format("json")
+
+        assert not isinstance(get_formatter(), SimpleTextFormatter)
+        with pytest.raises(ValueError):
+            set_log_format("xml")
+
+
+class TestSecretRedaction:
+    """Test secrets never reach the log output, whatever the format or level."""
+
+    SECRET_KEY = "wJalrXUtnFEMI/K7MDENG/bPxRfiCYREDACTKEY"
+    SESSION_TOKEN = "IQoJb3JpZ2luX2VjEXAMPLESESSIONTOKENVALUE"
+
+    def _capture(self, formatter: logthings.Formatter) -> str:
+        """Log secrets in every part of a record and return the output."""
+        register_sensitive_value(self.SECRET_KEY)
+        register_sensitive_value(self.SESSION_TOKEN)
+        stream = io.StringIO()
+        handler = logthings.StreamHandler(stream)
+        handler.setFormatter(formatter)
+        previous_level = LOG.level
+        LOG.addHandler(handler)
+        LOG.setLevel(logthings.DEBUG)
+        try:
+            LOG.debug("Secret key is %s", self.SECRET_KEY)
+            LOG.debug(
+                "Fetched credentials",
+                extra={"credentials": {"SessionToken": self.SESSION_TOKEN}},
+            )
+            try:
+                raise ValueError(f"bad token {self.SESSION_TOKEN}")
+            except ValueError as error:
+                LOG.error("Failed")
+                LOG.exception(error)
+        finally:
+            LOG.removeHandler(handler)
+            LOG.setLevel(previous_level)
+        return stream.getvalue()
+
+    def test_json_output_redacted(self):
+        """Test registered secrets are redacted from JSON log lines."""
+        output = self._capture(ServiceAwareJsonFormatter())
+
+        assert self.SECRET_KEY not in output
+        assert self.SESSION_TOKEN not in output
+        assert "wJal****" in output
+        assert "IQoJ****" in output
+
+    def test_text_output_redacted(self):
+        """Test registered secrets are redacted from text log lines."""
+        output = self._capture(SimpleTextFormatter())
+
+        assert self.SECRET_KEY not in output
+        assert self.SESSION_TOKEN not in output
+        assert "IQoJ****" in output
+
+
 class TestRequestContextFilter:
     """Test RequestContextFilter."""
 
@@ -342,6 +461,8 @@ class TestHealthCheckFilterExtended:
             "GET /health HTTP/1.1 200 -",
             "HEAD /health HTTP/1.1 200 -",
             "GET /health HTTP/1.1 201 -",
+            "GET /healthz HTTP/1.1 200 -",
+            "GET /readyz HTTP/1.1 200 -",
         ]
 
         for pattern in success_patterns:
@@ -362,6 +483,7 @@ class TestHealthCheckFilterExtended:
             "HEAD /health HTTP/1.1 503 -",
             "GET /health HTTP/1.1 404 -",
             "GET /health HTTP/1.1 400 -",
+            "GET /readyz HTTP/1.1 503 -",
         ]
 
         for pattern in error_patterns:
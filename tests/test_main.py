@@ -6,15 +6,36 @@
 
 from __future__ import annotations
 
+import io
 import os
+import json
 import tempfile
-from unittest.mock import patch
+import threading
+import logging as logthings
+from datetime import datetime, timezone
+from unittest.mock import MagicMock, patch
 
 import yaml
 import pytest
+from botocore.exceptions import ClientError, EndpointConnectionError
+from botocore.credentials import ContainerProvider
 
-from credproxy.app import init_app
+from credproxy import __version__
+from credproxy.app import REQUEST_ID_HEADER, init_app
 from credproxy.config import Config
+from credproxy.logger import LOG, SimpleJsonFormatter
+from credproxy.version import VERSION_HEADER
+from credproxy.credentials_handler import ServiceCredentialsManager
+
+
+def _credentials(access_key: str, expiry: float = 1893456000.0):
+    """Build the cache entry of credentials served by a mocked handler."""
+    return ServiceCredentialsManager(
+        aws_access_key_id=access_key,
+        aws_secret_access_key=f"{access_key.lower()}secret",
+        session_token=f"{access_key.lower()}token",
+        expiry=expiry,
+    )
 
 
 class TestMainApp:
@@ -40,7 +61,7 @@ class TestMainApp:
 
         with app.test_client() as client:
             response = client.get("/v1/credentials")
-            assert response.status_code == 401  # Missing auth header
+            assert response.status_code == 403  # Missing auth header
 
     def test_credentials_endpoint_no_auth_token(self):
         """Test credentials endpoint without authorization token."""
@@ -49,7 +70,7 @@ class TestMainApp:
 
         with app.test_client() as client:
             response = client.get("/v1/credentials")
-            assert response.status_code == 401  # Missing auth header
+            assert response.status_code == 403  # Missing auth header
 
     def test_credentials_endpoint_invalid_token(self):
         """Test credentials endpoint with invalid authorization token."""
@@ -60,9 +81,9 @@ class TestMainApp:
             response = client.get(
                 "/v1/credentials", headers={"Authorization": "invalid-token"}
             )
-            assert response.status_code == 401  # Invalid token
+            assert response.status_code == 403  # Invalid token
 
-    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials")
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
     def test_credentials_endpoint_no_credentials_yet(self, mock_get_creds):
         """Test credentials endpoint when credentials not yet available."""
         config_data = {
@@ -105,7 +126,155 @@ class TestMainApp:
         finally:
             os.unlink(temp_file)
 
-    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials")
+    @pytest.mark.parametrize(
+        "code, status",
+        [
+            ("AccessDenied", 403),
+            ("InvalidIdentityToken", 403),
+            ("ValidationError", 400),
+            ("MalformedPolicyDocument", 400),
+            ("InternalFailure", 502),
+            ("Throttling", 502),
+        ],
+    )
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
+    def test_credentials_endpoint_sts_error(self, mock_get_creds, code, status):
+        """Test STS errors are returned to the client as AWS errors."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "test-service": {
+                        "auth_token": "valid-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::123456789012:role/TestRole"
+                        },
+                    }
+                }
+            }
+        )
+        app = init_app(config)
+        mock_get_creds.side_effect = ClientError(
+            {"Error": {"Code": code, "Message": "STS error message"}},
+            "AssumeRole",
+        )
+
+        with app.test_client() as client:
+            response = client.get(
+                "/v1/credentials", headers={"Authorization": "valid-token"}
+            )
+            assert response.status_code == status
+            assert response.get_json() == {"code": code, "message": "STS error message"}
+
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
+    def test_credentials_endpoint_sts_unreachable(self, mock_get_creds):
+        """Test STS network errors are answered with 502."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "test-service": {
+                        "auth_token": "valid-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::123456789012:role/TestRole"
+                        },
+                    }
+                }
+            }
+        )
+        app = init_app(config)
+        mock_get_creds.side_effect = EndpointConnectionError(
+            endpoint_url="https://sts.us-west-2.amazonaws.com"
+        )
+
+        with app.test_client() as client:
+            response = client.get(
+                "/v1/credentials", headers={"Authorization": "valid-token"}
+            )
+            assert response.status_code == 502
+            assert response.get_json()["code"] == "EndpointConnectionError"
+
+    def test_request_id_header(self):
+        """Test every response echoes a unique generated request ID."""
+        app = init_app(Config())
+
+        with app.test_client() as client:
+            first = client.get("/health").headers[REQUEST_ID_HEADER]
+            second = client.get("/v1/credentials").headers[REQUEST_ID_HEADER]
+
+        assert len(first) == 32
+        assert first != second
+
+    def test_credentials_request_logged(self):
+        """Test credential requests log cache, STS latency and no secrets."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "test-service": {
+                        "auth_token": "logged-service-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::123456789012:role/LoggedRole"
+                        },
+                    }
+                }
+            }
+        )
+        app = init_app(config)
+        credentials = {
+            "AccessKeyId": "ASIALOGGEDACCESSKEY1",
+            "SecretAccessKey": "logged/secret/access/key/value/0123456789",
+            "SessionToken": "logged-session-token-value",
+            "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+        }
+        stream = io.StringIO()
+        handler = logthings.StreamHandler(stream)
+        handler.setFormatter(SimpleJsonFormatter())
+        previous_level = LOG.level
+        LOG.addHandler(handler)
+        LOG.setLevel(logthings.DEBUG)
+        try:
+            with (
+                patch(
+                    "credproxy.credentials_handler.CredentialsHandler._assume_role",
+                    return_value=credentials,
+                ),
+                app.test_client() as client,
+            ):
+                headers = {"Authorization": "logged-service-token"}
+                responses = [
+                    client.get("/v1/credentials", headers=headers) for _ in range(2)
+                ]
+        finally:
+            LOG.removeHandler(handler)
+            LOG.setLevel(previous_level)
+            app.config["credentials_handler"].cleanup()
+
+        output = stream.getvalue()
+        for secret in (
+            credentials["SecretAccessKey"],
+            credentials["SessionToken"],
+            "logged-service-token",
+        ):
+            assert secret not in output
+
+        lines = [
+            json.loads(line)
+            for line in output.splitlines()
+            if "Providing credentials for service" in line
+        ]
+        assert [line["credentials"]["cache"] for line in lines] == ["miss", "hit"]
+        assert lines[0]["credentials"]["sts_duration"] >= 0
+        assert lines[1]["credentials"]["sts_duration"] is None
+        assert (
+            lines[0]["credentials"]["role"]
+            == "arn:aws:iam::123456789012:role/LoggedRole"
+        )
+        assert [line["request"]["request_id"] for line in lines] == [
+            response.headers[REQUEST_ID_HEADER] for response in responses
+        ]
+
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
     def test_credentials_endpoint_success(self, mock_get_creds):
         """Test successful credentials endpoint response."""
         config_data = {
@@ -135,13 +304,7 @@ class TestMainApp:
             config = Config.from_file(temp_file)
             app = init_app(config)
 
-            test_creds = {
-                "AccessKeyId": "TESTKEY",
-                "SecretAccessKey": "testsecret",
-                "Token": "testtoken",
-                "Expiration": 1234567890000,
-            }
-            mock_get_creds.return_value = test_creds
+            mock_get_creds.return_value = _credentials("TESTKEY")
 
             with app.test_client() as client:
                 response = client.get(
@@ -150,14 +313,181 @@ class TestMainApp:
                 assert response.status_code == 200
                 data = response.get_json()
                 assert data["AccessKeyId"] == "TESTKEY"
-                assert data["SecretAccessKey"] == "testsecret"
+                assert data["SecretAccessKey"] == "testkeysecret"
 
         finally:
             os.unlink(temp_file)
 
+    def _two_services_app(self):
+        config = Config.from_dict(
+            {
+                "services": {
+                    name: {
+                        "auth_token": f"{name}-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": f"arn:aws:iam::123456789012:role/{name}"
+                        },
+                    }
+                    for name in ("reader", "writer")
+                }
+            }
+        )
+        return init_app(config)
+
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
+    def test_service_credentials_by_path(self, mock_get_creds):
+        """Test /v1/credentials/<service> serves the named service."""
+        app = self._two_services_app()
+        mock_get_creds.return_value = _credentials("WRITERKEY")
+
+        with app.test_client() as client:
+            response = client.get(
+                "/v1/credentials/writer", headers={"Authorization": "writer-token"}
+            )
+
+        assert response.status_code == 200
+        assert response.get_json()["AccessKeyId"] == "WRITERKEY"
+        mock_get_creds.assert_called_once_with("writer", "writer-token")
+
+    def test_hung_sts_call_times_out(self):
+        """Test a request is answered with 504 once the request timeout passed."""
+        app = self._two_services_app()
+        handler = app.config["credentials_handler"]
+        handler.config.credentials.request_timeout = 0.1
+        release = threading.Event()
+        credentials = {
+            "AccessKeyId": "ASIAREADERKEY",
+            "SecretAccessKey": "reader-secret",
+            "SessionToken": "reader-session-token",
+            "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+        }
+
+        def hung_assume_role(service_config):
+            release.wait(timeout=5)
+            return credentials
+
+        with (
+            app.test_client() as client,
+            patch.object(handler, "_assume_role", side_effect=hung_assume_role),
+        ):
+            timed_out = client.get(
+                "/v1/credentials", headers={"Authorization": "reader-token"}
+            )
+            release.set()
+            # The role assumption completes and is cached in background
+            for thread in threading.enumerate():
+                if thread.name == "singleflight-reader":
+                    thread.join(timeout=5)
+            served = client.get(
+                "/v1/credentials", headers={"Authorization": "reader-token"}
+            )
+
+        assert timed_out.status_code == 504
+        assert timed_out.get_json() == {
+            "code": "RequestTimeout",
+            "message": "Timed out after 0.1 seconds getting credentials",
+        }
+        assert served.status_code == 200
+        assert served.get_json()["AccessKeyId"] == "ASIAREADERKEY"
+        handler.cleanup()
+
+    def test_service_credentials_unknown_service(self):
+        """Test an unknown service name in the path is not found."""
+        app = self._two_services_app()
+
+        with app.test_client() as client:
+            response = client.get(
+                "/v1/credentials/admin", headers={"Authorization": "reader-token"}
+            )
+
+        assert response.status_code == 404
+
+    def test_service_credentials_token_of_other_service(self):
+        """Test the token of a service cannot get another service credentials."""
+        app = self._two_services_app()
+
+        with app.test_client() as client:
+            other = client.get(
+                "/v1/credentials/writer", headers={"Authorization": "reader-token"}
+            )
+            missing = client.get("/v1/credentials/writer")
+            unknown = client.get(
+                "/v1/credentials/admin", headers={"Authorization": "invalid-token"}
+            )
+
+        assert other.status_code == 403
+        assert missing.status_code == 403
+        assert unknown.status_code == 403
+
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
+    def test_version_header(self, mock_get_creds):
+        """Test credentials responses carry the version of CredProxy."""
+        app = self._two_services_app()
+        mock_get_creds.return_value = _credentials("READERKEY")
+
+        with app.test_client() as client:
+            credentials = client.get(
+                "/v1/credentials", headers={"Authorization": "reader-token"}
+            )
+            health = client.get("/healthz")
+
+        assert credentials.headers[VERSION_HEADER] == __version__
+        assert VERSION_HEADER not in health.headers
+
+    def test_liveness_without_credentials(self):
+        """Test /healthz answers without credentials nor authorization token."""
+        app = self._two_services_app()
+
+        with app.test_client() as client:
+            response = client.get("/healthz")
+
+        assert response.status_code == 200
+        assert response.get_json() == {"status": "alive"}
+
+    def test_readiness_follows_credentials(self):
+        """Test /readyz is ready once credentials were obtained, until they lapse."""
+        app = self._two_services_app()
+        handler = app.config["credentials_handler"]
+        expiration = datetime(2099, 1, 1, tzinfo=timezone.utc)
+        credentials = {
+            "AccessKeyId": "ASIAREADERKEY",
+            "SecretAccessKey": "reader-secret",
+            "SessionToken": "reader-session-token",
+            "Expiration": expiration,
+        }
+
+        with app.test_client() as client:
+            not_ready = client.get("/readyz")
+            with patch.object(handler, "_assume_role", return_value=credentials):
+                client.get("/v1/credentials", headers={"Authorization": "reader-token"})
+            ready = client.get("/readyz")
+
+            # A failed refresh keeps the service ready while its credentials last
+            with patch.object(handler, "_assume_role", side_effect=Exception("STS")):
+                handler._refresh_credentials("reader")
+                still_ready = client.get("/readyz")
+                handler.cache["reader"].expiry = 0
+                expired = client.get("/readyz")
+
+        assert not_ready.status_code == 503
+        assert not_ready.get_json()["expiration"] is None
+        assert ready.status_code == 200
+        assert ready.get_json() == {
+            "status": "ready",
+            "expiration": "2099-01-01T00:00:00Z",
+            "services": {"reader": "2099-01-01T00:00:00Z"},
+            "sources": {},
+        }
+        assert still_ready.status_code == 200
+        assert expired.status_code == 503
+        assert expired.get_json()["status"] == "not ready"
+        handler.cleanup()
+
     def test_metrics_endpoint_available(self):
         """Test that metrics endpoint is available and returns correct format."""
         config = Config()
+        config.metrics.prometheus.enabled = True
         app = init_app(config)
 
         with app.test_client() as client:
@@ -172,6 +502,287 @@ class TestMainApp:
             assert "credproxy_" in metrics_data
 
 
+class TestAdminRefresh:
+    """Test the admin endpoints forcing refreshes and describing the cache."""
+
+    def _admin_app(self, admin_token: str | None = "admin-token-0123456789"):
+        server = {"admin_token": admin_token} if admin_token else {}
+        config = Config.from_dict(
+            {
+                "server": server,
+                "services": {
+                    name: {
+                        "auth_token": f"{name}-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": f"arn:aws:iam::123456789012:role/{name}"
+                        },
+                    }
+                    for name in ("reader", "writer")
+                },
+            }
+        )
+        return init_app(config)
+
+    def test_admin_endpoints_disabled_without_token(self):
+        """Test the admin endpoints are not found without server.admin_token."""
+        app = self._admin_app(None)
+
+        with app.test_client() as client:
+            response = client.post(
+                "/admin/refresh", headers={"Authorization": "reader-token"}
+            )
+
+        assert response.status_code == 404
+
+    def test_admin_token_required(self):
+        """Test service tokens cannot force refreshes."""
+        app = self._admin_app()
+
+        with app.test_client() as client:
+            service_token = client.post(
+                "/admin/refresh/reader", headers={"Authorization": "reader-token"}
+            )
+            missing = client.post("/admin/refresh/reader")
+
+        assert service_token.status_code == 403
+        assert missing.status_code == 403
+
+    def test_refresh_service(self):
+        """Test a service refresh answers the expiry of its new credentials."""
+        app = self._admin_app()
+        handler = app.config["credentials_handler"]
+        credentials = {
+            "AccessKeyId": "ASIAFORCEDKEY",
+            "SecretAccessKey": "forced-secret",
+            "SessionToken": "forced-session-token",
+            "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+        }
+        headers = {"Authorization": "admin-token-0123456789"}
+
+        with (
+            app.test_client() as client,
+            patch.object(handler, "_assume_role", return_value=credentials),
+        ):
+            response = client.post("/admin/refresh/writer", headers=headers)
+            unknown = client.post("/admin/refresh/other", headers=headers)
+
+        assert response.status_code == 200
+        assert response.get_json() == {
+            "service": "writer",
+            "expiration": "2099-01-01T00:00:00Z",
+        }
+        assert handler.cache["writer"].aws_access_key_id == "ASIAFORCEDKEY"
+        assert unknown.status_code == 404
+
+    def test_refresh_all_services(self):
+        """Test every service is refreshed, failures answering 502."""
+        app = self._admin_app()
+        handler = app.config["credentials_handler"]
+        denied = ClientError(
+            {"Error": {"Code": "AccessDenied", "Message": "Not authorized"}},
+            "AssumeRole",
+        )
+        credentials = {
+            "AccessKeyId": "ASIAREADERKEY",
+            "SecretAccessKey": "reader-secret",
+            "SessionToken": "reader-session-token",
+            "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+        }
+
+        with (
+            app.test_client() as client,
+            patch.object(handler, "_assume_role", side_effect=[credentials, denied]),
+        ):
+            response = client.post(
+                "/admin/refresh", headers={"Authorization": "admin-token-0123456789"}
+            )
+
+        assert response.status_code == 502
+        assert response.get_json() == {
+            "services": {
+                "reader": {"expiration": "2099-01-01T00:00:00Z"},
+                "writer": {"code": "AccessDenied", "message": "Not authorized"},
+            }
+        }
+
+    def test_status_without_secrets(self):
+        """Test the status describes the cache of every service, not its secrets."""
+        app = self._admin_app()
+        handler = app.config["credentials_handler"]
+        denied = ClientError(
+            {"Error": {"Code": "AccessDenied", "Message": "Not authorized"}},
+            "AssumeRole",
+        )
+        credentials = {
+            "AccessKeyId": "ASIAREADERKEY",
+            "SecretAccessKey": "reader-secret",
+            "SessionToken": "reader-session-token",
+            "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+        }
+
+        with (
+            app.test_client() as client,
+            patch.object(handler, "_assume_role", side_effect=[credentials, denied]),
+        ):
+            client.get("/v1/credentials", headers={"Authorization": "reader-token"})
+            client.get("/v1/credentials", headers={"Authorization": "writer-token"})
+            service_token = client.get(
+                "/admin/status", headers={"Authorization": "reader-token"}
+            )
+            response = client.get(
+                "/admin/status", headers={"Authorization": "admin-token-0123456789"}
+            )
+
+        assert service_token.status_code == 403
+        assert response.status_code == 200
+        services = response.get_json()["services"]
+        assert services["reader"]["last_refresh"] is not None
+        assert services == {
+            "reader": {
+                "source": "default",
+                "role_arn": "arn:aws:iam::123456789012:role/reader",
+                "cache": "valid",
+                "expiration": "2099-01-01T00:00:00Z",
+                "last_refresh": services["reader"]["last_refresh"],
+                "last_error": None,
+                "refreshing": False,
+            },
+            "writer": {
+                "source": "default",
+                "role_arn": "arn:aws:iam::123456789012:role/writer",
+                "cache": "empty",
+                "expiration": None,
+                "last_refresh": None,
+                "last_error": str(denied),
+                "refreshing": False,
+            },
+        }
+        for secret in ("ASIAREADERKEY", "reader-secret", "reader-session-token"):
+            assert secret not in response.get_data(as_text=True)
+        handler.cleanup()
+
+    def test_admin_token_of_a_service_rejected(self):
+        """Test the admin token cannot be the token of a service."""
+        service = {
+            "auth_token": "shared-token-0123456789",
+            "source_credentials": {"region": "us-west-2"},
+            "assumed_role": {"RoleArn": "arn:aws:iam::123456789012:role/reader"},
+        }
+
+        with pytest.raises(ValueError, match="must differ"):
+            Config.from_dict(
+                {
+                    "server": {"admin_token": "shared-token-0123456789"},
+                    "services": {"reader": service},
+                }
+            )
+
+
+class TestClientAllowlists:
+    """Test the services clients of an allowlist may get the credentials of."""
+
+    def _app(self):
+        config = Config.from_dict(
+            {
+                "services": {
+                    name: {
+                        "auth_token": f"{name}-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": f"arn:aws:iam::123456789012:role/{name}"
+                        },
+                    }
+                    for name in ("reader", "writer")
+                },
+                "clients": {
+                    "ci": {"auth_token": "ci-token-0123456789", "services": ["reader"]},
+                    "locked": {"auth_token": "locked-token-0123456", "services": []},
+                    "runner": {
+                        "client_cn": "runner.example.com",
+                        "services": ["reader", "writer", "retired"],
+                    },
+                },
+            }
+        )
+        return init_app(config)
+
+    @pytest.mark.parametrize(
+        "headers, client_cn, service_name, status",
+        [
+            # Clients of an allowlist
+            ({"Authorization": "ci-token-0123456789"}, None, "reader", 200),
+            ({"Authorization": "ci-token-0123456789"}, None, "writer", 403),
+            # Services outside of the allowlist cannot be probed
+            ({"Authorization": "ci-token-0123456789"}, None, "unknown", 403),
+            ({"Authorization": "locked-token-0123456"}, None, "reader", 403),
+            ({}, "runner.example.com", "writer", 200),
+            ({}, "other.example.com", "writer", 403),
+            ({}, "runner.example.com", "retired", 404),
+            # Clients without an allowlist, served the service of their token
+            ({"Authorization": "reader-token"}, None, "reader", 200),
+            ({"Authorization": "reader-token"}, None, "writer", 403),
+            ({"Authorization": "unknown-token"}, None, "reader", 403),
+        ],
+    )
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
+    def test_allowlist_matrix(
+        self, mock_get_creds, headers, client_cn, service_name, status
+    ):
+        """Test allowed, denied and unconfigured clients."""
+        mock_get_creds.return_value = _credentials("ALLOWEDKEY")
+
+        with self._app().test_client() as client:
+            response = client.get(
+                f"/v1/credentials/{service_name}",
+                headers=headers,
+                environ_base={"credproxy.client_cn": client_cn},
+            )
+
+        assert response.status_code == status
+        assert mock_get_creds.called is (status == 200)
+
+    @pytest.mark.parametrize(
+        "token, result",
+        [
+            ("ci-token-0123456789", "denied_not_allowed"),
+            ("unknown-token", "denied_invalid_token"),
+        ],
+    )
+    def test_denials_recorded(self, token, result):
+        """Test allowlist denials are told apart from invalid tokens in metrics."""
+        with (
+            self._app().test_client() as client,
+            patch("credproxy.app.record_request") as mock_record,
+        ):
+            client.get("/v1/credentials/writer", headers={"Authorization": token})
+
+        assert mock_record.call_args.kwargs["result"] == result
+
+    def test_client_token_must_differ(self):
+        """Test the token of a client cannot be the token of a service."""
+        with pytest.raises(ValueError, match="must differ"):
+            Config.from_dict(
+                {
+                    "services": {
+                        "reader": {
+                            "auth_token": "shared-token-0123456789",
+                            "source_credentials": {"region": "us-west-2"},
+                            "assumed_role": {
+                                "RoleArn": "arn:aws:iam::123456789012:role/reader"
+                            },
+                        }
+                    },
+                    "clients": {
+                        "ci": {
+                            "auth_token": "shared-token-0123456789",
+                            "services": ["reader"],
+                        }
+                    },
+                }
+            )
+
+
 class TestCredentialMethods:
     """Test credential retrieval methods."""
 
@@ -241,7 +852,7 @@ class TestCredentialMethods:
         )
         assert parsed_time == expiration_time
 
-    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials")
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
     def test_credentials_response_format(self, mock_get_creds):
         """Test that credentials response has correct format for AWS SDK."""
         from datetime import datetime, timezone
@@ -273,16 +884,11 @@ class TestCredentialMethods:
             config = Config.from_file(temp_file)
             app = init_app(config)
 
-            # Create properly formatted credentials
-            expiration_time = datetime.now(timezone.utc)
-            test_creds = {
-                "AccessKeyId": "TESTKEY",
-                "SecretAccessKey": "testsecret",
-                "Token": "testtoken",
-                "Expiration": expiration_time.isoformat(),
-            }
-
-            mock_get_creds.return_value = test_creds
+            # Served without fractional seconds
+            expiration_time = datetime.now(timezone.utc).replace(microsecond=0)
+            mock_get_creds.return_value = _credentials(
+                "TESTKEY", expiration_time.timestamp()
+            )
 
             with app.test_client() as client:
                 response = client.get(
@@ -308,9 +914,66 @@ class TestCredentialMethods:
         finally:
             os.unlink(temp_file)
 
+    @patch("boto3.client")
+    def test_credentials_round_trip_with_container_provider(self, mock_boto3_client):
+        """Test botocore's container credentials provider reads the response."""
+        expiration_time = datetime(2030, 1, 2, 3, 4, 5, tzinfo=timezone.utc)
+        mock_boto3_client.return_value.assume_role.return_value = {
+            "Credentials": {
+                "AccessKeyId": "ASIAROUNDTRIPKEY",
+                "SecretAccessKey": "roundtripsecret",
+                "SessionToken": "roundtriptoken",
+                "Expiration": expiration_time,
+            }
+        }
+        config = Config.from_dict(
+            {
+                "services": {
+                    "test-service": {
+                        "auth_token": "round-trip-token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::123456789012:role/TestRole"
+                        },
+                    }
+                }
+            }
+        )
+        app = init_app(config)
+        full_uri = "http://localhost/v1/credentials"
+
+        with app.test_client() as client:
+
+            def retrieve_full_uri(uri, headers=None):
+                assert uri == full_uri
+                response = client.get("/v1/credentials", headers=headers)
+                assert response.status_code == 200
+                body = response.get_json()
+                assert body["RoleArn"] == "arn:aws:iam::123456789012:role/TestRole"
+                assert body["Expiration"] == "2030-01-02T03:04:05Z"
+                return body
+
+            fetcher = MagicMock()
+            fetcher.retrieve_full_uri.side_effect = retrieve_full_uri
+            provider = ContainerProvider(
+                environ={
+                    "AWS_CONTAINER_CREDENTIALS_FULL_URI": full_uri,
+                    "AWS_CONTAINER_AUTHORIZATION_TOKEN": "round-trip-token",
+                },
+                fetcher=fetcher,
+            )
+            credentials = provider.load()
+
+        frozen = credentials.get_frozen_credentials()
+        assert frozen.access_key == "ASIAROUNDTRIPKEY"
+        assert frozen.secret_key == "roundtripsecret"
+        assert frozen.token == "roundtriptoken"
+        assert credentials._expiry_time == expiration_time
+
     def test_metrics_endpoint_available(self):
         """Test that metrics endpoint is available and returns correct format."""
         config = Config()
+        config.metrics.prometheus.enabled = True
         app = init_app(config)
 
         with app.test_client() as client:
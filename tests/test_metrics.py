@@ -13,8 +13,13 @@ from credproxy.metrics import (
     REGISTRY,
     get_metrics,
     init_metrics,
+    record_refresh,
     record_request,
     update_active_services,
+    track_credentials_expiry,
+    record_credentials_served,
+    record_sts_assume_duration,
+    untrack_credentials_expiry,
 )
 
 
@@ -54,6 +59,75 @@ class TestServiceDiscoveryMetrics:
         update_active_services(5)
 
 
+class TestCredentialMetrics:
+    """Test credential issuance and refresh metrics."""
+
+    def test_record_credentials_served(self):
+        """Test served credentials are counted per role."""
+        role = "arn:aws:iam::123456789012:role/MetricsRole"
+        labels = {"role": role}
+        before = (
+            REGISTRY.get_sample_value("credproxy_credentials_served_total", labels)
+            or 0.0
+        )
+        record_credentials_served(role)
+        record_credentials_served(role)
+
+        assert (
+            REGISTRY.get_sample_value("credproxy_credentials_served_total", labels)
+            == before + 2
+        )
+
+    def test_record_sts_assume_duration(self):
+        """Test STS call durations are observed."""
+        before = (
+            REGISTRY.get_sample_value("credproxy_sts_assume_duration_seconds_count")
+            or 0.0
+        )
+        record_sts_assume_duration(0.2)
+
+        assert (
+            REGISTRY.get_sample_value("credproxy_sts_assume_duration_seconds_count")
+            == before + 1
+        )
+
+    def test_record_refresh(self):
+        """Test refreshes are counted by result."""
+        labels = {"result": "failure"}
+        before = REGISTRY.get_sample_value("credproxy_refresh_total", labels) or 0.0
+        record_refresh("failure")
+
+        refreshes = REGISTRY.get_sample_value("credproxy_refresh_total", labels)
+        assert refreshes == before + 1
+
+    def test_credentials_expiry_tracking(self):
+        """Test the expiry gauge counts down and is removed on untrack."""
+        labels = {"service_name": "expiry-service"}
+        with patch("credproxy.metrics.time.time", return_value=1000.0):
+            track_credentials_expiry("expiry-service", 1600.0)
+            assert (
+                REGISTRY.get_sample_value(
+                    "credproxy_credentials_expiry_seconds", labels
+                )
+                == 600.0
+            )
+        with patch("credproxy.metrics.time.time", return_value=2000.0):
+            assert (
+                REGISTRY.get_sample_value(
+                    "credproxy_credentials_expiry_seconds", labels
+                )
+                == 0.0
+            )
+
+        untrack_credentials_expiry("expiry-service")
+        assert (
+            REGISTRY.get_sample_value("credproxy_credentials_expiry_seconds", labels)
+            is None
+        )
+        # Untracking an unknown service is a no-op
+        untrack_credentials_expiry("expiry-service")
+
+
 class TestMetricsEndpoint:
     """Test metrics endpoint functionality."""
 
@@ -15,37 +15,37 @@ class TestMetricsConfig:
     def test_prometheus_config_defaults(self):
         """Test PrometheusConfig default values."""
         config = PrometheusConfig()
-        assert config.enabled is True
+        assert config.enabled is False
 
     def test_prometheus_config_custom_values(self):
         """Test PrometheusConfig with custom values."""
-        config = PrometheusConfig(enabled=False)
-        assert config.enabled is False
+        config = PrometheusConfig(enabled=True)
+        assert config.enabled is True
 
     def test_metrics_config_defaults(self):
         """Test MetricsConfig default values."""
         config = MetricsConfig()
         assert isinstance(config.prometheus, PrometheusConfig)
-        assert config.prometheus.enabled is True
+        assert config.prometheus.enabled is False
 
     def test_metrics_config_custom_prometheus(self):
         """Test MetricsConfig with custom Prometheus config."""
-        prometheus_config = PrometheusConfig(enabled=False)
+        prometheus_config = PrometheusConfig(enabled=True)
         config = MetricsConfig(prometheus=prometheus_config)
-        assert config.prometheus.enabled is False
+        assert config.prometheus.enabled is True
 
     def test_main_config_metrics_defaults(self):
         """Test main Config class includes metrics with defaults."""
         config = Config()
         assert isinstance(config.metrics, MetricsConfig)
-        assert config.metrics.prometheus.enabled is True
+        assert config.metrics.prometheus.enabled is False
 
 
 class TestMetricsConfigFromDict:
     """Test loading metrics configuration from dictionaries."""
 
     def test_load_config_without_metrics_section(self):
-        """Test loading config without metrics section uses defaults."""
+        """Test loading config without metrics section disables metrics."""
         config_dict = {
             "services": {
                 "test-service": {
@@ -59,7 +59,7 @@ class TestMetricsConfigFromDict:
         }
 
         config = Config.from_dict(config_dict)
-        assert config.metrics.prometheus.enabled is True
+        assert config.metrics.prometheus.enabled is False
 
     def test_load_config_with_metrics_enabled(self):
         """Test loading config with metrics explicitly enabled."""
@@ -191,7 +191,7 @@ class TestMetricsEndpointRegistration:
             assert "text/plain" in response.content_type
 
     def test_default_metrics_endpoint_when_no_config(self):
-        """Test that default metrics endpoint works when no metrics config provided."""
+        """Test that metrics endpoint is disabled when no metrics config provided."""
         config_dict = {
             "services": {
                 "test-service": {
@@ -208,7 +208,6 @@ class TestMetricsEndpointRegistration:
         app = init_app(config)
 
         with app.test_client() as client:
-            # Test that the default metrics endpoint is accessible
+            # Metrics are off by default
             response = client.get("/metrics")
-            assert response.status_code == 200
-            assert "text/plain" in response.content_type
+            assert response.status_code == 404
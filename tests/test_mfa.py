@@ -0,0 +1,24 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for MFA token code providers."""
+
+from __future__ import annotations
+
+from unittest.mock import MagicMock
+
+from credproxy.mfa import StdinMFAProvider
+
+
+class TestStdinMFAProvider:
+    """Test the terminal MFA prompt."""
+
+    def test_token_code_prompts_for_serial(self):
+        """Test the prompt names the MFA device and the code is stripped."""
+        prompt = MagicMock(return_value=" 123456\n")
+        provider = StdinMFAProvider(prompt=prompt)
+
+        assert provider.token_code("arn:aws:iam::123456789012:mfa/user") == "123456"
+        prompt.assert_called_once_with(
+            "Enter MFA code for arn:aws:iam::123456789012:mfa/user: "
+        )
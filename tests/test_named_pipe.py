@@ -0,0 +1,75 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for serving CredProxy on a Windows named pipe."""
+
+from __future__ import annotations
+
+import sys
+import json
+from unittest.mock import patch
+
+import pytest
+
+from credproxy.app import init_app
+from tests.mock_aws import mock_config
+from credproxy.config import Config
+from credproxy.named_pipe import NamedPipeServer
+
+
+PIPE_NAME = r"\\.\pipe\credproxy-tests"
+
+
+class TestNamedPipeConfig:
+    """Test configuring the named pipe."""
+
+    def test_named_pipe_parsed(self):
+        """Test server.named_pipe is parsed."""
+        config = mock_config(server={"named_pipe": PIPE_NAME})
+        assert config.server.named_pipe == PIPE_NAME
+        assert Config().server.named_pipe is None
+
+    def test_invalid_named_pipe_rejected(self):
+        """Test names which are not local pipes are rejected."""
+        with pytest.raises(ValueError):
+            mock_config(server={"named_pipe": r"\\host\pipe\credproxy"})
+
+
+class TestNamedPipeServer:
+    """Test the named pipe server."""
+
+    def test_not_supported_off_windows(self):
+        """Test starting fails clearly on other platforms."""
+        server = NamedPipeServer(init_app(Config()), PIPE_NAME)
+
+        with patch("credproxy.named_pipe.sys.platform", "linux"):
+            with pytest.raises(OSError, match="only supported on Windows"):
+                server.start()
+        server.stop()
+
+    @pytest.mark.skipif(sys.platform != "win32", reason="Windows named pipes")
+    def test_serves_requests_and_removes_pipe(self):
+        """Test the pipe serves the app and is removed on stop."""
+        server = NamedPipeServer(init_app(Config()), PIPE_NAME)
+        server.start()
+        try:
+            with open(PIPE_NAME, "r+b", buffering=0) as pipe:
+                pipe.write(
+                    b"GET /health HTTP/1.1\r\nHost: localhost\r\n"
+                    b"Connection: close\r\n\r\n"
+                )
+                response = b""
+                try:
+                    while chunk := pipe.read(65536):
+                        response += chunk
+                except BrokenPipeError:
+                    # Disconnected by the server once the response is sent
+                    pass
+            status, _, body = response.partition(b"\r\n\r\n")
+            assert status.split()[1] == b"200"
+            assert json.loads(body)["status"] == "healthy"
+        finally:
+            server.stop()
+
+        with pytest.raises(FileNotFoundError):
+            open(PIPE_NAME, "r+b", buffering=0)
@@ -0,0 +1,119 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for child processes tracking and orphans reaping."""
+
+from __future__ import annotations
+
+import os
+import sys
+import time
+import threading
+import subprocess
+from unittest.mock import patch
+
+import pytest
+
+from credproxy import processes
+from credproxy.processes import (
+    run_command,
+    reap_orphans,
+    terminate_children,
+    install_orphan_reaper,
+)
+
+
+pytestmark = pytest.mark.skipif(
+    not hasattr(os, "waitid"), reason="Reaping requires os.waitid"
+)
+
+
+def _wait_exited(pid: int) -> None:
+    """Wait for a child to exit, without reaping it."""
+    while not os.waitid(os.P_PID, pid, os.WEXITED | os.WNOHANG | os.WNOWAIT):
+        time.sleep(0.01)
+
+
+class TestRunCommand:
+    """Test running tracked commands."""
+
+    def test_output_returned(self):
+        """Test the command output is returned and the command untracked."""
+        assert run_command([sys.executable, "-c", "print('assertion')"], 10) == (
+            "assertion\n"
+        )
+        assert processes._children == {}
+
+    def test_failure_raised(self):
+        """Test failing commands raise with their exit code."""
+        with pytest.raises(subprocess.CalledProcessError) as error:
+            run_command([sys.executable, "-c", "raise SystemExit(3)"], 10)
+
+        assert error.value.returncode == 3
+
+    def test_timeout_kills_command(self):
+        """Test commands running past the timeout are killed."""
+        with pytest.raises(subprocess.TimeoutExpired):
+            run_command([sys.executable, "-c", "import time; time.sleep(30)"], 0.2)
+
+        assert processes._children == {}
+
+    def test_terminated_on_shutdown(self):
+        """Test commands still running are terminated."""
+        result = {}
+
+        def run():
+            try:
+                run_command([sys.executable, "-c", "import time; time.sleep(30)"], 30)
+            except subprocess.CalledProcessError as error:
+                result["returncode"] = error.returncode
+
+        thread = threading.Thread(target=run)
+        thread.start()
+        while not processes._children:
+            time.sleep(0.01)
+        terminate_children()
+        thread.join(timeout=10)
+
+        assert result["returncode"] < 0
+
+
+class TestReapOrphans:
+    """Test reaping exited processes not run by run_command."""
+
+    def test_exited_process_reaped(self):
+        """Test exited processes are reaped, leaving no zombie."""
+        pid = os.posix_spawn(sys.executable, [sys.executable, "-c", ""], os.environ)
+        _wait_exited(pid)
+
+        reap_orphans()
+
+        with pytest.raises(ChildProcessError):
+            os.waitpid(pid, os.WNOHANG)
+
+    def test_commands_left_to_run_command(self):
+        """Test exited commands are left for their exit code to be read."""
+        pid = os.posix_spawn(sys.executable, [sys.executable, "-c", ""], os.environ)
+        _wait_exited(pid)
+
+        with patch.dict(processes._children, {pid: None}):
+            reap_orphans()
+
+        assert os.waitpid(pid, 0)[0] == pid
+
+    def test_reaper_installed_as_pid_1_only(self):
+        """Test the SIGCHLD handler is only installed running as PID 1."""
+        with (
+            patch("credproxy.processes.signal.signal") as mock_signal,
+            patch("credproxy.processes.os.getpid", return_value=4242),
+        ):
+            assert install_orphan_reaper() is False
+        mock_signal.assert_not_called()
+
+        with (
+            patch("credproxy.processes.signal.signal") as mock_signal,
+            patch("credproxy.processes.os.getpid", return_value=1),
+            patch.object(processes, "_reaping", False),
+        ):
+            assert install_orphan_reaper() is True
+        mock_signal.assert_called_once_with(processes.signal.SIGCHLD, reap_orphans)
@@ -0,0 +1,138 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for custom source credentials providers."""
+
+from __future__ import annotations
+
+import time
+from datetime import datetime, timezone
+from unittest.mock import MagicMock, patch
+
+from credproxy.app import init_app
+from tests.mock_aws import mock_config
+from credproxy.providers import SourceCredentials, CredentialsProvider
+
+
+# Replaced by the providers of the tests
+SOURCE_CREDENTIALS = {"iam_profile": {"profile_name": "unused"}}
+# Longer than the hour roles assumed with role session credentials are capped to
+ASSUMED_ROLE = {"DurationSeconds": 7200}
+
+
+class VaultProvider(CredentialsProvider):
+    """Provider of the keys of a secrets system."""
+
+    def __init__(self, role_session: bool = False):
+        self.role_session = role_session
+        self.calls = 0
+
+    def retrieve(self, retry_policy=None) -> SourceCredentials:
+        self.calls += 1
+        return SourceCredentials("ASIAVAULTKEY", "vault-secret", "vault-token")
+
+
+def _mock_sts_client() -> MagicMock:
+    sts_client = MagicMock()
+    sts_client.assume_role.return_value = {
+        "Credentials": {
+            "AccessKeyId": "ASIAMYAPPKEY",
+            "SecretAccessKey": "my-app-secret",
+            "SessionToken": "my-app-session-token",
+            "Expiration": datetime(2099, 1, 1, tzinfo=timezone.utc),
+        }
+    }
+    return sts_client
+
+
+class TestSourceCredentials:
+    """Test the credentials returned by providers."""
+
+    def test_expiry(self):
+        """Test credentials expire within the margin, long-term keys never."""
+        expiration = time.time() + 60
+        credentials = SourceCredentials("AKIAKEY", "secret", expiration=expiration)
+
+        assert not credentials.is_expired()
+        assert credentials.is_expired(margin=120)
+        assert not SourceCredentials("AKIAKEY", "secret").is_expired(margin=3600)
+
+    def test_aws_config_without_session_token(self):
+        """Test long-term keys are passed to boto3 without a session token."""
+        assert SourceCredentials("AKIAKEY", "secret").to_aws_config() == {
+            "aws_access_key_id": "AKIAKEY",
+            "aws_secret_access_key": "secret",
+        }
+
+
+class TestCustomProviders:
+    """Test services using the providers of an application."""
+
+    def test_provider_replaces_configured_method(self):
+        """Test the role is assumed with the keys of the provider."""
+        provider = VaultProvider()
+        config = mock_config(
+            source_credentials=SOURCE_CREDENTIALS, assumed_role=ASSUMED_ROLE
+        )
+        app = init_app(config, source_providers={"my-app": provider})
+        handler = app.config["credentials_handler"]
+        sts_client = _mock_sts_client()
+
+        with (
+            app.test_client() as client,
+            patch(
+                "credproxy.credentials_handler.boto3.client", return_value=sts_client
+            ) as mock_client,
+        ):
+            response = client.get(
+                "/v1/credentials", headers={"Authorization": "my-app-token"}
+            )
+
+        assert response.status_code == 200
+        assert response.get_json()["AccessKeyId"] == "ASIAMYAPPKEY"
+        assert provider.calls == 1
+        kwargs = mock_client.call_args.kwargs
+        assert kwargs["aws_access_key_id"] == "ASIAVAULTKEY"
+        assert kwargs["aws_session_token"] == "vault-token"
+        assert "profile_name" not in kwargs
+        assert sts_client.assume_role.call_args.kwargs["DurationSeconds"] == 7200
+        assert handler.credentials_status()["my-app"].source == "provider"
+        handler.cleanup()
+
+    def test_role_session_provider_duration_capped(self):
+        """Test roles assumed with role session credentials last an hour at most."""
+        config = mock_config(
+            source_credentials=SOURCE_CREDENTIALS, assumed_role=ASSUMED_ROLE
+        )
+        app = init_app(
+            config, source_providers={"my-app": VaultProvider(role_session=True)}
+        )
+        handler = app.config["credentials_handler"]
+        sts_client = _mock_sts_client()
+
+        with patch(
+            "credproxy.credentials_handler.boto3.client", return_value=sts_client
+        ):
+            handler.get_credentials("my-app")
+
+        assert sts_client.assume_role.call_args.kwargs["DurationSeconds"] == 3600
+        handler.cleanup()
+
+    def test_provider_error_answered(self):
+        """Test errors of the provider are answered without credentials."""
+        provider = MagicMock(spec=CredentialsProvider)
+        provider.role_session = False
+        provider.retrieve.side_effect = RuntimeError("Vault sealed")
+        config = mock_config(
+            source_credentials=SOURCE_CREDENTIALS, assumed_role=ASSUMED_ROLE
+        )
+        app = init_app(config, source_providers={"my-app": provider})
+
+        with app.test_client() as client:
+            response = client.get(
+                "/v1/credentials", headers={"Authorization": "my-app-token"}
+            )
+
+        assert response.status_code == 500
+        assert "AccessKeyId" not in response.get_json()
+        app.config["credentials_handler"].cleanup()
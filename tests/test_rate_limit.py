@@ -0,0 +1,134 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the rate limiting of credential requests."""
+
+from __future__ import annotations
+
+from datetime import datetime, timezone, timedelta
+from unittest.mock import patch
+
+import pytest
+
+from credproxy.app import init_app
+from tests.mock_aws import mock_config
+from credproxy.rate_limit import RateLimitExceeded, TokenBucketRateLimiter
+from credproxy.credentials_handler import CredentialsHandler
+
+
+def _assume_role_response() -> dict:
+    return {
+        "Credentials": {
+            "AccessKeyId": "ASIARATELIMITED",
+            "SecretAccessKey": "rate-limited-secret",
+            "SessionToken": "rate-limited-token",
+            "Expiration": datetime.now(timezone.utc) + timedelta(hours=1),
+        }
+    }
+
+
+class TestTokenBucketRateLimiter:
+    """Test the token buckets of clients."""
+
+    def test_burst_then_refill(self):
+        """Test a client gets its burst, then a request per refilled token."""
+        limiter = TokenBucketRateLimiter(requests_per_second=2, burst=3)
+        with patch("time.monotonic", return_value=100.0):
+            assert [limiter.acquire("client") for _ in range(3)] == [0, 0, 0]
+            assert limiter.acquire("client") == pytest.approx(0.5)
+            # Other clients have their own bucket
+            assert limiter.acquire("other-client") == 0
+        with patch("time.monotonic", return_value=100.5):
+            assert limiter.acquire("client") == 0
+            assert limiter.acquire("client") == pytest.approx(0.5)
+
+    def test_default_burst(self):
+        """Test the burst defaults to the rate, rounded up."""
+        assert TokenBucketRateLimiter(2.5).burst == 3
+        assert TokenBucketRateLimiter(0.1).burst == 1
+
+    def test_refilled_clients_forgotten(self):
+        """Test clients with a refilled bucket are forgotten past the limit."""
+        limiter = TokenBucketRateLimiter(requests_per_second=1)
+        with (
+            patch("credproxy.rate_limit.MAX_TRACKED_CLIENTS", 2),
+            patch("time.monotonic", return_value=100.0),
+        ):
+            limiter.acquire("first-client")
+            limiter.acquire("second-client")
+        with (
+            patch("credproxy.rate_limit.MAX_TRACKED_CLIENTS", 2),
+            patch("time.monotonic", return_value=101.0),
+        ):
+            limiter.acquire("third-client")
+
+        assert set(limiter._buckets) == {"third-client"}
+
+
+class TestHandlerRateLimit:
+    """Test the credentials handler rate limiting clients."""
+
+    def test_requests_limited(self):
+        """Test cached credentials are rate limited too by default."""
+        config = mock_config(credentials={"rate_limit": {"requests_per_second": 1}})
+        handler = CredentialsHandler(config)
+        try:
+            with patch("boto3.client") as mock_client:
+                mock_client.return_value.assume_role.return_value = (
+                    _assume_role_response()
+                )
+                handler.get_credentials("my-app", "client")
+                with pytest.raises(RateLimitExceeded) as error:
+                    handler.get_credentials("my-app", "client")
+                # Internal lookups are not limited
+                handler.get_credentials("my-app")
+        finally:
+            handler.cleanup()
+
+        assert 0 < error.value.retry_after <= 1
+
+    def test_sts_requests_only(self):
+        """Test only requests calling STS are limited with sts_requests_only."""
+        handler = CredentialsHandler(
+            mock_config(
+                credentials={
+                    "rate_limit": {"requests_per_second": 1, "sts_requests_only": True}
+                }
+            )
+        )
+        try:
+            with patch("boto3.client") as mock_client:
+                mock_client.return_value.assume_role.return_value = (
+                    _assume_role_response()
+                )
+                for _ in range(3):
+                    handler.get_credentials("my-app", "client")
+
+                handler.discard_credentials("my-app")
+                with pytest.raises(RateLimitExceeded):
+                    handler.get_credentials("my-app", "client")
+        finally:
+            handler.cleanup()
+
+        mock_client.return_value.assume_role.assert_called_once()
+
+
+class TestRateLimitedEndpoint:
+    """Test the answer to rate limited requests."""
+
+    @patch("credproxy.credentials_handler.CredentialsHandler.get_credentials_entry")
+    def test_too_many_requests(self, mock_get_creds):
+        """Test rate limited requests get 429 with Retry-After."""
+        config = mock_config(credentials={"rate_limit": {"requests_per_second": 1}})
+        app = init_app(config)
+        mock_get_creds.side_effect = RateLimitExceeded(retry_after=0.2)
+
+        with app.test_client() as client:
+            response = client.get(
+                "/v1/credentials", headers={"Authorization": "my-app-token"}
+            )
+
+        assert response.status_code == 429
+        assert response.headers["Retry-After"] == "1"
+        assert response.get_json() == {"code": "Throttling", "message": "Rate exceeded"}
+        mock_get_creds.assert_called_once_with("my-app", "my-app-token")
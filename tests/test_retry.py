@@ -0,0 +1,120 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the STS calls retry policy."""
+
+from __future__ import annotations
+
+import time
+from unittest.mock import MagicMock, patch
+
+import pytest
+from botocore.exceptions import ClientError, ReadTimeoutError, EndpointConnectionError
+
+from credproxy.retry import StsRetryPolicy, is_retryable
+
+
+def _client_error(code: str, status_code: int) -> ClientError:
+    """Build an STS error response."""
+    return ClientError(
+        {
+            "Error": {"Code": code, "Message": code},
+            "ResponseMetadata": {"HTTPStatusCode": status_code},
+        },
+        "AssumeRole",
+    )
+
+
+class TestIsRetryable:
+    """Test which STS errors are transient."""
+
+    def test_transient_errors_retried(self):
+        """Test throttling, server and network errors are retried."""
+        assert is_retryable(_client_error("Throttling", 400))
+        assert is_retryable(_client_error("RequestLimitExceeded", 400))
+        assert is_retryable(_client_error("InternalFailure", 500))
+        assert is_retryable(_client_error("ServiceUnavailable", 503))
+        assert is_retryable(EndpointConnectionError(endpoint_url="https://sts"))
+        assert is_retryable(ReadTimeoutError(endpoint_url="https://sts"))
+
+    def test_request_errors_not_retried(self):
+        """Test errors caused by the request itself are not retried."""
+        assert not is_retryable(_client_error("AccessDenied", 403))
+        assert not is_retryable(_client_error("ValidationError", 400))
+        assert not is_retryable(ValueError("not an STS error"))
+
+
+class TestStsRetryPolicy:
+    """Test retrying STS calls with backoff."""
+
+    def test_retried_until_success(self):
+        """Test a call failing transiently is retried until it succeeds."""
+        function = MagicMock(
+            side_effect=[
+                _client_error("Throttling", 400),
+                _client_error("InternalFailure", 500),
+                "done",
+            ]
+        )
+
+        with patch("credproxy.retry.time.sleep") as mock_sleep:
+            result = StsRetryPolicy(max_attempts=3).call("AssumeRole", function)
+
+        assert result == "done"
+        assert function.call_count == 3
+        assert mock_sleep.call_count == 2
+
+    def test_backoff_grows_up_to_max_delay(self):
+        """Test the longest delay doubles on every retry, capped by max_delay."""
+        function = MagicMock(side_effect=_client_error("Throttling", 400))
+
+        with (
+            patch("credproxy.retry.time.sleep"),
+            patch(
+                "credproxy.retry.random.uniform", side_effect=lambda low, high: high
+            ) as mock_uniform,
+        ):
+            with pytest.raises(ClientError):
+                StsRetryPolicy(max_attempts=5, max_delay=1.5).call(
+                    "AssumeRole", function
+                )
+
+        assert [call.args[1] for call in mock_uniform.call_args_list] == [
+            0.5,
+            1.0,
+            1.5,
+            1.5,
+        ]
+
+    def test_attempts_exhausted(self):
+        """Test the last error is raised once all attempts failed."""
+        function = MagicMock(side_effect=_client_error("Throttling", 400))
+
+        with patch("credproxy.retry.time.sleep"):
+            with pytest.raises(ClientError):
+                StsRetryPolicy(max_attempts=3).call("AssumeRole", function)
+
+        assert function.call_count == 3
+
+    def test_access_denied_not_retried(self):
+        """Test AccessDenied is raised on the first attempt."""
+        function = MagicMock(side_effect=_client_error("AccessDenied", 403))
+
+        with patch("credproxy.retry.time.sleep") as mock_sleep:
+            with pytest.raises(ClientError):
+                StsRetryPolicy(max_attempts=3).call("AssumeRole", function)
+
+        assert function.call_count == 1
+        mock_sleep.assert_not_called()
+
+    def test_no_retry_past_deadline(self):
+        """Test retries do not wait past the deadline of the request."""
+        function = MagicMock(side_effect=_client_error("Throttling", 400))
+        policy = StsRetryPolicy(max_attempts=3, deadline=time.monotonic() - 1)
+
+        with patch("credproxy.retry.time.sleep") as mock_sleep:
+            with pytest.raises(ClientError):
+                policy.call("AssumeRole", function)
+
+        assert function.call_count == 1
+        mock_sleep.assert_not_called()
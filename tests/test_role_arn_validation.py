@@ -1,9 +1,14 @@
 """Unit tests for AWS IAM Role ARN validation."""
 
+from unittest.mock import patch
+
 import pytest
 import jsonschema
+from botocore.exceptions import BotoCoreError
 
 from credproxy.config import Config
+from credproxy.partitions import arn_partition, check_partition, region_partition
+from credproxy.credentials_handler import CredentialsHandler
 
 
 class TestRoleARNValidation:
@@ -42,6 +47,9 @@ class TestRoleARNValidation:
             # Complex path with multiple levels
             "arn:aws:iam::123456789012:role/division_abc/subdivision_xyz/product_1234/"
             "engineering/RoleName",
+            # Isolated partitions
+            "arn:aws-cn:iam::123456789012:role/MyRole",
+            "arn:aws-us-gov:iam::123456789012:role/MyRole",
         ]
 
         for arn in valid_arns:
@@ -69,8 +77,8 @@ class TestRoleARNValidation:
         schema = self.get_schema()
 
         invalid_arns = [
-            # Wrong partition
-            "arn:aws-cn:iam::123456789012:role/MyRole",
+            # Unknown partition
+            "arn:aws-mars:iam::123456789012:role/MyRole",
             # Wrong service
             "arn:aws:s3::123456789012:role/MyRole",
             # Region specified (should be empty for IAM)
@@ -224,3 +232,106 @@ class TestRoleARNValidation:
 
             # Should not raise any exception
             jsonschema.validate(config_data, schema)
+
+
+def _partition_config(region: str, role_arn: str, **service) -> dict:
+    """Build a configuration of a service assuming role_arn in region."""
+    return {
+        "services": {
+            "test-service": {
+                "auth_token": "test-token",
+                "source_credentials": {"region": region},
+                "assumed_role": {"RoleArn": role_arn},
+                **service,
+            }
+        }
+    }
+
+
+class TestRoleARNPartition:
+    """Test the region of services is in the partition of their role ARNs."""
+
+    @pytest.mark.parametrize(
+        "region, role_arn",
+        [
+            ("us-east-1", "arn:aws:iam::123456789012:role/MyRole"),
+            ("us-gov-west-1", "arn:aws-us-gov:iam::123456789012:role/MyRole"),
+            ("cn-north-1", "arn:aws-cn:iam::123456789012:role/MyRole"),
+        ],
+    )
+    def test_region_in_partition(self, region, role_arn):
+        """Test commercial, GovCloud and China roles load with their regions."""
+        config = Config.from_dict(_partition_config(region, role_arn))
+
+        assert config.services["test-service"].source_credentials.region == region
+
+    @pytest.mark.parametrize(
+        "region, role_arn",
+        [
+            ("cn-north-1", "arn:aws:iam::123456789012:role/MyRole"),
+            ("us-east-1", "arn:aws-us-gov:iam::123456789012:role/MyRole"),
+            ("us-gov-east-1", "arn:aws-cn:iam::123456789012:role/MyRole"),
+        ],
+    )
+    def test_region_outside_partition(self, region, role_arn):
+        """Test a region of another partition fails loading the configuration."""
+        with pytest.raises(ValueError, match="partition"):
+            Config.from_dict(_partition_config(region, role_arn))
+
+    def test_role_chain_and_source_arns_checked(self):
+        """Test the role chain and source credentials ARNs are checked too."""
+        role_arn = "arn:aws-us-gov:iam::123456789012:role/MyRole"
+        with pytest.raises(ValueError, match="arn:aws:iam::123456789012:role/Hop"):
+            Config.from_dict(
+                _partition_config(
+                    "us-gov-west-1",
+                    role_arn,
+                    role_chain=[{"RoleArn": "arn:aws:iam::123456789012:role/Hop"}],
+                )
+            )
+        with pytest.raises(ValueError, match="arn:aws:iam::123456789012:role/Irsa"):
+            Config.from_dict(
+                _partition_config(
+                    "us-gov-west-1",
+                    role_arn,
+                    source_credentials={
+                        "region": "us-gov-west-1",
+                        "web_identity": {
+                            "token_file": "/var/run/secrets/token",
+                            "role_arn": "arn:aws:iam::123456789012:role/Irsa",
+                        },
+                    },
+                )
+            )
+
+    def test_sts_client_in_role_region(self):
+        """Test STS is called in the region, the SDK resolving its partition."""
+        config = Config.from_dict(
+            _partition_config(
+                "cn-northwest-1", "arn:aws-cn:iam::123456789012:role/MyRole"
+            )
+        )
+        handler = CredentialsHandler(config)
+
+        aws_config = handler._get_aws_config(config.services["test-service"])
+
+        assert aws_config == {"region_name": "cn-northwest-1"}
+        handler.cleanup()
+
+
+class TestPartitions:
+    """Test the partitions of regions and ARNs."""
+
+    def test_arn_partition(self):
+        """Test the partition is read from ARNs."""
+        assert arn_partition("arn:aws:iam::123456789012:role/MyRole") == "aws"
+        assert arn_partition("arn:aws-cn:iam::123456789012:role/MyRole") == "aws-cn"
+
+    def test_unknown_region_not_checked(self):
+        """Test regions the SDK does not know are not checked."""
+        with patch("credproxy.partitions.boto3.Session") as mock_session:
+            mock_session.return_value.get_partition_for_region.side_effect = (
+                BotoCoreError()
+            )
+            check_partition("xx-unknown-9", ["arn:aws:iam::123456789012:role/R"])
+        region_partition.cache_clear()
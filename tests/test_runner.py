@@ -2,14 +2,28 @@
 
 from __future__ import annotations
 
+import io
+import json
 import signal
 from unittest.mock import MagicMock, patch
 
+import pytest
+
+from credproxy.cli import create_parser
+from credproxy.config import Config, TLSConfig, RateLimitConfig
 from credproxy.runner import (
     run_server,
+    reload_config,
+    shutdown_event,
+    give_up_startup,
+    reload_callbacks,
     setup_cli_logging,
+    apply_cli_overrides,
     validate_config_file,
+    run_reload_callbacks,
     setup_signal_handlers,
+    stop_background_services,
+    print_process_credentials,
 )
 
 
@@ -21,34 +35,74 @@ class TestSignalHandlers:
         with patch("signal.signal") as mock_signal:
             setup_signal_handlers()
 
-            # Verify signal.signal was called for SIGTERM and SIGINT
-            assert mock_signal.call_count == 2
+            # Verify signal.signal was called for SIGTERM, SIGINT and SIGHUP
+            assert mock_signal.call_count == 3
             # Check that the calls were made with the right signals
             calls = mock_signal.call_args_list
             signals = [call[0][0] for call in calls]
             assert signal.SIGTERM in signals
             assert signal.SIGINT in signals
+            assert signal.SIGHUP in signals
+
+    def test_signal_handler_requests_drain(self):
+        """Test the signal handler starts the drain instead of exiting."""
+        import credproxy.runner
+
+        credproxy.runner.shutdown_requested = False
+        credproxy.runner.shutdown_event.clear()
+
+        with patch("signal.signal") as mock_signal:
+            captured_handlers = {}
+
+            def capture_handler(sig, handler):
+                captured_handlers[sig] = handler
+
+            mock_signal.side_effect = capture_handler
+            setup_signal_handlers()
 
-    def test_setup_signal_handlers_cleanup_functionality(self):
-        """Test that signal handler cleanup functionality works."""
-        # Test the signal handler function directly
         with patch("sys.exit") as mock_exit:
-            # Import the signal handler function by testing setup_signal_handlers
-            with patch("signal.signal") as mock_signal:
-                captured_handler = None
+            captured_handlers[signal.SIGTERM](signal.SIGTERM, None)
+
+        assert credproxy.runner.shutdown_event.is_set()
+        mock_exit.assert_not_called()
+
+        credproxy.runner.shutdown_requested = False
+        credproxy.runner.shutdown_event.clear()
+
+    def test_sighup_requests_reload(self):
+        """Test SIGHUP requests a reload without shutting down."""
+        import credproxy.runner
 
-                def capture_handler(sig, handler):
-                    nonlocal captured_handler
-                    captured_handler = handler
+        credproxy.runner.reload_event.clear()
+        with patch("signal.signal") as mock_signal:
+            captured_handlers = {}
 
-                mock_signal.side_effect = capture_handler
-                setup_signal_handlers()
+            def capture_handler(sig, handler):
+                captured_handlers[sig] = handler
 
-                # Now test the captured handler
-                if captured_handler:
-                    # Test with no Flask app (should not crash)
-                    captured_handler(signal.SIGTERM, None)
-                    mock_exit.assert_called_with(0)
+            mock_signal.side_effect = capture_handler
+            setup_signal_handlers()
+
+        captured_handlers[signal.SIGHUP](signal.SIGHUP, None)
+
+        assert credproxy.runner.reload_event.is_set()
+        assert not credproxy.runner.shutdown_event.is_set()
+        credproxy.runner.reload_event.clear()
+
+    def test_reload_callbacks_run_after_failure(self):
+        """Test a failing reload callback does not prevent the next ones."""
+        calls = []
+
+        def failing():
+            raise OSError("unreadable certificate")
+
+        reload_callbacks.extend([failing, lambda: calls.append("reloaded")])
+        try:
+            run_reload_callbacks()
+        finally:
+            reload_callbacks.clear()
+
+        assert calls == ["reloaded"]
 
 
 class TestConfigValidation:
@@ -114,352 +168,809 @@ class TestCliLogging:
             mock_handler2.setLevel.assert_called_once()
 
 
+class TestCliOverrides:
+    """Test command-line overrides applied to the loaded configuration."""
+
+    def test_no_overrides_keep_config(self):
+        """Test config values are untouched without CLI overrides."""
+        config = Config()
+        apply_cli_overrides(config, create_parser().parse_args([]))
+
+        assert config.imds.mode == "v2-optional"
+        assert config.credentials.refresh_buffer_seconds == 300
+
+    def test_overrides_applied(self):
+        """Test CLI flags override config values."""
+        config = Config()
+        args = create_parser().parse_args(
+            [
+                "--imds-mode",
+                "v2-required",
+                "--refresh-window",
+                "60",
+                "--refresh-jitter",
+                "15",
+                "--expiry-skew",
+                "30",
+                "--sts-max-attempts",
+                "5",
+                "--request-timeout",
+                "10",
+            ]
+        )
+        apply_cli_overrides(config, args)
+
+        assert config.imds.mode == "v2-required"
+        assert config.credentials.refresh_buffer_seconds == 60
+        assert config.credentials.refresh_jitter_seconds == 15
+        assert config.credentials.expiry_skew_seconds == 30
+        assert config.credentials.sts_max_attempts == 5
+        assert config.credentials.request_timeout == 10
+
+    def test_listen_unix_override(self):
+        """Test --listen-unix sets the unix socket path."""
+        config = Config()
+        args = create_parser().parse_args(["--listen-unix", "/run/credproxy.sock"])
+        apply_cli_overrides(config, args)
+
+        assert config.server.unix_socket == "/run/credproxy.sock"
+
+    def test_listen_pipe_override(self):
+        """Test --listen-pipe sets the named pipe."""
+        config = Config()
+        with patch("credproxy.cli.sys.platform", "win32"):
+            args = create_parser().parse_args(["--listen-pipe", r"\\.\pipe\credproxy"])
+        apply_cli_overrides(config, args)
+
+        assert config.server.named_pipe == r"\\.\pipe\credproxy"
+
+    def test_audit_log_override(self):
+        """Test --audit-log sets the audit file."""
+        config = Config()
+        args = create_parser().parse_args(["--audit-log", "/var/log/credproxy.audit"])
+        apply_cli_overrides(config, args)
+
+        assert config.server.audit_log == "/var/log/credproxy.audit"
+
+    def test_cache_dir_override(self):
+        """Test --cache-dir enables the disk cache, keyed by --cache-key-file."""
+        config = Config()
+        args = create_parser().parse_args(
+            [
+                "--cache-dir",
+                "/var/cache/credproxy",
+                "--cache-key-file",
+                "/run/secrets/cache.key",
+            ]
+        )
+        apply_cli_overrides(config, args)
+
+        assert config.credentials.cache_dir == "/var/cache/credproxy"
+        assert config.credentials.cache_key_file == "/run/secrets/cache.key"
+
+    def test_rate_limit_override(self):
+        """Test --rate-limit, --rate-burst and --rate-limit-sts-only."""
+        config = Config()
+        args = create_parser().parse_args(
+            ["--rate-limit", "0.5", "--rate-burst", "5", "--rate-limit-sts-only"]
+        )
+        apply_cli_overrides(config, args)
+
+        assert config.credentials.rate_limit == RateLimitConfig(
+            requests_per_second=0.5, burst=5, sts_requests_only=True
+        )
+
+    def test_rate_burst_requires_rate_limit(self):
+        """Test --rate-burst is rejected without a rate limit."""
+        args = create_parser().parse_args(["--rate-burst", "5"])
+        with pytest.raises(ValueError):
+            apply_cli_overrides(Config(), args)
+
+    def test_metrics_addr_override(self):
+        """Test --metrics-addr enables the metrics server on the given address."""
+        config = Config()
+        args = create_parser().parse_args(["--metrics-addr", "127.0.0.1:9100"])
+        apply_cli_overrides(config, args)
+
+        assert config.metrics.prometheus.enabled is True
+        assert config.metrics.prometheus.host == "127.0.0.1"
+        assert config.metrics.prometheus.port == 9100
+
+    def test_sts_overrides_applied_to_all_services(self):
+        """Test --region and --sts-endpoint override every service and defaults."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "test-service": {
+                        "auth_token": "test-token",
+                        "source_credentials": {"region": "cn-northwest-1"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws-cn:iam::123456789012:role/TestRole"
+                        },
+                    }
+                }
+            }
+        )
+        args = create_parser().parse_args(
+            [
+                "--region",
+                "cn-north-1",
+                "--sts-endpoint",
+                "https://sts.cn-north-1.amazonaws.com.cn",
+            ]
+        )
+        apply_cli_overrides(config, args)
+
+        for source_credentials in (
+            config.aws_defaults,
+            config.services["test-service"].source_credentials,
+        ):
+            assert source_credentials.region == "cn-north-1"
+            assert source_credentials.sts_endpoint == (
+                "https://sts.cn-north-1.amazonaws.com.cn"
+            )
+
+    def test_region_override_partition_checked(self):
+        """Test --region is checked against the partition of the role ARNs."""
+        config = Config.from_dict(
+            {
+                "services": {
+                    "gov-app": {
+                        "auth_token": "gov-app-token",
+                        "source_credentials": {"region": "us-gov-west-1"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws-us-gov:iam::123456789012:role/GovApp"
+                        },
+                    }
+                }
+            }
+        )
+        args = create_parser().parse_args(["--region", "us-east-1"])
+
+        with pytest.raises(ValueError, match="Invalid service 'gov-app'"):
+            apply_cli_overrides(config, args)
+
+    def test_shutdown_timeout_override(self):
+        """Test --shutdown-timeout sets the in-flight requests drain timeout."""
+        config = Config()
+        args = create_parser().parse_args(["--shutdown-timeout", "2.5"])
+        apply_cli_overrides(config, args)
+
+        assert config.server.shutdown_timeout == 2.5
+
+    def test_tls_overrides(self):
+        """Test --tls-cert and --tls-key enable TLS, keeping the client CA."""
+        config = Config()
+        config.server.tls = TLSConfig(
+            "/etc/tls/old.pem", "/etc/tls/old-key.pem", "/etc/tls/clients.pem"
+        )
+        args = create_parser().parse_args(
+            ["--tls-cert", "/etc/tls/new.pem", "--tls-key", "/etc/tls/new-key.pem"]
+        )
+        apply_cli_overrides(config, args)
+
+        assert config.server.tls == TLSConfig(
+            "/etc/tls/new.pem", "/etc/tls/new-key.pem", "/etc/tls/clients.pem"
+        )
+
+    def test_tls_client_ca_requires_tls(self):
+        """Test --tls-client-ca fails without a server certificate."""
+        args = create_parser().parse_args(["--tls-client-ca", "/etc/tls/ca.pem"])
+
+        with pytest.raises(ValueError):
+            apply_cli_overrides(Config(), args)
+
+
+def _service(token: str, role: str) -> dict:
+    return {
+        "auth_token": token,
+        "source_credentials": {"region": "us-west-2"},
+        "assumed_role": {"RoleArn": f"arn:aws:iam::123456789012:role/{role}"},
+    }
+
+
+class TestReloadConfig:
+    """Test reloading the configuration file on SIGHUP."""
+
+    def _reload(self, tmp_path, services: dict, **settings) -> tuple[bool, MagicMock]:
+        """Write services to the config file and reload the app from it."""
+        config_file = tmp_path / "config.json"
+        config_file.write_text(json.dumps({"services": services, **settings}))
+        args = create_parser().parse_args(["--config", str(config_file)])
+        self.app.config["credentials_handler"].reset_mock()
+        result = reload_config(self.app, args)
+        return result, self.app.config["credentials_handler"]
+
+    def _start(self, tmp_path) -> Config:
+        config_file = tmp_path / "config.json"
+        config_file.write_text(
+            json.dumps(
+                {
+                    "services": {
+                        "kept": _service("kept-token", "KeptRole"),
+                        "changed": _service("changed-token", "OldRole"),
+                        "removed": _service("removed-token", "RemovedRole"),
+                    }
+                }
+            )
+        )
+        config = Config.from_file(str(config_file))
+        self.app = MagicMock()
+        self.app.config = {
+            "credproxy_config": config,
+            "credentials_handler": MagicMock(),
+        }
+        return config
+
+    def test_services_diffed(self, tmp_path):
+        """Test added, removed and changed services are applied."""
+        config = self._start(tmp_path)
+        kept = config.services["kept"]
+
+        result, credentials_handler = self._reload(
+            tmp_path,
+            {
+                "kept": _service("kept-token", "KeptRole"),
+                "changed": _service("changed-token", "NewRole"),
+                "added": _service("added-token", "AddedRole"),
+            },
+            credentials={"retry_delay": 5},
+        )
+
+        assert result is True
+        assert set(config.services) == {"kept", "changed", "added"}
+        assert config.services["kept"] is kept
+        assert config.services["changed"].assumed_role.RoleArn.endswith("/NewRole")
+        assert config.credentials.retry_delay == 5
+        assert config.get_service_name_by_token("added-token") == "added"
+        assert config.get_service_name_by_token("removed-token") is None
+        # Only the removed and changed services lose their cached credentials
+        assert sorted(
+            call.args[0]
+            for call in credentials_handler.discard_credentials.call_args_list
+        ) == ["changed", "removed"]
+
+    def test_dynamic_services_kept(self, tmp_path):
+        """Test services of the dynamic services directories are not removed."""
+        config = self._start(tmp_path)
+        dynamic = Config.from_dict({"services": {"dynamic": _service("d", "Role")}})
+        dynamic.services["dynamic"].source_file = str(tmp_path / "dynamic.yaml")
+        config.add_service("dynamic", dynamic.services["dynamic"])
+
+        self._reload(tmp_path, {"kept": _service("kept-token", "KeptRole")})
+
+        assert set(config.services) == {"kept", "dynamic"}
+
+    def test_invalid_config_kept(self, tmp_path):
+        """Test the current configuration is kept if the file fails validation."""
+        config = self._start(tmp_path)
+
+        result, credentials_handler = self._reload(
+            tmp_path, {"kept": {"auth_token": "kept-token"}}
+        )
+
+        assert result is False
+        assert set(config.services) == {"kept", "changed", "removed"}
+        credentials_handler.discard_credentials.assert_not_called()
+
+
 class TestRunServer:
     """Test run_server function."""
 
+    @patch("credproxy.runner.CredProxyServer")
     @patch("credproxy.runner.init_app")
     @patch("credproxy.runner.Config.from_file")
     @patch("credproxy.runner.setup_signal_handlers")
     def test_run_server_success(
-        self, mock_setup_signals, mock_config_from_file, mock_init_app
+        self,
+        mock_setup_signals,
+        mock_config_from_file,
+        mock_init_app,
+        mock_server,
     ):
         """Test successful server run."""
         # Setup mocks
         mock_args = MagicMock()
         mock_args.config = "test_config.yaml"
         mock_args.dev = False
+        mock_args.listen_unix = None
+        mock_args.listen_pipe = None
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
 
         mock_config = MagicMock()
         mock_config.server.host = "localhost"
         mock_config.server.port = 8080
         mock_config.server.debug = False
+        mock_config.server.unix_socket = None
+        mock_config.server.named_pipe = None
+        mock_config.server.tls = None
         mock_config_from_file.return_value = mock_config
 
         mock_app = MagicMock()
         mock_init_app.return_value = mock_app
 
-        # Mock app.run to avoid actually starting server
-        mock_app.run = MagicMock()
-
         result = run_server(mock_args)
 
         assert result == 0
         mock_setup_signals.assert_called_once()
         mock_config_from_file.assert_called_once_with("test_config.yaml")
         mock_init_app.assert_called_once_with(mock_config)
-        mock_app.run.assert_called_once_with(host="localhost", port=8080, debug=False)
-
+        mock_server.assert_called_once_with(
+            mock_app,
+            "localhost",
+            8080,
+            shutdown_timeout=mock_config.server.shutdown_timeout,
+            ssl_context=None,
+            read_timeout=mock_config.server.read_timeout,
+            write_timeout=mock_config.server.write_timeout,
+            idle_timeout=mock_config.server.idle_timeout,
+        )
+        mock_server.return_value.serve_until.assert_called_once()
+        assert mock_app.debug is False
+
+    @patch("credproxy.runner.CredProxyServer")
     @patch("credproxy.runner.init_app")
     @patch("credproxy.runner.Config.from_file")
     @patch("credproxy.runner.setup_signal_handlers")
     def test_run_server_with_dev_flag(
-        self, mock_setup_signals, mock_config_from_file, mock_init_app
+        self,
+        mock_setup_signals,
+        mock_config_from_file,
+        mock_init_app,
+        mock_server,
     ):
         """Test server run with dev flag overriding config debug."""
         mock_args = MagicMock()
         mock_args.config = "test_config.yaml"
         mock_args.dev = True
+        mock_args.listen_unix = None
+        mock_args.listen_pipe = None
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
 
         mock_config = MagicMock()
         mock_config.server.host = "localhost"
         mock_config.server.port = 8080
         mock_config.server.debug = False  # Config debug is False
+        mock_config.server.unix_socket = None
+        mock_config.server.named_pipe = None
+        mock_config.server.tls = None
         mock_config_from_file.return_value = mock_config
 
         mock_app = MagicMock()
         mock_init_app.return_value = mock_app
-        mock_app.run = MagicMock()
 
         result = run_server(mock_args)
 
         assert result == 0
         # Debug should be True due to --dev flag
-        mock_app.run.assert_called_once_with(host="localhost", port=8080, debug=True)
+        assert mock_app.debug is True
 
+    @patch("credproxy.runner.CredProxyServer")
+    @patch("credproxy.runner.UnixSocketServer")
+    @patch("credproxy.runner.init_app")
+    @patch("credproxy.runner.Config.from_file")
+    @patch("credproxy.runner.setup_signal_handlers")
+    def test_run_server_with_unix_socket(
+        self,
+        mock_setup_signals,
+        mock_config_from_file,
+        mock_init_app,
+        mock_unix_server,
+        mock_server,
+    ):
+        """Test the unix socket is served alongside TCP and stopped on exit."""
+        mock_args = MagicMock()
+        mock_args.config = "test_config.yaml"
+        mock_args.dev = False
+        mock_args.listen_unix = "/run/credproxy.sock"
+        mock_args.listen_pipe = None
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
+
+        mock_config = MagicMock()
+        mock_config.server.host = "localhost"
+        mock_config.server.port = 8080
+        mock_config.server.debug = False
+        mock_config.server.tls = None
+        mock_config.server.named_pipe = None
+        mock_config_from_file.return_value = mock_config
+
+        mock_app = MagicMock()
+        mock_init_app.return_value = mock_app
+
+        result = run_server(mock_args)
+
+        assert result == 0
+        mock_unix_server.assert_called_once_with(mock_app, "/run/credproxy.sock")
+        mock_unix_server.return_value.start.assert_called_once()
+        mock_unix_server.return_value.stop.assert_called_once()
+        mock_server.assert_called_once_with(
+            mock_app,
+            "localhost",
+            8080,
+            shutdown_timeout=mock_config.server.shutdown_timeout,
+            ssl_context=None,
+            read_timeout=mock_config.server.read_timeout,
+            write_timeout=mock_config.server.write_timeout,
+            idle_timeout=mock_config.server.idle_timeout,
+        )
+        mock_server.return_value.serve_until.assert_called_once()
+        assert mock_app.debug is False
+
+    @patch("credproxy.runner.CredProxyServer")
+    @patch("credproxy.runner.NamedPipeServer")
+    @patch("credproxy.runner.init_app")
+    @patch("credproxy.runner.Config.from_file")
+    @patch("credproxy.runner.setup_signal_handlers")
+    def test_run_server_with_named_pipe(
+        self,
+        mock_setup_signals,
+        mock_config_from_file,
+        mock_init_app,
+        mock_pipe_server,
+        mock_server,
+    ):
+        """Test the named pipe is served alongside TCP and stopped on exit."""
+        mock_args = MagicMock()
+        mock_args.config = "test_config.yaml"
+        mock_args.dev = False
+        mock_args.listen_unix = None
+        mock_args.listen_pipe = r"\\.\pipe\credproxy"
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
+
+        mock_config = MagicMock()
+        mock_config.server.host = "localhost"
+        mock_config.server.port = 8080
+        mock_config.server.debug = False
+        mock_config.server.tls = None
+        mock_config.server.unix_socket = None
+        mock_config_from_file.return_value = mock_config
+
+        mock_app = MagicMock()
+        mock_init_app.return_value = mock_app
+
+        result = run_server(mock_args)
+
+        assert result == 0
+        mock_pipe_server.assert_called_once_with(mock_app, r"\\.\pipe\credproxy")
+        mock_pipe_server.return_value.start.assert_called_once()
+        mock_pipe_server.return_value.stop.assert_called_once()
+        mock_server.assert_called_once_with(
+            mock_app,
+            "localhost",
+            8080,
+            shutdown_timeout=mock_config.server.shutdown_timeout,
+            ssl_context=None,
+            read_timeout=mock_config.server.read_timeout,
+            write_timeout=mock_config.server.write_timeout,
+            idle_timeout=mock_config.server.idle_timeout,
+        )
+        mock_server.return_value.serve_until.assert_called_once()
+        assert mock_app.debug is False
+
+    @patch("credproxy.runner.CredProxyServer")
     @patch("credproxy.runner.init_app")
     @patch("credproxy.runner.Config.from_file")
     @patch("credproxy.runner.setup_signal_handlers")
     def test_run_server_keyboard_interrupt(
-        self, mock_setup_signals, mock_config_from_file, mock_init_app
+        self,
+        mock_setup_signals,
+        mock_config_from_file,
+        mock_init_app,
+        mock_server,
     ):
         """Test server run handles KeyboardInterrupt."""
         mock_args = MagicMock()
         mock_args.config = "test_config.yaml"
         mock_args.dev = False
+        mock_args.listen_unix = None
+        mock_args.listen_pipe = None
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
 
         mock_config = MagicMock()
         mock_config.server.host = "localhost"
         mock_config.server.port = 8080
         mock_config.server.debug = False
+        mock_config.server.unix_socket = None
+        mock_config.server.named_pipe = None
+        mock_config.server.tls = None
         mock_config_from_file.return_value = mock_config
 
         mock_app = MagicMock()
         mock_init_app.return_value = mock_app
-        mock_app.run.side_effect = KeyboardInterrupt()
+        mock_server.return_value.serve_until.side_effect = KeyboardInterrupt()
 
         result = run_server(mock_args)
 
         assert result == 0
 
+    @patch("credproxy.runner.CredProxyServer")
     @patch("credproxy.runner.init_app")
     @patch("credproxy.runner.Config.from_file")
     @patch("credproxy.runner.setup_signal_handlers")
     def test_run_server_general_exception(
-        self, mock_setup_signals, mock_config_from_file, mock_init_app
+        self,
+        mock_setup_signals,
+        mock_config_from_file,
+        mock_init_app,
+        mock_server,
     ):
         """Test server run handles general exceptions."""
         mock_args = MagicMock()
         mock_args.config = "test_config.yaml"
         mock_args.dev = False
+        mock_args.listen_unix = None
+        mock_args.listen_pipe = None
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
 
         mock_config = MagicMock()
         mock_config.server.host = "localhost"
         mock_config.server.port = 8080
         mock_config.server.debug = False
+        mock_config.server.unix_socket = None
+        mock_config.server.named_pipe = None
+        mock_config.server.tls = None
         mock_config_from_file.return_value = mock_config
 
         mock_app = MagicMock()
         mock_init_app.return_value = mock_app
-        mock_app.run.side_effect = Exception("Server error")
+        mock_server.return_value.serve_until.side_effect = Exception("Server error")
 
         result = run_server(mock_args)
 
         assert result == 1
 
+    @patch("credproxy.runner.stop_background_services")
+    @patch("credproxy.runner.CredProxyServer")
+    @patch("credproxy.runner.init_app")
     @patch("credproxy.runner.Config.from_file")
     @patch("credproxy.runner.setup_signal_handlers")
-    def test_run_server_config_error(self, mock_setup_signals, mock_config_from_file):
-        """Test server run handles config loading errors."""
+    def test_run_server_shutdown_timeout(
+        self,
+        mock_setup_signals,
+        mock_config_from_file,
+        mock_init_app,
+        mock_server,
+        mock_stop_background,
+    ):
+        """Test requests still in flight after the drain timeout fail the exit."""
         mock_args = MagicMock()
-        mock_args.config = "nonexistent.yaml"
+        mock_args.config = "test_config.yaml"
         mock_args.dev = False
+        mock_args.listen_unix = None
+        mock_args.listen_pipe = None
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
 
-        mock_config_from_file.side_effect = FileNotFoundError("Config not found")
+        mock_config = MagicMock()
+        mock_config.server.host = "localhost"
+        mock_config.server.port = 8080
+        mock_config.server.debug = False
+        mock_config.server.unix_socket = None
+        mock_config.server.named_pipe = None
+        mock_config.server.tls = None
+        mock_config_from_file.return_value = mock_config
+
+        mock_app = MagicMock()
+        mock_init_app.return_value = mock_app
+        mock_server.return_value.serve_until.return_value = False
 
         result = run_server(mock_args)
 
         assert result == 1
-        mock_setup_signals.assert_called_once()
-        mock_config_from_file.assert_called_once_with("nonexistent.yaml")
-
-    def test_signal_handler_duplicate_shutdown(self):
-        """Test signal handler handles duplicate shutdown signals."""
-        # Import the signal handler function
-
-        # Reset global state
-        import credproxy.runner
+        mock_stop_background.assert_called_once_with(mock_app)
 
-        credproxy.runner.shutdown_requested = False
+    @patch("credproxy.runner.stop_background_services")
+    @patch("credproxy.runner.CredProxyServer")
+    @patch("credproxy.runner.init_app")
+    @patch("credproxy.runner.Config.from_file")
+    @patch("credproxy.runner.setup_signal_handlers")
+    def test_run_server_startup_max_wait(
+        self,
+        mock_setup_signals,
+        mock_config_from_file,
+        mock_init_app,
+        mock_server,
+        mock_stop_background,
+    ):
+        """Test credentials still failing after the startup wait fail the exit."""
+        mock_args = MagicMock()
+        mock_args.config = "test_config.yaml"
+        mock_args.dev = False
+        mock_args.listen_unix = None
+        mock_args.listen_pipe = None
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
+        mock_args.startup_max_wait = 120
 
-        # Get the signal handler by calling setup_signal_handlers
-        with patch("signal.signal") as mock_signal:
-            captured_handler = None
+        mock_config = MagicMock()
+        mock_config.server.unix_socket = None
+        mock_config.server.named_pipe = None
+        mock_config.server.tls = None
+        mock_config_from_file.return_value = mock_config
 
-            def capture_handler(sig, handler):
-                nonlocal captured_handler
-                captured_handler = handler
+        mock_app = MagicMock()
+        mock_init_app.return_value = mock_app
+        credentials_handler = mock_app.config.__getitem__.return_value
+        credentials_handler.start_startup_fetches.side_effect = (
+            lambda max_wait, on_give_up: on_give_up()
+        )
+        mock_server.return_value.serve_until.return_value = True
 
-            mock_signal.side_effect = capture_handler
-            setup_signal_handlers()
+        try:
+            result = run_server(mock_args)
+        finally:
+            shutdown_event.clear()
 
-            # Now test the captured handler with duplicate signals
-            if captured_handler:
-                with patch("sys.exit") as mock_exit:
-                    # First signal should set shutdown_requested and call exit
-                    captured_handler(signal.SIGTERM, None)
-                    assert credproxy.runner.shutdown_requested is True
-                    mock_exit.assert_called_with(0)
-
-                    # Reset mock for second call
-                    mock_exit.reset_mock()
-
-                    # Second signal should exit early (line 35->exit)
-                    captured_handler(signal.SIGTERM, None)
-                    # Should not call exit again since shutdown already requested
-                    mock_exit.assert_not_called()
-
-    def test_signal_handler_cleanup_exception_handling(self):
-        """Test signal handler exception handling in cleanup block."""
-        # Import the signal handler function
-        import credproxy.runner
+        assert result == 1
+        credentials_handler.start_startup_fetches.assert_called_once_with(
+            120, give_up_startup
+        )
+        assert mock_config.credentials.startup_max_wait == 120
 
-        credproxy.runner.shutdown_requested = False
+    @patch("credproxy.runner.Config.from_file")
+    @patch("credproxy.runner.setup_signal_handlers")
+    def test_run_server_config_error(self, mock_setup_signals, mock_config_from_file):
+        """Test server run handles config loading errors."""
+        mock_args = MagicMock()
+        mock_args.config = "nonexistent.yaml"
+        mock_args.dev = False
+        mock_args.listen_unix = None
+        mock_args.listen_pipe = None
+        mock_args.metrics_addr = None
+        mock_args.tls_cert = None
+        mock_args.tls_client_ca = None
 
-        # Get the signal handler by calling setup_signal_handlers
-        with patch("signal.signal") as mock_signal:
-            captured_handler = None
+        mock_config_from_file.side_effect = FileNotFoundError("Config not found")
 
-            def capture_handler(sig, handler):
-                nonlocal captured_handler
-                captured_handler = handler
+        result = run_server(mock_args)
 
-            mock_signal.side_effect = capture_handler
-            setup_signal_handlers()
+        assert result == 1
+        mock_setup_signals.assert_called_once()
+        mock_config_from_file.assert_called_once_with("nonexistent.yaml")
 
-            # Now test the captured handler triggers exception handling
-            if captured_handler:
-                with patch("sys.exit") as mock_exit:
-                    # Create a mock current_app that raises exception when accessed
-                    mock_current_app = MagicMock()
-                    mock_current_app.config.get.side_effect = RuntimeError(
-                        "Flask cleanup error"
-                    )
-
-                    with patch("flask.current_app", mock_current_app):
-                        # Should handle exception gracefully (lines 51-53)
-                        captured_handler(signal.SIGTERM, None)
-
-                        # Should still exit despite exception
-                        mock_exit.assert_called_with(0)
-
-    def test_signal_handler_no_flask_app(self):
-        """Test signal handler when no Flask app is available."""
-        # Import the signal handler function
+    def test_signal_handler_duplicate_shutdown(self):
+        """Test signal handler handles duplicate shutdown signals."""
         import credproxy.runner
 
         credproxy.runner.shutdown_requested = False
+        credproxy.runner.shutdown_event.clear()
 
-        # Get the signal handler by calling setup_signal_handlers
         with patch("signal.signal") as mock_signal:
-            captured_handler = None
+            captured_handlers = {}
 
             def capture_handler(sig, handler):
-                nonlocal captured_handler
-                captured_handler = handler
+                captured_handlers[sig] = handler
 
             mock_signal.side_effect = capture_handler
             setup_signal_handlers()
 
-            # Now test the captured handler with no Flask app
-            if captured_handler:
-                with patch("sys.exit") as mock_exit:
-                    with patch("flask.current_app", None):
-                        # Should handle missing Flask app gracefully (lines 43-46)
-                        captured_handler(signal.SIGTERM, None)
-
-                        # Should still exit despite missing Flask app
-                        mock_exit.assert_called_with(0)
+        with patch("credproxy.runner.LOG") as mock_log:
+            # First signal requests the drain
+            captured_handlers[signal.SIGTERM](signal.SIGTERM, None)
+            assert credproxy.runner.shutdown_requested is True
+            assert credproxy.runner.shutdown_event.is_set()
 
-    def test_signal_handler_credentials_cleanup(self):
-        """Test signal handler credentials cleanup (lines 45-46)."""
-        # Import the signal handler function
-        import credproxy.runner
+            # Second signal is ignored while draining
+            captured_handlers[signal.SIGINT](signal.SIGINT, None)
+            mock_log.info.assert_called_once()
 
         credproxy.runner.shutdown_requested = False
+        credproxy.runner.shutdown_event.clear()
 
-        # Get the signal handler by calling setup_signal_handlers
-        with patch("signal.signal") as mock_signal:
-            captured_handler = None
 
-            def capture_handler(sig, handler):
-                nonlocal captured_handler
-                captured_handler = handler
+class TestStopBackgroundServices:
+    """Test stopping the app background services after the drain."""
 
-            mock_signal.side_effect = capture_handler
-            setup_signal_handlers()
+    def _app(self, credentials_handler=None, file_watcher=None) -> MagicMock:
+        mock_app = MagicMock()
+        mock_app.config.get.side_effect = lambda key, default=None: {
+            "credentials_handler": credentials_handler,
+            "file_watcher": file_watcher,
+        }.get(key, default)
+        return mock_app
+
+    def test_both_components_stopped(self):
+        """Test the credentials refresher and file watcher are stopped."""
+        mock_credentials_handler = MagicMock()
+        mock_file_watcher = MagicMock()
+
+        with patch("credproxy.runner.terminate_children") as mock_terminate:
+            stop_background_services(
+                self._app(mock_credentials_handler, mock_file_watcher)
+            )
+
+        mock_credentials_handler.cleanup.assert_called_once()
+        mock_file_watcher.stop.assert_called_once()
+        mock_terminate.assert_called_once()
+
+    def test_missing_components(self):
+        """Test apps without background services are handled."""
+        stop_background_services(self._app())
+
+    def test_cleanup_exception_handling(self):
+        """Test cleanup errors do not prevent the shutdown."""
+        mock_app = MagicMock()
+        mock_app.config.get.side_effect = RuntimeError("Flask cleanup error")
 
-            # Now test the captured handler with credentials cleanup
-            if captured_handler:
-                with patch("sys.exit") as mock_exit:
-                    # Mock Flask app with credentials handler
-                    mock_credentials_handler = MagicMock()
-                    mock_credentials_handler.cleanup = MagicMock()
-
-                    mock_current_app = MagicMock()
-                    mock_current_app.config.get.side_effect = (
-                        lambda key, default=None: {
-                            "credentials_handler": mock_credentials_handler,
-                            "file_watcher": None,
-                        }.get(key, default)
-                    )
-
-                    with patch("flask.current_app", mock_current_app):
-                        captured_handler(signal.SIGTERM, None)
-
-                        # Verify credentials cleanup was called
-                        mock_credentials_handler.cleanup.assert_called_once()
-                        mock_exit.assert_called_with(0)
-
-    def test_signal_handler_file_watcher_cleanup(self):
-        """Test signal handler file watcher cleanup (lines 48-50)."""
-        # Import the signal handler function
-        import credproxy.runner
+        stop_background_services(mock_app)
 
-        credproxy.runner.shutdown_requested = False
 
-        # Get the signal handler by calling setup_signal_handlers
-        with patch("signal.signal") as mock_signal:
-            captured_handler = None
+class TestPrintProcessCredentials:
+    """Test printing credentials in the credential_process format."""
 
-            def capture_handler(sig, handler):
-                nonlocal captured_handler
-                captured_handler = handler
+    def _args(self, service: str = "my-app"):
+        return create_parser().parse_args(
+            ["credentials", "--service", service, "--config", "config.yaml"]
+        )
 
-            mock_signal.side_effect = capture_handler
-            setup_signal_handlers()
+    @patch("credproxy.runner.CredentialsHandler")
+    @patch("credproxy.runner.Config.from_file")
+    def test_credentials_printed(self, mock_config_from_file, mock_handler):
+        """Test the version 1 credential_process JSON is printed on stdout."""
+        mock_config_from_file.return_value.services = {"my-app": MagicMock()}
+        mock_config_from_file.return_value.credentials.cache_dir = None
+        mock_handler.return_value.get_credentials.return_value = {
+            "AccessKeyId": "ASIAPROCESSKEY",
+            "SecretAccessKey": "process-secret",
+            "Token": "process-session-token",
+            "Expiration": "2025-01-01T12:00:00Z",
+            "RoleArn": "arn:aws:iam::123456789012:role/MyAppRole",
+        }
+
+        with patch("sys.stdout", new_callable=io.StringIO) as mock_stdout:
+            result = print_process_credentials(self._args())
 
-            # Now test the captured handler with file watcher cleanup
-            if captured_handler:
-                with patch("sys.exit") as mock_exit:
-                    # Mock Flask app with file watcher
-                    mock_file_watcher = MagicMock()
-                    mock_file_watcher.stop = MagicMock()
-
-                    mock_current_app = MagicMock()
-                    mock_current_app.config.get.side_effect = (
-                        lambda key, default=None: {
-                            "credentials_handler": None,
-                            "file_watcher": mock_file_watcher,
-                        }.get(key, default)
-                    )
-
-                    with patch("flask.current_app", mock_current_app):
-                        captured_handler(signal.SIGTERM, None)
-
-                        # Verify file watcher cleanup was called
-                        mock_file_watcher.stop.assert_called_once()
-                        mock_exit.assert_called_with(0)
-
-    def test_signal_handler_both_components_cleanup(self):
-        """Test signal handler cleanup of both components (lines 45-50)."""
-        # Import the signal handler function
-        import credproxy.runner
+        assert result == 0
+        assert json.loads(mock_stdout.getvalue()) == {
+            "Version": 1,
+            "AccessKeyId": "ASIAPROCESSKEY",
+            "SecretAccessKey": "process-secret",
+            "SessionToken": "process-session-token",
+            "Expiration": "2025-01-01T12:00:00Z",
+        }
+        mock_config_from_file.assert_called_once_with("config.yaml")
+        mock_handler.return_value.get_credentials.assert_called_once_with("my-app")
+        mock_handler.return_value.cleanup.assert_called_once()
+
+    @patch("credproxy.runner.CredentialsHandler")
+    @patch("credproxy.runner.Config.from_file")
+    def test_unknown_service(self, mock_config_from_file, mock_handler):
+        """Test an unknown service fails without printing anything."""
+        mock_config_from_file.return_value.services = {"my-app": MagicMock()}
+        mock_config_from_file.return_value.credentials.cache_dir = None
 
-        credproxy.runner.shutdown_requested = False
+        with patch("sys.stdout", new_callable=io.StringIO) as mock_stdout:
+            result = print_process_credentials(self._args("other-app"))
 
-        # Get the signal handler by calling setup_signal_handlers
-        with patch("signal.signal") as mock_signal:
-            captured_handler = None
+        assert result == 1
+        assert mock_stdout.getvalue() == ""
+        mock_handler.assert_not_called()
 
-            def capture_handler(sig, handler):
-                nonlocal captured_handler
-                captured_handler = handler
+    @patch("credproxy.runner.CredentialsHandler")
+    @patch("credproxy.runner.Config.from_file")
+    def test_credentials_error(self, mock_config_from_file, mock_handler):
+        """Test failing to get the credentials exits non-zero."""
+        mock_config_from_file.return_value.services = {"my-app": MagicMock()}
+        mock_config_from_file.return_value.credentials.cache_dir = None
+        mock_handler.return_value.get_credentials.side_effect = Exception("denied")
 
-            mock_signal.side_effect = capture_handler
-            setup_signal_handlers()
+        with patch("sys.stdout", new_callable=io.StringIO) as mock_stdout:
+            result = print_process_credentials(self._args())
 
-            # Now test the captured handler with both components
-            if captured_handler:
-                with patch("sys.exit") as mock_exit:
-                    # Mock Flask app with both components
-                    mock_credentials_handler = MagicMock()
-                    mock_credentials_handler.cleanup = MagicMock()
-
-                    mock_file_watcher = MagicMock()
-                    mock_file_watcher.stop = MagicMock()
-
-                    mock_current_app = MagicMock()
-                    mock_current_app.config.get.side_effect = (
-                        lambda key, default=None: {
-                            "credentials_handler": mock_credentials_handler,
-                            "file_watcher": mock_file_watcher,
-                        }.get(key, default)
-                    )
-
-                    with patch("flask.current_app", mock_current_app):
-                        captured_handler(signal.SIGTERM, None)
-
-                        # Verify both cleanups were called
-                        mock_credentials_handler.cleanup.assert_called_once()
-                        mock_file_watcher.stop.assert_called_once()
-                        mock_exit.assert_called_with(0)
+        assert result == 1
+        assert mock_stdout.getvalue() == ""
+        mock_handler.return_value.cleanup.assert_called_once()
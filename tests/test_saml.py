@@ -0,0 +1,205 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for SAML federation source credentials."""
+
+from __future__ import annotations
+
+import base64
+import subprocess
+from dataclasses import replace
+from datetime import datetime, timezone, timedelta
+from unittest.mock import MagicMock, patch
+
+import pytest
+
+from credproxy.saml import (
+    SAMLAssertionError,
+    SAMLAssertionProvider,
+    check_assertion,
+    encode_assertion,
+)
+from tests.mock_aws import mock_config
+from credproxy.config import Config
+from credproxy.credentials_handler import CredentialsHandler
+
+
+PRINCIPAL_ARN = "arn:aws:iam::123456789012:saml-provider/corporate-idp"
+ROLE_ARN = "arn:aws:iam::123456789012:role/FederatedRole"
+OTHER_ROLE_ARN = "arn:aws:iam::123456789012:role/OtherRole"
+
+
+def _saml_response(role_arn: str = ROLE_ARN, expires: datetime | None = None) -> str:
+    """Build a SAML response listing role_arn, expiring in an hour by default."""
+    expires = expires or datetime.now(timezone.utc) + timedelta(hours=1)
+    not_on_or_after = expires.strftime("%Y-%m-%dT%H:%M:%SZ")
+    return (
+        '<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"'
+        ' xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">'
+        "<saml:Assertion>"
+        f'<saml:Conditions NotOnOrAfter="{not_on_or_after}"/>'
+        "<saml:AttributeStatement>"
+        '<saml:Attribute Name="https://aws.amazon.com/SAML/Attributes/Role">'
+        f"<saml:AttributeValue>{role_arn},{PRINCIPAL_ARN}</saml:AttributeValue>"
+        "</saml:Attribute>"
+        "</saml:AttributeStatement>"
+        "</saml:Assertion>"
+        "</samlp:Response>"
+    )
+
+
+def _encoded(response: str) -> str:
+    return base64.b64encode(response.encode()).decode()
+
+
+def _mock_sts_client() -> MagicMock:
+    """Build an STS client answering AssumeRoleWithSAML."""
+    sts_client = MagicMock()
+    sts_client.assume_role_with_saml.return_value = {
+        "Credentials": {
+            "AccessKeyId": "ASIASAMLKEY",
+            "SecretAccessKey": "saml-secret",
+            "SessionToken": "saml-session-token",
+            "Expiration": datetime.now(timezone.utc) + timedelta(hours=1),
+        }
+    }
+    return sts_client
+
+
+class TestSAMLAssertion:
+    """Test encoding and checking SAML responses."""
+
+    def test_xml_and_base64_encoded(self):
+        """Test XML responses are encoded, base64 responses unwrapped."""
+        response = _saml_response()
+        encoded = _encoded(response)
+        wrapped = "\n".join(encoded[i : i + 64] for i in range(0, len(encoded), 64))
+
+        assert encode_assertion(response + "\n") == encoded
+        assert encode_assertion(wrapped) == encoded
+        with pytest.raises(SAMLAssertionError):
+            encode_assertion("not a SAML response!")
+
+    def test_expired_assertion_rejected(self):
+        """Test an expired assertion fails before calling STS."""
+        expired = datetime.now(timezone.utc) - timedelta(minutes=5)
+        encoded = _encoded(_saml_response(expires=expired))
+
+        with pytest.raises(SAMLAssertionError, match="expired at"):
+            check_assertion(encoded, ROLE_ARN, PRINCIPAL_ARN)
+
+    def test_role_not_listed_rejected(self):
+        """Test an assertion must list the role with its SAML provider."""
+        encoded = _encoded(_saml_response())
+
+        check_assertion(encoded, ROLE_ARN, PRINCIPAL_ARN)
+        with pytest.raises(SAMLAssertionError, match="does not list role"):
+            check_assertion(encoded, OTHER_ROLE_ARN, PRINCIPAL_ARN)
+
+
+class TestSAMLAssertionProvider:
+    """Test getting the assertion and assuming the role."""
+
+    def test_assertion_command(self):
+        """Test the assertion is read from the command stdout."""
+        provider = SAMLAssertionProvider(
+            PRINCIPAL_ARN, ROLE_ARN, assertion_command="saml-login --print"
+        )
+
+        with patch("credproxy.saml.run_command") as mock_run:
+            mock_run.return_value = _saml_response()
+            assert provider.read_assertion() == _encoded(_saml_response())
+
+        assert mock_run.call_args.args[0] == ["saml-login", "--print"]
+
+    def test_failed_assertion_command(self):
+        """Test a failing command is reported with its exit code."""
+        provider = SAMLAssertionProvider(
+            PRINCIPAL_ARN, ROLE_ARN, assertion_command="saml-login"
+        )
+
+        with patch(
+            "credproxy.saml.run_command",
+            side_effect=subprocess.CalledProcessError(2, ["saml-login"]),
+        ):
+            with pytest.raises(SAMLAssertionError, match="exited with code 2"):
+                provider.read_assertion()
+
+    def test_role_session_reused(self, tmp_path):
+        """Test the assertion is only read again once the session expires."""
+        assertion_file = tmp_path / "assertion.xml"
+        assertion_file.write_text(_saml_response())
+        provider = SAMLAssertionProvider(
+            PRINCIPAL_ARN, ROLE_ARN, assertion_file=str(assertion_file)
+        )
+        sts_client = _mock_sts_client()
+
+        with patch("credproxy.saml.boto3.client", return_value=sts_client):
+            result = provider.retrieve()
+            assert provider.retrieve() == result
+            provider._credentials = replace(result, expiration=0.0)
+            provider.retrieve()
+
+        assert result.to_aws_config() == {
+            "aws_access_key_id": "ASIASAMLKEY",
+            "aws_secret_access_key": "saml-secret",
+            "aws_session_token": "saml-session-token",
+        }
+        assert sts_client.assume_role_with_saml.call_count == 2
+        sts_client.assume_role_with_saml.assert_called_with(
+            RoleArn=ROLE_ARN,
+            PrincipalArn=PRINCIPAL_ARN,
+            SAMLAssertion=_encoded(_saml_response()),
+        )
+
+
+class TestSAMLSourceCredentials:
+    """Test SAML as a service source credentials method."""
+
+    def _config(self, saml: dict) -> Config:
+        return mock_config(("federated",), source_credentials={"saml": saml})
+
+    def test_saml_config_parsed(self):
+        """Test the saml source credentials are parsed."""
+        config = self._config(
+            {
+                "principal_arn": PRINCIPAL_ARN,
+                "role_arn": ROLE_ARN,
+                "assertion_command": "saml-login",
+            }
+        )
+        saml = config.services["federated"].source_credentials.saml
+
+        assert saml.principal_arn == PRINCIPAL_ARN
+        assert saml.role_arn == ROLE_ARN
+        assert saml.assertion_command == "saml-login"
+        assert saml.assertion_file is None
+
+    def test_assertion_source_required(self):
+        """Test the schema requires exactly one of the file or command."""
+        with pytest.raises(Exception):
+            self._config({"principal_arn": PRINCIPAL_ARN, "role_arn": ROLE_ARN})
+
+    def test_saml_session_used_as_source(self, tmp_path):
+        """Test the SAML role session is the source of the service role."""
+        assertion_file = tmp_path / "assertion.xml"
+        assertion_file.write_text(_saml_response())
+        config = self._config(
+            {
+                "principal_arn": PRINCIPAL_ARN,
+                "role_arn": ROLE_ARN,
+                "assertion_file": str(assertion_file),
+            }
+        )
+        handler = CredentialsHandler(config)
+
+        with patch("credproxy.saml.boto3.client", return_value=_mock_sts_client()):
+            result = handler._get_aws_config(config.services["federated"])
+
+        assert result == {
+            "region_name": "us-west-2",
+            "aws_access_key_id": "ASIASAMLKEY",
+            "aws_secret_access_key": "saml-secret",
+            "aws_session_token": "saml-session-token",
+        }
+        handler.cleanup()
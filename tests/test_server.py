@@ -0,0 +1,184 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the TCP server graceful shutdown."""
+
+from __future__ import annotations
+
+import socket
+import threading
+import http.client
+from unittest.mock import patch
+
+from flask import Flask
+
+from credproxy.server import CredProxyServer, InFlightRequests
+
+
+def _slow_app(release: threading.Event, started: threading.Event) -> Flask:
+    """Create an app whose /slow route blocks until released."""
+    app = Flask("slow-app")
+
+    @app.route("/slow")
+    def slow():
+        started.set()
+        release.wait(timeout=5)
+        return "done"
+
+    @app.route("/fast")
+    def fast():
+        return "fast"
+
+    return app
+
+
+def _get(port: int, path: str, results: list) -> None:
+    """Request path and append the status and body to results."""
+    connection = http.client.HTTPConnection("127.0.0.1", port, timeout=5)
+    try:
+        connection.request("GET", path)
+        response = connection.getresponse()
+        results.append((response.status, response.read().decode()))
+    finally:
+        connection.close()
+
+
+class TestInFlightRequests:
+    """Test the in-flight requests counting middleware."""
+
+    def test_counts_until_response_closed(self):
+        """Test a request is in flight until its response is closed."""
+
+        def wsgi_app(environ, start_response):
+            start_response("200 OK", [])
+            return [b"body"]
+
+        in_flight = InFlightRequests(wsgi_app)
+        response = in_flight({}, lambda status, headers: None)
+
+        assert in_flight.count == 1
+        assert in_flight.wait_idle(0) is False
+        assert list(response) == [b"body"]
+        response.close()
+        assert in_flight.count == 0
+        assert in_flight.wait_idle(0) is True
+
+    def test_failing_app_not_counted(self):
+        """Test requests raising in the app are no longer counted."""
+
+        def wsgi_app(environ, start_response):
+            raise RuntimeError("boom")
+
+        in_flight = InFlightRequests(wsgi_app)
+        try:
+            in_flight({}, lambda status, headers: None)
+        except RuntimeError:
+            pass
+
+        assert in_flight.count == 0
+
+
+class TestCredProxyServer:
+    """Test serving and draining in-flight requests."""
+
+    def test_in_flight_request_drained(self):
+        """Test shutdown waits for an in-flight request to complete."""
+        release, started = threading.Event(), threading.Event()
+        server = CredProxyServer(
+            _slow_app(release, started), "127.0.0.1", 0, shutdown_timeout=5
+        )
+        server.start()
+        results: list = []
+        client = threading.Thread(target=_get, args=(server.port, "/slow", results))
+        client.start()
+        assert started.wait(timeout=5)
+
+        drained: list = []
+        shutdown = threading.Thread(target=lambda: drained.append(server.shutdown()))
+        shutdown.start()
+        # The request is still running while the server drains
+        shutdown.join(timeout=0.2)
+        assert shutdown.is_alive()
+
+        release.set()
+        shutdown.join(timeout=5)
+        client.join(timeout=5)
+
+        assert drained == [True]
+        assert results == [(200, "done")]
+
+    def test_drain_timeout(self):
+        """Test shutdown reports requests still in flight after the timeout."""
+        release, started = threading.Event(), threading.Event()
+        server = CredProxyServer(
+            _slow_app(release, started), "127.0.0.1", 0, shutdown_timeout=0.1
+        )
+        server.start()
+        client = threading.Thread(target=_get, args=(server.port, "/slow", []))
+        client.start()
+        assert started.wait(timeout=5)
+
+        try:
+            assert server.shutdown() is False
+        finally:
+            release.set()
+            client.join(timeout=5)
+
+    def test_new_connections_refused_after_shutdown(self):
+        """Test the listening socket is closed on shutdown."""
+        app = _slow_app(threading.Event(), threading.Event())
+        server = CredProxyServer(app, "127.0.0.1", 0)
+        server.start()
+        results: list = []
+        _get(server.port, "/fast", results)
+        assert results == [(200, "fast")]
+
+        assert server.shutdown() is True
+        assert app.config["_shutdown_requested"] is True
+        try:
+            _get(server.port, "/fast", results)
+        except ConnectionRefusedError:
+            pass
+        assert len(results) == 1
+
+    def test_serve_until_stop_event(self):
+        """Test serving stops once the stop event is set."""
+        app = _slow_app(threading.Event(), threading.Event())
+        server = CredProxyServer(app, "127.0.0.1", 0)
+        stop_event = threading.Event()
+        stop_event.set()
+
+        assert server.serve_until(stop_event) is True
+
+    def test_signal_before_serving(self):
+        """Test no socket is bound when shutdown was requested while starting."""
+        app = _slow_app(threading.Event(), threading.Event())
+        server = CredProxyServer(app, "127.0.0.1", 0)
+        stop_event = threading.Event()
+        stop_event.set()
+
+        with patch.object(server, "start") as mock_start:
+            assert server.serve_until(stop_event) is True
+
+        mock_start.assert_not_called()
+
+    def test_shutdown_without_start(self):
+        """Test shutdown is a no-op when the server never started."""
+        app = _slow_app(threading.Event(), threading.Event())
+
+        assert CredProxyServer(app, "127.0.0.1", 0).shutdown() is True
+
+    def test_slow_client_disconnected(self):
+        """Test a client not finishing its request headers is disconnected."""
+        app = _slow_app(threading.Event(), threading.Event())
+        server = CredProxyServer(app, "127.0.0.1", 0, read_timeout=0.2)
+        server.start()
+        connection = socket.create_connection(("127.0.0.1", server.port), timeout=5)
+
+        try:
+            connection.sendall(b"GET /fast HTTP/1.1\r\nHost: localhost\r\n")
+            # Closed by the server instead of waiting for the end of the headers
+            assert connection.recv(1024) == b""
+        finally:
+            connection.close()
+            server.shutdown()
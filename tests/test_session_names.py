@@ -0,0 +1,91 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for role session name templates."""
+
+from __future__ import annotations
+
+from contextlib import contextmanager
+from unittest.mock import patch
+
+import pytest
+
+from tests.mock_aws import mock_config
+from credproxy.config import Config
+from credproxy.session_names import MAX_SESSION_NAME_LENGTH, expand_session_name
+
+
+@contextmanager
+def _host(user: str = "ops", hostname: str = "node-1"):
+    """Run as user on hostname."""
+    with (
+        patch("credproxy.session_names.getpass.getuser", return_value=user),
+        patch("credproxy.session_names.socket.gethostname", return_value=hostname),
+    ):
+        yield
+
+
+class TestExpandSessionName:
+    """Test expanding the variables of session name templates."""
+
+    def test_variables_expanded(self):
+        """Test every variable is expanded, with or without spaces."""
+        with _host():
+            session_name = expand_session_name(
+                "{{.User}}@{{ .Hostname }}-{{.ProfileName}}", "my-app"
+            )
+
+        assert session_name == "ops@node-1-my-app"
+
+    def test_invalid_characters_replaced(self):
+        """Test characters STS does not accept are replaced with dashes."""
+        with _host(user="CORP\\jane doe"):
+            assert expand_session_name("{{.User}}", "my-app") == "CORP-jane-doe"
+
+    def test_long_names_truncated(self):
+        """Test expanded names are truncated to the length STS accepts."""
+        with _host(hostname="ip-10-0-0-1." + "a" * 60):
+            session_name = expand_session_name("credproxy-{{.Hostname}}", "my-app")
+
+        assert len(session_name) == MAX_SESSION_NAME_LENGTH
+        assert session_name.startswith("credproxy-ip-10-0-0-1.aaa")
+
+    def test_unknown_user(self):
+        """Test a UID without a user name still expands."""
+        with patch("credproxy.session_names.getpass.getuser", side_effect=KeyError):
+            assert expand_session_name("{{.User}}", "my-app") == "unknown"
+
+
+class TestServiceSessionNames:
+    """Test the session names of the services."""
+
+    def _config(self, **assumed_role) -> Config:
+        return mock_config(
+            assumed_role=assumed_role,
+            role_chain=[
+                {
+                    "RoleArn": "arn:aws:iam::111111111111:role/Hop",
+                    "RoleSessionName": "hop-{{.ProfileName}}",
+                }
+            ],
+        )
+
+    def test_default_names_user_and_host(self):
+        """Test sessions are traceable to the user and host by default."""
+        with _host():
+            service = self._config().services["my-app"]
+
+        assert service.assumed_role.RoleSessionName == "credproxy-ops-node-1"
+        assert service.role_chain[0].RoleSessionName == "hop-my-app"
+
+    def test_static_name_kept(self):
+        """Test session names without variables are used as they are."""
+        with _host():
+            service = self._config(RoleSessionName="my-session").services["my-app"]
+
+        assert service.assumed_role.RoleSessionName == "my-session"
+
+    def test_unknown_variable_rejected(self):
+        """Test the schema rejects variables other than the supported ones."""
+        with pytest.raises(ValueError):
+            self._config(RoleSessionName="{{.Account}}")
@@ -10,6 +10,7 @@ import os
 
 from credproxy.settings import (
     get_log_level,
+    get_log_format,
     _validate_log_level,
     get_credproxy_namespace,
 )
@@ -120,6 +121,34 @@ class TestGetLogLevel:
         assert get_log_level(self.namespace) == "debug"
 
 
+class TestGetLogFormat:
+    """Test get_log_format function with environment variables."""
+
+    def setup_method(self):
+        """Setup test environment."""
+        self.namespace = get_credproxy_namespace()
+        self.env_var = f"{self.namespace}LOG_FORMAT"
+
+    def teardown_method(self):
+        """Cleanup test environment."""
+        if self.env_var in os.environ:
+            del os.environ[self.env_var]
+
+    def test_default_format(self):
+        """Test logs are JSON when the environment variable is not set."""
+        if self.env_var in os.environ:
+            del os.environ[self.env_var]
+        assert get_log_format(self.namespace) == "json"
+
+    def test_format_from_env(self):
+        """Test the format is read case-insensitively, falling back to json."""
+        os.environ[self.env_var] = " TEXT "
+        assert get_log_format(self.namespace) == "text"
+
+        os.environ[self.env_var] = "xml"
+        assert get_log_format(self.namespace) == "json"
+
+
 class TestNamespaceHandling:
     """Test namespace handling in settings functions."""
 
@@ -0,0 +1,173 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the shell exports of the env command."""
+
+from __future__ import annotations
+
+import io
+import shutil
+import subprocess
+from unittest.mock import patch
+
+import yaml
+import pytest
+
+from credproxy.cli import main
+
+
+TOKEN = "my-app-token-'quoted'"
+URI = "http://127.0.0.1:1338/v1/credentials/my-app"
+
+
+def _write_config(directory, server: dict | None = None, **service) -> str:
+    config_file = directory / "config.yaml"
+    config_file.write_text(
+        yaml.safe_dump(
+            {
+                "server": {"host": "0.0.0.0", **(server or {})},
+                "services": {
+                    "my-app": {
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::123456789012:role/MyAppRole"
+                        },
+                        **(service or {"auth_token": TOKEN}),
+                    }
+                },
+            }
+        )
+    )
+    return str(config_file)
+
+
+def _run_env(config_file: str, *argv: str) -> tuple[int, str]:
+    with patch("sys.stdout", new_callable=io.StringIO) as mock_stdout:
+        result = main(["env", "--profile", "my-app", "--config", config_file, *argv])
+    return result, mock_stdout.getvalue()
+
+
+class TestEnvCommand:
+    """Test the env command."""
+
+    @pytest.mark.parametrize(
+        "shell, expected",
+        [
+            (
+                "bash",
+                f"export AWS_CONTAINER_CREDENTIALS_FULL_URI={URI}\n"
+                "export AWS_CONTAINER_AUTHORIZATION_TOKEN="
+                "'my-app-token-'\"'\"'quoted'\"'\"''\n",
+            ),
+            (
+                "fish",
+                f"set -gx AWS_CONTAINER_CREDENTIALS_FULL_URI '{URI}';\n"
+                "set -gx AWS_CONTAINER_AUTHORIZATION_TOKEN "
+                "'my-app-token-\\'quoted\\'';\n",
+            ),
+            (
+                "powershell",
+                f"$Env:AWS_CONTAINER_CREDENTIALS_FULL_URI = '{URI}'\n"
+                "$Env:AWS_CONTAINER_AUTHORIZATION_TOKEN = 'my-app-token-''quoted'''\n",
+            ),
+        ],
+    )
+    def test_exports_in_shell_syntax(self, tmp_path, shell, expected):
+        """Test the exports of each shell, reaching listeners of every address."""
+        result, output = _run_env(_write_config(tmp_path), "--shell", shell)
+
+        assert result == 0
+        assert output == expected
+
+    def test_bash_exports_evaluated(self, tmp_path):
+        """Test bash sets the variables as configured once evaluated."""
+        if shutil.which("bash") is None:
+            pytest.skip("bash is required to evaluate the exports")
+        _, output = _run_env(_write_config(tmp_path))
+
+        evaluated = subprocess.run(
+            [
+                "bash",
+                "-c",
+                'eval "$1"; printf "%s\\n" "$AWS_CONTAINER_CREDENTIALS_FULL_URI" '
+                '"$AWS_CONTAINER_AUTHORIZATION_TOKEN"',
+                "bash",
+                output,
+            ],
+            check=True,
+            capture_output=True,
+            text=True,
+        )
+        assert evaluated.stdout.splitlines() == [URI, TOKEN]
+
+    def test_token_file_exported(self, tmp_path):
+        """Test services with a token file export the file, following rotations."""
+        token_file = tmp_path / "token"
+        token_file.write_text("my-app-file-token\n")
+        config_file = _write_config(tmp_path, auth_token_file=str(token_file))
+
+        result, output = _run_env(config_file)
+
+        assert result == 0
+        assert output.splitlines()[1] == (
+            f"export AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE={token_file}"
+        )
+        assert "my-app-file-token" not in output
+
+    @pytest.mark.parametrize(
+        "server, uri",
+        [
+            ({"host": "::"}, "http://[::1]:1338/v1/credentials/my-app"),
+            (
+                {"host": "localhost", "port": 8080},
+                "http://localhost:8080/v1/credentials/my-app",
+            ),
+            (
+                {
+                    "host": "credproxy.internal",
+                    "tls": {"cert_file": "server.pem", "key_file": "server-key.pem"},
+                },
+                "https://credproxy.internal:1338/v1/credentials/my-app",
+            ),
+        ],
+    )
+    def test_uri_of_listener(self, tmp_path, server, uri):
+        """Test the URI follows the host, port and TLS of the listener."""
+        result, output = _run_env(_write_config(tmp_path, server), "--shell", "fish")
+
+        assert result == 0
+        assert output.splitlines()[0] == (
+            f"set -gx AWS_CONTAINER_CREDENTIALS_FULL_URI '{uri}';"
+        )
+
+    def test_unusable_listener_not_exported(self, tmp_path):
+        """Test a listener the SDKs reject is reported rather than exported."""
+        config_file = _write_config(tmp_path, {"host": "10.0.0.5"})
+
+        with patch("credproxy.shell_env.LOG") as mock_log:
+            result, output = _run_env(config_file)
+
+        assert result == 1
+        assert output == ""
+        assert "loopback" in mock_log.error.call_args.args[0]
+
+    def test_unix_socket_noted(self, tmp_path):
+        """Test a Unix socket listener is noted, the TCP listener being exported."""
+        config_file = _write_config(tmp_path, {"unix_socket": "/run/credproxy.sock"})
+
+        with patch("credproxy.shell_env.LOG") as mock_log:
+            result, output = _run_env(config_file)
+
+        assert result == 0
+        assert output.splitlines()[0].endswith(URI)
+        assert mock_log.warning.call_args.args[1] == "/run/credproxy.sock"
+
+    def test_unknown_service(self, tmp_path):
+        """Test an unknown service fails without printing anything."""
+        with patch("sys.stdout", new_callable=io.StringIO) as mock_stdout:
+            result = main(
+                ["env", "--profile", "other-app", "--config", _write_config(tmp_path)]
+            )
+
+        assert result == 1
+        assert mock_stdout.getvalue() == ""
@@ -0,0 +1,121 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the collapsing of concurrent calls."""
+
+from __future__ import annotations
+
+import threading
+
+import pytest
+
+from credproxy.singleflight import SingleFlight
+
+
+def _run_concurrently(single_flight: SingleFlight, function, callers: int) -> list:
+    """Call function from callers threads at once, collecting their outcomes."""
+    outcomes = []
+    outcomes_lock = threading.Lock()
+
+    def call():
+        try:
+            outcome = single_flight.do("key", function)
+        except Exception as error:
+            outcome = error
+        with outcomes_lock:
+            outcomes.append(outcome)
+
+    threads = [threading.Thread(target=call) for _ in range(callers)]
+    for thread in threads:
+        thread.start()
+    for thread in threads:
+        thread.join(timeout=5)
+    return outcomes
+
+
+class TestSingleFlight:
+    """Test sharing one call between concurrent callers."""
+
+    def test_concurrent_calls_shared(self):
+        """Test callers waiting for the call in flight get its result."""
+        single_flight = SingleFlight()
+        started = threading.Event()
+        release = threading.Event()
+        calls = []
+
+        def slow_call():
+            calls.append(1)
+            started.set()
+            release.wait(timeout=5)
+            return "result"
+
+        leader = threading.Thread(target=single_flight.do, args=("key", slow_call))
+        leader.start()
+        started.wait(timeout=5)
+        threading.Timer(0.1, release.set).start()
+        outcomes = _run_concurrently(single_flight, slow_call, 10)
+        leader.join(timeout=5)
+
+        assert outcomes == ["result"] * 10
+        assert len(calls) == 1
+
+    def test_error_shared(self):
+        """Test callers waiting for a failing call get its error."""
+        single_flight = SingleFlight()
+        started = threading.Event()
+        release = threading.Event()
+        error = RuntimeError("STS unavailable")
+
+        def failing_call():
+            started.set()
+            release.wait(timeout=5)
+            raise error
+
+        leader = threading.Thread(
+            target=_run_concurrently, args=(single_flight, failing_call, 1)
+        )
+        leader.start()
+        started.wait(timeout=5)
+        threading.Timer(0.1, release.set).start()
+        outcomes = _run_concurrently(single_flight, failing_call, 5)
+        leader.join(timeout=5)
+
+        assert outcomes == [error] * 5
+        # The next caller makes a new call
+        with pytest.raises(RuntimeError):
+            single_flight.do("key", failing_call)
+
+    def test_completed_calls_not_reused(self):
+        """Test a call is made again once the previous one completed."""
+        single_flight = SingleFlight()
+        results = iter(["first", "second"])
+
+        assert single_flight.do("key", lambda: next(results)) == "first"
+        assert single_flight.do("key", lambda: next(results)) == "second"
+        assert single_flight.do("other", lambda: "other") == "other"
+
+    def test_timeout_leaves_call_running(self):
+        """Test callers stop waiting after the timeout while the call goes on."""
+        single_flight = SingleFlight()
+        release = threading.Event()
+        finished = threading.Event()
+
+        def hung_call():
+            release.wait(timeout=5)
+            finished.set()
+            return "late"
+
+        with pytest.raises(TimeoutError):
+            single_flight.do("key", hung_call, timeout=0.1)
+        with pytest.raises(TimeoutError):
+            single_flight.do("key", hung_call, timeout=0.1)
+        (call_thread,) = [
+            thread
+            for thread in threading.enumerate()
+            if thread.name == "singleflight-key"
+        ]
+        release.set()
+        call_thread.join(timeout=5)
+
+        assert finished.is_set()
+        assert single_flight.do("key", lambda: "next", timeout=1) == "next"
@@ -0,0 +1,345 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for IAM Identity Center (SSO) source credentials."""
+
+from __future__ import annotations
+
+import json
+import time
+import hashlib
+from datetime import datetime, timezone, timedelta
+from unittest.mock import MagicMock, patch
+
+import pytest
+from botocore.exceptions import ClientError
+
+from credproxy.sso import DEVICE_CODE_GRANT_TYPE, SSOTokenProvider
+from tests.mock_aws import mock_jwt, mock_config
+from credproxy.config import Config
+from credproxy.tokens import TokenLifetimeError
+from credproxy.providers import SourceCredentials
+from credproxy.credentials_handler import CredentialsHandler
+
+
+START_URL = "https://example.awsapps.com/start"
+
+
+def _timestamp(delta: timedelta) -> str:
+    """Build an AWS CLI cache timestamp relative to now."""
+    return (datetime.now(timezone.utc) + delta).strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def _client_error(code: str) -> ClientError:
+    """Build a ClientError with the given error code."""
+    return ClientError({"Error": {"Code": code, "Message": code}}, "Operation")
+
+
+def _write_token(
+    provider: SSOTokenProvider,
+    expires_in: timedelta,
+    access_token: str = "cached-access-token",
+) -> None:
+    """Write a cached SSO token the way the AWS CLI does."""
+    provider.cache_dir.mkdir(parents=True, exist_ok=True)
+    provider.token_cache_path.write_text(
+        json.dumps(
+            {
+                "startUrl": START_URL,
+                "region": "us-east-1",
+                "accessToken": access_token,
+                "expiresAt": _timestamp(expires_in),
+            }
+        )
+    )
+
+
+def _mock_oidc_client() -> MagicMock:
+    """Build an sso-oidc client completing the device flow."""
+    oidc_client = MagicMock()
+    oidc_client.register_client.return_value = {
+        "clientId": "client-id",
+        "clientSecret": "client-secret",
+        "clientSecretExpiresAt": int(
+            (datetime.now(timezone.utc) + timedelta(days=90)).timestamp()
+        ),
+    }
+    oidc_client.start_device_authorization.return_value = {
+        "deviceCode": "device-code",
+        "userCode": "ABCD-EFGH",
+        "verificationUriComplete": "https://device.sso.example/?user_code=ABCD-EFGH",
+        "expiresIn": 600,
+        "interval": 1,
+    }
+    oidc_client.create_token.return_value = {
+        "accessToken": "new-access-token",
+        "expiresIn": 28800,
+    }
+    return oidc_client
+
+
+class TestSSOTokenProvider:
+    """Test SSO access token caching and the device authorization flow."""
+
+    def test_cache_file_named_like_aws_cli(self, tmp_path):
+        """Test the token cache file is the SHA1 of the start URL."""
+        provider = SSOTokenProvider(START_URL, "us-east-1", cache_dir=tmp_path)
+
+        expected = hashlib.sha1(START_URL.encode("utf-8")).hexdigest() + ".json"
+        assert provider.token_cache_path == tmp_path / expected
+
+    def test_valid_cached_token_reused(self, tmp_path):
+        """Test a valid cached token is used without signing in."""
+        provider = SSOTokenProvider(START_URL, "us-east-1", cache_dir=tmp_path)
+        _write_token(provider, timedelta(hours=1))
+
+        with patch("credproxy.sso.boto3.client") as mock_client:
+            assert provider.access_token() == "cached-access-token"
+
+        mock_client.assert_not_called()
+
+    def test_expired_token_triggers_device_flow(self, tmp_path):
+        """Test an expired token starts the device flow and caches the result."""
+        notify = MagicMock()
+        provider = SSOTokenProvider(
+            START_URL, "us-east-1", cache_dir=tmp_path, notify=notify
+        )
+        _write_token(provider, timedelta(minutes=-5))
+        oidc_client = _mock_oidc_client()
+        oidc_client.create_token.side_effect = [
+            _client_error("AuthorizationPendingException"),
+            _client_error("SlowDownException"),
+            oidc_client.create_token.return_value,
+        ]
+
+        with (
+            patch("credproxy.sso.boto3.client", return_value=oidc_client),
+            patch("credproxy.sso.time.sleep") as mock_sleep,
+        ):
+            assert provider.access_token() == "new-access-token"
+
+        # The polling interval grows when asked to slow down
+        assert [call.args[0] for call in mock_sleep.call_args_list] == [1, 1, 6]
+        assert "ABCD-EFGH" in notify.call_args.args[0]
+        oidc_client.create_token.assert_called_with(
+            grantType=DEVICE_CODE_GRANT_TYPE,
+            deviceCode="device-code",
+            clientId="client-id",
+            clientSecret="client-secret",
+        )
+
+        cached = json.loads(provider.token_cache_path.read_text())
+        assert cached["startUrl"] == START_URL
+        assert cached["region"] == "us-east-1"
+        assert cached["accessToken"] == "new-access-token"
+        assert cached["expiresAt"].endswith("Z")
+        assert provider.token_cache_path.stat().st_mode & 0o777 == 0o600
+
+    def test_token_expired_by_claims_signs_in_again(self, tmp_path):
+        """Test a cached JWT expired by its claims is not used."""
+        provider = SSOTokenProvider(START_URL, "us-east-1", cache_dir=tmp_path)
+        _write_token(provider, timedelta(hours=1), mock_jwt(exp=1767225600))
+
+        with (
+            patch("credproxy.sso.boto3.client", return_value=_mock_oidc_client()),
+            patch("credproxy.sso.time.sleep"),
+            patch("credproxy.sso.LOG") as mock_log,
+        ):
+            assert provider.access_token() == "new-access-token"
+
+        assert str(mock_log.warning.call_args.args[1]) == (
+            f"SSO token cached at {provider.token_cache_path} expired at "
+            "2026-01-01T00:00:00Z"
+        )
+
+    def test_new_token_not_yet_valid_raises(self, tmp_path):
+        """Test a new token valid only in the future fails, pointing at the clock."""
+        provider = SSOTokenProvider(START_URL, "us-east-1", cache_dir=tmp_path)
+        oidc_client = _mock_oidc_client()
+        oidc_client.create_token.return_value = {
+            "accessToken": mock_jwt(nbf=time.time() + 3600),
+            "expiresIn": 28800,
+        }
+
+        with (
+            patch("credproxy.sso.boto3.client", return_value=oidc_client),
+            patch("credproxy.sso.time.sleep"),
+        ):
+            with pytest.raises(TokenLifetimeError, match="check the clock"):
+                provider.access_token()
+
+        assert not provider.token_cache_path.exists()
+
+    def test_client_registration_reused(self, tmp_path):
+        """Test a cached client registration is reused for the device flow."""
+        provider = SSOTokenProvider(START_URL, "us-east-1", cache_dir=tmp_path)
+        provider.registration_cache_path.write_text(
+            json.dumps(
+                {
+                    "clientId": "cached-client",
+                    "clientSecret": "cached-secret",
+                    "expiresAt": _timestamp(timedelta(days=30)),
+                }
+            )
+        )
+        oidc_client = _mock_oidc_client()
+
+        with (
+            patch("credproxy.sso.boto3.client", return_value=oidc_client),
+            patch("credproxy.sso.time.sleep"),
+        ):
+            provider.access_token()
+
+        oidc_client.register_client.assert_not_called()
+        assert (
+            oidc_client.start_device_authorization.call_args.kwargs["clientId"]
+            == "cached-client"
+        )
+
+    def test_denied_authorization_raises(self, tmp_path):
+        """Test a denied device authorization is raised."""
+        provider = SSOTokenProvider(START_URL, "us-east-1", cache_dir=tmp_path)
+        oidc_client = _mock_oidc_client()
+        oidc_client.create_token.side_effect = _client_error("AccessDeniedException")
+
+        with (
+            patch("credproxy.sso.boto3.client", return_value=oidc_client),
+            patch("credproxy.sso.time.sleep"),
+        ):
+            with pytest.raises(ClientError):
+                provider.access_token()
+
+        assert not provider.token_cache_path.exists()
+
+    def test_role_credentials(self, tmp_path):
+        """Test permission set role credentials are returned for boto3."""
+        provider = SSOTokenProvider(START_URL, "us-east-1", cache_dir=tmp_path)
+        _write_token(provider, timedelta(hours=1))
+        sso_client = MagicMock()
+        sso_client.get_role_credentials.return_value = {
+            "roleCredentials": {
+                "accessKeyId": "ASIASSOKEY",
+                "secretAccessKey": "sso-secret",
+                "sessionToken": "sso-session-token",
+                "expiration": 1700000000000,
+            }
+        }
+
+        with patch("credproxy.sso.boto3.client", return_value=sso_client):
+            result = provider.role_credentials("123456789012", "ReadOnly")
+
+        sso_client.get_role_credentials.assert_called_once_with(
+            roleName="ReadOnly",
+            accountId="123456789012",
+            accessToken="cached-access-token",
+        )
+        assert result == SourceCredentials(
+            "ASIASSOKEY", "sso-secret", "sso-session-token", expiration=1700000000.0
+        )
+
+    def test_rejected_token_signs_in_again(self, tmp_path):
+        """Test a token rejected by SSO is dropped and the device flow rerun."""
+        provider = SSOTokenProvider(START_URL, "us-east-1", cache_dir=tmp_path)
+        _write_token(provider, timedelta(hours=1))
+        client = _mock_oidc_client()
+        client.get_role_credentials.side_effect = [
+            _client_error("UnauthorizedException"),
+            {
+                "roleCredentials": {
+                    "accessKeyId": "ASIASSOKEY",
+                    "secretAccessKey": "sso-secret",
+                    "sessionToken": "sso-session-token",
+                }
+            },
+        ]
+
+        with (
+            patch("credproxy.sso.boto3.client", return_value=client),
+            patch("credproxy.sso.time.sleep"),
+        ):
+            result = provider.role_credentials("123456789012", "ReadOnly")
+
+        assert result.access_key_id == "ASIASSOKEY"
+        assert result.expiration is None
+        assert (
+            client.get_role_credentials.call_args.kwargs["accessToken"]
+            == "new-access-token"
+        )
+
+
+class TestSSOSourceCredentials:
+    """Test SSO as a service source credentials method."""
+
+    def _config(self) -> Config:
+        sso = {
+            "start_url": START_URL,
+            "sso_region": "us-east-1",
+            "account_id": "123456789012",
+            "role_name": "ReadOnly",
+        }
+        return mock_config(("sso-service",), source_credentials={"sso": sso})
+
+    def test_sso_config_parsed(self):
+        """Test the sso source credentials are parsed."""
+        sso = self._config().services["sso-service"].source_credentials.sso
+
+        assert sso.start_url == START_URL
+        assert sso.sso_region == "us-east-1"
+        assert sso.account_id == "123456789012"
+        assert sso.role_name == "ReadOnly"
+
+    def test_invalid_account_id_rejected(self):
+        """Test the schema rejects malformed SSO account IDs."""
+        with pytest.raises(Exception):
+            Config.from_dict(
+                {
+                    "services": {
+                        "sso-service": {
+                            "auth_token": "sso-token",
+                            "source_credentials": {
+                                "sso": {
+                                    "start_url": START_URL,
+                                    "sso_region": "us-east-1",
+                                    "account_id": "1234",
+                                    "role_name": "ReadOnly",
+                                }
+                            },
+                            "assumed_role": {
+                                "RoleArn": "arn:aws:iam::123456789012:role/Role"
+                            },
+                        }
+                    }
+                }
+            )
+
+    def test_aws_config_uses_sso_role_credentials(self):
+        """Test STS is called with the SSO role credentials."""
+        config = self._config()
+        handler = CredentialsHandler(config)
+        sso_credentials = SourceCredentials(
+            "ASIASSOKEY", "sso-secret", "sso-session-token"
+        )
+
+        with patch(
+            "credproxy.credentials_handler.SSOTokenProvider.role_credentials",
+            return_value=sso_credentials,
+        ) as mock_role_credentials:
+            result = handler._get_aws_config(config.services["sso-service"])
+
+        mock_role_credentials.assert_called_once_with("123456789012", "ReadOnly")
+        assert result == {
+            "region_name": "us-west-2",
+            "aws_access_key_id": "ASIASSOKEY",
+            "aws_secret_access_key": "sso-secret",
+            "aws_session_token": "sso-session-token",
+        }
+        handler.cleanup()
+
+    def test_token_provider_shared_per_start_url(self):
+        """Test services of the same start URL share one token provider."""
+        config = self._config()
+        handler = CredentialsHandler(config)
+        sso = config.services["sso-service"].source_credentials.sso
+
+        assert handler._sso_token_provider(sso) is handler._sso_token_provider(sso)
+        handler.cleanup()
@@ -0,0 +1,194 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the proxy and CA bundle of the STS clients of source credentials."""
+
+from __future__ import annotations
+
+import shutil
+import subprocess
+from pathlib import Path
+from datetime import datetime, timedelta, timezone
+from unittest.mock import MagicMock, patch
+
+import pytest
+
+from credproxy.config import Config
+from credproxy.retry import NO_CLIENT_RETRIES
+from credproxy.credentials_handler import CredentialsHandler
+
+
+PROXY = "http://proxy.internal:3128"
+GOV_PROXY = "http://gov-proxy.internal:3128"
+
+
+def _ca_bundle(directory: Path) -> str:
+    """Create a self-signed test CA certificate."""
+    if shutil.which("openssl") is None:
+        pytest.skip("openssl is required to create test certificates")
+    ca_bundle = directory / "ca.pem"
+    subprocess.run(
+        [
+            "openssl",
+            "req",
+            "-x509",
+            "-newkey",
+            "ec",
+            "-pkeyopt",
+            "ec_paramgen_curve:prime256v1",
+            "-nodes",
+            "-keyout",
+            str(directory / "ca-key.pem"),
+            "-subj",
+            "/CN=credproxy-test-ca",
+            "-days",
+            "1",
+            "-out",
+            str(ca_bundle),
+        ],
+        check=True,
+        capture_output=True,
+    )
+    return str(ca_bundle)
+
+
+def _service(name: str, source_credentials: dict, role_chain: bool = False) -> dict:
+    service = {
+        "auth_token": f"{name}-token",
+        "source_credentials": {"region": "us-west-2", **source_credentials},
+        "assumed_role": {"RoleArn": f"arn:aws:iam::123456789012:role/{name}"},
+    }
+    if role_chain:
+        service["role_chain"] = [{"RoleArn": "arn:aws:iam::123456789012:role/hop"}]
+    return service
+
+
+def _mock_sts_client() -> MagicMock:
+    sts_client = MagicMock()
+    credentials = {
+        "AccessKeyId": "ASIAPROXIEDKEY",
+        "SecretAccessKey": "proxied-secret",
+        "SessionToken": "proxied-session-token",
+        "Expiration": datetime.now(timezone.utc) + timedelta(hours=1),
+    }
+    sts_client.assume_role.return_value = {"Credentials": credentials}
+    sts_client.assume_role_with_web_identity.return_value = {
+        "Credentials": credentials
+    }
+    return sts_client
+
+
+class TestStsHttpConfig:
+    """Test the loading of the HTTP options of source credentials."""
+
+    def test_inherited_from_aws_defaults(self, tmp_path):
+        """Test the options of aws_defaults apply unless a service sets its own."""
+        ca_bundle = _ca_bundle(tmp_path)
+        config = Config.from_dict(
+            {
+                "aws_defaults": {"http_proxy": PROXY, "ca_bundle": ca_bundle},
+                "services": {
+                    "my-app": _service("my-app", {}),
+                    "gov-app": _service("gov-app", {"http_proxy": GOV_PROXY}),
+                },
+            }
+        )
+
+        my_app = config.services["my-app"].source_credentials
+        gov_app = config.services["gov-app"].source_credentials
+        assert (my_app.http_proxy, my_app.ca_bundle) == (PROXY, ca_bundle)
+        assert (gov_app.http_proxy, gov_app.ca_bundle) == (GOV_PROXY, ca_bundle)
+        assert not my_app.insecure_skip_verify
+
+    @pytest.mark.parametrize("content", [None, "", "not a certificate\n"])
+    def test_invalid_ca_bundle_rejected(self, tmp_path, content):
+        """Test a missing CA bundle, or one without certificates, fails loading."""
+        ca_bundle = tmp_path / "ca.pem"
+        if content is not None:
+            ca_bundle.write_text(content)
+        service = _service("my-app", {"ca_bundle": str(ca_bundle)})
+
+        with pytest.raises(ValueError, match="Invalid CA bundle"):
+            Config.from_dict({"services": {"my-app": service}})
+
+    def test_insecure_skip_verify_warned(self):
+        """Test disabling the TLS verification of STS logs a warning."""
+        with patch("credproxy.config.LOG") as mock_log:
+            Config.from_dict(
+                {
+                    "services": {
+                        "my-app": _service("my-app", {"insecure_skip_verify": True})
+                    }
+                }
+            )
+
+        message, service_name = mock_log.warning.call_args.args
+        assert "insecure_skip_verify" in message
+        assert service_name == "my-app"
+
+
+class TestStsHttpClients:
+    """Test the STS clients use the HTTP options of their source credentials."""
+
+    def test_options_of_each_service(self, tmp_path):
+        """Test every hop uses the options of its service, and only of its service."""
+        ca_bundle = _ca_bundle(tmp_path)
+        config = Config.from_dict(
+            {
+                "services": {
+                    "my-app": _service(
+                        "my-app",
+                        {"http_proxy": PROXY, "ca_bundle": ca_bundle},
+                        role_chain=True,
+                    ),
+                    "direct-app": _service("direct-app", {}),
+                }
+            }
+        )
+        handler = CredentialsHandler(config)
+
+        with patch("boto3.client", return_value=_mock_sts_client()) as mock_client:
+            handler.get_credentials("my-app")
+            handler.get_credentials("direct-app")
+        handler.cleanup()
+
+        *proxied_calls, direct_call = mock_client.call_args_list
+        assert len(proxied_calls) == 2
+        for call in proxied_calls:
+            assert call.kwargs["verify"] == ca_bundle
+            assert call.kwargs["config"].proxies == {"http": PROXY, "https": PROXY}
+            assert call.kwargs["config"].retries == NO_CLIENT_RETRIES
+        assert "verify" not in direct_call.kwargs
+        assert direct_call.kwargs["config"].proxies is None
+
+    def test_insecure_skip_verify_of_web_identity(self, tmp_path):
+        """Test AssumeRoleWithWebIdentity uses the options of the source."""
+        token_file = tmp_path / "token"
+        token_file.write_text("header.payload.signature")
+        config = Config.from_dict(
+            {
+                "services": {
+                    "my-app": _service(
+                        "my-app",
+                        {
+                            "http_proxy": PROXY,
+                            "insecure_skip_verify": True,
+                            "web_identity": {
+                                "token_file": str(token_file),
+                                "role_arn": "arn:aws:iam::123456789012:role/web",
+                            },
+                        },
+                    )
+                }
+            }
+        )
+        handler = CredentialsHandler(config)
+
+        with patch("boto3.client", return_value=_mock_sts_client()) as mock_client:
+            handler.get_credentials("my-app")
+        handler.cleanup()
+
+        web_identity_call, assume_role_call = mock_client.call_args_list
+        for call in (web_identity_call, assume_role_call):
+            assert call.kwargs["verify"] is False
+            assert call.kwargs["config"].proxies == {"http": PROXY, "https": PROXY}
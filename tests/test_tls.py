@@ -0,0 +1,234 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for serving the TCP listener over TLS."""
+
+from __future__ import annotations
+
+import ssl
+import shutil
+import subprocess
+import http.client
+from pathlib import Path
+
+import pytest
+from flask import Flask, request
+
+from credproxy.tls import CLIENT_CN_ENVIRON, ReloadableSSLContext
+from credproxy.config import TLSConfig
+from credproxy.server import CredProxyServer
+
+
+def _openssl(*args: str) -> None:
+    subprocess.run(["openssl", *args], check=True, capture_output=True)
+
+
+def _generate_key(path: Path) -> None:
+    _openssl("ecparam", "-name", "prime256v1", "-genkey", "-noout", "-out", str(path))
+
+
+def _issue_certificate(directory: Path, name: str, extensions: str) -> None:
+    """Issue a certificate signed by the test CA of directory."""
+    key, csr, ext = (
+        directory / f"{name}{suffix}" for suffix in ("-key.pem", ".csr", ".ext")
+    )
+    _generate_key(key)
+    _openssl("req", "-new", "-key", str(key), "-subj", f"/CN={name}", "-out", str(csr))
+    ext.write_text(extensions)
+    _openssl(
+        "x509",
+        "-req",
+        "-in",
+        str(csr),
+        "-CA",
+        str(directory / "ca.pem"),
+        "-CAkey",
+        str(directory / "ca-key.pem"),
+        "-CAcreateserial",
+        "-days",
+        "1",
+        "-extfile",
+        str(ext),
+        "-out",
+        str(directory / f"{name}.pem"),
+    )
+
+
+def _certificates(directory: Path) -> Path:
+    """Create a test CA, two server certificates and a client certificate."""
+    if shutil.which("openssl") is None:
+        pytest.skip("openssl is required to create test certificates")
+    ca_key = directory / "ca-key.pem"
+    _generate_key(ca_key)
+    _openssl(
+        "req",
+        "-x509",
+        "-new",
+        "-key",
+        str(ca_key),
+        "-subj",
+        "/CN=CredProxy Test CA",
+        "-days",
+        "1",
+        "-out",
+        str(directory / "ca.pem"),
+    )
+    server_extensions = "subjectAltName=DNS:localhost,IP:127.0.0.1\n"
+    _issue_certificate(directory, "server", server_extensions)
+    _issue_certificate(directory, "rotated", server_extensions)
+    _issue_certificate(directory, "client", "extendedKeyUsage=clientAuth\n")
+    return directory
+
+
+def _app() -> Flask:
+    app = Flask("tls-app")
+
+    @app.route("/health")
+    def health():
+        return "healthy"
+
+    @app.route("/client-cn")
+    def client_cn():
+        return request.environ.get(CLIENT_CN_ENVIRON) or ""
+
+    return app
+
+
+def _client_context(
+    certificates: Path, client_certificate: bool = False
+) -> ssl.SSLContext:
+    context = ssl.create_default_context(cafile=str(certificates / "ca.pem"))
+    if client_certificate:
+        context.load_cert_chain(
+            str(certificates / "client.pem"), str(certificates / "client-key.pem")
+        )
+    return context
+
+
+def _get(port: int, context: ssl.SSLContext) -> tuple[int, dict]:
+    """Get /health, returning the status and the server certificate."""
+    connection = http.client.HTTPSConnection(
+        "127.0.0.1", port, context=context, timeout=5
+    )
+    try:
+        connection.connect()
+        certificate = connection.sock.getpeercert()
+        connection.request("GET", "/health")
+        response = connection.getresponse()
+        response.read()
+        return response.status, certificate
+    finally:
+        connection.close()
+
+
+def _serve(tls_config: TLSConfig) -> tuple[CredProxyServer, ReloadableSSLContext]:
+    tls_context = ReloadableSSLContext(tls_config)
+    server = CredProxyServer(
+        _app(), "127.0.0.1", 0, shutdown_timeout=1, ssl_context=tls_context.context
+    )
+    server.start()
+    return server, tls_context
+
+
+class TestTLS:
+    """Test TLS and mutual TLS on the TCP listener."""
+
+    def test_served_over_tls(self, tmp_path):
+        """Test requests are served over TLS with the configured certificate."""
+        certificates = _certificates(tmp_path)
+        server, _ = _serve(
+            TLSConfig(
+                str(certificates / "server.pem"), str(certificates / "server-key.pem")
+            )
+        )
+        try:
+            status, certificate = _get(server.port, _client_context(certificates))
+        finally:
+            server.shutdown()
+
+        assert status == 200
+        assert dict(field[0] for field in certificate["subject"]) == {
+            "commonName": "server"
+        }
+
+    def test_client_certificate_required(self, tmp_path):
+        """Test clients without a certificate of the client CA are rejected."""
+        certificates = _certificates(tmp_path)
+        server, _ = _serve(
+            TLSConfig(
+                str(certificates / "server.pem"),
+                str(certificates / "server-key.pem"),
+                client_ca_file=str(certificates / "ca.pem"),
+            )
+        )
+        try:
+            with pytest.raises((ssl.SSLError, ConnectionError)):
+                _get(server.port, _client_context(certificates))
+            status, _ = _get(
+                server.port, _client_context(certificates, client_certificate=True)
+            )
+        finally:
+            server.shutdown()
+
+        assert status == 200
+
+    def test_client_common_name_in_environ(self, tmp_path):
+        """Test the common name of verified client certificates reaches the app."""
+        certificates = _certificates(tmp_path)
+        server, _ = _serve(
+            TLSConfig(
+                str(certificates / "server.pem"),
+                str(certificates / "server-key.pem"),
+                client_ca_file=str(certificates / "ca.pem"),
+            )
+        )
+        connection = http.client.HTTPSConnection(
+            "127.0.0.1",
+            server.port,
+            context=_client_context(certificates, client_certificate=True),
+            timeout=5,
+        )
+        try:
+            connection.request("GET", "/client-cn")
+            common_name = connection.getresponse().read().decode()
+        finally:
+            connection.close()
+            server.shutdown()
+
+        assert common_name == "client"
+
+    def test_certificate_reloaded(self, tmp_path):
+        """Test a rotated certificate is served after a reload."""
+        certificates = _certificates(tmp_path)
+        cert_file, key_file = tmp_path / "tls.pem", tmp_path / "tls-key.pem"
+        shutil.copy(certificates / "server.pem", cert_file)
+        shutil.copy(certificates / "server-key.pem", key_file)
+        server, tls_context = _serve(TLSConfig(str(cert_file), str(key_file)))
+        try:
+            _, before = _get(server.port, _client_context(certificates))
+            shutil.copy(certificates / "rotated.pem", cert_file)
+            shutil.copy(certificates / "rotated-key.pem", key_file)
+            assert tls_context.reload() is True
+            _, after = _get(server.port, _client_context(certificates))
+        finally:
+            server.shutdown()
+
+        assert before["serialNumber"] != after["serialNumber"]
+        assert dict(field[0] for field in after["subject"]) == {"commonName": "rotated"}
+
+    def test_failed_reload_keeps_certificate(self, tmp_path):
+        """Test an unreadable certificate keeps the current one served."""
+        certificates = _certificates(tmp_path)
+        cert_file = tmp_path / "tls.pem"
+        shutil.copy(certificates / "server.pem", cert_file)
+        server, tls_context = _serve(
+            TLSConfig(str(cert_file), str(certificates / "server-key.pem"))
+        )
+        try:
+            cert_file.write_text("not a certificate")
+            assert tls_context.reload() is False
+            status, _ = _get(server.port, _client_context(certificates))
+        finally:
+            server.shutdown()
+
+        assert status == 200
@@ -0,0 +1,69 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the lifetime checks of the tokens exchanged for credentials."""
+
+from __future__ import annotations
+
+import pytest
+
+from tests.mock_aws import mock_jwt
+from credproxy.tokens import (
+    CLOCK_LEEWAY_SECONDS,
+    TokenLifetimeError,
+    jwt_claims,
+    check_token_lifetime,
+)
+
+
+NOW = 1767225600.0  # 2026-01-01T00:00:00Z
+
+
+class TestTokenLifetime:
+    """Test the exp and nbf claims are checked."""
+
+    def test_claims_decoded(self):
+        """Test the claims of JWTs are decoded, other tokens having none."""
+        assert jwt_claims(mock_jwt(sub="my-app", exp=NOW)) == {
+            "sub": "my-app",
+            "exp": NOW,
+        }
+        for token in ("opaque-access-token", "a.b", "header.!!!.signature"):
+            assert jwt_claims(token) is None
+
+    def test_expired_token_rejected(self):
+        """Test a token expired beyond the clock leeway is rejected with its time."""
+        token = mock_jwt(exp=NOW - CLOCK_LEEWAY_SECONDS - 1)
+
+        with pytest.raises(TokenLifetimeError) as error:
+            check_token_lifetime(token, "Web identity token at /token", now=NOW)
+
+        assert str(error.value) == (
+            "Web identity token at /token expired at 2025-12-31T23:59:29Z"
+        )
+        assert error.value.code == "ExpiredSourceToken"
+
+    def test_not_yet_valid_token_rejected(self):
+        """Test a token valid only in the future points at the clock of the host."""
+        token = mock_jwt(nbf=NOW + 3600, exp=NOW + 7200)
+
+        with pytest.raises(
+            TokenLifetimeError, match="not valid until 2026-01-01T01"
+        ) as error:
+            check_token_lifetime(token, "SSO token", now=NOW)
+
+        assert error.value.code == "SourceTokenNotYetValid"
+
+    @pytest.mark.parametrize(
+        "token",
+        [
+            mock_jwt(exp=NOW - CLOCK_LEEWAY_SECONDS + 1),
+            mock_jwt(nbf=NOW + CLOCK_LEEWAY_SECONDS - 1),
+            mock_jwt(sub="no-lifetime"),
+            mock_jwt(exp="tomorrow"),
+            "opaque-access-token",
+        ],
+    )
+    def test_usable_tokens_accepted(self, token):
+        """Test tokens within the leeway, or without lifetime claims, are used."""
+        check_token_lifetime(token, "token", now=NOW)
@@ -0,0 +1,120 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the optional OpenTelemetry tracing."""
+
+from __future__ import annotations
+
+from unittest.mock import patch
+
+import pytest
+from flask import Flask
+
+from credproxy import tracing
+from credproxy.cli import create_parser
+from credproxy.config import Config
+from credproxy.runner import apply_cli_overrides
+
+
+# Trace context of the client sending the request
+TRACE_ID = "4bf92f3577b34da6a3ce929d0e0e4736"
+TRACEPARENT = f"00-{TRACE_ID}-00f067aa0ba902b7-01"
+
+
+def _memory_exporter():
+    """Create an in-memory span exporter, skipping without the SDK."""
+    try:
+        from opentelemetry.sdk.trace.export.in_memory_span_exporter import (
+            InMemorySpanExporter,
+        )
+    except ImportError:
+        pytest.skip("opentelemetry-sdk is required to export spans")
+    return InMemorySpanExporter()
+
+
+class TestTracingDisabled:
+    """Test tracing does nothing unless enabled."""
+
+    def test_span_without_tracing(self):
+        """Test spans and attributes are no-ops without tracing."""
+        assert not tracing.tracing_enabled()
+        with tracing.span("sts.AssumeRole", {"credproxy.role_arn": "arn"}) as span:
+            tracing.set_span_attribute("credproxy.cache", "miss")
+        assert span is None
+
+    def test_sdk_missing(self):
+        """Test tracing is not enabled without the OpenTelemetry SDK."""
+        with patch.object(tracing, "TracerProvider", None):
+            assert tracing.init_tracing() is False
+        assert not tracing.tracing_enabled()
+
+    def test_tracing_flag(self):
+        """Test --tracing enables tracing."""
+        config = Config()
+        apply_cli_overrides(config, create_parser().parse_args(["--tracing"]))
+
+        assert config.metrics.tracing.enabled is True
+
+    def test_tracing_config_parsed(self):
+        """Test metrics.tracing.enabled is read from the configuration."""
+        config = Config.from_dict(
+            {
+                "metrics": {"tracing": {"enabled": True}},
+                "services": {
+                    "my-app": {
+                        "auth_token": "token",
+                        "source_credentials": {"region": "us-west-2"},
+                        "assumed_role": {
+                            "RoleArn": "arn:aws:iam::123456789012:role/MyAppRole"
+                        },
+                    }
+                },
+            }
+        )
+
+        assert config.metrics.tracing.enabled is True
+
+
+class TestTracingEnabled:
+    """Test the spans of traced requests."""
+
+    def test_request_trace_continued(self):
+        """Test the request span continues the client trace and nests STS spans."""
+        exporter = _memory_exporter()
+        app = Flask("traced-app")
+        tracing.init_tracing(span_exporter=exporter)
+        try:
+            tracing.instrument_app(app)
+
+            @app.route("/v1/credentials")
+            def credentials():
+                with tracing.span("credentials.lookup"):
+                    tracing.set_span_attribute("credproxy.cache", "miss")
+                    with tracing.span("sts.AssumeRole", {"credproxy.role_arn": "arn"}):
+                        pass
+                return "credentials"
+
+            response = app.test_client().get(
+                "/v1/credentials", headers={"traceparent": TRACEPARENT}
+            )
+        finally:
+            tracing.shutdown_tracing()
+
+        assert response.status_code == 200
+        spans = {span.name: span for span in exporter.get_finished_spans()}
+        assert set(spans) == {
+            "GET /v1/credentials",
+            "credentials.lookup",
+            "sts.AssumeRole",
+        }
+        request_span = spans["GET /v1/credentials"]
+        assert format(request_span.context.trace_id, "032x") == TRACE_ID
+        assert request_span.attributes["http.response.status_code"] == 200
+        assert spans["credentials.lookup"].parent.span_id == (
+            request_span.context.span_id
+        )
+        assert spans["credentials.lookup"].attributes["credproxy.cache"] == "miss"
+        assert spans["sts.AssumeRole"].parent.span_id == (
+            spans["credentials.lookup"].context.span_id
+        )
+        assert spans["sts.AssumeRole"].attributes["credproxy.role_arn"] == "arn"
@@ -0,0 +1,83 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for serving CredProxy on a Unix domain socket."""
+
+from __future__ import annotations
+
+import os
+import json
+import stat
+import socket
+import http.client
+
+import pytest
+
+from credproxy.app import init_app
+from credproxy.config import Config
+from credproxy.unix_socket import UNIX_SOCKET_MODE, UnixSocketServer
+
+
+class UnixHTTPConnection(http.client.HTTPConnection):
+    """HTTP connection over a Unix domain socket."""
+
+    def __init__(self, path: str):
+        super().__init__("localhost")
+        self.unix_path = path
+
+    def connect(self):
+        self.sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        self.sock.connect(self.unix_path)
+
+
+class TestUnixSocketServer:
+    """Test the Unix domain socket server."""
+
+    def test_serves_requests_with_owner_only_permissions(self, tmp_path):
+        """Test the socket is restricted to its owner and serves the app."""
+        socket_path = str(tmp_path / "credproxy.sock")
+        server = UnixSocketServer(init_app(Config()), socket_path)
+        server.start()
+        try:
+            mode = os.stat(socket_path).st_mode
+            assert stat.S_ISSOCK(mode)
+            assert stat.S_IMODE(mode) == UNIX_SOCKET_MODE
+
+            connection = UnixHTTPConnection(socket_path)
+            connection.request("GET", "/health")
+            response = connection.getresponse()
+            assert response.status == 200
+            assert json.loads(response.read())["status"] == "healthy"
+            connection.close()
+        finally:
+            server.stop()
+
+        assert not os.path.exists(socket_path)
+
+    def test_stale_socket_replaced(self, tmp_path):
+        """Test a socket left by a previous run is replaced."""
+        socket_path = str(tmp_path / "credproxy.sock")
+        stale = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        stale.bind(socket_path)
+        stale.close()
+
+        server = UnixSocketServer(init_app(Config()), socket_path)
+        server.start()
+        server.stop()
+
+        assert not os.path.exists(socket_path)
+
+    def test_refuses_to_replace_regular_file(self, tmp_path):
+        """Test an existing non-socket file at the path is left untouched."""
+        socket_path = tmp_path / "credproxy.sock"
+        socket_path.write_text("not a socket")
+
+        server = UnixSocketServer(init_app(Config()), str(socket_path))
+        with pytest.raises(FileExistsError):
+            server.start()
+
+        assert socket_path.read_text() == "not a socket"
+
+    def test_stop_without_start(self, tmp_path):
+        """Test stopping a server that never started is a no-op."""
+        UnixSocketServer(init_app(Config()), str(tmp_path / "unused.sock")).stop()
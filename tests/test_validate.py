@@ -0,0 +1,168 @@
+#  SPDX-License-Identifier: MPL-2.0
+#  Copyright 2025-present John Mille <john@ews-network.net>
+
+"""Tests for the validate command."""
+
+from __future__ import annotations
+
+import io
+from datetime import datetime, timezone, timedelta
+from unittest.mock import patch
+
+import yaml
+import pytest
+from botocore.exceptions import ClientError
+
+from credproxy.cli import main, create_parser
+from credproxy.config import Config
+from credproxy.validate import ServiceValidation, format_results, validate_config
+
+
+def _service(role_name: str, **assumed_role) -> dict:
+    return {
+        "auth_token": f"{role_name}-token",
+        "source_credentials": {"region": "us-west-2"},
+        "assumed_role": {
+            "RoleArn": f"arn:aws:iam::123456789012:role/{role_name}",
+            **assumed_role,
+        },
+    }
+
+
+def _validate(config_data: dict, *arguments: str):
+    args = create_parser().parse_args(["validate", *arguments])
+    return validate_config(config_data, "config.yaml", args)
+
+
+class TestValidateConfig:
+    """Test the validation of each service."""
+
+    def test_services_validated_separately(self):
+        """Test the errors of every service are reported, not only the first."""
+        config_errors, results = _validate(
+            {
+                "services": {
+                    "valid": _service("ValidRole"),
+                    "short-session": _service("ShortRole", DurationSeconds=60),
+                    "bad-mfa": _service("MfaRole", SerialNumber="not-a-device"),
+                }
+            }
+        )
+
+        assert config_errors == []
+        assert [(result.service_name, result.ok) for result in results] == [
+            ("valid", True),
+            ("short-session", False),
+            ("bad-mfa", False),
+        ]
+        assert results[0].details == "arn:aws:iam::123456789012:role/ValidRole"
+        assert results[1].details.startswith("assumed_role -> DurationSeconds: ")
+        assert results[2].details.startswith("assumed_role -> SerialNumber: ")
+
+    def test_several_source_methods(self):
+        """Test source credentials with several methods are rejected."""
+        service = _service("MyAppRole")
+        service["source_credentials"].update(
+            {
+                "iam_profile": {"profile_name": "default"},
+                "web_identity": {
+                    "token_file": "/var/run/token",
+                    "role_arn": "arn:aws:iam::123456789012:role/IrsaRole",
+                },
+            }
+        )
+
+        _, (result,) = _validate({"services": {"my-app": service}})
+
+        assert result.ok is False
+        assert result.details == (
+            "source_credentials: Only one of iam_profile, iam_keys, sso, "
+            "web_identity, saml, static and sources can be set"
+        )
+        with pytest.raises(ValueError):
+            Config.from_dict({"services": {"my-app": service}})
+
+    def test_configuration_errors(self):
+        """Test errors outside of the services are reported on their own."""
+        config_errors, (result,) = _validate(
+            {"server": {"port": 0}, "services": {"my-app": _service("MyAppRole")}}
+        )
+
+        assert config_errors == ["server -> port: 0 is less than the minimum of 1"]
+        assert result.ok is False
+
+
+class TestCheckAssume:
+    """Test assuming the role of each service with --check-assume."""
+
+    def test_roles_assumed(self):
+        """Test each role is assumed once, failures showing the STS error."""
+        denied = ClientError(
+            {"Error": {"Code": "AccessDenied", "Message": "Not authorized"}},
+            "AssumeRole",
+        )
+        response = {
+            "Credentials": {
+                "AccessKeyId": "ASIACHECKASSUME",
+                "SecretAccessKey": "check-assume-secret",
+                "SessionToken": "check-assume-token",
+                "Expiration": datetime.now(timezone.utc) + timedelta(hours=1),
+            }
+        }
+        with patch("boto3.client") as mock_client:
+            mock_client.return_value.assume_role.side_effect = [response, denied]
+            _, results = _validate(
+                {
+                    "services": {
+                        "allowed": _service("AllowedRole"),
+                        "denied": _service("DeniedRole"),
+                    }
+                },
+                "--check-assume",
+            )
+
+        assert results == [
+            ServiceValidation(
+                "allowed", True, "Assumed arn:aws:iam::123456789012:role/AllowedRole"
+            ),
+            ServiceValidation("denied", False, "AccessDenied: Not authorized"),
+        ]
+
+
+class TestValidateCommand:
+    """Test the output and exit status of credproxy validate."""
+
+    def test_results_table(self):
+        """Test the results are aligned in columns."""
+        table = format_results(
+            ["server -> port: 0 is less than the minimum of 1"],
+            [
+                ServiceValidation("my-app", True, "arn"),
+                ServiceValidation("other", False, "DurationSeconds"),
+            ],
+        )
+
+        assert table == (
+            "SERVICE  RESULT  DETAILS\n"
+            "my-app   OK      arn\n"
+            "other    FAIL    DurationSeconds\n"
+            "Configuration error at server -> port: 0 is less than the minimum of 1\n"
+        )
+
+    @pytest.mark.parametrize(
+        "duration, exit_code", [(3600, 0), (60, 1)], ids=["valid", "invalid"]
+    )
+    def test_exit_code(self, tmp_path, duration, exit_code):
+        """Test the command exits with 1 when a service failed."""
+        service = _service("MyAppRole", DurationSeconds=duration)
+        config_file = tmp_path / "config.yaml"
+        config_file.write_text(yaml.safe_dump({"services": {"my-app": service}}))
+
+        with (
+            patch("sys.stdout", new_callable=io.StringIO) as stdout,
+            # Keeps the log level of the other tests
+            patch("credproxy.runner.setup_cli_logging"),
+        ):
+            assert main(["validate", "--config", str(config_file)]) == exit_code
+
+        assert stdout.getvalue().startswith("SERVICE  RESULT  DETAILS\nmy-app  ")